@@ -1,41 +1,42 @@
 package api
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"telegram-bot/config"
 	"telegram-bot/services"
 )
 
-var authService = &services.AuthService{}
+var (
+	authService   = &services.AuthService{}
+	rbacService   = &services.RBACService{}
+	apiKeyService = &services.APIKeyService{}
+	adminAuth     = &services.AdminAuthService{}
+)
 
 // AuthMiddleware بررسی احراز هویت کاربر
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header مفقود است"})
-			c.Abort()
-			return
-		}
-
-		// پردازش "Bearer token"
-		parts := strings.SplitN(authHeader, " ", 2)
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header نامعتبر است"})
-			c.Abort()
+		userID, ok := authenticate(c)
+		if !ok {
 			return
 		}
 
-		token := parts[1]
-		userID, err := authService.VerifyJWT(token)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token نامعتبر است"})
-			c.Abort()
-			return
+		// کلید API فقط به routeهایی که scope دارند و با scope خودش دسترسی دارد
+		if c.GetUint("api_key_id") != 0 {
+			scope, allowed := apiKeyScopes[c.Request.Method+" "+c.FullPath()]
+			if !allowed || !services.HasPermission(c.GetStringSlice("api_key_scopes"), scope) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "کلید API مجوز این عملیات را ندارد", "scope": scope})
+				c.Abort()
+				return
+			}
 		}
 
 		c.Set("user_id", userID)
@@ -43,97 +44,264 @@ func AuthMiddleware() gin.HandlerFunc {
 	}
 }
 
-// AdminAuthMiddleware بررسی احراز هویت ادمین
+// AdminAuthMiddleware بررسی احراز هویت کارکنان پنل ادمین؛ مجوز هر route جداگانه با RequirePermission بررسی می‌شود
 func AdminAuthMiddleware() gin.HandlerFunc {
+	return staffAuthMiddleware()
+}
+
+// SupportAuthMiddleware بررسی احراز هویت کارکنان پنل پشتیبانی؛ مجوز هر route جداگانه با RequirePermission بررسی می‌شود
+func SupportAuthMiddleware() gin.HandlerFunc {
+	return staffAuthMiddleware()
+}
+
+// staffAuthMiddleware بررسی token و بارگذاری مجوزهای کاربر؛ کاربر بدون هیچ نقشی رد می‌شود
+//
+// token پنل مدیریت (ورود با نام کاربری) هم پذیرفته می‌شود و مجوزها از نقش حساب مدیریت خوانده می‌شوند.
+func staffAuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// ابتدا بررسی token عادی
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header مفقود است"})
-			c.Abort()
+		if claims := adminClaimsFromRequest(c); claims != nil {
+			authenticateAdminAccount(c, claims)
 			return
 		}
 
-		parts := strings.SplitN(authHeader, " ", 2)
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header نامعتبر است"})
-			c.Abort()
+		userID, ok := authenticate(c)
+		if !ok {
 			return
 		}
 
-		token := parts[1]
-		userID, err := authService.VerifyJWT(token)
+		permissions, err := rbacService.GetUserPermissions(userID)
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token نامعتبر است"})
 			c.Abort()
 			return
 		}
+		// مجوزهای کلید API محدود به scopeهایی است که کاربر هنوز دارد
+		if c.GetUint("api_key_id") != 0 {
+			var granted []string
+			for _, scope := range c.GetStringSlice("api_key_scopes") {
+				if services.HasPermission(permissions, scope) {
+					granted = append(granted, scope)
+				}
+			}
+			permissions = granted
+		}
+
+		if len(permissions) == 0 {
+			c.JSON(http.StatusForbidden, gin.H{"error": "شما به این بخش دسترسی ندارید"})
+			c.Abort()
+			return
+		}
 
 		c.Set("user_id", userID)
-		c.Set("is_admin", true)
+		c.Set("permissions", permissions)
 		c.Next()
 	}
 }
 
-// SupportAuthMiddleware بررسی احراز هویت پشتیبان
-func SupportAuthMiddleware() gin.HandlerFunc {
+// RequireAdminAccount محدود کردن route به token پنل مدیریت؛ پس از AdminAuthMiddleware
+func RequireAdminAccount() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header مفقود است"})
+		if c.GetUint("admin_id") == 0 {
+			c.JSON(http.StatusForbidden, gin.H{"error": "این عملیات فقط با ورود پنل مدیریت ممکن است"})
 			c.Abort()
 			return
 		}
 
-		parts := strings.SplitN(authHeader, " ", 2)
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header نامعتبر است"})
-			c.Abort()
-			return
-		}
+		c.Next()
+	}
+}
 
-		token := parts[1]
-		userID, err := authService.VerifyJWT(token)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token نامعتبر است"})
+// RequirePermission بررسی مجوز لازم برای route؛ پس از AdminAuthMiddleware یا SupportAuthMiddleware
+func RequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		permissions := c.GetStringSlice("permissions")
+		if !services.HasPermission(permissions, permission) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":      "شما مجوز این عملیات را ندارید",
+				"permission": permission,
+			})
 			c.Abort()
 			return
 		}
 
-		c.Set("user_id", userID)
-		c.Set("is_support", true)
 		c.Next()
 	}
 }
 
-// BasicAuthMiddleware احراز هویت Basic (برای ادمین و پشتیبان)
-func BasicAuthMiddleware() gin.HandlerFunc {
+// authenticate خواندن و بررسی Bearer token یا کلید API؛ در صورت خطا پاسخ ارسال و درخواست متوقف می‌شود
+func authenticate(c *gin.Context) (uint, bool) {
+	if rawKey := apiKeyFromRequest(c); rawKey != "" {
+		return authenticateAPIKey(c, rawKey)
+	}
+
+	authHeader := c.GetHeader("Authorization")
+	if authHeader == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header مفقود است"})
+		c.Abort()
+		return 0, false
+	}
+
+	// پردازش "Bearer token"
+	parts := strings.SplitN(authHeader, " ", 2)
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header نامعتبر است"})
+		c.Abort()
+		return 0, false
+	}
+
+	token := parts[1]
+	claims, err := authService.VerifyJWT(token)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Token نامعتبر است"})
+		c.Abort()
+		return 0, false
+	}
+
+	// بررسی فهرست ابطال (خروج، خروج از همه دستگاه‌ها یا چرخش refresh token)
+	revoked, err := authService.IsTokenRevoked(claims.JTI)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در بررسی token"})
+		c.Abort()
+		return 0, false
+	}
+	if revoked {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Token باطل شده است"})
+		c.Abort()
+		return 0, false
+	}
+
+	c.Set("session_id", claims.SessionID)
+	c.Set("jti", claims.JTI)
+	return claims.UserID, true
+}
+
+// adminClaimsFromRequest claims token پنل مدیریت؛ nil یعنی token از نوع دیگری است
+func adminClaimsFromRequest(c *gin.Context) *services.AdminClaims {
+	token, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !found {
+		return nil
+	}
+
+	claims, err := adminAuth.VerifyToken(token)
+	if err != nil {
+		return nil
+	}
+	return claims
+}
+
+// adminActor انجام‌دهنده درخواست مدیریتی برای ثبت در دفتر توکن؛ کاربر متصل و حساب پنل مدیریت در صورت وجود
+func adminActor(c *gin.Context) services.Actor {
+	return services.Actor{UserID: c.GetUint("user_id"), AccountID: c.GetUint("admin_id")}
+}
+
+// authenticateAdminAccount بررسی ابطال token و وضعیت حساب مدیریت و ثبت مجوزهای نقش آن در context
+func authenticateAdminAccount(c *gin.Context, claims *services.AdminClaims) {
+	revoked, err := authService.IsTokenRevoked(claims.JTI)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در بررسی token"})
+		c.Abort()
+		return
+	}
+	if revoked {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Token باطل شده است"})
+		c.Abort()
+		return
+	}
+
+	account, permissions, err := adminAuth.GetAccountPermissions(claims.AccountID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Token نامعتبر است"})
+		c.Abort()
+		return
+	}
+	if len(permissions) == 0 {
+		c.JSON(http.StatusForbidden, gin.H{"error": "شما به این بخش دسترسی ندارید"})
+		c.Abort()
+		return
+	}
+
+	c.Set("admin_id", account.ID)
+	c.Set("admin_claims", claims)
+	c.Set("user_id", account.UserID)
+	c.Set("permissions", permissions)
+	c.Next()
+}
+
+// RateLimitMiddleware محدودیت نرخ؛ پس از احراز هویت بر اساس کلید API یا کاربر و نقش و در غیر این صورت بر اساس IP
+func RateLimitMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		username, password, ok := c.Request.BasicAuth()
-		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Basic auth مفقود است"})
-			c.Abort()
-			return
+		key := services.RateLimitKey("ip", c.ClientIP())
+		role := services.RoleGuest
+		if userID := c.GetUint("user_id"); userID != 0 {
+			key = services.RateLimitKey("user", userID)
+			role = services.UserRole(userID)
 		}
-
-		// بررسی نام کاربری و رمز عبور
-		if username != config.AppConfig.AdminUsername {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "نام کاربری نامعتبر است"})
-			c.Abort()
-			return
+		if adminID := c.GetUint("admin_id"); adminID != 0 {
+			key = services.RateLimitKey("admin", adminID)
+			role = services.RoleAdmin
+		}
+		// هر کلید API سطل جداگانه دارد تا یک ابزار خودکار سهم کاربر را مصرف نکند
+		if keyID := c.GetUint("api_key_id"); keyID != 0 {
+			key = services.RateLimitKey("apikey", keyID)
 		}
 
-		if !authService.VerifyAdminPassword(config.AppConfig.AdminPassword, password) {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "رمز عبور نامعتبر است"})
+		limiter := services.GetRateLimiter()
+		var allowed bool
+		var wait time.Duration
+		if limit, ok := c.Get("api_key_rate"); ok {
+			allowed, wait = limiter.AllowLimit(key, limit.(config.RateLimit))
+		} else {
+			allowed, wait = limiter.Allow(key, role)
+		}
+		if !allowed {
+			seconds := int(math.Ceil(wait.Seconds()))
+			c.Header("Retry-After", strconv.Itoa(seconds))
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"error":       "تعداد درخواست‌ها بیش از حد مجاز است",
+				"retry_after": seconds,
+			})
 			c.Abort()
 			return
 		}
 
-		c.Set("admin", true)
 		c.Next()
 	}
 }
 
+// apiKeyFromRequest کلید API از هدر X-API-Key یا Bearer با پیشوند کلید
+func apiKeyFromRequest(c *gin.Context) string {
+	if key := c.GetHeader("X-API-Key"); key != "" {
+		return key
+	}
+
+	if token, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); found && strings.HasPrefix(token, services.APIKeyPrefix) {
+		return token
+	}
+	return ""
+}
+
+// authenticateAPIKey بررسی کلید API و ثبت scope و محدودیت نرخ آن در context
+func authenticateAPIKey(c *gin.Context, rawKey string) (uint, bool) {
+	key, err := apiKeyService.Authenticate(rawKey, c.ClientIP())
+	if errors.Is(err, services.ErrInvalidAPIKey) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		c.Abort()
+		return 0, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در بررسی کلید API"})
+		c.Abort()
+		return 0, false
+	}
+
+	c.Set("api_key_id", key.ID)
+	c.Set("api_key_scopes", services.KeyScopes(key))
+	if limit, ok := services.KeyRateLimit(key); ok {
+		c.Set("api_key_rate", limit)
+	}
+	return key.UserID, true
+}
+
 // ErrorHandlingMiddleware مدیریت خطاها
 func ErrorHandlingMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
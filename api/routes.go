@@ -1,23 +1,39 @@
 package api
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"telegram-bot/config"
 	"telegram-bot/database"
 	"telegram-bot/services"
 )
 
 var (
-	authService  = &services.AuthService{}
-	userService  = &services.UserService{}
-	tokenService = &services.TokenService{}
-	aiService    = &services.AIService{}
+	authService    = &services.AuthService{}
+	userService    = &services.UserService{}
+	tokenService   = &services.TokenService{}
+	aiService      = &services.AIService{}
+	threadService  = &services.ThreadService{}
+	settingService = &services.SettingService{}
+	pricingService = &services.PricingService{}
+	usageService   = &services.UsageService{}
+	planService    = &services.PlanService{}
+	paymentService = &services.PaymentService{}
+	featureService = &services.FeatureService{}
+	otpService     = &services.OTPService{}
+	loginGuard     = &services.LoginGuardService{}
 )
 
-// login ورود
+// login بررسی اطلاعات ورود و ارسال کد یک‌بارمصرف؛ token پس از تایید کد در verifyOTP صادر می‌شود
 func login(c *gin.Context) {
 	var req struct {
 		Phone        string `json:"phone" binding:"required"`
@@ -29,90 +45,1256 @@ func login(c *gin.Context) {
 		return
 	}
 
+	subject := services.LoginSubject{Flow: services.LoginFlowAPI, Phone: req.Phone, IP: c.ClientIP()}
+	if !checkLoginGuard(c, subject) {
+		return
+	}
+
 	user, err := authService.LoginUser(req.Phone, req.NationalCode)
 	if err != nil {
+		loginGuard.RecordFailure(subject, services.LoginReasonInvalidCredentials)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "اطلاعات نامعتبر است"})
 		return
 	}
 
-	token, err := authService.GenerateJWT(user.ID)
+	// ورود موفق پس از تایید کد در verifyOTP ثبت می‌شود
+	challenge, err := otpService.SendLoginCode(user)
+	if errors.Is(err, services.ErrOTPResendTooSoon) {
+		wait := int(math.Ceil(otpService.ResendWait(user.ID).Seconds()))
+		c.Header("Retry-After", strconv.Itoa(wait))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error(), "retry_after": wait})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در ارسال کد تایید"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"otp_required": true,
+		"challenge_id": challenge.ChallengeID,
+		"channel":      challenge.Channel,
+		"destination":  challenge.Destination,
+		"expires_in":   challenge.ExpiresIn,
+	})
+}
+
+// checkLoginGuard بررسی قفل ورود؛ در صورت قفل یا تاخیر پاسخ 429 ارسال می‌شود
+func checkLoginGuard(c *gin.Context, subject services.LoginSubject) bool {
+	wait, err := loginGuard.Check(subject)
+	if errors.Is(err, services.ErrLoginLocked) || errors.Is(err, services.ErrLoginThrottled) {
+		seconds := int(math.Ceil(wait.Seconds()))
+		c.Header("Retry-After", strconv.Itoa(seconds))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error(), "retry_after": seconds})
+		return false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در بررسی ورود"})
+		return false
+	}
+	return true
+}
+
+// verifyOTP تایید کد یک‌بارمصرف و صدور token
+func verifyOTP(c *gin.Context) {
+	var req struct {
+		ChallengeID string `json:"challenge_id" binding:"required"`
+		Code        string `json:"code" binding:"required"`
+	}
+
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	subject := services.LoginSubject{Flow: services.LoginFlowOTP, IP: c.ClientIP()}
+	if !checkLoginGuard(c, subject) {
+		return
+	}
+
+	userID, err := otpService.VerifyLoginCode(req.ChallengeID, strings.TrimSpace(req.Code))
+	if errors.Is(err, services.ErrOTPInvalid) || errors.Is(err, services.ErrOTPExpired) || errors.Is(err, services.ErrOTPTooManyAttempts) {
+		loginGuard.RecordFailure(subject, services.LoginReasonInvalidOTP)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := userService.GetUser(userID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "کاربر یافت نشد"})
+		return
+	}
+	subject.Phone = user.PhoneNumber
+	loginGuard.RecordSuccess(subject, user.ID)
+
+	tokens, err := authService.CreateSession(user.ID, c.Request.UserAgent(), c.ClientIP())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در تولید token"})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"token": token,
-		"user": gin.H{
-			"id":        user.ID,
-			"full_name": user.FullName,
-			"phone":     user.PhoneNumber,
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"token_type":    tokens.TokenType,
+		"expires_in":    tokens.ExpiresIn,
+		"user": gin.H{
+			"id":        user.ID,
+			"full_name": user.FullName,
+			"phone":     user.PhoneNumber,
+		},
+	})
+}
+
+// refreshToken صدور access token جدید با refresh token؛ refresh token هم عوض می‌شود
+func refreshToken(c *gin.Context) {
+	var req struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tokens, err := authService.RefreshSession(req.RefreshToken, c.Request.UserAgent(), c.ClientIP())
+	if errors.Is(err, services.ErrInvalidRefreshToken) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"token_type":    tokens.TokenType,
+		"expires_in":    tokens.ExpiresIn,
+	})
+}
+
+// logout خروج از نشست فعلی
+func logout(c *gin.Context) {
+	if err := authService.RevokeSession(c.GetUint("user_id"), c.GetUint("session_id")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "خروج موفق"})
+}
+
+// logoutAll خروج از همه دستگاه‌ها
+func logoutAll(c *gin.Context) {
+	revoked, err := authService.RevokeAllSessions(c.GetUint("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "از همه دستگاه‌ها خارج شدید",
+		"revoked": revoked,
+	})
+}
+
+// getUserSessions دریافت نشست‌های فعال کاربر
+func getUserSessions(c *gin.Context) {
+	respondSessions(c, c.GetUint("user_id"))
+}
+
+// apiKeyRequest بدنه درخواست صدور کلید API
+type apiKeyRequest struct {
+	Name       string    `json:"name" binding:"required"`
+	Scopes     []string  `json:"scopes" binding:"required"`
+	ExpiresAt  time.Time `json:"expires_at"` // خالی یعنی بدون انقضا
+	RatePerMin int       `json:"rate_per_minute"`
+	RateBurst  int       `json:"rate_burst"`
+}
+
+// getAPIKeys دریافت کلیدهای API کاربر
+func getAPIKeys(c *gin.Context) {
+	respondAPIKeys(c, c.GetUint("user_id"))
+}
+
+// createAPIKey صدور کلید API توسط خود کاربر؛ محدودیت نرخ اختصاصی فقط توسط ادمین تعیین می‌شود
+func createAPIKey(c *gin.Context) {
+	var req apiKeyRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID := c.GetUint("user_id")
+	issueAPIKey(c, userID, userID, &services.APIKeyRequest{
+		Name:      req.Name,
+		Scopes:    req.Scopes,
+		ExpiresAt: req.ExpiresAt,
+	})
+}
+
+// revokeAPIKey ابطال کلید API کاربر
+func revokeAPIKey(c *gin.Context) {
+	keyID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "شناسه کلید نامعتبر است"})
+		return
+	}
+
+	if err := apiKeyService.RevokeKey(uint(keyID), c.GetUint("user_id")); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "کلید API باطل شد"})
+}
+
+// issueAPIKey صدور کلید و پاسخ؛ کلید خام فقط در همین پاسخ نمایش داده می‌شود
+func issueAPIKey(c *gin.Context, userID, createdBy uint, req *services.APIKeyRequest) {
+	key, rawKey, err := apiKeyService.CreateKey(userID, createdBy, req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := apiKeyResponse(key)
+	response["key"] = rawKey
+	c.JSON(http.StatusCreated, gin.H{
+		"api_key": response,
+		"message": "این کلید فقط یک بار نمایش داده می‌شود؛ آن را در جای امن نگه دارید",
+	})
+}
+
+// respondAPIKeys پاسخ فهرست کلیدهای API کاربر
+func respondAPIKeys(c *gin.Context, userID uint) {
+	keys, err := apiKeyService.GetUserKeys(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	items := make([]gin.H, 0, len(keys))
+	for i := range keys {
+		items = append(items, apiKeyResponse(&keys[i]))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"api_keys": items,
+		"scopes":   apiKeyService.GetScopeTitles(),
+	})
+}
+
+// apiKeyResponse اطلاعات قابل نمایش کلید بدون هش
+func apiKeyResponse(key *database.APIKey) gin.H {
+	return gin.H{
+		"id":              key.ID,
+		"name":            key.Name,
+		"prefix":          key.Prefix,
+		"scopes":          services.KeyScopes(key),
+		"rate_per_minute": key.RatePerMin,
+		"rate_burst":      key.RateBurst,
+		"expires_at":      key.ExpiresAt,
+		"last_used_at":    key.LastUsedAt,
+		"last_used_ip":    key.LastUsedIP,
+		"revoked":         key.Revoked,
+		"created_by":      key.CreatedBy,
+		"created_at":      key.CreatedAt,
+	}
+}
+
+// getUserProfile دریافت پروفایل کاربر
+func getUserProfile(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	user, err := userService.GetUser(userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "کاربر یافت نشد"})
+		return
+	}
+
+	tokens, _ := tokenService.GetUserTokens(userID)
+
+	plan, err := planService.GetUserPlan(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	featureUsage, err := featureService.GetDailyBreakdown(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":               user.ID,
+		"full_name":        user.FullName,
+		"phone":            user.PhoneNumber,
+		"tokens":           tokens,
+		"purchased_tokens": user.PurchasedTokens,
+		"plan":             plan,
+		"plan_expires_at":  user.PlanExpiresAt,
+		"feature_usage":    featureUsage,
+		"created_at":       user.CreatedAt,
+	})
+}
+
+// getUserTokens دریافت توکن‌های کاربر
+func getUserTokens(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	tokens, err := tokenService.GetUserTokens(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"tokens": tokens,
+	})
+}
+
+// getUserTransactions دریافت تاریخچه تغییرات توکن کاربر
+func getUserTransactions(c *gin.Context) {
+	respondTransactions(c, c.GetUint("user_id"))
+}
+
+// respondTransactions پاسخ صفحه‌بندی‌شده دفتر توکن
+func respondTransactions(c *gin.Context, userID uint) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+
+	transactions, total, err := tokenService.GetTransactions(userID, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"transactions": transactions,
+		"total":        total,
+	})
+}
+
+// transferTokens انتقال توکن به کاربر دیگر با شماره تلفن
+func transferTokens(c *gin.Context) {
+	var req struct {
+		Phone  string `json:"phone" binding:"required"`
+		Amount int    `json:"amount" binding:"required"`
+		Note   string `json:"note"`
+	}
+
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	recipient, err := userService.GetUserByPhone(req.Phone)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "گیرنده یافت نشد"})
+		return
+	}
+
+	transfer, err := tokenService.TransferTokens(c.GetUint("user_id"), recipient.ID, req.Amount, req.Note)
+	switch {
+	case errors.Is(err, services.ErrInsufficientTokens), errors.Is(err, services.ErrTransferLimitExceeded):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tokens, _ := tokenService.GetUserTokens(c.GetUint("user_id"))
+	c.JSON(http.StatusOK, gin.H{
+		"transfer": transfer,
+		"tokens":   tokens,
+	})
+}
+
+// getTokenPacks دریافت بسته‌های توکن قابل خرید
+func getTokenPacks(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"packs": paymentService.GetTokenPacks(),
+	})
+}
+
+// purchaseTokenPack ایجاد فاکتور خرید بسته توکن
+func purchaseTokenPack(c *gin.Context) {
+	var req struct {
+		PackID string `json:"pack_id" binding:"required"`
+	}
+
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	payment, paymentURL, err := paymentService.CreatePurchase(c.GetUint("user_id"), req.PackID)
+	if errors.Is(err, services.ErrPackNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"payment_id":  payment.ID,
+		"authority":   payment.Authority,
+		"payment_url": paymentURL,
+	})
+}
+
+// getUserPayments دریافت آخرین پرداخت‌های کاربر
+func getUserPayments(c *gin.Context) {
+	payments, err := paymentService.GetUserPayments(c.GetUint("user_id"), 20)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"payments": payments,
+	})
+}
+
+// paymentCallback بازگشت کاربر از درگاه و تایید پرداخت
+func paymentCallback(c *gin.Context) {
+	authority := c.Query("Authority")
+	if authority == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "شناسه پرداخت ارسال نشده است"})
+		return
+	}
+
+	payment, err := paymentService.VerifyPayment(authority, c.Query("Status"))
+	switch {
+	case errors.Is(err, services.ErrPaymentNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, services.ErrPaymentCancelled), errors.Is(err, services.ErrPaymentNotVerified):
+		c.JSON(http.StatusPaymentRequired, gin.H{"error": err.Error(), "status": payment.Status})
+		return
+	case err != nil:
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "پرداخت با موفقیت انجام شد",
+		"tokens":  payment.Tokens,
+		"ref_id":  payment.RefID,
+	})
+}
+
+// getUserConversations دریافت گفتگوهای کاربر
+func getUserConversations(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	conversations, err := aiService.GetConversationHistory(userID, 50)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"conversations": conversations,
+	})
+}
+
+// getUserThreads دریافت رشته‌های گفتگوی کاربر
+func getUserThreads(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	threads, err := threadService.GetUserThreads(userID, 50)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"threads": threads,
+	})
+}
+
+// createThread ایجاد رشته گفتگوی جدید
+func createThread(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	var req struct {
+		Title string `json:"title"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	thread, err := threadService.CreateThread(userID, req.Title)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, thread)
+}
+
+// getThread دریافت یک رشته گفتگو همراه با پیام‌هایش
+func getThread(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	threadID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "شناسه گفتگو نامعتبر است"})
+		return
+	}
+
+	thread, err := threadService.GetThread(userID, uint(threadID))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	conversations, err := threadService.GetThreadConversations(thread.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"thread":        thread,
+		"conversations": conversations,
+	})
+}
+
+// aiQuery پرس‌وجو از AI
+func aiQuery(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	var req struct {
+		Question string `json:"question" binding:"required"`
+		Provider string `json:"provider"`
+		ThreadID uint   `json:"thread_id"`
+		Stream   bool   `json:"stream"`
+	}
+
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// رزرو اعتبار پیش از ارسال
+	reservation, ok := reserveTokens(c, userID, aiService.EstimateQueryCredits(userID, req.Question))
+	if !ok {
+		return
+	}
+
+	// تعیین رشته گفتگو
+	thread, err := threadService.ResolveThread(userID, req.ThreadID)
+	if err != nil {
+		releaseTokens(reservation, "رشته گفتگو یافت نشد")
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	opts := services.QueryOptions{
+		Provider: req.Provider,
+		ThreadID: thread.ID,
+	}
+
+	// نسخه استریم (text/event-stream)
+	if req.Stream || strings.Contains(c.GetHeader("Accept"), "text/event-stream") {
+		streamAIQuery(c, userID, req.Question, opts, reservation)
+		return
+	}
+
+	// ارسال به AI
+	result, err := aiService.QueryAIWithOptions(c.Request.Context(), userID, req.Question, opts)
+	if err != nil && result == nil {
+		log.Printf("❌ خطا در AI query: %v", err)
+		releaseTokens(reservation, "خطای AI")
+		respondAIError(c, err)
+		return
+	}
+
+	if err != nil {
+		// پاسخ دریافت شده ولی ذخیره نشد؛ مصرف همچنان محاسبه می‌شود
+		log.Printf("⚠️  %v", err)
+	}
+
+	// تسویه رزرو بر اساس مصرف واقعی
+	commitTokens(reservation, result)
+
+	c.JSON(http.StatusOK, gin.H{
+		"response":  result.Content,
+		"thread_id": thread.ID,
+		"usage":     usageResponse(result),
+	})
+}
+
+// reserveTokens رزرو اعتبار پیش از فراخوانی AI؛ در صورت خطا پاسخ را می‌نویسد
+func reserveTokens(c *gin.Context, userID uint, amount int) (*database.TokenReservation, bool) {
+	reservation, err := tokenService.ReserveTokens(userID, amount)
+	if errors.Is(err, services.ErrInsufficientTokens) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "موجودی توکن کافی نیست"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return reservation, true
+}
+
+// commitTokens تسویه رزرو با هزینه واقعی درخواست
+func commitTokens(reservation *database.TokenReservation, result *services.QueryResult) {
+	if err := tokenService.CommitReservation(reservation.ID, result.Feature, result.Credits, result.Reference); err != nil {
+		log.Printf("❌ خطا در تسویه رزرو %d: %v", reservation.ID, err)
+	}
+}
+
+// releaseTokens آزاد کردن رزرو درخواست ناموفق
+func releaseTokens(reservation *database.TokenReservation, reason string) {
+	if err := tokenService.ReleaseReservation(reservation.ID, reason); err != nil {
+		log.Printf("❌ خطا در آزادسازی رزرو %d: %v", reservation.ID, err)
+	}
+}
+
+// usageResponse مصرف توکن و اعتبار یک درخواست برای پاسخ API
+func usageResponse(result *services.QueryResult) gin.H {
+	return gin.H{
+		"model":             result.Model,
+		"prompt_tokens":     result.PromptTokens,
+		"completion_tokens": result.CompletionTokens,
+		"estimated":         result.UsageEstimated,
+		"feature":           result.Feature,
+		"credits":           result.Credits,
+	}
+}
+
+// streamAIQuery ارسال پاسخ AI به‌صورت Server-Sent Events
+func streamAIQuery(c *gin.Context, userID uint, question string, opts services.QueryOptions, reservation *database.TokenReservation) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	opts.OnDelta = func(chunk string) {
+		c.SSEvent("delta", gin.H{"content": chunk})
+		c.Writer.Flush()
+	}
+
+	result, err := aiService.QueryAIWithOptions(c.Request.Context(), userID, question, opts)
+	if err != nil && result == nil {
+		log.Printf("❌ خطا در AI query: %v", err)
+		releaseTokens(reservation, "خطای AI")
+		c.SSEvent("error", gin.H{"error": services.AIErrorMessage(err)})
+		c.Writer.Flush()
+		return
+	}
+
+	if err != nil {
+		// پاسخ دریافت شده ولی ذخیره نشد؛ مصرف همچنان محاسبه می‌شود
+		log.Printf("⚠️  %v", err)
+	}
+
+	// تسویه رزرو بر اساس مصرف واقعی
+	commitTokens(reservation, result)
+
+	c.SSEvent("done", gin.H{
+		"response":  result.Content,
+		"thread_id": opts.ThreadID,
+		"usage":     usageResponse(result),
+	})
+	c.Writer.Flush()
+}
+
+// respondAIError تبدیل خطای سرویس AI به پاسخ HTTP مناسب
+func respondAIError(c *gin.Context, err error) {
+	if errors.Is(err, services.ErrFeatureCapReached) || errors.Is(err, services.ErrModelNotAllowed) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	status := http.StatusInternalServerError
+
+	var aiErr *services.AIError
+	if errors.As(err, &aiErr) {
+		switch aiErr.Kind {
+		case services.AIErrorRateLimited, services.AIErrorCircuitOpen:
+			status = http.StatusServiceUnavailable
+		case services.AIErrorTimeout:
+			status = http.StatusGatewayTimeout
+		case services.AIErrorServer, services.AIErrorNetwork, services.AIErrorInvalidResponse, services.AIErrorAuth:
+			status = http.StatusBadGateway
+		}
+
+		if aiErr.RetryAfter > 0 {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(aiErr.RetryAfter.Seconds()))))
+		}
+	}
+
+	c.JSON(status, gin.H{"error": services.AIErrorMessage(err)})
+}
+
+// analyzeCode تحلیل کد
+func analyzeCode(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	var req struct {
+		Code     string `json:"code" binding:"required"`
+		Language string `json:"language" binding:"required"`
+		Filename string `json:"filename" binding:"required"`
+		Provider string `json:"provider"`
+	}
+
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// بررسی حجم کد بر اساس پلن کاربر، مانند آپلود فایل در ربات
+	plan, err := planService.GetUserPlan(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در دریافت پلن کاربر"})
+		return
+	}
+	if len(req.Code) > plan.MaxFileSizeMB*1024*1024 {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error":            fmt.Sprintf("حداکثر حجم کد در پلن شما %d مگابایت است", plan.MaxFileSizeMB),
+			"max_file_size_mb": plan.MaxFileSizeMB,
+		})
+		return
+	}
+
+	// رزرو اعتبار پیش از ارسال
+	reservation, ok := reserveTokens(c, userID, aiService.EstimateAnalysisCredits(userID, req.Code, ""))
+	if !ok {
+		return
+	}
+
+	// تحلیل کد
+	result, err := aiService.AnalyzeCodeWithOptions(c.Request.Context(), userID, req.Code, req.Language, req.Filename, services.QueryOptions{Provider: req.Provider})
+	if err != nil && result == nil {
+		log.Printf("❌ خطا در تحلیل کد: %v", err)
+		releaseTokens(reservation, "خطای AI")
+		respondAIError(c, err)
+		return
+	}
+
+	if err != nil {
+		// پاسخ دریافت شده ولی ذخیره نشد؛ مصرف همچنان محاسبه می‌شود
+		log.Printf("⚠️  %v", err)
+	}
+
+	// تسویه رزرو بر اساس مصرف واقعی
+	commitTokens(reservation, result)
+
+	c.JSON(http.StatusOK, gin.H{
+		"original": req.Code,
+		"fixed":    result.Content,
+		"usage":    usageResponse(result),
+	})
+}
+
+// createSupportTicket ایجاد تیکت پشتیبانی
+func createSupportTicket(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	var req struct {
+		Message string `json:"message" binding:"required"`
+	}
+
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// ایجاد تیکت
+	ticket := database.SupportMessage{
+		UserID:     userID,
+		Message:    req.Message,
+		SenderType: "user",
+	}
+
+	if err := database.DB.Create(&ticket).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در ایجاد تیکت"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ticket_id": ticket.ID,
+		"message":   "تیکت با موفقیت ایجاد شد",
+	})
+}
+
+// getSupportTicket دریافت تیکت
+func getSupportTicket(c *gin.Context) {
+	ticketID := c.Param("id")
+
+	var ticket database.SupportMessage
+	if err := database.DB.First(&ticket, ticketID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "تیکت یافت نشد"})
+		return
+	}
+
+	c.JSON(http.StatusOK, ticket)
+}
+
+// adminGetUsers دریافت تمام کاربران
+func adminGetUsers(c *gin.Context) {
+	users, total, err := userService.GetAllUsers(100, 0)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"users": users,
+		"total": total,
+	})
+}
+
+// adminGetUser دریافت کاربر
+func adminGetUser(c *gin.Context) {
+	userID := c.Param("id")
+
+	var user database.User
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "کاربر یافت نشد"})
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// adminImportUsers وارد کردن کاربران
+func adminImportUsers(c *gin.Context) {
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "فایل الزامی است"})
+		return
+	}
+
+	filePath := fmt.Sprintf("./data/uploads/%s", file.Filename)
+	if err := c.SaveUploadedFile(file, filePath); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در ذخیره فایل"})
+		return
+	}
+
+	imported, errs, err := userService.ImportUsers(filePath)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"imported": imported,
+		"errors":   errs,
+	})
+}
+
+// adminUpdateTokens به‌روزرسانی توکن‌ها
+func adminUpdateTokens(c *gin.Context) {
+	userID := c.Param("id")
+
+	var req struct {
+		Amount    int    `json:"amount"`
+		Unlimited bool   `json:"unlimited"`
+		Note      string `json:"note"` // دلیل تغییر برای دفتر توکن
+	}
+
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user database.User
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "کاربر یافت نشد"})
+		return
+	}
+
+	var err error
+	switch {
+	case req.Unlimited:
+		err = tokenService.SetUnlimitedTokens(user.ID, true)
+	case user.UnlimitedTokens:
+		if err = tokenService.SetUnlimitedTokens(user.ID, false); err == nil {
+			err = tokenService.SetTokenBalance(user.ID, req.Amount, adminActor(c), req.Note)
+		}
+	default:
+		err = tokenService.SetTokenBalance(user.ID, req.Amount, adminActor(c), req.Note)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "توکن‌ها به‌روزرسانی شدند"})
+}
+
+// adminGetUserTransactions دریافت دفتر توکن یک کاربر
+func adminGetUserTransactions(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "شناسه کاربر نامعتبر است"})
+		return
+	}
+
+	respondTransactions(c, uint(userID))
+}
+
+// adminDeleteUser حذف کاربر
+func adminDeleteUser(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "شناسه کاربر نامعتبر است"})
+		return
+	}
+
+	if err := userService.DeleteUser(uint(userID)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "کاربر حذف شد"})
+}
+
+// adminGetConversations دریافت گفتگوها
+func adminGetConversations(c *gin.Context) {
+	var conversations []database.Conversation
+	if err := database.DB.Limit(100).Find(&conversations).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"conversations": conversations,
+	})
+}
+
+// adminGetAnalytics دریافت آنالیتیکس
+func adminGetAnalytics(c *gin.Context) {
+	var userCount int64
+	var conversationCount int64
+	var codeAnalysisCount int64
+
+	database.DB.Model(&database.User{}).Count(&userCount)
+	database.DB.Model(&database.Conversation{}).Count(&conversationCount)
+	database.DB.Model(&database.CodeAnalysis{}).Count(&codeAnalysisCount)
+
+	_, usage, err := usageService.GetUsageReport(time.Time{})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"total_users":         userCount,
+		"total_conversations": conversationCount,
+		"total_code_analysis": codeAnalysisCount,
+		"usage":               usage,
+	})
+}
+
+// adminGetPlans دریافت پلن‌های اشتراک
+func adminGetPlans(c *gin.Context) {
+	plans, err := planService.GetPlans()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"plans": plans,
+	})
+}
+
+// adminSavePlan ایجاد یا به‌روزرسانی پلن
+func adminSavePlan(c *gin.Context) {
+	var req struct {
+		Name          string `json:"name" binding:"required"`
+		Title         string `json:"title" binding:"required"`
+		DailyTokens   int    `json:"daily_tokens"`
+		MonthlyTokens int    `json:"monthly_tokens"`
+		Unlimited     bool   `json:"unlimited"`
+		AllowedModels string `json:"allowed_models"`
+		MaxFileSizeMB int    `json:"max_file_size_mb"`
+		Priority      int    `json:"priority"`
+	}
+
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	plan := database.Plan{
+		Name:          req.Name,
+		Title:         req.Title,
+		DailyTokens:   req.DailyTokens,
+		MonthlyTokens: req.MonthlyTokens,
+		Unlimited:     req.Unlimited,
+		AllowedModels: req.AllowedModels,
+		MaxFileSizeMB: req.MaxFileSizeMB,
+		Priority:      req.Priority,
+	}
+
+	if err := planService.SavePlan(&plan); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, plan)
+}
+
+// adminAssignPlan تخصیص پلن به کاربر
+func adminAssignPlan(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "شناسه کاربر نامعتبر است"})
+		return
+	}
+
+	var req struct {
+		Plan      string    `json:"plan" binding:"required"`
+		StartsAt  time.Time `json:"starts_at"`  // خالی یعنی از همین حالا
+		ExpiresAt time.Time `json:"expires_at"` // خالی یعنی بدون انقضا
+	}
+
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := planService.AssignPlan(uint(userID), req.Plan, req.StartsAt, req.ExpiresAt, adminActor(c)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "پلن کاربر به‌روزرسانی شد"})
+}
+
+// adminGetUserSessions دریافت نشست‌های فعال یک کاربر
+func adminGetUserSessions(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "شناسه کاربر نامعتبر است"})
+		return
+	}
+
+	respondSessions(c, uint(userID))
+}
+
+// adminRevokeUserSessions باطل کردن همه نشست‌های یک کاربر
+func adminRevokeUserSessions(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "شناسه کاربر نامعتبر است"})
+		return
+	}
+
+	revoked, err := authService.RevokeAllSessions(uint(userID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "نشست‌های کاربر باطل شد",
+		"revoked": revoked,
+	})
+}
+
+// respondSessions پاسخ فهرست نشست‌های فعال کاربر
+func respondSessions(c *gin.Context, userID uint) {
+	sessions, err := authService.GetActiveSessions(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	current := c.GetUint("session_id")
+	items := make([]gin.H, 0, len(sessions))
+	for _, session := range sessions {
+		items = append(items, gin.H{
+			"id":           session.ID,
+			"user_agent":   session.UserAgent,
+			"ip":           session.IP,
+			"created_at":   session.CreatedAt,
+			"last_used_at": session.LastUsedAt,
+			"expires_at":   session.ExpiresAt,
+			"current":      session.UserID == c.GetUint("user_id") && session.ID == current,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessions": items})
+}
+
+// adminGetUserAPIKeys دریافت کلیدهای API یک کاربر
+func adminGetUserAPIKeys(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "شناسه کاربر نامعتبر است"})
+		return
+	}
+
+	respondAPIKeys(c, uint(userID))
+}
+
+// adminCreateUserAPIKey صدور کلید API برای کاربر با محدودیت نرخ اختصاصی
+func adminCreateUserAPIKey(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "شناسه کاربر نامعتبر است"})
+		return
+	}
+
+	var req apiKeyRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	issueAPIKey(c, uint(userID), c.GetUint("user_id"), &services.APIKeyRequest{
+		Name:       req.Name,
+		Scopes:     req.Scopes,
+		ExpiresAt:  req.ExpiresAt,
+		RatePerMin: req.RatePerMin,
+		RateBurst:  req.RateBurst,
+
+		IssuerPermissions: c.GetStringSlice("permissions"),
+	})
+}
+
+// adminRevokeAPIKey ابطال کلید API هر کاربر
+func adminRevokeAPIKey(c *gin.Context) {
+	keyID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "شناسه کلید نامعتبر است"})
+		return
+	}
+
+	if err := apiKeyService.RevokeKey(uint(keyID), 0); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "کلید API باطل شد"})
+}
+
+// adminLogin ورود پنل مدیریت با نام کاربری، رمز عبور و در صورت فعال بودن، کد TOTP
+func adminLogin(c *gin.Context) {
+	var req struct {
+		Username string `json:"username" binding:"required"`
+		Password string `json:"password" binding:"required"`
+		TOTPCode string `json:"totp_code"`
+	}
+
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	subject := services.LoginSubject{Flow: services.LoginFlowAdmin, Username: req.Username, IP: c.ClientIP()}
+	if !checkLoginGuard(c, subject) {
+		return
+	}
+
+	token, account, err := adminAuth.Login(req.Username, req.Password, req.TOTPCode)
+	if errors.Is(err, services.ErrAdminTOTPRequired) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error(), "totp_required": true})
+		return
+	}
+	if errors.Is(err, services.ErrAdminInvalidCredentials) {
+		loginGuard.RecordFailure(subject, services.LoginReasonInvalidCredentials)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, services.ErrAdminTOTPInvalid) {
+		loginGuard.RecordFailure(subject, services.LoginReasonInvalidTOTP)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در ورود"})
+		return
+	}
+	loginGuard.RecordSuccess(subject, account.UserID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":      token,
+		"token_type": "Bearer",
+		"expires_in": config.AppConfig.AdminTokenTTLMinutes * 60,
+		"account": gin.H{
+			"id":           account.ID,
+			"username":     account.Username,
+			"role":         account.Role,
+			"totp_enabled": account.TOTPEnabled,
 		},
 	})
 }
 
-// logout خروج
-func logout(c *gin.Context) {
+// adminLogout ابطال token پنل مدیریت
+func adminLogout(c *gin.Context) {
+	claims, _ := c.MustGet("admin_claims").(*services.AdminClaims)
+	if err := adminAuth.Logout(claims); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در خروج"})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "خروج موفق"})
 }
 
-// getUserProfile دریافت پروفایل کاربر
-func getUserProfile(c *gin.Context) {
-	userID := c.GetUint("user_id")
-
-	user, err := userService.GetUser(userID)
+// adminSetupTOTP ایجاد کلید تایید دومرحله‌ای برای اسکن در برنامه احراز هویت
+func adminSetupTOTP(c *gin.Context) {
+	secret, uri, err := adminAuth.SetupTOTP(c.GetUint("admin_id"))
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "کاربر یافت نشد"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	tokens, _ := tokenService.GetUserTokens(userID)
-
 	c.JSON(http.StatusOK, gin.H{
-		"id":         user.ID,
-		"full_name":  user.FullName,
-		"phone":      user.PhoneNumber,
-		"tokens":     tokens,
-		"created_at": user.CreatedAt,
+		"secret":      secret,
+		"otpauth_uri": uri,
+		"message":     "کد برنامه احراز هویت را برای فعال‌سازی ارسال کنید",
 	})
 }
 
-// getUserTokens دریافت توکن‌های کاربر
-func getUserTokens(c *gin.Context) {
-	userID := c.GetUint("user_id")
+// adminConfirmTOTP فعال‌سازی تایید دومرحله‌ای
+func adminConfirmTOTP(c *gin.Context) {
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
 
-	tokens, err := tokenService.GetUserTokens(userID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"tokens": tokens,
-	})
-}
-
-// getUserConversations دریافت گفتگوهای کاربر
-func getUserConversations(c *gin.Context) {
-	userID := c.GetUint("user_id")
-
-	conversations, err := aiService.GetConversationHistory(userID, 50)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := adminAuth.ConfirmTOTP(c.GetUint("admin_id"), req.Code); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"conversations": conversations,
-	})
+	c.JSON(http.StatusOK, gin.H{"message": "تایید دومرحله‌ای فعال شد"})
 }
 
-// aiQuery پرس‌وجو از AI
-func aiQuery(c *gin.Context) {
-	userID := c.GetUint("user_id")
-
+// adminDisableTOTP غیرفعال کردن تایید دومرحله‌ای
+func adminDisableTOTP(c *gin.Context) {
 	var req struct {
-		Question string `json:"question" binding:"required"`
+		Password string `json:"password" binding:"required"`
+		Code     string `json:"code" binding:"required"`
 	}
 
 	if err := c.BindJSON(&req); err != nil {
@@ -120,37 +1302,46 @@ func aiQuery(c *gin.Context) {
 		return
 	}
 
-	// بررسی توکن
-	tokens, _ := tokenService.GetUserTokens(userID)
-	if tokens <= 0 {
-		c.JSON(http.StatusForbidden, gin.H{"error": "موجودی توکن کافی نیست"})
+	if err := adminAuth.DisableTOTP(c.GetUint("admin_id"), req.Password, req.Code); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// ارسال به AI
-	response, err := aiService.QueryAI(userID, req.Question)
+	c.JSON(http.StatusOK, gin.H{"message": "تایید دومرحله‌ای غیرفعال شد"})
+}
+
+// adminGetAccounts دریافت حساب‌های پنل مدیریت
+func adminGetAccounts(c *gin.Context) {
+	accounts, err := adminAuth.GetAccounts()
 	if err != nil {
-		log.Printf("❌ خطا در AI query: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در پردازش درخواست"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	// کسر توکن
-	_ = tokenService.DeductTokens(userID, 1)
+	result := make([]gin.H, 0, len(accounts))
+	for _, account := range accounts {
+		result = append(result, gin.H{
+			"id":            account.ID,
+			"username":      account.Username,
+			"role":          account.Role,
+			"user_id":       account.UserID,
+			"totp_enabled":  account.TOTPEnabled,
+			"disabled":      account.Disabled,
+			"last_login_at": account.LastLoginAt,
+			"created_at":    account.CreatedAt,
+		})
+	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"response": response,
-	})
+	c.JSON(http.StatusOK, gin.H{"accounts": result})
 }
 
-// analyzeCode تحلیل کد
-func analyzeCode(c *gin.Context) {
-	userID := c.GetUint("user_id")
-
+// adminCreateAccount ایجاد حساب پنل مدیریت
+func adminCreateAccount(c *gin.Context) {
 	var req struct {
-		Code     string `json:"code" binding:"required"`
-		Language string `json:"language" binding:"required"`
-		Filename string `json:"filename" binding:"required"`
+		Username string `json:"username" binding:"required"`
+		Password string `json:"password" binding:"required"`
+		Role     string `json:"role" binding:"required"`
+		UserID   uint   `json:"user_id"`
 	}
 
 	if err := c.BindJSON(&req); err != nil {
@@ -158,134 +1349,161 @@ func analyzeCode(c *gin.Context) {
 		return
 	}
 
-	// بررسی توکن
-	tokens, _ := tokenService.GetUserTokens(userID)
-	if tokens <= 0 {
-		c.JSON(http.StatusForbidden, gin.H{"error": "موجودی توکن کافی نیست"})
-		return
-	}
-
-	// تحلیل کد
-	original, fixed, err := aiService.AnalyzeCode(userID, req.Code, req.Language, req.Filename)
+	account, err := adminAuth.CreateAccount(req.Username, req.Password, req.Role, req.UserID)
 	if err != nil {
-		log.Printf("❌ خطا در تحلیل کد: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در پردازش درخواست"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// کسر توکن
-	_ = tokenService.DeductTokens(userID, 1)
-
 	c.JSON(http.StatusOK, gin.H{
-		"original": original,
-		"fixed":    fixed,
+		"message": "حساب مدیریت ایجاد شد",
+		"account": gin.H{"id": account.ID, "username": account.Username, "role": account.Role},
 	})
 }
 
-// createSupportTicket ایجاد تیکت پشتیبانی
-func createSupportTicket(c *gin.Context) {
-	userID := c.GetUint("user_id")
+// adminGetLockouts دریافت قفل‌های ورود فعال و کلیدهای دارای خطای اخیر
+func adminGetLockouts(c *gin.Context) {
+	lockouts, err := loginGuard.GetLockouts()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
-	var req struct {
-		Message string `json:"message" binding:"required"`
+	now := time.Now()
+	result := make([]gin.H, 0, len(lockouts))
+	for _, lockout := range lockouts {
+		result = append(result, gin.H{
+			"id":              lockout.ID,
+			"key":             lockout.LockKey,
+			"failures":        lockout.Failures,
+			"lock_count":      lockout.LockCount,
+			"locked":          lockout.LockedUntil.After(now),
+			"locked_until":    lockout.LockedUntil,
+			"last_failure_at": lockout.LastFailureAt,
+		})
 	}
 
-	if err := c.BindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	c.JSON(http.StatusOK, gin.H{"lockouts": result})
+}
+
+// adminUnlockLogin برداشتن قفل ورود
+func adminUnlockLogin(c *gin.Context) {
+	lockoutID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "شناسه قفل نامعتبر است"})
 		return
 	}
 
-	// ایجاد تیکت
-	ticket := database.SupportMessage{
-		UserID:     userID,
-		Message:    req.Message,
-		SenderType: "user",
+	unlockedBy := fmt.Sprintf("user:%d", c.GetUint("user_id"))
+	if adminID := c.GetUint("admin_id"); adminID != 0 {
+		unlockedBy = fmt.Sprintf("account:%d", adminID)
 	}
 
-	if err := database.DB.Create(&ticket).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در ایجاد تیکت"})
+	if err := loginGuard.Unlock(uint(lockoutID), unlockedBy); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"ticket_id": ticket.ID,
-		"message":   "تیکت با موفقیت ایجاد شد",
-	})
+	c.JSON(http.StatusOK, gin.H{"message": "قفل ورود برداشته شد"})
 }
 
-// getSupportTicket دریافت تیکت
-func getSupportTicket(c *gin.Context) {
-	ticketID := c.Param("id")
-
-	var ticket database.SupportMessage
-	if err := database.DB.First(&ticket, ticketID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "تیکت یافت نشد"})
+// adminGetLoginAttempts سابقه تلاش‌های ورود با فیلتر شماره، نام کاربری، IP یا چت
+func adminGetLoginAttempts(c *gin.Context) {
+	chatID, _ := strconv.ParseInt(c.Query("chat_id"), 10, 64)
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+
+	attempts, err := loginGuard.GetAttempts(services.LoginAttemptFilter{
+		Phone:    c.Query("phone"),
+		Username: c.Query("username"),
+		IP:       c.Query("ip"),
+		ChatID:   chatID,
+		Limit:    limit,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, ticket)
+	c.JSON(http.StatusOK, gin.H{"attempts": attempts})
 }
 
-// adminGetUsers دریافت تمام کاربران
-func adminGetUsers(c *gin.Context) {
-	users, total, err := userService.GetAllUsers(100, 0)
+// adminGetRoles دریافت نقش‌ها و فهرست مجوزهای معتبر
+func adminGetRoles(c *gin.Context) {
+	roles, err := rbacService.GetRoles()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"users": users,
-		"total": total,
+		"roles":       roles,
+		"permissions": rbacService.GetPermissionTitles(),
 	})
 }
 
-// adminGetUser دریافت کاربر
-func adminGetUser(c *gin.Context) {
-	userID := c.Param("id")
+// adminSaveRole ایجاد یا به‌روزرسانی نقش
+func adminSaveRole(c *gin.Context) {
+	var req struct {
+		Name        string   `json:"name" binding:"required"`
+		Title       string   `json:"title" binding:"required"`
+		Permissions []string `json:"permissions"`
+	}
 
-	var user database.User
-	if err := database.DB.First(&user, userID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "کاربر یافت نشد"})
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, user)
+	role := database.Role{
+		Name:        req.Name,
+		Title:       req.Title,
+		Permissions: strings.Join(req.Permissions, ","),
+	}
+
+	if err := rbacService.SaveRole(&role); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"role": role})
 }
 
-// adminImportUsers وارد کردن کاربران
-func adminImportUsers(c *gin.Context) {
-	file, err := c.FormFile("file")
+// adminGetUserRoles دریافت نقش‌ها و مجوزهای کاربر
+func adminGetUserRoles(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 64)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "فایل الزامی است"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "شناسه کاربر نامعتبر است"})
 		return
 	}
 
-	filePath := fmt.Sprintf("./data/uploads/%s", file.Filename)
-	if err := c.SaveUploadedFile(file, filePath); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "خطا در ذخیره فایل"})
+	roles, err := rbacService.GetUserRoles(uint(userID))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 		return
 	}
 
-	imported, errs, err := userService.ImportUsers(filePath)
+	permissions, err := rbacService.GetUserPermissions(uint(userID))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"imported": imported,
-		"errors":   errs,
+		"roles":       roles,
+		"permissions": permissions,
 	})
 }
 
-// adminUpdateTokens به‌روزرسانی توکن‌ها
-func adminUpdateTokens(c *gin.Context) {
-	userID := c.Param("id")
+// adminAssignRoles جایگزینی نقش‌های کاربر
+func adminAssignRoles(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "شناسه کاربر نامعتبر است"})
+		return
+	}
 
 	var req struct {
-		Amount    int  `json:"amount"`
-		Unlimited bool `json:"unlimited"`
+		Roles []string `json:"roles"`
 	}
 
 	if err := c.BindJSON(&req); err != nil {
@@ -293,64 +1511,120 @@ func adminUpdateTokens(c *gin.Context) {
 		return
 	}
 
-	var user database.User
-	if err := database.DB.First(&user, userID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "کاربر یافت نشد"})
+	if err := rbacService.AssignRoles(uint(userID), req.Roles); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	if req.Unlimited {
-		_ = tokenService.SetUnlimitedTokens(user.ID, true)
-	} else {
-		user.DailyTokens = req.Amount
-		_ = database.DB.Save(&user)
-	}
-
-	c.JSON(http.StatusOK, gin.H{"message": "توکن‌ها به‌روزرسانی شدند"})
+	c.JSON(http.StatusOK, gin.H{"message": "نقش‌های کاربر به‌روزرسانی شد"})
 }
 
-// adminDeleteUser حذف کاربر
-func adminDeleteUser(c *gin.Context) {
-	userID := c.Param("id")
+// adminGetUsage گزارش مصرف توکن و اعتبار به تفکیک کاربر
+func adminGetUsage(c *gin.Context) {
+	days, _ := strconv.Atoi(c.DefaultQuery("days", "30"))
+
+	var since time.Time
+	if days > 0 {
+		since = time.Now().AddDate(0, 0, -days)
+	}
 
-	if err := userService.DeleteUser(uint(c.GetInt64("user_id"))); err != nil {
+	users, total, err := usageService.GetUsageReport(since)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "کاربر حذف شد"})
+	c.JSON(http.StatusOK, gin.H{
+		"days":  days,
+		"total": total,
+		"users": users,
+	})
 }
 
-// adminGetConversations دریافت گفتگوها
-func adminGetConversations(c *gin.Context) {
-	var conversations []database.Conversation
-	if err := database.DB.Limit(100).Find(&conversations).Error; err != nil {
+// adminGetPricing دریافت جدول نرخ اعتبار مدل‌ها
+func adminGetPricing(c *gin.Context) {
+	pricing, err := pricingService.GetAllPricing()
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"conversations": conversations,
+		"pricing": pricing,
+		"default": gin.H{
+			"prompt_credits_per_1k":     config.AppConfig.PromptCreditsPer1K,
+			"completion_credits_per_1k": config.AppConfig.CompletionCreditsPer1K,
+			"min_credits_per_request":   config.AppConfig.MinCreditsPerRequest,
+		},
 	})
 }
 
-// adminGetAnalytics دریافت آنالیتیکس
-func adminGetAnalytics(c *gin.Context) {
-	var userCount int64
-	var conversationCount int64
-	var codeAnalysisCount int64
+// adminUpdatePricing ثبت یا به‌روزرسانی نرخ اعتبار یک مدل
+func adminUpdatePricing(c *gin.Context) {
+	var req struct {
+		Model                  string  `json:"model" binding:"required"`
+		PromptCreditsPer1K     float64 `json:"prompt_credits_per_1k"`
+		CompletionCreditsPer1K float64 `json:"completion_credits_per_1k"`
+	}
 
-	database.DB.Model(&database.User{}).Count(&userCount)
-	database.DB.Model(&database.Conversation{}).Count(&conversationCount)
-	database.DB.Model(&database.CodeAnalysis{}).Count(&codeAnalysisCount)
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := pricingService.SetPricing(req.Model, req.PromptCreditsPer1K, req.CompletionCreditsPer1K); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "نرخ مدل به‌روزرسانی شد"})
+}
+
+// adminDeletePricing حذف نرخ اختصاصی یک مدل
+func adminDeletePricing(c *gin.Context) {
+	if err := pricingService.DeletePricing(c.Param("model")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
+	c.JSON(http.StatusOK, gin.H{"message": "نرخ مدل حذف شد"})
+}
+
+// adminGetFeatures دریافت هزینه و سقف روزانه قابلیت‌ها
+func adminGetFeatures(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
-		"total_users":         userCount,
-		"total_conversations": conversationCount,
-		"total_code_analysis": codeAnalysisCount,
+		"features": featureService.GetAllFeatureCosts(),
 	})
 }
 
+// adminUpdateFeature تنظیم هزینه و سقف روزانه یک قابلیت
+func adminUpdateFeature(c *gin.Context) {
+	var req struct {
+		Feature     string  `json:"feature" binding:"required"`
+		BaseCredits int     `json:"base_credits"`
+		Multiplier  float64 `json:"multiplier"`
+		DailyCap    int     `json:"daily_cap"`
+	}
+
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	cost := database.FeaturePricing{
+		Feature:     req.Feature,
+		BaseCredits: req.BaseCredits,
+		Multiplier:  req.Multiplier,
+		DailyCap:    req.DailyCap,
+	}
+	if err := featureService.SetFeatureCost(&cost); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, cost)
+}
+
 // adminAddSupport افزودن پشتیبان
 func adminAddSupport(c *gin.Context) {
 	var req struct {
@@ -394,8 +1668,8 @@ func adminDeleteSupport(c *gin.Context) {
 // adminUpdateSettings به‌روزرسانی تنظیمات
 func adminUpdateSettings(c *gin.Context) {
 	var req struct {
-		Key   string `json:"key" binding:"required"`
-		Value string `json:"value" binding:"required"`
+		Key   string  `json:"key" binding:"required"`
+		Value *string `json:"value" binding:"required"` // مقدار خالی برای پاک کردن مجاز است
 	}
 
 	if err := c.BindJSON(&req); err != nil {
@@ -403,16 +1677,57 @@ func adminUpdateSettings(c *gin.Context) {
 		return
 	}
 
-	setting := database.Setting{
-		Key:   req.Key,
-		Value: req.Value,
+	if err := settingService.SetSetting(req.Key, *req.Value); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
 
-	database.DB.Save(&setting)
-
 	c.JSON(http.StatusOK, gin.H{"message": "تنظیمات به‌روزرسانی شدند"})
 }
 
+// adminGetSettings دریافت تنظیمات
+func adminGetSettings(c *gin.Context) {
+	settings, err := settingService.GetAllSettings()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"settings": settings,
+	})
+}
+
+// adminUpdateUserModel تنظیم مدل اختصاصی کاربر
+func adminUpdateUserModel(c *gin.Context) {
+	userID := c.Param("id")
+
+	var req struct {
+		Model string `json:"model"` // خالی یعنی بازگشت به تنظیمات عمومی
+	}
+
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user database.User
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "کاربر یافت نشد"})
+		return
+	}
+
+	if err := database.DB.Model(&user).Update("ai_model", req.Model).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "مدل کاربر به‌روزرسانی شد",
+		"resolved_model": aiService.ResolveModel(user.ID),
+	})
+}
+
 // supportGetTickets دریافت تیکت‌های پشتیبانی
 func supportGetTickets(c *gin.Context) {
 	var tickets []database.SupportMessage
@@ -9,6 +9,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"telegram-bot/config"
+	"telegram-bot/services"
 )
 
 var (
@@ -21,6 +22,13 @@ func InitServer() {
 	gin.SetMode(gin.ReleaseMode)
 	Engine = gin.New()
 
+	// بدون پراکسی مورد اعتماد، ClientIP همان آدرس اتصال است و X-Forwarded-For جعلی
+	// نمی‌تواند محدودیت نرخ و قفل ورود بر اساس IP را دور بزند
+	if err := Engine.SetTrustedProxies(config.AppConfig.TrustedProxies); err != nil {
+		log.Printf("⚠️  خطا در تنظیم پراکسی‌های مورد اعتماد، هیچ پراکسی‌ای پذیرفته نمی‌شود: %v", err)
+		Engine.SetTrustedProxies(nil)
+	}
+
 	// Middlewares
 	Engine.Use(gin.Logger())
 	Engine.Use(gin.Recovery())
@@ -49,6 +57,14 @@ func StopServer(timeout time.Duration) error {
 	return Server.Shutdown(ctx)
 }
 
+// apiKeyScopes routeهای قابل دسترسی با کلید API و scope لازم هر کدام؛ بقیه routeهای کاربر فقط با JWT
+var apiKeyScopes = map[string]string{
+	"POST /api/v1/ai/query":        services.ScopeAIQuery,
+	"POST /api/v1/ai/analyze-code": services.ScopeAIAnalyze,
+	"GET /api/v1/user/profile":     services.ScopeProfileRead,
+	"GET /api/v1/user/tokens":      services.ScopeProfileRead,
+}
+
 // setupRoutes تنظیم routes
 func setupRoutes(engine *gin.Engine) {
 	// Health check
@@ -56,20 +72,45 @@ func setupRoutes(engine *gin.Engine) {
 
 	// Public routes
 	public := engine.Group("/api/v1")
+	public.Use(RateLimitMiddleware())
 	{
 		public.POST("/auth/login", login)
-		public.POST("/auth/logout", logout)
+		public.POST("/auth/verify-otp", verifyOTP)
+		public.POST("/auth/refresh", refreshToken)
+		public.GET("/payments/callback", paymentCallback)
 	}
 
 	// Protected routes
 	protected := engine.Group("/api/v1")
-	protected.Use(AuthMiddleware())
+	protected.Use(AuthMiddleware(), RateLimitMiddleware())
 	{
+		// Session routes
+		protected.POST("/auth/logout", logout)
+		protected.POST("/auth/logout-all", logoutAll)
+		protected.GET("/auth/sessions", getUserSessions)
+
+		// API key routes
+		protected.GET("/api-keys", getAPIKeys)
+		protected.POST("/api-keys", createAPIKey)
+		protected.DELETE("/api-keys/:id", revokeAPIKey)
+
 		// User routes
 		protected.GET("/user/profile", getUserProfile)
 		protected.GET("/user/tokens", getUserTokens)
+		protected.GET("/user/tokens/transactions", getUserTransactions)
+		protected.POST("/user/tokens/transfer", transferTokens)
 		protected.GET("/user/conversations", getUserConversations)
 
+		// Payment routes
+		protected.GET("/payments/packs", getTokenPacks)
+		protected.POST("/payments/purchase", purchaseTokenPack)
+		protected.GET("/payments", getUserPayments)
+
+		// Thread routes
+		protected.GET("/threads", getUserThreads)
+		protected.POST("/threads", createThread)
+		protected.GET("/threads/:id", getThread)
+
 		// AI routes
 		protected.POST("/ai/query", aiQuery)
 		protected.POST("/ai/analyze-code", analyzeCode)
@@ -79,31 +120,68 @@ func setupRoutes(engine *gin.Engine) {
 		protected.GET("/support/tickets/:id", getSupportTicket)
 	}
 
+	// Admin panel login
+	adminPublic := engine.Group("/api/v1/admin/auth")
+	adminPublic.Use(RateLimitMiddleware())
+	{
+		adminPublic.POST("/login", adminLogin)
+	}
+
 	// Admin routes
 	admin := engine.Group("/api/v1/admin")
-	admin.Use(AdminAuthMiddleware())
+	admin.Use(AdminAuthMiddleware(), RateLimitMiddleware())
 	{
-		admin.GET("/users", adminGetUsers)
-		admin.GET("/users/:id", adminGetUser)
-		admin.POST("/users/import", adminImportUsers)
-		admin.PUT("/users/:id/tokens", adminUpdateTokens)
-		admin.DELETE("/users/:id", adminDeleteUser)
-		admin.GET("/conversations", adminGetConversations)
-		admin.GET("/analytics", adminGetAnalytics)
-		admin.POST("/support/add", adminAddSupport)
-		admin.DELETE("/support/:id", adminDeleteSupport)
-		admin.PUT("/settings", adminUpdateSettings)
+		admin.POST("/auth/logout", RequireAdminAccount(), adminLogout)
+		admin.POST("/auth/totp/setup", RequireAdminAccount(), adminSetupTOTP)
+		admin.POST("/auth/totp/confirm", RequireAdminAccount(), adminConfirmTOTP)
+		admin.POST("/auth/totp/disable", RequireAdminAccount(), adminDisableTOTP)
+		admin.GET("/accounts", RequirePermission(services.PermRolesRead), adminGetAccounts)
+		admin.POST("/accounts", RequirePermission(services.PermRolesWrite), adminCreateAccount)
+		admin.GET("/security/lockouts", RequirePermission(services.PermUsersRead), adminGetLockouts)
+		admin.DELETE("/security/lockouts/:id", RequirePermission(services.PermUsersWrite), adminUnlockLogin)
+		admin.GET("/security/login-attempts", RequirePermission(services.PermUsersRead), adminGetLoginAttempts)
+		admin.GET("/users", RequirePermission(services.PermUsersRead), adminGetUsers)
+		admin.GET("/users/:id", RequirePermission(services.PermUsersRead), adminGetUser)
+		admin.POST("/users/import", RequirePermission(services.PermUsersWrite), adminImportUsers)
+		admin.PUT("/users/:id/tokens", RequirePermission(services.PermTokensWrite), adminUpdateTokens)
+		admin.GET("/users/:id/transactions", RequirePermission(services.PermUsersRead), adminGetUserTransactions)
+		admin.DELETE("/users/:id", RequirePermission(services.PermUsersWrite), adminDeleteUser)
+		admin.GET("/conversations", RequirePermission(services.PermUsersRead), adminGetConversations)
+		admin.GET("/analytics", RequirePermission(services.PermAnalyticsRead), adminGetAnalytics)
+		admin.GET("/usage", RequirePermission(services.PermAnalyticsRead), adminGetUsage)
+		admin.GET("/pricing", RequirePermission(services.PermBillingRead), adminGetPricing)
+		admin.PUT("/pricing", RequirePermission(services.PermBillingWrite), adminUpdatePricing)
+		admin.DELETE("/pricing/:model", RequirePermission(services.PermBillingWrite), adminDeletePricing)
+		admin.GET("/features", RequirePermission(services.PermBillingRead), adminGetFeatures)
+		admin.PUT("/features", RequirePermission(services.PermBillingWrite), adminUpdateFeature)
+		admin.POST("/support/add", RequirePermission(services.PermStaffWrite), adminAddSupport)
+		admin.DELETE("/support/:id", RequirePermission(services.PermStaffWrite), adminDeleteSupport)
+		admin.PUT("/users/:id/model", RequirePermission(services.PermUsersWrite), adminUpdateUserModel)
+		admin.PUT("/users/:id/plan", RequirePermission(services.PermUsersWrite), adminAssignPlan)
+		admin.GET("/plans", RequirePermission(services.PermBillingRead), adminGetPlans)
+		admin.PUT("/plans", RequirePermission(services.PermBillingWrite), adminSavePlan)
+		admin.GET("/settings", RequirePermission(services.PermSettingsRead), adminGetSettings)
+		admin.PUT("/settings", RequirePermission(services.PermSettingsWrite), adminUpdateSettings)
+		admin.GET("/roles", RequirePermission(services.PermRolesRead), adminGetRoles)
+		admin.PUT("/roles", RequirePermission(services.PermRolesWrite), adminSaveRole)
+		admin.GET("/users/:id/sessions", RequirePermission(services.PermUsersRead), adminGetUserSessions)
+		admin.DELETE("/users/:id/sessions", RequirePermission(services.PermUsersWrite), adminRevokeUserSessions)
+		admin.GET("/users/:id/api-keys", RequirePermission(services.PermUsersRead), adminGetUserAPIKeys)
+		admin.POST("/users/:id/api-keys", RequirePermission(services.PermAPIKeysWrite), adminCreateUserAPIKey)
+		admin.DELETE("/api-keys/:id", RequirePermission(services.PermAPIKeysWrite), adminRevokeAPIKey)
+		admin.GET("/users/:id/roles", RequirePermission(services.PermRolesRead), adminGetUserRoles)
+		admin.PUT("/users/:id/roles", RequirePermission(services.PermRolesWrite), adminAssignRoles)
 	}
 
 	// Support routes
 	support := engine.Group("/api/v1/support")
-	support.Use(SupportAuthMiddleware())
+	support.Use(SupportAuthMiddleware(), RateLimitMiddleware())
 	{
-		support.GET("/tickets", supportGetTickets)
-		support.PUT("/tickets/:id/status", supportUpdateTicketStatus)
-		support.POST("/tickets/:id/message", supportAddMessage)
-		support.GET("/profile", supportGetProfile)
-		support.PUT("/online-status", supportSetOnlineStatus)
+		support.GET("/tickets", RequirePermission(services.PermTicketsRead), supportGetTickets)
+		support.PUT("/tickets/:id/status", RequirePermission(services.PermTicketsWrite), supportUpdateTicketStatus)
+		support.POST("/tickets/:id/message", RequirePermission(services.PermTicketsReply), supportAddMessage)
+		support.GET("/profile", RequirePermission(services.PermTicketsRead), supportGetProfile)
+		support.PUT("/online-status", RequirePermission(services.PermTicketsReply), supportSetOnlineStatus)
 	}
 }
 
@@ -124,10 +202,21 @@ func CORSMiddleware() gin.HandlerFunc {
 	}
 }
 
-// healthCheck بررسی سلامت سرور
+// healthCheck بررسی سلامت سرور و backendهای AI
 func healthCheck(c *gin.Context) {
+	backends := services.AIHealthReport()
+
+	status := "degraded"
+	for _, backend := range backends {
+		if backend.Status != services.BackendDown {
+			status = "ok"
+			break
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"status": "ok",
-		"time":   time.Now(),
+		"status":      status,
+		"time":        time.Now(),
+		"ai_backends": backends,
 	})
 }
@@ -1,27 +1,39 @@
 package bot
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"telegram-bot/bot/fsm"
 	"telegram-bot/config"
 	"telegram-bot/database"
 	"telegram-bot/services"
 )
 
 var (
-	BotAPI       *tgbotapi.BotAPI
-	UserSessions map[int64]*UserSession
+	BotAPI   *tgbotapi.BotAPI
+	Sessions SessionStore
+
+	// runCtx context اجرای ربات؛ با StartBot تنظیم و هنگام shutdown لغو می‌شود
+	runCtx = context.Background()
 )
 
+// aiRequestTimeout سقف کل یک درخواست AI ربات، شامل تلاش دوباره و ارائه‌دهنده‌های جایگزین
+const aiRequestTimeout = 10 * time.Minute
+
 // UserSession جلسه کاربر
 type UserSession struct {
 	UserID       uint
-	State        string // "authenticated", "waiting_phone", "waiting_national_code", "in_chat", "in_support"
+	ThreadID     uint      // رشته گفتگوی فعال در حالت چت
+	State        string    // مرحله گفتگو؛ مراحل در dialogs.go تعریف شده‌اند
+	ActiveAt     time.Time // آخرین فعالیت در مرحله فعلی؛ مبنای مهلت مرحله
 	Phone        string
 	NationalCode string
 	FullName     string
+	OTPChallenge string // چالش کد ورود در انتظار تایید
 }
 
 // InitBot شروع ربات
@@ -33,69 +45,76 @@ func InitBot() error {
 	}
 
 	BotAPI.Debug = false
-	UserSessions = make(map[int64]*UserSession)
+
+	// بازگرداندن سشن‌ها تا کاربران در همان مرحله قبل از راه‌اندازی دوباره ادامه دهند
+	Sessions = NewSessionStore()
+	restored, err := Sessions.Restore()
+	if err != nil {
+		return fmt.Errorf("خطا در بازگرداندن سشن‌ها: %w", err)
+	}
+	log.Printf("✅ %d سشن ربات بازگردانده شد", restored)
 
 	log.Printf("✅ ربات %s با موفقیت شروع شد", BotAPI.Self.UserName)
 	return nil
 }
 
-// StartBot شروع دریافت پیام‌ها
-func StartBot() {
+// StartBot شروع دریافت پیام‌ها تا لغو ctx
+//
+// درخواست‌های AI در حال اجرا از ctx مشتق می‌شوند و با shutdown لغو می‌شوند.
+func StartBot(ctx context.Context) {
+	runCtx = ctx
+
 	u := tgbotapi.NewUpdate(0)
 	u.Timeout = 60
 
 	updates := BotAPI.GetUpdatesChan(u)
 
-	for update := range updates {
-		if update.Message != nil {
-			go handleMessage(&update)
-		} else if update.CallbackQuery != nil {
-			go handleCallback(&update)
+	for {
+		select {
+		case <-ctx.Done():
+			BotAPI.StopReceivingUpdates()
+			return
+		case update, ok := <-updates:
+			if !ok {
+				return
+			}
+			if update.Message != nil {
+				go handleMessage(&update)
+			} else if update.CallbackQuery != nil {
+				go handleCallback(&update)
+			}
 		}
 	}
 }
 
+// aiContext context یک درخواست AI؛ با shutdown ربات یا پایان مهلت لغو می‌شود
+func aiContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(runCtx, aiRequestTimeout)
+}
+
 // handleMessage مدیریت پیام‌ها
 func handleMessage(update *tgbotapi.Update) {
 	chatID := update.Message.Chat.ID
-	text := update.Message.Text
-
-	// دریافت یا ایجاد سشن
-	session, exists := UserSessions[chatID]
-	if !exists {
-		session = &UserSession{State: "not_authenticated"}
-		UserSessions[chatID] = session
-	}
 
-	// بررسی احراز هویت
-	if session.State == "not_authenticated" {
-		handleAuthentication(chatID, text, session, update)
+	if !RateLimitMiddleware(chatID) {
 		return
 	}
 
-	// مدیریت دستورات
-	if text == "/start" {
-		showMainMenu(chatID)
-		return
-	}
+	// updateهای هر چت به ترتیب پردازش می‌شوند
+	unlock := Sessions.Lock(chatID)
+	defer unlock()
 
-	if text == "/logout" {
-		logout(chatID, session)
-		return
+	// دریافت یا ایجاد سشن
+	session, exists := Sessions.Get(chatID)
+	if !exists {
+		session = &UserSession{State: string(stateNotAuthenticated)}
 	}
+	defer persistSession(chatID, session)
 
-	// بر اساس حالت
-	switch session.State {
-	case "waiting_phone":
-		handlePhoneInput(chatID, text, session)
-	case "waiting_national_code":
-		handleNationalCodeInput(chatID, text, session)
-	case "in_chat":
-		handleAIChat(chatID, text, session)
-	case "in_support":
-		handleSupportChat(chatID, text, session)
-	default:
-		showMainMenu(chatID)
+	// پردازش بر اساس مرحله گفتگو؛ ورودی‌های هر مرحله در dialogs.go تعریف شده‌اند
+	d := &dialog{chatID: chatID, session: session, update: update}
+	if _, err := dialogs.Handle(d, messageInput(update.Message)); err != nil {
+		log.Printf("❌ %v", err)
 	}
 }
 
@@ -105,21 +124,27 @@ func handleCallback(update *tgbotapi.Update) {
 	chatID := query.Message.Chat.ID
 	data := query.Data
 
-	session, exists := UserSessions[chatID]
+	unlock := Sessions.Lock(chatID)
+	defer unlock()
+
+	session, exists := Sessions.Get(chatID)
 	if !exists {
 		return
 	}
+	defer persistSession(chatID, session)
 
-	switch data {
-	case "profile":
-		showProfile(chatID, session)
-	case "start_chat":
-		startChat(chatID, session)
-	case "support":
-		startSupport(chatID, session)
-	case "back":
-		showMainMenu(chatID)
-	default:
+	// پاسخ callback دکمه را از حالت انتظار خارج می‌کند و زمان انتظار را نشان می‌دهد
+	if allowed, wait := allowRequest(chatID); !allowed {
+		BotAPI.AnswerCallbackQuery(tgbotapi.NewCallback(query.ID, cooldownText(wait)))
+		return
+	}
+
+	d := &dialog{chatID: chatID, session: session, update: update}
+	handled, err := dialogs.Handle(d, fsm.Input{Kind: fsm.InputCallback, Data: data})
+	if err != nil {
+		log.Printf("❌ %v", err)
+	}
+	if !handled {
 		log.Printf("⚠️  Callback نامشخص: %s", data)
 	}
 
@@ -127,14 +152,29 @@ func handleCallback(update *tgbotapi.Update) {
 	BotAPI.AnswerCallbackQuery(tgbotapi.NewCallback(query.ID, ""))
 }
 
-// GetSession دریافت سشن
+// GetSession دریافت کپی سشن؛ تغییرات آن ذخیره نمی‌شوند
 func GetSession(chatID int64) *UserSession {
-	return UserSessions[chatID]
+	session, _ := Sessions.Get(chatID)
+	return session
 }
 
 // DeleteSession حذف سشن
 func DeleteSession(chatID int64) {
-	delete(UserSessions, chatID)
+	if err := Sessions.Delete(chatID); err != nil {
+		log.Printf("❌ %v", err)
+	}
+}
+
+// persistSession ذخیره سشن پس از پردازش update؛ سشن خالی (خروج یا ورود شروع‌نشده) حذف می‌شود
+func persistSession(chatID int64, session *UserSession) {
+	if session.State == string(stateNotAuthenticated) && session.UserID == 0 {
+		DeleteSession(chatID)
+		return
+	}
+
+	if err := Sessions.Save(chatID, session); err != nil {
+		log.Printf("❌ %v", err)
+	}
 }
 
 // SendMessage ارسال پیام
@@ -145,6 +185,20 @@ func SendMessage(chatID int64, text string) error {
 	return err
 }
 
+// EditMessage ویرایش متن یک پیام ارسال‌شده
+func EditMessage(chatID int64, messageID int, text string, parseMode string) error {
+	edit := tgbotapi.NewEditMessageText(chatID, messageID, text)
+	edit.ParseMode = parseMode
+	_, err := BotAPI.Request(edit)
+	return err
+}
+
+// DeleteMessage حذف یک پیام
+func DeleteMessage(chatID int64, messageID int) error {
+	_, err := BotAPI.Request(tgbotapi.NewDeleteMessage(chatID, messageID))
+	return err
+}
+
 // SendWithButtons ارسال پیام با دکمه‌ها
 func SendWithButtons(chatID int64, text string, buttons [][]tgbotapi.InlineKeyboardButton) error {
 	msg := tgbotapi.NewMessage(chatID, text)
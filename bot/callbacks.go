@@ -1,9 +1,15 @@
 package bot
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"net/http"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"telegram-bot/services"
+	"telegram-bot/utils"
 )
 
 // RegisterCallbacks ثبت کال‌بک‌ها
@@ -12,15 +18,14 @@ func RegisterCallbacks() {
 	log.Println("✅ Callback handlers ثبت شدند")
 }
 
-// ListenForFileUploads گوش دادن به آپلود فایل‌ها
-func ListenForFileUploads(update *tgbotapi.Update) {
+// ListenForFileUploads گوش دادن به آپلود فایل‌ها؛ session سشن قفل‌شده همان چت است
+func ListenForFileUploads(update *tgbotapi.Update, session *UserSession) {
 	if update.Message.Document == nil {
 		return
 	}
 
 	chatID := update.Message.Chat.ID
-	session := GetSession(chatID)
-	if session == nil || session.State != "in_chat" {
+	if session.State != string(stateInChat) {
 		SendMessage(chatID, "❌ لطفاً ابتدا از بخش 'شروع چت' استفاده کنید.")
 		return
 	}
@@ -30,16 +35,113 @@ func ListenForFileUploads(update *tgbotapi.Update) {
 	fileID := document.FileID
 	fileName := document.FileName
 
-	// دریافت فایل
-	file, err := BotAPI.GetFile(tgbotapi.FileConfig{FileID: fileID})
+	// بررسی حجم فایل بر اساس پلن کاربر
+	plan, err := planService.GetUserPlan(session.UserID)
 	if err != nil {
-		SendMessage(chatID, "❌ خطا در دریافت فایل")
+		SendMessage(chatID, "❌ خطا در دریافت اطلاعات")
+		return
+	}
+	if document.FileSize > plan.MaxFileSizeMB*1024*1024 {
+		SendMessage(chatID, fmt.Sprintf("❌ حداکثر حجم فایل در پلن شما %d مگابایت است.", plan.MaxFileSizeMB))
+		return
+	}
+
+	if !utils.IsValidCodeFile(fileName) {
+		SendMessage(chatID, "❌ این نوع فایل پشتیبانی نمی‌شود. لطفاً فایل کد بفرستید.")
+		return
+	}
+
+	// دریافت و تحلیل فایل بیرون از قفل چت
+	userID, fileSize := session.UserID, int64(document.FileSize)
+	runAIRequest(chatID, func() {
+		file, err := BotAPI.GetFile(tgbotapi.FileConfig{FileID: fileID})
+		if err != nil {
+			SendMessage(chatID, "❌ خطا در دریافت فایل")
+			return
+		}
+
+		// دانلود فایل
+		code, err := downloadFile(file.Link(BotAPI.Token), fileSize)
+		if err != nil {
+			log.Printf("❌ خطا در دانلود فایل %s: %v", fileName, err)
+			SendMessage(chatID, "❌ خطا در دریافت فایل")
+			return
+		}
+
+		log.Printf("📎 فایل دریافت شد: %s", fileName)
+		analyzeUploadedFile(chatID, userID, code, fileName)
+	})
+}
+
+// analyzeUploadedFile تحلیل کد فایل آپلودشده با هزینه و سقف روزانه آپلود فایل
+func analyzeUploadedFile(chatID int64, userID uint, code, fileName string) {
+	estimate := aiService.EstimateAnalysisCredits(userID, code, services.FeatureFileUpload)
+	reservation, err := tokenService.ReserveTokens(userID, estimate)
+	if errors.Is(err, services.ErrInsufficientTokens) {
+		SendMessage(chatID, "❌ موجودی توکن شما تمام شده است. بعداً دوباره تلاش کنید.")
+		return
+	}
+	if err != nil {
+		log.Printf("❌ خطا در رزرو توکن کاربر %d: %v", userID, err)
+		SendMessage(chatID, "❌ خطا در بررسی موجودی توکن")
+		return
+	}
+
+	sentMsg, err := BotAPI.Send(tgbotapi.NewMessage(chatID, "⏳ درحال تحلیل فایل..."))
+	if err != nil {
+		log.Printf("❌ خطا در ارسال پیام: %v", err)
+		_ = tokenService.ReleaseReservation(reservation.ID, "خطا در ارسال پیام")
 		return
 	}
 
-	// دانلود فایل
-	fileURL := file.Link(BotAPI.Token)
-	_ = fileURL // استفاده شود
+	ctx, cancel := aiContext()
+	defer cancel()
 
-	log.Printf("📎 فایل دریافت شد: %s", fileName)
+	reply := newStreamingReply(chatID, sentMsg.MessageID)
+	result, err := aiService.AnalyzeCodeWithOptions(ctx, userID, code, utils.DetectLanguage(fileName), fileName, services.QueryOptions{
+		Feature: services.FeatureFileUpload,
+		OnDelta: reply.OnDelta,
+	})
+	if err != nil && result == nil {
+		log.Printf("❌ خطا در تحلیل فایل برای کاربر %d: %v", userID, err)
+		if err := tokenService.ReleaseReservation(reservation.ID, "خطای AI"); err != nil {
+			log.Printf("❌ خطا در آزادسازی رزرو %d: %v", reservation.ID, err)
+		}
+		reply.Fail()
+		SendMessage(chatID, "❌ "+services.AIErrorMessage(err))
+		return
+	}
+
+	if err != nil {
+		// پاسخ دریافت شده ولی ذخیره نشد؛ مصرف همچنان محاسبه می‌شود
+		log.Printf("⚠️  %v", err)
+	}
+
+	if err := tokenService.CommitReservation(reservation.ID, result.Feature, result.Credits, result.Reference); err != nil {
+		log.Printf("❌ خطا در تسویه رزرو %d: %v", reservation.ID, err)
+	}
+
+	reply.Finish(result.Content)
+}
+
+// downloadFile دانلود محتوای فایل تلگرام؛ فایل بزرگ‌تر از اندازه اعلام‌شده پذیرفته نمی‌شود
+func downloadFile(url string, size int64) (string, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+
+	content, err := io.ReadAll(io.LimitReader(resp.Body, size+1))
+	if err != nil {
+		return "", err
+	}
+	if int64(len(content)) > size {
+		return "", fmt.Errorf("حجم فایل بیشتر از مقدار اعلام‌شده است")
+	}
+	return string(content), nil
 }
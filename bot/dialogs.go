@@ -0,0 +1,187 @@
+package bot
+
+import (
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"telegram-bot/bot/fsm"
+)
+
+// مراحل گفتگوی ربات
+const (
+	stateNotAuthenticated    fsm.State = "not_authenticated"
+	stateWaitingPhone        fsm.State = "waiting_phone"
+	stateWaitingNationalCode fsm.State = "waiting_national_code"
+	stateWaitingOTP          fsm.State = "waiting_otp"
+	stateAuthenticated       fsm.State = "authenticated"
+	stateInChat              fsm.State = "in_chat"
+	stateInSupport           fsm.State = "in_support"
+)
+
+const (
+	// loginStepTimeout مهلت وارد کردن شماره، کد ملی یا کد تایید
+	loginStepTimeout = 10 * time.Minute
+	// supportIdleTimeout پس از این مدت بی‌فعالیتی، گفتگوی پشتیبانی بسته می‌شود
+	supportIdleTimeout = 30 * time.Minute
+)
+
+// dialog زمینه پردازش یک update در ماشین حالت
+type dialog struct {
+	chatID  int64
+	session *UserSession
+	update  *tgbotapi.Update
+}
+
+// State مرحله فعلی گفتگو
+func (d *dialog) State() fsm.State {
+	return fsm.State(d.session.State)
+}
+
+// SetState تغییر مرحله گفتگو
+func (d *dialog) SetState(state fsm.State) {
+	d.session.State = string(state)
+}
+
+// ActiveAt آخرین فعالیت در مرحله فعلی
+func (d *dialog) ActiveAt() time.Time {
+	return d.session.ActiveAt
+}
+
+// SetActiveAt ثبت فعالیت در مرحله فعلی
+func (d *dialog) SetActiveAt(at time.Time) {
+	d.session.ActiveAt = at
+}
+
+// menuInputs دستورها و دکمه‌های منوی اصلی که در همه مراحل پس از ورود پذیرفته می‌شوند
+func menuInputs() []fsm.Transition[*dialog] {
+	return []fsm.Transition[*dialog]{
+		fsm.On(fsm.Command("/start"), stay(func(d *dialog, _ fsm.Input) { showMainMenu(d.chatID) })),
+		fsm.On(fsm.Command("/logout"), logout),
+		fsm.On(fsm.Command("/new"), startNewThread),
+		fsm.On(fsm.Command("/threads"), stay(func(d *dialog, _ fsm.Input) { showThreads(d.chatID, d.session) })),
+		fsm.On(fsm.Command("/gift"), stay(func(d *dialog, in fsm.Input) { handleGift(d.chatID, in.Data, d.session) })),
+		fsm.On(fsm.Document(), stay(func(d *dialog, _ fsm.Input) { ListenForFileUploads(d.update, d.session) })),
+		fsm.On(fsm.Callback("profile"), stay(func(d *dialog, _ fsm.Input) { showProfile(d.chatID, d.session) })),
+		fsm.On(fsm.Callback("start_chat"), startChat),
+		fsm.On(fsm.Callback("support"), startSupport),
+		fsm.On(fsm.Callback("back"), stay(func(d *dialog, _ fsm.Input) { showMainMenu(d.chatID) })),
+		fsm.On(fsm.Callback("new_thread"), startNewThread),
+		fsm.On(fsm.Callback("threads"), stay(func(d *dialog, _ fsm.Input) { showThreads(d.chatID, d.session) })),
+		fsm.On(fsm.Callback("buy_tokens"), stay(func(d *dialog, _ fsm.Input) { showTokenPacks(d.chatID) })),
+		fsm.On(fsm.CallbackPrefix("thread:"), resumeThread),
+		fsm.On(fsm.CallbackPrefix("buy:"), stay(func(d *dialog, in fsm.Input) { buyTokenPack(d.chatID, d.session, strings.TrimPrefix(in.Data, "buy:")) })),
+	}
+}
+
+// loggedInNext مراحلی که از منوی اصلی قابل دسترسی‌اند
+var loggedInNext = []fsm.State{stateNotAuthenticated, stateAuthenticated, stateInChat, stateInSupport}
+
+// dialogs ماشین حالت گفتگوی ربات
+var dialogs = fsm.MustNew(stateNotAuthenticated,
+	fsm.StateDef[*dialog]{
+		Name: stateNotAuthenticated,
+		Inputs: []fsm.Transition[*dialog]{
+			fsm.On(fsm.Command("/start"), handleAuthentication),
+		},
+		Next: []fsm.State{stateWaitingPhone, stateAuthenticated},
+	},
+	fsm.StateDef[*dialog]{
+		Name: stateWaitingPhone,
+		OnEnter: func(d *dialog) {
+			SendMessage(d.chatID, "👋 سلام! برای شروع، لطفاً شماره تلفن خود را وارد کنید:\n\nمثال: 09123456789")
+		},
+		Inputs: []fsm.Transition[*dialog]{
+			fsm.On(fsm.Command("/start"), handleAuthentication),
+			fsm.On(fsm.Text(), handlePhoneInput),
+		},
+		Next:      []fsm.State{stateWaitingNationalCode, stateAuthenticated},
+		Timeout:   loginStepTimeout,
+		OnTimeout: loginTimedOut,
+		TimeoutTo: stateNotAuthenticated,
+	},
+	fsm.StateDef[*dialog]{
+		Name: stateWaitingNationalCode,
+		OnEnter: func(d *dialog) {
+			SendMessage(d.chatID, "✅ شماره ثبت شد. حالا کد ملی خود را وارد کنید:")
+		},
+		Inputs: []fsm.Transition[*dialog]{
+			fsm.On(fsm.Command("/start"), handleAuthentication),
+			fsm.On(fsm.Text(), handleNationalCodeInput),
+		},
+		Next:      []fsm.State{stateWaitingPhone, stateWaitingOTP},
+		Timeout:   loginStepTimeout,
+		OnTimeout: loginTimedOut,
+		TimeoutTo: stateNotAuthenticated,
+	},
+	fsm.StateDef[*dialog]{
+		Name: stateWaitingOTP,
+		Inputs: []fsm.Transition[*dialog]{
+			fsm.On(fsm.Command("/start"), handleAuthentication),
+			fsm.On(fsm.Text(), handleOTPInput),
+		},
+		Next:      []fsm.State{stateWaitingPhone, stateNotAuthenticated, stateAuthenticated},
+		Timeout:   loginStepTimeout,
+		OnTimeout: loginTimedOut,
+		TimeoutTo: stateNotAuthenticated,
+	},
+	fsm.StateDef[*dialog]{
+		Name:    stateAuthenticated,
+		OnEnter: func(d *dialog) { showMainMenu(d.chatID) },
+		Inputs:  loggedInInputs(stay(func(d *dialog, _ fsm.Input) { showMainMenu(d.chatID) })),
+		Next:    loggedInNext,
+	},
+	fsm.StateDef[*dialog]{
+		Name: stateInChat,
+		Inputs: loggedInInputs(stay(func(d *dialog, in fsm.Input) { handleAIChat(d.chatID, in.Data, d.session) }),
+			fsm.On(fsm.Command("/back"), backToMenu),
+		),
+		Next: loggedInNext,
+	},
+	fsm.StateDef[*dialog]{
+		Name: stateInSupport,
+		Inputs: loggedInInputs(stay(func(d *dialog, in fsm.Input) { handleSupportChat(d.chatID, in.Data, d.session) }),
+			fsm.On(fsm.Command("/back"), closeSupport),
+			fsm.On(fsm.Command("/close"), closeSupport),
+		),
+		Next:    loggedInNext,
+		Timeout: supportIdleTimeout,
+		OnTimeout: func(d *dialog) {
+			SendMessage(d.chatID, "⌛ گفتگوی پشتیبانی به دلیل عدم فعالیت بسته شد و این پیام به پشتیبانی نرسید. برای ادامه، دوباره گزینه پشتیبانی را انتخاب کنید.")
+		},
+		TimeoutTo: stateAuthenticated,
+	},
+)
+
+// loggedInInputs ورودی‌های مرحله پس از ورود: ابتدا ورودی‌های خاص مرحله، سپس منوی اصلی و در آخر پیام متنی آزاد
+func loggedInInputs(onText fsm.Action[*dialog], specific ...fsm.Transition[*dialog]) []fsm.Transition[*dialog] {
+	inputs := append(specific, menuInputs()...)
+	return append(inputs, fsm.On(fsm.Text(), onText))
+}
+
+// stay تبدیل یک نمایش یا عملیات بدون تغییر مرحله به action
+func stay(fn func(d *dialog, in fsm.Input)) fsm.Action[*dialog] {
+	return func(d *dialog, in fsm.Input) fsm.State {
+		fn(d, in)
+		return fsm.Stay
+	}
+}
+
+// loginTimedOut پیام پایان مهلت ورود و پاک کردن اطلاعات واردشده
+func loginTimedOut(d *dialog) {
+	*d.session = UserSession{State: string(stateNotAuthenticated)}
+	SendMessage(d.chatID, "⌛ مهلت ورود به پایان رسید. برای شروع دوباره /start را بنویسید.")
+}
+
+// backToMenu خروج از حالت چت
+func backToMenu(d *dialog, _ fsm.Input) fsm.State {
+	return stateAuthenticated
+}
+
+// messageInput تبدیل پیام تلگرام به ورودی ماشین حالت
+func messageInput(message *tgbotapi.Message) fsm.Input {
+	if message.Document != nil {
+		return fsm.Input{Kind: fsm.InputDocument, Data: message.Document.FileName}
+	}
+	return fsm.Input{Kind: fsm.InputText, Data: message.Text}
+}
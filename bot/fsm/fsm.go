@@ -0,0 +1,237 @@
+// Package fsm ماشین حالت ساده برای گفتگوهای چندمرحله‌ای ربات
+//
+// هر مرحله به‌صورت اعلانی تعریف می‌شود: کار هنگام ورود، ورودی‌های پذیرفته‌شده،
+// مراحل مجاز بعدی و مهلت بی‌فعالیتی. ماشین به تلگرام وابسته نیست و وضعیت را
+// از طریق Context می‌خواند و می‌نویسد.
+package fsm
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// State نام یک مرحله گفتگو
+type State string
+
+// Stay نتیجه action برای ماندن در مرحله فعلی بدون اجرای دوباره OnEnter
+const Stay State = ""
+
+// InputKind نوع ورودی کاربر
+type InputKind int
+
+const (
+	InputText InputKind = iota
+	InputCallback
+	InputDocument
+)
+
+// Input یک ورودی کاربر؛ Data متن پیام، داده دکمه یا نام فایل است
+type Input struct {
+	Kind InputKind
+	Data string
+}
+
+// Context وضعیت گفتگوی یک کاربر که ماشین آن را می‌خواند و تغییر می‌دهد
+type Context interface {
+	State() State
+	SetState(state State)
+	ActiveAt() time.Time // آخرین فعالیت در مرحله فعلی؛ مبنای مهلت
+	SetActiveAt(at time.Time)
+}
+
+// Matcher بررسی پذیرفته شدن ورودی
+type Matcher func(in Input) bool
+
+// Action پردازش ورودی و برگرداندن مرحله بعد؛ Stay یعنی ماندن و نام همین مرحله یعنی ورود دوباره
+type Action[C Context] func(ctx C, in Input) State
+
+// Transition یک ورودی پذیرفته‌شده و action آن
+type Transition[C Context] struct {
+	Match  Matcher
+	Action Action[C]
+}
+
+// StateDef تعریف یک مرحله
+type StateDef[C Context] struct {
+	Name    State
+	OnEnter func(ctx C)
+
+	// Inputs به ترتیب بررسی می‌شوند و اولین مورد منطبق اجرا می‌شود
+	Inputs []Transition[C]
+
+	// Next مراحلی که actionها می‌توانند به آن‌ها بروند؛ ورود دوباره به همین مرحله همیشه مجاز است
+	Next []State
+
+	// Timeout مهلت بی‌فعالیتی؛ پس از آن اولین ورودی ابتدا به TimeoutTo منتقل و سپس در آنجا پردازش می‌شود
+	Timeout   time.Duration
+	OnTimeout func(ctx C)
+	TimeoutTo State
+}
+
+// Machine ماشین حالت گفتگو
+type Machine[C Context] struct {
+	initial State
+	states  map[State]*StateDef[C]
+
+	// Clock زمان فعلی؛ برای تست قابل جایگزینی است
+	Clock func() time.Time
+}
+
+// On ساخت انتقال
+func On[C Context](match Matcher, action Action[C]) Transition[C] {
+	return Transition[C]{Match: match, Action: action}
+}
+
+// New ساخت ماشین و بررسی سازگاری تعریف مراحل
+func New[C Context](initial State, defs ...StateDef[C]) (*Machine[C], error) {
+	m := &Machine[C]{initial: initial, states: make(map[State]*StateDef[C], len(defs)), Clock: time.Now}
+	for i := range defs {
+		def := &defs[i]
+		if def.Name == Stay {
+			return nil, fmt.Errorf("fsm: نام مرحله خالی است")
+		}
+		if _, exists := m.states[def.Name]; exists {
+			return nil, fmt.Errorf("fsm: مرحله %s تکراری است", def.Name)
+		}
+		m.states[def.Name] = def
+	}
+
+	if _, ok := m.states[initial]; !ok {
+		return nil, fmt.Errorf("fsm: مرحله شروع %s تعریف نشده است", initial)
+	}
+	for _, def := range m.states {
+		for _, next := range def.Next {
+			if _, ok := m.states[next]; !ok {
+				return nil, fmt.Errorf("fsm: مرحله %s از %s تعریف نشده است", next, def.Name)
+			}
+		}
+		if def.Timeout > 0 {
+			if _, ok := m.states[def.TimeoutTo]; !ok {
+				return nil, fmt.Errorf("fsm: مرحله پس از مهلت %s تعریف نشده است", def.Name)
+			}
+		}
+	}
+	return m, nil
+}
+
+// MustNew مانند New؛ در صورت تعریف نادرست panic می‌کند
+func MustNew[C Context](initial State, defs ...StateDef[C]) *Machine[C] {
+	m, err := New(initial, defs...)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// Handle پردازش یک ورودی در مرحله فعلی؛ handled نادرست یعنی مرحله این ورودی را نمی‌پذیرد
+//
+// مرحله ناشناخته (مثلاً سشن ذخیره‌شده از نسخه قبلی) به مرحله شروع برمی‌گردد. اگر مهلت
+// مرحله گذشته باشد، پس از OnTimeout و ورود به TimeoutTo همان ورودی در مرحله جدید
+// پردازش می‌شود؛ اگر مرحله جدید آن را نپذیرد، ورودی کنار گذاشته می‌شود.
+func (m *Machine[C]) Handle(ctx C, in Input) (handled bool, err error) {
+	now := m.Clock()
+
+	def, ok := m.states[ctx.State()]
+	if !ok {
+		m.enter(ctx, m.initial, now)
+		def = m.states[m.initial]
+	}
+
+	if def.Timeout > 0 && !ctx.ActiveAt().IsZero() && now.Sub(ctx.ActiveAt()) > def.Timeout {
+		if def.OnTimeout != nil {
+			def.OnTimeout(ctx)
+		}
+		m.enter(ctx, def.TimeoutTo, now)
+
+		_, err := m.dispatch(ctx, m.states[def.TimeoutTo], in, now)
+		return true, err
+	}
+
+	return m.dispatch(ctx, def, in, now)
+}
+
+// dispatch اجرای اولین انتقال منطبق مرحله و ورود به مرحله بعد
+func (m *Machine[C]) dispatch(ctx C, def *StateDef[C], in Input, now time.Time) (bool, error) {
+	for _, transition := range def.Inputs {
+		if !transition.Match(in) {
+			continue
+		}
+
+		ctx.SetActiveAt(now)
+		next := transition.Action(ctx, in)
+		if next == Stay {
+			return true, nil
+		}
+		if next != def.Name && !def.allows(next) {
+			return true, fmt.Errorf("fsm: انتقال از %s به %s تعریف نشده است", def.Name, next)
+		}
+
+		m.enter(ctx, next, now)
+		return true, nil
+	}
+	return false, nil
+}
+
+// Enter انتقال مستقیم به یک مرحله، مثلاً از بیرون گفتگو
+func (m *Machine[C]) Enter(ctx C, state State) error {
+	if _, ok := m.states[state]; !ok {
+		return fmt.Errorf("fsm: مرحله %s تعریف نشده است", state)
+	}
+	m.enter(ctx, state, m.Clock())
+	return nil
+}
+
+// enter ثبت مرحله جدید و اجرای OnEnter آن
+func (m *Machine[C]) enter(ctx C, state State, now time.Time) {
+	ctx.SetState(state)
+	ctx.SetActiveAt(now)
+	if def := m.states[state]; def.OnEnter != nil {
+		def.OnEnter(ctx)
+	}
+}
+
+// allows مجاز بودن انتقال به مرحله
+func (d *StateDef[C]) allows(state State) bool {
+	for _, next := range d.Next {
+		if next == state {
+			return true
+		}
+	}
+	return false
+}
+
+// Text هر پیام متنی
+func Text() Matcher {
+	return func(in Input) bool {
+		return in.Kind == InputText
+	}
+}
+
+// Command دستور متنی، با یا بدون آرگومان؛ Command("/gift") با "/gift 10 ..." هم منطبق است
+func Command(name string) Matcher {
+	return func(in Input) bool {
+		return in.Kind == InputText && (in.Data == name || strings.HasPrefix(in.Data, name+" "))
+	}
+}
+
+// Callback داده دقیق دکمه
+func Callback(data string) Matcher {
+	return func(in Input) bool {
+		return in.Kind == InputCallback && in.Data == data
+	}
+}
+
+// CallbackPrefix دکمه‌هایی که داده‌شان با prefix شروع می‌شود، مثلاً "thread:"
+func CallbackPrefix(prefix string) Matcher {
+	return func(in Input) bool {
+		return in.Kind == InputCallback && strings.HasPrefix(in.Data, prefix)
+	}
+}
+
+// Document فایل ارسالی
+func Document() Matcher {
+	return func(in Input) bool {
+		return in.Kind == InputDocument
+	}
+}
@@ -0,0 +1,414 @@
+package fsm
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testContext زمینه ساده برای تست که کارهای انجام‌شده را ثبت می‌کند
+type testContext struct {
+	state    State
+	activeAt time.Time
+	log      []string
+}
+
+func (c *testContext) State() State             { return c.state }
+func (c *testContext) SetState(state State)     { c.state = state }
+func (c *testContext) ActiveAt() time.Time      { return c.activeAt }
+func (c *testContext) SetActiveAt(at time.Time) { c.activeAt = at }
+
+// fakeClock ساعت قابل جابه‌جایی برای تست مهلت‌ها
+type fakeClock struct{ now time.Time }
+
+func (c *fakeClock) Now() time.Time          { return c.now }
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+// record actionی که نامش را ثبت می‌کند و به next می‌رود
+func record(name string, next State) Action[*testContext] {
+	return func(ctx *testContext, in Input) State {
+		ctx.log = append(ctx.log, name+":"+in.Data)
+		return next
+	}
+}
+
+// entered کار OnEnter که ورود به مرحله را ثبت می‌کند
+func entered(state State) func(ctx *testContext) {
+	return func(ctx *testContext) {
+		ctx.log = append(ctx.log, "enter:"+string(state))
+	}
+}
+
+const (
+	stateIdle    State = "idle"
+	stateAsking  State = "asking"
+	stateSupport State = "support"
+)
+
+func newTestMachine(t *testing.T, clock *fakeClock) *Machine[*testContext] {
+	t.Helper()
+
+	m, err := New(stateIdle,
+		StateDef[*testContext]{
+			Name:    stateIdle,
+			OnEnter: entered(stateIdle),
+			Inputs: []Transition[*testContext]{
+				On(Command("/ask"), record("ask", stateAsking)),
+				On(Command("/support"), record("support", stateSupport)),
+				On(Command("/bad"), record("bad", stateSupport+"x")),
+				On(Callback("menu"), record("menu", Stay)),
+				On(CallbackPrefix("item:"), record("item", Stay)),
+				On(Document(), record("file", Stay)),
+				On(Text(), record("text", Stay)),
+			},
+			Next: []State{stateAsking, stateSupport},
+		},
+		StateDef[*testContext]{
+			Name:    stateAsking,
+			OnEnter: entered(stateAsking),
+			Inputs: []Transition[*testContext]{
+				On(Command("/again"), record("again", stateAsking)),
+				On(Command("/support"), record("support", stateSupport)),
+				On(Text(), record("answer", stateIdle)),
+			},
+			Next: []State{stateIdle},
+		},
+		StateDef[*testContext]{
+			Name:    stateSupport,
+			OnEnter: entered(stateSupport),
+			Inputs: []Transition[*testContext]{
+				On(Command("/close"), record("close", stateIdle)),
+				On(Text(), record("message", Stay)),
+			},
+			Next:      []State{stateIdle},
+			Timeout:   30 * time.Minute,
+			OnTimeout: func(ctx *testContext) { ctx.log = append(ctx.log, "timeout") },
+			TimeoutTo: stateIdle,
+		},
+	)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if clock != nil {
+		m.Clock = clock.Now
+	}
+	return m
+}
+
+func TestHandleMatchOrder(t *testing.T) {
+	tests := []struct {
+		name    string
+		state   State
+		in      Input
+		handled bool
+		want    State
+		log     []string
+	}{
+		{"command before text", stateIdle, Input{Kind: InputText, Data: "/ask"}, true, stateAsking, []string{"ask:/ask", "enter:asking"}},
+		{"command with argument", stateIdle, Input{Kind: InputText, Data: "/ask why"}, true, stateAsking, []string{"ask:/ask why", "enter:asking"}},
+		{"command prefix is not a command", stateIdle, Input{Kind: InputText, Data: "/asked"}, true, stateIdle, []string{"text:/asked"}},
+		{"plain text", stateIdle, Input{Kind: InputText, Data: "hello"}, true, stateIdle, []string{"text:hello"}},
+		{"exact callback", stateIdle, Input{Kind: InputCallback, Data: "menu"}, true, stateIdle, []string{"menu:menu"}},
+		{"callback prefix", stateIdle, Input{Kind: InputCallback, Data: "item:7"}, true, stateIdle, []string{"item:item:7"}},
+		{"document", stateIdle, Input{Kind: InputDocument, Data: "main.go"}, true, stateIdle, []string{"file:main.go"}},
+		{"unknown callback", stateIdle, Input{Kind: InputCallback, Data: "other"}, false, stateIdle, nil},
+		{"document not accepted", stateAsking, Input{Kind: InputDocument, Data: "main.go"}, false, stateAsking, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestMachine(t, nil)
+			ctx := &testContext{state: tt.state}
+
+			handled, err := m.Handle(ctx, tt.in)
+			if err != nil {
+				t.Fatalf("Handle: %v", err)
+			}
+			if handled != tt.handled {
+				t.Errorf("handled = %v, want %v", handled, tt.handled)
+			}
+			if ctx.state != tt.want {
+				t.Errorf("state = %q, want %q", ctx.state, tt.want)
+			}
+			if !reflect.DeepEqual(ctx.log, tt.log) {
+				t.Errorf("log = %v, want %v", ctx.log, tt.log)
+			}
+		})
+	}
+}
+
+func TestHandleStay(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	m := newTestMachine(t, clock)
+	ctx := &testContext{state: stateSupport, activeAt: clock.now}
+
+	clock.Advance(10 * time.Minute)
+	if _, err := m.Handle(ctx, Input{Kind: InputText, Data: "hi"}); err != nil {
+		t.Fatalf("Handle: %v", err)
+	}
+
+	// Stay مرحله را تغییر نمی‌دهد و OnEnter را دوباره اجرا نمی‌کند، ولی فعالیت را ثبت می‌کند
+	if ctx.state != stateSupport {
+		t.Errorf("state = %q, want %q", ctx.state, stateSupport)
+	}
+	if want := []string{"message:hi"}; !reflect.DeepEqual(ctx.log, want) {
+		t.Errorf("log = %v, want %v", ctx.log, want)
+	}
+	if !ctx.activeAt.Equal(clock.now) {
+		t.Errorf("activeAt = %v, want %v", ctx.activeAt, clock.now)
+	}
+}
+
+func TestHandleReenterSameState(t *testing.T) {
+	m := newTestMachine(t, nil)
+	ctx := &testContext{state: stateAsking}
+
+	if _, err := m.Handle(ctx, Input{Kind: InputText, Data: "/again"}); err != nil {
+		t.Fatalf("Handle: %v", err)
+	}
+
+	// برگرداندن نام همین مرحله یعنی ورود دوباره، حتی اگر در Next نباشد
+	if want := []string{"again:/again", "enter:asking"}; !reflect.DeepEqual(ctx.log, want) {
+		t.Errorf("log = %v, want %v", ctx.log, want)
+	}
+}
+
+func TestHandleUndeclaredTransition(t *testing.T) {
+	tests := []struct {
+		name  string
+		state State
+		in    string
+	}{
+		{"undefined state", stateIdle, "/bad"},
+		{"defined but not in Next", stateAsking, "/support"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestMachine(t, nil)
+			ctx := &testContext{state: tt.state}
+
+			handled, err := m.Handle(ctx, Input{Kind: InputText, Data: tt.in})
+			if err == nil {
+				t.Fatal("Handle: want error for undeclared transition")
+			}
+			if !handled {
+				t.Error("handled = false, want true")
+			}
+			if ctx.state != tt.state {
+				t.Errorf("state = %q, want unchanged %q", ctx.state, tt.state)
+			}
+		})
+	}
+}
+
+func TestAllows(t *testing.T) {
+	def := &StateDef[*testContext]{Name: stateIdle, Next: []State{stateAsking}}
+
+	tests := []struct {
+		state State
+		want  bool
+	}{
+		{stateAsking, true},
+		{stateSupport, false},
+		{stateIdle, false}, // ورود دوباره در Handle جداگانه مجاز است
+		{Stay, false},
+	}
+	for _, tt := range tests {
+		if got := def.allows(tt.state); got != tt.want {
+			t.Errorf("allows(%q) = %v, want %v", tt.state, got, tt.want)
+		}
+	}
+}
+
+func TestHandleUnknownStateFallsBackToInitial(t *testing.T) {
+	tests := []struct {
+		name string
+		in   Input
+		want State
+		log  []string
+	}{
+		{"input accepted by initial", Input{Kind: InputText, Data: "/ask"}, stateAsking, []string{"enter:idle", "ask:/ask", "enter:asking"}},
+		{"input not accepted by initial", Input{Kind: InputCallback, Data: "other"}, stateIdle, []string{"enter:idle"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestMachine(t, nil)
+			ctx := &testContext{state: "removed_in_new_version"}
+
+			if _, err := m.Handle(ctx, tt.in); err != nil {
+				t.Fatalf("Handle: %v", err)
+			}
+			if ctx.state != tt.want {
+				t.Errorf("state = %q, want %q", ctx.state, tt.want)
+			}
+			if !reflect.DeepEqual(ctx.log, tt.log) {
+				t.Errorf("log = %v, want %v", ctx.log, tt.log)
+			}
+		})
+	}
+}
+
+func TestHandleTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		idle    time.Duration
+		in      Input
+		handled bool
+		want    State
+		log     []string
+	}{
+		{
+			name:    "within timeout",
+			idle:    29 * time.Minute,
+			in:      Input{Kind: InputText, Data: "hi"},
+			handled: true,
+			want:    stateSupport,
+			log:     []string{"message:hi"},
+		},
+		{
+			name:    "timed out input handled by TimeoutTo",
+			idle:    31 * time.Minute,
+			in:      Input{Kind: InputText, Data: "hi"},
+			handled: true,
+			want:    stateIdle,
+			log:     []string{"timeout", "enter:idle", "text:hi"},
+		},
+		{
+			name:    "timed out input moves on from TimeoutTo",
+			idle:    31 * time.Minute,
+			in:      Input{Kind: InputText, Data: "/ask"},
+			handled: true,
+			want:    stateAsking,
+			log:     []string{"timeout", "enter:idle", "ask:/ask", "enter:asking"},
+		},
+		{
+			name:    "timed out input not accepted by TimeoutTo is dropped",
+			idle:    31 * time.Minute,
+			in:      Input{Kind: InputCallback, Data: "other"},
+			handled: true,
+			want:    stateIdle,
+			log:     []string{"timeout", "enter:idle"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+			m := newTestMachine(t, clock)
+			ctx := &testContext{state: stateSupport, activeAt: clock.now}
+
+			clock.Advance(tt.idle)
+			handled, err := m.Handle(ctx, tt.in)
+			if err != nil {
+				t.Fatalf("Handle: %v", err)
+			}
+			if handled != tt.handled {
+				t.Errorf("handled = %v, want %v", handled, tt.handled)
+			}
+			if ctx.state != tt.want {
+				t.Errorf("state = %q, want %q", ctx.state, tt.want)
+			}
+			if !reflect.DeepEqual(ctx.log, tt.log) {
+				t.Errorf("log = %v, want %v", ctx.log, tt.log)
+			}
+			if !ctx.activeAt.Equal(clock.now) {
+				t.Errorf("activeAt = %v, want %v", ctx.activeAt, clock.now)
+			}
+		})
+	}
+}
+
+func TestHandleTimeoutIgnoresZeroActiveAt(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	m := newTestMachine(t, clock)
+	ctx := &testContext{state: stateSupport}
+
+	if _, err := m.Handle(ctx, Input{Kind: InputText, Data: "hi"}); err != nil {
+		t.Fatalf("Handle: %v", err)
+	}
+	if want := []string{"message:hi"}; !reflect.DeepEqual(ctx.log, want) {
+		t.Errorf("log = %v, want %v", ctx.log, want)
+	}
+}
+
+func TestNewValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		initial State
+		defs    []StateDef[*testContext]
+		wantErr string
+	}{
+		{
+			name:    "valid",
+			initial: stateIdle,
+			defs:    []StateDef[*testContext]{{Name: stateIdle}},
+		},
+		{
+			name:    "empty name",
+			initial: stateIdle,
+			defs:    []StateDef[*testContext]{{Name: stateIdle}, {Name: Stay}},
+			wantErr: "خالی",
+		},
+		{
+			name:    "duplicate state",
+			initial: stateIdle,
+			defs:    []StateDef[*testContext]{{Name: stateIdle}, {Name: stateIdle}},
+			wantErr: "تکراری",
+		},
+		{
+			name:    "undefined initial",
+			initial: stateAsking,
+			defs:    []StateDef[*testContext]{{Name: stateIdle}},
+			wantErr: "مرحله شروع",
+		},
+		{
+			name:    "undefined next",
+			initial: stateIdle,
+			defs:    []StateDef[*testContext]{{Name: stateIdle, Next: []State{stateAsking}}},
+			wantErr: string(stateAsking),
+		},
+		{
+			name:    "undefined timeout target",
+			initial: stateIdle,
+			defs:    []StateDef[*testContext]{{Name: stateIdle, Timeout: time.Minute}},
+			wantErr: "مهلت",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.initial, tt.defs...)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("New: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("New error = %v, want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEnter(t *testing.T) {
+	m := newTestMachine(t, nil)
+	ctx := &testContext{state: stateIdle}
+
+	if err := m.Enter(ctx, stateSupport); err != nil {
+		t.Fatalf("Enter: %v", err)
+	}
+	if ctx.state != stateSupport {
+		t.Errorf("state = %q, want %q", ctx.state, stateSupport)
+	}
+	if want := []string{"enter:support"}; !reflect.DeepEqual(ctx.log, want) {
+		t.Errorf("log = %v, want %v", ctx.log, want)
+	}
+
+	if err := m.Enter(ctx, "missing"); err == nil {
+		t.Error("Enter: want error for undefined state")
+	}
+}
@@ -1,11 +1,18 @@
 package bot
 
 import (
+	"errors"
 	"fmt"
+	"html"
 	"log"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"telegram-bot/bot/fsm"
 	"telegram-bot/database"
 	"telegram-bot/services"
 	"telegram-bot/utils"
@@ -15,63 +22,141 @@ var authService = &services.AuthService{}
 var userService = &services.UserService{}
 var tokenService = &services.TokenService{}
 var aiService = &services.AIService{}
+var threadService = &services.ThreadService{}
+var planService = &services.PlanService{}
+var paymentService = &services.PaymentService{}
+var featureService = &services.FeatureService{}
+var loginGuard = &services.LoginGuardService{}
+var otpService = &services.OTPService{}
 
-// handleAuthentication مدیریت احراز هویت
-func handleAuthentication(chatID int64, text string, session *UserSession, update *tgbotapi.Update) {
-	if text == "/start" {
-		// بررسی وجود کاربر
-		user, _ := userService.GetUserByTelegramID(chatID)
-		if user != nil {
-			session.UserID = user.ID
-			session.State = "authenticated"
-			SendMessage(chatID, fmt.Sprintf("🎉 سلام %s! خوش‌آمدید!", user.FullName))
-			showMainMenu(chatID)
-			return
-		}
+// handleAuthentication مدیریت احراز هویت؛ کاربری که اتصال تلگرامش تایید شده مستقیم وارد می‌شود
+func handleAuthentication(d *dialog, _ fsm.Input) fsm.State {
+	chatID, session := d.chatID, d.session
 
-		// درخواست شماره
-		SendMessage(chatID, "👋 سلام! برای شروع، لطفاً شماره تلفن خود را وارد کنید:\n\nمثال: 09123456789")
-		session.State = "waiting_phone"
+	// بررسی وجود کاربر
+	user, _ := userService.GetUserByTelegramID(chatID)
+	if user != nil && user.TelegramVerified {
+		session.UserID = user.ID
+		SendMessage(chatID, fmt.Sprintf("🎉 سلام %s! خوش‌آمدید!", user.FullName))
+		return stateAuthenticated
 	}
+
+	// درخواست شماره
+	return stateWaitingPhone
 }
 
 // handlePhoneInput مدیریت ورودی شماره
-func handlePhoneInput(chatID int64, text string, session *UserSession) {
-	if !utils.ValidatePhoneNumber(text) {
-		SendMessage(chatID, "❌ شماره تلفن نامعتبر است. لطفاً دوباره تلاش کنید.")
-		return
+func handlePhoneInput(d *dialog, in fsm.Input) fsm.State {
+	if !utils.ValidatePhoneNumber(in.Data) {
+		SendMessage(d.chatID, "❌ شماره تلفن نامعتبر است. لطفاً دوباره تلاش کنید.")
+		return fsm.Stay
 	}
 
-	session.Phone = text
-	SendMessage(chatID, "✅ شماره ثبت شد. حالا کد ملی خود را وارد کنید:")
-	session.State = "waiting_national_code"
+	d.session.Phone = in.Data
+	return stateWaitingNationalCode
 }
 
 // handleNationalCodeInput مدیریت ورودی کد ملی
-func handleNationalCodeInput(chatID int64, text string, session *UserSession) {
+func handleNationalCodeInput(d *dialog, in fsm.Input) fsm.State {
+	chatID, session, text := d.chatID, d.session, in.Data
 	if !utils.ValidateNationalCode(text) {
 		SendMessage(chatID, "❌ کد ملی نامعتبر است. لطفاً دوباره تلاش کنید.")
-		return
+		return fsm.Stay
 	}
 
 	session.NationalCode = text
 
+	// محدودیت حدس زدن شماره و کد ملی برای هر شماره و چت
+	subject := services.LoginSubject{Flow: services.LoginFlowBot, Phone: session.Phone, ChatID: chatID}
+	wait, err := loginGuard.Check(subject)
+	if errors.Is(err, services.ErrLoginLocked) {
+		SendMessage(chatID, fmt.Sprintf("⛔️ به دلیل تلاش‌های ناموفق زیاد، ورود تا %d دقیقه دیگر قفل است.", int(math.Ceil(wait.Minutes()))))
+		return fsm.Stay
+	}
+	if errors.Is(err, services.ErrLoginThrottled) {
+		SendMessage(chatID, fmt.Sprintf("⏳ لطفاً %d ثانیه دیگر دوباره تلاش کنید.", int(math.Ceil(wait.Seconds()))))
+		return fsm.Stay
+	}
+	if err != nil {
+		SendMessage(chatID, "❌ خطا در بررسی ورود. لطفاً دوباره تلاش کنید.")
+		return fsm.Stay
+	}
+
 	// بررسی وجود کاربر
 	user, err := authService.LoginUser(session.Phone, session.NationalCode)
 	if err != nil {
+		loginGuard.RecordFailure(subject, services.LoginReasonInvalidCredentials)
 		SendMessage(chatID, "❌ این کاربر ثبت‌نام نکرده است. لطفاً با ادمین تماس بگیرید.")
-		return
+		return fsm.Stay
+	}
+
+	// شماره و کد ملی برای اتصال چت کافی نیست؛ کد به تلگرام تاییدشده قبلی یا با پیامک فرستاده می‌شود
+	challenge, err := otpService.SendLoginCode(user)
+	if errors.Is(err, services.ErrOTPResendTooSoon) {
+		wait := int(math.Ceil(otpService.ResendWait(user.ID).Seconds()))
+		SendMessage(chatID, fmt.Sprintf("⏳ کد قبلی به‌تازگی ارسال شده است. لطفاً %d ثانیه دیگر دوباره تلاش کنید.", wait))
+		return fsm.Stay
+	}
+	if err != nil {
+		log.Printf("❌ خطا در ارسال کد ورود برای کاربر %d: %v", user.ID, err)
+		SendMessage(chatID, "❌ خطا در ارسال کد تایید. لطفاً بعداً دوباره تلاش کنید.")
+		return fsm.Stay
+	}
+
+	session.NationalCode = ""
+	session.OTPChallenge = challenge.ChallengeID
+	SendMessage(chatID, fmt.Sprintf("🔐 کد تایید به %s ارسال شد. لطفاً کد را وارد کنید:", challenge.Destination))
+	return stateWaitingOTP
+}
+
+// handleOTPInput بررسی کد ورود و اتصال چت به حساب کاربر
+func handleOTPInput(d *dialog, in fsm.Input) fsm.State {
+	chatID, session := d.chatID, d.session
+
+	subject := services.LoginSubject{Flow: services.LoginFlowBot, Phone: session.Phone, ChatID: chatID}
+	wait, err := loginGuard.Check(subject)
+	if errors.Is(err, services.ErrLoginLocked) || errors.Is(err, services.ErrLoginThrottled) {
+		SendMessage(chatID, fmt.Sprintf("⏳ لطفاً %d ثانیه دیگر دوباره تلاش کنید.", int(math.Ceil(wait.Seconds()))))
+		return fsm.Stay
+	}
+	if err != nil {
+		SendMessage(chatID, "❌ خطا در بررسی ورود. لطفاً دوباره تلاش کنید.")
+		return fsm.Stay
+	}
+
+	userID, err := otpService.VerifyLoginCode(session.OTPChallenge, strings.TrimSpace(in.Data))
+	if errors.Is(err, services.ErrOTPInvalid) {
+		loginGuard.RecordFailure(subject, services.LoginReasonInvalidOTP)
+		SendMessage(chatID, "❌ کد تایید نادرست است. لطفاً دوباره تلاش کنید.")
+		return fsm.Stay
+	}
+	if errors.Is(err, services.ErrOTPExpired) || errors.Is(err, services.ErrOTPTooManyAttempts) {
+		loginGuard.RecordFailure(subject, services.LoginReasonInvalidOTP)
+		*session = UserSession{}
+		SendMessage(chatID, "❌ "+err.Error()+"\nبرای شروع دوباره /start را بنویسید.")
+		return stateNotAuthenticated
+	}
+	if err != nil {
+		SendMessage(chatID, "❌ خطا در بررسی کد. لطفاً دوباره تلاش کنید.")
+		return fsm.Stay
 	}
 
-	// به‌روزرسانی Telegram ID
-	user.TelegramID = chatID
-	_ = userService.UpdateUser(user)
+	if err := userService.LinkTelegram(userID, chatID); err != nil {
+		log.Printf("❌ %v", err)
+		SendMessage(chatID, "❌ خطا در اتصال حساب. لطفاً دوباره تلاش کنید.")
+		return fsm.Stay
+	}
+	user, err := userService.GetUser(userID)
+	if err != nil {
+		SendMessage(chatID, "❌ خطا در دریافت اطلاعات")
+		return fsm.Stay
+	}
+	loginGuard.RecordSuccess(subject, user.ID)
 
-	session.UserID = user.ID
-	session.State = "authenticated"
+	*session = UserSession{UserID: user.ID}
 
 	SendMessage(chatID, fmt.Sprintf("✅ خوش‌آمدید %s!", user.FullName))
-	showMainMenu(chatID)
+	return stateAuthenticated
 }
 
 // showMainMenu نمایش منوی اصلی
@@ -86,6 +171,13 @@ func showMainMenu(chatID int64) {
 		{
 			tgbotapi.NewInlineKeyboardButtonData("💬 شروع چت", "start_chat"),
 		},
+		{
+			tgbotapi.NewInlineKeyboardButtonData("🆕 گفتگوی جدید", "new_thread"),
+			tgbotapi.NewInlineKeyboardButtonData("🗂 گفتگوهای قبلی", "threads"),
+		},
+		{
+			tgbotapi.NewInlineKeyboardButtonData("💳 خرید توکن", "buy_tokens"),
+		},
 		{
 			tgbotapi.NewInlineKeyboardButtonData("📞 ارتباط با پشتیبانی", "support"),
 		},
@@ -104,22 +196,49 @@ func showProfile(chatID int64, session *UserSession) {
 
 	tokens, _ := tokenService.GetUserTokens(session.UserID)
 
+	planTitle := "رایگان"
+	if plan, err := planService.GetUserPlan(session.UserID); err == nil {
+		planTitle = plan.Title
+	}
+	if !user.PlanExpiresAt.IsZero() {
+		planTitle += " (تا " + user.PlanExpiresAt.Format("2006-01-02") + ")"
+	}
+
+	usage := ""
+	if breakdown, err := featureService.GetDailyBreakdown(session.UserID); err == nil {
+		for _, item := range breakdown {
+			limit := "بدون سقف"
+			if item.DailyCap > 0 {
+				limit = fmt.Sprintf("سقف %d", item.DailyCap)
+			}
+			usage += fmt.Sprintf("%s: %d اعتبار در %d درخواست (%s)\n", item.Title, item.Credits, item.Requests, limit)
+		}
+	}
+
 	text := fmt.Sprintf(
 		"<b>👤 حساب کاربری</b>\n\n"+
 			"<b>نام:</b> %s\n"+
 			"<b>شماره:</b> %s\n"+
-			"<b>توکن‌های امروز:</b> %d\n"+
+			"<b>پلن:</b> %s\n"+
+			"<b>موجودی توکن:</b> %d\n"+
+			"<b>توکن خریداری‌شده:</b> %d\n"+
 			"<b>وضعیت:</b> %s\n\n"+
-			"<b>📊 آمار:</b>\n"+
+			"<b>📊 مصرف امروز:</b>\n%s\n"+
 			"تاریخ ثبت‌نام: %s",
 		user.FullName,
 		user.PhoneNumber,
+		html.EscapeString(planTitle),
 		tokens,
+		user.PurchasedTokens,
 		map[bool]string{true: "✅ فعال", false: "❌ غیرفعال"}[user.UnlimitedTokens],
+		usage,
 		user.CreatedAt.Format("2006-01-02"),
 	)
 
 	buttons := [][]tgbotapi.InlineKeyboardButton{
+		{
+			tgbotapi.NewInlineKeyboardButtonData("💳 خرید توکن", "buy_tokens"),
+		},
 		{
 			tgbotapi.NewInlineKeyboardButtonData("🔙 بازگشت", "back"),
 		},
@@ -131,94 +250,343 @@ func showProfile(chatID int64, session *UserSession) {
 	_ = SendWithButtons(chatID, text, buttons)
 }
 
+// showTokenPacks نمایش بسته‌های توکن قابل خرید
+func showTokenPacks(chatID int64) {
+	packs := paymentService.GetTokenPacks()
+	if len(packs) == 0 {
+		SendMessage(chatID, "📭 در حال حاضر بسته‌ای برای خرید وجود ندارد.")
+		return
+	}
+
+	var buttons [][]tgbotapi.InlineKeyboardButton
+	for _, pack := range packs {
+		label := fmt.Sprintf("%d توکن · %d تومان", pack.Tokens, pack.Price/10)
+		buttons = append(buttons, []tgbotapi.InlineKeyboardButton{
+			tgbotapi.NewInlineKeyboardButtonData(label, "buy:"+pack.ID),
+		})
+	}
+	buttons = append(buttons, []tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardButtonData("🔙 بازگشت", "back"),
+	})
+
+	_ = SendWithButtons(chatID,
+		"<b>💳 خرید توکن</b>\n\n"+
+			"توکن خریداری‌شده با ریست روزانه از بین نمی‌رود و پس از تمام شدن سهمیه امروز مصرف می‌شود.\n"+
+			"یکی از بسته‌ها را انتخاب کنید:",
+		buttons,
+	)
+}
+
+// buyTokenPack ایجاد فاکتور و ارسال لینک پرداخت
+func buyTokenPack(chatID int64, session *UserSession, packID string) {
+	payment, paymentURL, err := paymentService.CreatePurchase(session.UserID, packID)
+	if errors.Is(err, services.ErrPackNotFound) {
+		SendMessage(chatID, "❌ بسته انتخاب‌شده یافت نشد")
+		return
+	}
+	if err != nil {
+		log.Printf("❌ خطا در ایجاد فاکتور: %v", err)
+		SendMessage(chatID, "❌ خطا در اتصال به درگاه پرداخت. لطفاً بعداً تلاش کنید.")
+		return
+	}
+
+	buttons := [][]tgbotapi.InlineKeyboardButton{
+		{
+			tgbotapi.NewInlineKeyboardButtonURL("💳 پرداخت", paymentURL),
+		},
+		{
+			tgbotapi.NewInlineKeyboardButtonData("🔙 بازگشت", "back"),
+		},
+	}
+
+	_ = SendWithButtons(chatID, fmt.Sprintf(
+		"<b>🧾 فاکتور خرید</b>\n\n"+
+			"<b>بسته:</b> %d توکن\n"+
+			"<b>مبلغ:</b> %d تومان\n\n"+
+			"پس از پرداخت، توکن‌ها به‌صورت خودکار به حسابتان اضافه می‌شوند.",
+		payment.Tokens, payment.Amount/10,
+	), buttons)
+}
+
+// handleGift انتقال توکن با دستور /gift <شماره> <مقدار>
+func handleGift(chatID int64, text string, session *UserSession) {
+	args := strings.Fields(text)
+	if len(args) != 3 {
+		SendMessage(chatID, "🎁 برای هدیه دادن توکن بنویسید:\n<code>/gift 09123456789 10</code>")
+		return
+	}
+
+	phone := args[1]
+	if !utils.ValidatePhoneNumber(phone) {
+		SendMessage(chatID, "❌ شماره تلفن نامعتبر است.")
+		return
+	}
+
+	amount, err := strconv.Atoi(args[2])
+	if err != nil || amount <= 0 {
+		SendMessage(chatID, "❌ مقدار توکن باید عددی بزرگ‌تر از صفر باشد.")
+		return
+	}
+
+	recipient, err := userService.GetUserByPhone(phone)
+	if err != nil {
+		SendMessage(chatID, "❌ کاربری با این شماره یافت نشد.")
+		return
+	}
+
+	_, err = tokenService.TransferTokens(session.UserID, recipient.ID, amount, "")
+	if errors.Is(err, services.ErrInsufficientTokens) {
+		SendMessage(chatID, "❌ موجودی توکن شما برای این انتقال کافی نیست.")
+		return
+	}
+	if err != nil {
+		if !errors.Is(err, services.ErrTransferLimitExceeded) {
+			log.Printf("❌ خطا در انتقال توکن: %v", err)
+		}
+		SendMessage(chatID, "❌ "+html.EscapeString(err.Error()))
+		return
+	}
+
+	tokens, _ := tokenService.GetUserTokens(session.UserID)
+	SendMessage(chatID, fmt.Sprintf(
+		"✅ <b>%d</b> توکن به %s هدیه داده شد.\nموجودی فعلی شما: %d",
+		amount, html.EscapeString(recipient.FullName), tokens,
+	))
+}
+
 // startChat شروع چت
-func startChat(chatID int64, session *UserSession) {
-	session.State = "in_chat"
+func startChat(d *dialog, _ fsm.Input) fsm.State {
+	chatID, session := d.chatID, d.session
+	thread, err := threadService.ResolveThread(session.UserID, session.ThreadID)
+	if err != nil {
+		SendMessage(chatID, "❌ خطا در شروع گفتگو")
+		return fsm.Stay
+	}
+
+	session.ThreadID = thread.ID
 	SendMessage(chatID,
 		"<b>💬 حالت چت</b>\n\n"+
 			"سوال خود را بپرسید یا فایل کدی را بفرستید.\n"+
+			"برای گفتگوی جدید /new و برای فهرست گفتگوها /threads را بنویسید.\n"+
 			"برای بازگشت، /back را بنویسید.",
 	)
+	return stateInChat
 }
 
-// handleAIChat مدیریت چت AI
-func handleAIChat(chatID int64, text string, session *UserSession) {
-	if text == "/back" {
-		session.State = "authenticated"
-		showMainMenu(chatID)
+// startNewThread شروع رشته گفتگوی جدید
+func startNewThread(d *dialog, _ fsm.Input) fsm.State {
+	chatID, session := d.chatID, d.session
+	thread, err := threadService.CreateThread(session.UserID, "")
+	if err != nil {
+		SendMessage(chatID, "❌ خطا در ایجاد گفتگو")
+		return fsm.Stay
+	}
+
+	session.ThreadID = thread.ID
+	SendMessage(chatID,
+		"<b>🆕 گفتگوی جدید</b>\n\n"+
+			"سوال خود را بپرسید. پاسخ‌ها در همین گفتگو ادامه پیدا می‌کنند.\n"+
+			"برای بازگشت، /back را بنویسید.",
+	)
+	return stateInChat
+}
+
+// showThreads نمایش آخرین گفتگوها برای ادامه
+func showThreads(chatID int64, session *UserSession) {
+	threads, err := threadService.GetUserThreads(session.UserID, 10)
+	if err != nil {
+		SendMessage(chatID, "❌ خطا در دریافت گفتگوها")
 		return
 	}
 
-	// بررسی موجودی توکن
-	tokens, err := tokenService.GetUserTokens(session.UserID)
-	if err != nil || tokens <= 0 {
+	if len(threads) == 0 {
+		SendMessage(chatID, "📭 هنوز گفتگویی ندارید. برای شروع /new را بنویسید.")
+		return
+	}
+
+	var buttons [][]tgbotapi.InlineKeyboardButton
+	for _, thread := range threads {
+		title := thread.Title
+		if title == "" {
+			title = "گفتگوی بدون عنوان"
+		}
+		label := fmt.Sprintf("%s · %s", title, thread.UpdatedAt.Format("01-02 15:04"))
+		if thread.ID == session.ThreadID {
+			label = "✅ " + label
+		}
+		buttons = append(buttons, []tgbotapi.InlineKeyboardButton{
+			tgbotapi.NewInlineKeyboardButtonData(label, fmt.Sprintf("thread:%d", thread.ID)),
+		})
+	}
+	buttons = append(buttons, []tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardButtonData("🔙 بازگشت", "back"),
+	})
+
+	_ = SendWithButtons(chatID, "<b>🗂 گفتگوهای اخیر</b>\n\nبرای ادامه، یکی را انتخاب کنید:", buttons)
+}
+
+// resumeThread ادامه یک رشته گفتگوی قبلی
+func resumeThread(d *dialog, in fsm.Input) fsm.State {
+	chatID, session := d.chatID, d.session
+	threadID, err := strconv.ParseUint(strings.TrimPrefix(in.Data, "thread:"), 10, 64)
+	if err != nil {
+		return fsm.Stay
+	}
+
+	thread, err := threadService.GetThread(session.UserID, uint(threadID))
+	if err != nil {
+		SendMessage(chatID, "❌ گفتگو یافت نشد")
+		return fsm.Stay
+	}
+
+	session.ThreadID = thread.ID
+
+	text := fmt.Sprintf("<b>↩️ ادامه گفتگو:</b> %s\n\n", html.EscapeString(thread.Title))
+	conversations, _ := threadService.GetThreadConversations(thread.ID)
+	if len(conversations) > 0 {
+		last := conversations[len(conversations)-1]
+		text += fmt.Sprintf("<b>آخرین سوال:</b> %s\n\n", html.EscapeString(last.Question))
+	}
+	text += "سوال بعدی خود را بپرسید. برای بازگشت، /back را بنویسید."
+
+	SendMessage(chatID, text)
+	return stateInChat
+}
+
+// aiRequests چت‌هایی که درخواست AI در حال اجرا دارند
+var aiRequests = &busyChats{chats: make(map[int64]bool)}
+
+// busyChats پرچم مشغول بودن هر چت
+type busyChats struct {
+	mu    sync.Mutex
+	chats map[int64]bool
+}
+
+// acquire علامت‌گذاری چت به‌عنوان مشغول؛ نادرست یعنی چت از قبل مشغول است
+func (b *busyChats) acquire(chatID int64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.chats[chatID] {
+		return false
+	}
+	b.chats[chatID] = true
+	return true
+}
+
+// release برداشتن پرچم مشغول بودن چت
+func (b *busyChats) release(chatID int64) {
+	b.mu.Lock()
+	delete(b.chats, chatID)
+	b.mu.Unlock()
+}
+
+// runAIRequest اجرای درخواست AI بیرون از قفل چت
+//
+// پاسخ AI ممکن است ده‌ها ثانیه طول بکشد؛ fn در goroutine جدا اجرا می‌شود تا
+// /back، /logout و دکمه‌های همان چت منتظر آن نمانند. fn نباید سشن را تغییر دهد و
+// هر چت در هر زمان فقط یک درخواست AI دارد.
+func runAIRequest(chatID int64, fn func()) {
+	if !aiRequests.acquire(chatID) {
+		SendMessage(chatID, "⏳ پاسخ قبلی هنوز در حال آماده شدن است. لطفاً کمی صبر کنید.")
+		return
+	}
+
+	go func() {
+		defer aiRequests.release(chatID)
+		fn()
+	}()
+}
+
+// handleAIChat مدیریت چت AI
+func handleAIChat(chatID int64, text string, session *UserSession) {
+	userID, threadID := session.UserID, session.ThreadID
+	runAIRequest(chatID, func() { queryAI(chatID, userID, threadID, text) })
+}
+
+// queryAI پرس‌وجو از AI و نمایش تدریجی پاسخ در چت
+func queryAI(chatID int64, userID, threadID uint, text string) {
+	// رزرو اعتبار پیش از ارسال؛ درخواست‌های هم‌زمان نمی‌توانند بیش از موجودی مصرف کنند
+	reservation, err := tokenService.ReserveTokens(userID, aiService.EstimateQueryCredits(userID, text))
+	if errors.Is(err, services.ErrInsufficientTokens) {
 		SendMessage(chatID, "❌ موجودی توکن شما تمام شده است. بعداً دوباره تلاش کنید.")
 		return
 	}
+	if err != nil {
+		log.Printf("❌ خطا در رزرو توکن کاربر %d: %v", userID, err)
+		SendMessage(chatID, "❌ خطا در بررسی موجودی توکن")
+		return
+	}
 
 	// ارسال پیام درحال‌پردازش
 	msg := tgbotapi.NewMessage(chatID, "⏳ درحال پردازش...")
 	sentMsg, err := BotAPI.Send(msg)
 	if err != nil {
 		log.Printf("❌ خطا در ارسال پیام: %v", err)
+		_ = tokenService.ReleaseReservation(reservation.ID, "خطا در ارسال پیام")
 		return
 	}
 
-	// پرس‌وجو از AI
-	response, err := aiService.QueryAI(session.UserID, text)
-	if err != nil {
-		BotAPI.DeleteMessage(chatID, sentMsg.MessageID)
-		SendMessage(chatID, fmt.Sprintf("❌ خطا: %v", err))
+	// پرس‌وجو از AI با نمایش تدریجی پاسخ
+	ctx, cancel := aiContext()
+	defer cancel()
+
+	reply := newStreamingReply(chatID, sentMsg.MessageID)
+	result, err := aiService.QueryAIWithOptions(ctx, userID, text, services.QueryOptions{
+		ThreadID: threadID,
+		OnDelta:  reply.OnDelta,
+	})
+	if err != nil && result == nil {
+		// خطای AI؛ رزرو آزاد می‌شود و توکنی کسر نمی‌شود
+		log.Printf("❌ خطا در AI برای کاربر %d: %v", userID, err)
+		if err := tokenService.ReleaseReservation(reservation.ID, "خطای AI"); err != nil {
+			log.Printf("❌ خطا در آزادسازی رزرو %d: %v", reservation.ID, err)
+		}
+		reply.Fail()
+		SendMessage(chatID, "❌ "+services.AIErrorMessage(err))
 		return
 	}
 
-	// کسر توکن
-	_ = tokenService.DeductTokens(session.UserID, 1)
-
-	// ارسال پاسخ
-	BotAPI.DeleteMessage(chatID, sentMsg.MessageID)
+	if err != nil {
+		// پاسخ دریافت شده ولی ذخیره نشد؛ مصرف همچنان محاسبه می‌شود
+		log.Printf("⚠️  %v", err)
+	}
 
-	if len(response) > 4096 {
-		// تقسیم به چند پیام
-		for i := 0; i < len(response); i += 4096 {
-			end := i + 4096
-			if end > len(response) {
-				end = len(response)
-			}
-			_ = SendMessage(chatID, response[i:end])
-		}
-	} else {
-		_ = SendMessage(chatID, response)
+	// تسویه رزرو بر اساس مصرف واقعی
+	if err := tokenService.CommitReservation(reservation.ID, result.Feature, result.Credits, result.Reference); err != nil {
+		log.Printf("❌ خطا در تسویه رزرو %d: %v", reservation.ID, err)
 	}
 
-	log.Printf("✅ پاسخ برای کاربر %d ارسال شد", session.UserID)
+	// ارسال پاسخ نهایی
+	reply.Finish(result.Content)
+
+	log.Printf("✅ پاسخ برای کاربر %d ارسال شد", userID)
 }
 
 // startSupport شروع پشتیبانی
-func startSupport(chatID int64, session *UserSession) {
+func startSupport(d *dialog, _ fsm.Input) fsm.State {
+	chatID, session := d.chatID, d.session
 	supporters, err := userService.GetOnlineSupporters()
 	if err != nil || len(supporters) == 0 {
 		SendMessage(chatID, "❌ در حال حاضر پشتیبان آنلاینی موجود نیست. بعداً دوباره تلاش کنید.")
-		return
+		return fsm.Stay
 	}
 
-	session.State = "in_support"
 	SendMessage(chatID, "📞 به پشتیبان متصل شدید. منتظر پاسخ باشید...")
 
 	// انتقال به اولین پشتیبان
 	supporter := supporters[0]
 	SendMessage(int64(supporter.ID), fmt.Sprintf("📥 تیکت جدید از: %s", session.Phone))
+	return stateInSupport
+}
+
+// closeSupport بستن گفتگوی پشتیبانی
+func closeSupport(d *dialog, _ fsm.Input) fsm.State {
+	SendMessage(d.chatID, "✅ تیکت بسته شد.")
+	return stateAuthenticated
 }
 
 // handleSupportChat مدیریت چت پشتیبانی
 func handleSupportChat(chatID int64, text string, session *UserSession) {
-	if text == "/back" || text == "/close" {
-		session.State = "authenticated"
-		SendMessage(chatID, "✅ تیکت بسته شد.")
-		showMainMenu(chatID)
-		return
-	}
-
 	// ذخیره پیام
 	var user database.User
 	database.DB.First(&user, session.UserID)
@@ -234,8 +602,9 @@ func handleSupportChat(chatID int64, text string, session *UserSession) {
 	log.Printf("📨 پیام پشتیبانی از %s: %s", user.FullName, text)
 }
 
-// logout خروج
-func logout(chatID int64, session *UserSession) {
-	DeleteSession(chatID)
-	SendMessage(chatID, "✅ شما خارج شدید. برای ورود دوباره /start را بنویسید.")
+// logout خروج؛ سشن پس از پردازش پیام حذف می‌شود
+func logout(d *dialog, _ fsm.Input) fsm.State {
+	*d.session = UserSession{}
+	SendMessage(d.chatID, "✅ شما خارج شدید. برای ورود دوباره /start را بنویسید.")
+	return stateNotAuthenticated
 }
@@ -1,9 +1,14 @@
 package bot
 
 import (
+	"fmt"
 	"log"
+	"math"
+	"sync"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"telegram-bot/services"
 )
 
 // AuthenticationMiddleware بررسی احراز هویت
@@ -15,7 +20,7 @@ func AuthenticationMiddleware(update *tgbotapi.Update) bool {
 	chatID := update.Message.Chat.ID
 	session := GetSession(chatID)
 
-	if session == nil || session.State == "not_authenticated" {
+	if session == nil || session.State == string(stateNotAuthenticated) {
 		SendMessage(chatID, "❌ ابتدا وارد شوید. /start را بنویسید.")
 		return false
 	}
@@ -23,10 +28,67 @@ func AuthenticationMiddleware(update *tgbotapi.Update) bool {
 	return true
 }
 
-// RateLimitMiddleware محدودیت نرخ
+// cooldownWarnings آخرین زمان ارسال پیام محدودیت به هر چت؛ تا پایان انتظار دوباره ارسال نمی‌شود
+var (
+	cooldownWarnings   = make(map[int64]time.Time)
+	cooldownWarningsMu sync.Mutex
+)
+
+// RateLimitMiddleware محدودیت نرخ پیام‌ها؛ در صورت رد، پیام انتظار به چت ارسال می‌شود
 func RateLimitMiddleware(chatID int64) bool {
-	// می‌توان اجرای بیش‌ازحد را محدود کرد
-	return true
+	allowed, wait := allowRequest(chatID)
+	if !allowed {
+		warnCooldown(chatID, wait)
+	}
+	return allowed
+}
+
+// allowRequest محدودیت نرخ بر اساس چت و کاربر و مدت انتظار در صورت رد
+//
+// هر پیام یا دکمه یک توکن از سطل چت و در صورت ورود، از سطل کاربر مصرف می‌کند.
+// محدودیت‌ها بر اساس نقش کاربر از تنظیمات خوانده می‌شوند.
+func allowRequest(chatID int64) (bool, time.Duration) {
+	var userID uint
+	if session := GetSession(chatID); session != nil {
+		userID = session.UserID
+	}
+
+	limiter := services.GetRateLimiter()
+	role := services.UserRole(userID)
+
+	allowed, wait := limiter.Allow(services.RateLimitKey("chat", chatID), role)
+	if allowed && userID != 0 {
+		allowed, wait = limiter.Allow(services.RateLimitKey("user", userID), role)
+	}
+	return allowed, wait
+}
+
+// warnCooldown ارسال پیام محدودیت حداکثر یک بار در هر دوره انتظار
+func warnCooldown(chatID int64, wait time.Duration) {
+	cooldownWarningsMu.Lock()
+	until, warned := cooldownWarnings[chatID]
+	now := time.Now()
+	if warned && now.Before(until) {
+		cooldownWarningsMu.Unlock()
+		return
+	}
+	if len(cooldownWarnings) > 1000 {
+		for id, expires := range cooldownWarnings {
+			if now.After(expires) {
+				delete(cooldownWarnings, id)
+			}
+		}
+	}
+	cooldownWarnings[chatID] = now.Add(wait)
+	cooldownWarningsMu.Unlock()
+
+	SendMessage(chatID, cooldownText(wait))
+}
+
+// cooldownText متن پیام محدودیت نرخ
+func cooldownText(wait time.Duration) string {
+	seconds := int(math.Ceil(wait.Seconds()))
+	return fmt.Sprintf("⏳ تعداد درخواست‌های شما زیاد است. لطفاً %d ثانیه دیگر دوباره تلاش کنید.", seconds)
 }
 
 // LoggingMiddleware ثبت اطلاعات
@@ -0,0 +1,270 @@
+package bot
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"gorm.io/gorm/clause"
+	"telegram-bot/config"
+	"telegram-bot/database"
+)
+
+// sessionShardCount تعداد بخش‌های نگهداری سشن؛ قفل هر بخش جداست تا چت‌ها منتظر هم نمانند
+const sessionShardCount = 32
+
+// SessionStore نگهداری سشن چت‌ها
+//
+// Get یک کپی برمی‌گرداند و تغییرات فقط با Save ثبت می‌شوند. پردازش هر update
+// باید بین Lock و unlock همان چت انجام شود تا updateهای یک چت هم‌زمان اجرا نشوند.
+type SessionStore interface {
+	Get(chatID int64) (*UserSession, bool)
+	Save(chatID int64, session *UserSession) error
+	Delete(chatID int64) error
+	Lock(chatID int64) (unlock func())
+	Restore() (int, error)      // بارگذاری سشن‌های ذخیره‌شده هنگام شروع ربات
+	PurgeExpired() (int, error) // حذف سشن‌های منقضی
+}
+
+// NewSessionStore ایجاد محل نگهداری سشن بر اساس BOT_SESSION_STORE
+func NewSessionStore() SessionStore {
+	ttl := time.Duration(config.AppConfig.BotSessionTTLHours) * time.Hour
+	if config.AppConfig.BotSessionStore == "memory" {
+		return NewMemorySessionStore(ttl)
+	}
+	return NewSQLiteSessionStore(ttl)
+}
+
+// MemorySessionStore نگهداری سشن‌ها در حافظه؛ با راه‌اندازی دوباره از بین می‌روند
+type MemorySessionStore struct {
+	shards [sessionShardCount]sessionShard
+	locks  chatLocks
+	ttl    time.Duration
+}
+
+// sessionShard یک بخش از سشن‌ها با قفل جداگانه
+type sessionShard struct {
+	mu    sync.RWMutex
+	items map[int64]sessionEntry
+}
+
+// sessionEntry سشن و زمان انقضای آن
+type sessionEntry struct {
+	session   UserSession
+	expiresAt time.Time
+}
+
+// NewMemorySessionStore ایجاد نگهداری سشن در حافظه؛ ttl صفر یعنی بدون انقضا
+func NewMemorySessionStore(ttl time.Duration) *MemorySessionStore {
+	store := &MemorySessionStore{ttl: ttl, locks: chatLocks{locks: make(map[int64]*chatLock)}}
+	for i := range store.shards {
+		store.shards[i].items = make(map[int64]sessionEntry)
+	}
+	return store
+}
+
+// shard بخش مربوط به چت
+func (s *MemorySessionStore) shard(chatID int64) *sessionShard {
+	index := chatID % sessionShardCount
+	if index < 0 {
+		index = -index // شناسه گروه‌های تلگرام منفی است
+	}
+	return &s.shards[index]
+}
+
+// Get دریافت کپی سشن؛ سشن منقضی وجود ندارد
+func (s *MemorySessionStore) Get(chatID int64) (*UserSession, bool) {
+	shard := s.shard(chatID)
+	shard.mu.RLock()
+	entry, ok := shard.items[chatID]
+	shard.mu.RUnlock()
+
+	if !ok || (!entry.expiresAt.IsZero() && time.Now().After(entry.expiresAt)) {
+		return nil, false
+	}
+	session := entry.session
+	return &session, true
+}
+
+// Save ذخیره سشن و تمدید انقضای آن
+func (s *MemorySessionStore) Save(chatID int64, session *UserSession) error {
+	s.put(chatID, session)
+	return nil
+}
+
+// Delete حذف سشن
+func (s *MemorySessionStore) Delete(chatID int64) error {
+	shard := s.shard(chatID)
+	shard.mu.Lock()
+	delete(shard.items, chatID)
+	shard.mu.Unlock()
+	return nil
+}
+
+// Lock قفل پردازش یک چت
+func (s *MemorySessionStore) Lock(chatID int64) func() {
+	return s.locks.lock(chatID)
+}
+
+// Restore سشن‌های حافظه پس از راه‌اندازی دوباره وجود ندارند
+func (s *MemorySessionStore) Restore() (int, error) {
+	return 0, nil
+}
+
+// PurgeExpired حذف سشن‌های منقضی از حافظه
+func (s *MemorySessionStore) PurgeExpired() (int, error) {
+	now := time.Now()
+	purged := 0
+	for i := range s.shards {
+		shard := &s.shards[i]
+		shard.mu.Lock()
+		for chatID, entry := range shard.items {
+			if !entry.expiresAt.IsZero() && now.After(entry.expiresAt) {
+				delete(shard.items, chatID)
+				purged++
+			}
+		}
+		shard.mu.Unlock()
+	}
+	return purged, nil
+}
+
+// put ذخیره کپی سشن و برگرداندن زمان انقضای جدید
+func (s *MemorySessionStore) put(chatID int64, session *UserSession) time.Time {
+	var expiresAt time.Time
+	if s.ttl > 0 {
+		expiresAt = time.Now().Add(s.ttl)
+	}
+	s.putUntil(chatID, session, expiresAt)
+	return expiresAt
+}
+
+// putUntil ذخیره کپی سشن با زمان انقضای مشخص
+func (s *MemorySessionStore) putUntil(chatID int64, session *UserSession, expiresAt time.Time) {
+	shard := s.shard(chatID)
+	shard.mu.Lock()
+	shard.items[chatID] = sessionEntry{session: *session, expiresAt: expiresAt}
+	shard.mu.Unlock()
+}
+
+// SQLiteSessionStore نگهداری سشن‌ها در حافظه با ذخیره هم‌زمان در جدول bot_sessions
+//
+// خواندن از حافظه انجام می‌شود و جدول فقط برای بازگرداندن سشن‌ها پس از راه‌اندازی
+// دوباره است.
+type SQLiteSessionStore struct {
+	*MemorySessionStore
+}
+
+// NewSQLiteSessionStore ایجاد نگهداری سشن با پشتیبان دیتابیس
+func NewSQLiteSessionStore(ttl time.Duration) *SQLiteSessionStore {
+	return &SQLiteSessionStore{MemorySessionStore: NewMemorySessionStore(ttl)}
+}
+
+// Save ذخیره سشن در حافظه و دیتابیس
+func (s *SQLiteSessionStore) Save(chatID int64, session *UserSession) error {
+	expiresAt := s.put(chatID, session)
+	if expiresAt.IsZero() {
+		expiresAt = time.Now().AddDate(100, 0, 0)
+	}
+
+	row := &database.BotSession{
+		ChatID:       chatID,
+		UserID:       session.UserID,
+		ThreadID:     session.ThreadID,
+		State:        session.State,
+		ActiveAt:     session.ActiveAt,
+		Phone:        session.Phone,
+		FullName:     session.FullName,
+		OTPChallenge: session.OTPChallenge,
+		ExpiresAt:    expiresAt,
+		UpdatedAt:    time.Now(),
+	}
+	if err := database.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "chat_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"user_id", "thread_id", "state", "active_at", "phone", "full_name", "otp_challenge", "expires_at", "updated_at"}),
+	}).Create(row).Error; err != nil {
+		return fmt.Errorf("خطا در ذخیره سشن چت %d: %w", chatID, err)
+	}
+	return nil
+}
+
+// Delete حذف سشن از حافظه و دیتابیس
+func (s *SQLiteSessionStore) Delete(chatID int64) error {
+	_ = s.MemorySessionStore.Delete(chatID)
+	if err := database.DB.Where("chat_id = ?", chatID).Delete(&database.BotSession{}).Error; err != nil {
+		return fmt.Errorf("خطا در حذف سشن چت %d: %w", chatID, err)
+	}
+	return nil
+}
+
+// Restore بارگذاری سشن‌های منقضی‌نشده از دیتابیس؛ سشن‌های منقضی حذف می‌شوند
+func (s *SQLiteSessionStore) Restore() (int, error) {
+	if _, err := s.PurgeExpired(); err != nil {
+		return 0, err
+	}
+
+	var rows []database.BotSession
+	if err := database.DB.Find(&rows).Error; err != nil {
+		return 0, fmt.Errorf("خطا در بارگذاری سشن‌ها: %w", err)
+	}
+
+	for _, row := range rows {
+		s.putUntil(row.ChatID, &UserSession{
+			UserID:       row.UserID,
+			ThreadID:     row.ThreadID,
+			State:        row.State,
+			ActiveAt:     row.ActiveAt,
+			Phone:        row.Phone,
+			FullName:     row.FullName,
+			OTPChallenge: row.OTPChallenge,
+		}, row.ExpiresAt)
+	}
+	return len(rows), nil
+}
+
+// PurgeExpired حذف سشن‌های منقضی از حافظه و دیتابیس
+func (s *SQLiteSessionStore) PurgeExpired() (int, error) {
+	_, _ = s.MemorySessionStore.PurgeExpired()
+
+	result := database.DB.Where("expires_at < ?", time.Now()).Delete(&database.BotSession{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("خطا در حذف سشن‌های منقضی: %w", result.Error)
+	}
+	return int(result.RowsAffected), nil
+}
+
+// chatLocks قفل جداگانه برای هر چت؛ قفل‌های بدون استفاده حذف می‌شوند
+type chatLocks struct {
+	mu    sync.Mutex
+	locks map[int64]*chatLock
+}
+
+// chatLock قفل یک چت و تعداد منتظران آن
+type chatLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// lock گرفتن قفل چت و برگرداندن تابع آزادسازی
+func (l *chatLocks) lock(chatID int64) func() {
+	l.mu.Lock()
+	entry, ok := l.locks[chatID]
+	if !ok {
+		entry = &chatLock{}
+		l.locks[chatID] = entry
+	}
+	entry.refs++
+	l.mu.Unlock()
+
+	entry.mu.Lock()
+	return func() {
+		entry.mu.Unlock()
+
+		l.mu.Lock()
+		entry.refs--
+		if entry.refs == 0 {
+			delete(l.locks, chatID)
+		}
+		l.mu.Unlock()
+	}
+}
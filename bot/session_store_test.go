@@ -0,0 +1,56 @@
+package bot
+
+import (
+	"testing"
+	"time"
+
+	"telegram-bot/config"
+	"telegram-bot/database"
+)
+
+func TestSQLiteSessionStoreRestore(t *testing.T) {
+	config.AppConfig = &config.Config{}
+	if err := database.InitDatabase(t.TempDir() + "/test.db?_busy_timeout=5000"); err != nil {
+		t.Fatalf("InitDatabase: %v", err)
+	}
+	t.Cleanup(func() {
+		if db, err := database.DB.DB(); err == nil {
+			db.Close()
+		}
+	})
+
+	saved := &UserSession{UserID: 7, ThreadID: 3, State: "in_chat", Phone: "09120000001", FullName: "کاربر تست"}
+	if err := NewSQLiteSessionStore(time.Hour).Save(42, saved); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if err := database.DB.Create(&database.BotSession{ChatID: 43, UserID: 8, ExpiresAt: time.Now().Add(-time.Minute)}).Error; err != nil {
+		t.Fatalf("create expired session: %v", err)
+	}
+
+	// محل نگهداری جدید مثل راه‌اندازی دوباره ربات است
+	store := NewSQLiteSessionStore(time.Hour)
+	restored, err := store.Restore()
+	if err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if restored != 1 {
+		t.Errorf("restored = %d, want 1", restored)
+	}
+
+	session, ok := store.Get(42)
+	if !ok {
+		t.Fatal("restored session not found")
+	}
+	if *session != *saved {
+		t.Errorf("session = %+v, want %+v", *session, *saved)
+	}
+
+	if _, ok := store.Get(43); ok {
+		t.Error("expired session restored")
+	}
+	var count int64
+	database.DB.Model(&database.BotSession{}).Where("chat_id = ?", 43).Count(&count)
+	if count != 0 {
+		t.Errorf("expired rows = %d, want 0", count)
+	}
+}
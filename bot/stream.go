@@ -0,0 +1,99 @@
+package bot
+
+import (
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"telegram-bot/config"
+)
+
+// telegramMessageLimit حداکثر طول متن یک پیام تلگرام
+const telegramMessageLimit = 4096
+
+// streamCursor نشانگر ادامه داشتن پاسخ
+const streamCursor = " ▌"
+
+// streamingReply نمایش تدریجی پاسخ AI با ویرایش پیام «درحال پردازش»
+type streamingReply struct {
+	chatID    int64
+	messageID int
+	interval  time.Duration
+
+	mu       sync.Mutex
+	text     strings.Builder
+	lastEdit time.Time
+	lastSent string
+}
+
+// newStreamingReply ایجاد نمایش‌دهنده استریم برای پیام موجود
+func newStreamingReply(chatID int64, messageID int) *streamingReply {
+	return &streamingReply{
+		chatID:    chatID,
+		messageID: messageID,
+		interval:  time.Duration(config.AppConfig.StreamEditIntervalMS) * time.Millisecond,
+	}
+}
+
+// OnDelta افزودن تکه جدید و ویرایش پیام در صورت گذشتن فاصله مجاز
+func (r *streamingReply) OnDelta(chunk string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.text.WriteString(chunk)
+
+	// رعایت محدودیت ویرایش تلگرام
+	if time.Since(r.lastEdit) < r.interval {
+		return
+	}
+
+	preview := r.text.String()
+	if utf8.RuneCountInString(preview)+utf8.RuneCountInString(streamCursor) > telegramMessageLimit {
+		// پیش‌نمایش فقط تا سقف یک پیام؛ بقیه در پایان ارسال می‌شود
+		preview = string([]rune(preview)[:telegramMessageLimit-utf8.RuneCountInString(streamCursor)])
+	}
+	preview += streamCursor
+
+	if preview == r.lastSent {
+		return
+	}
+
+	r.lastEdit = time.Now()
+	if err := EditMessage(r.chatID, r.messageID, preview, ""); err == nil {
+		r.lastSent = preview
+	}
+}
+
+// Finish نمایش پاسخ نهایی؛ پاسخ‌های طولانی در چند پیام ارسال می‌شوند
+func (r *streamingReply) Finish(response string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if utf8.RuneCountInString(response) <= telegramMessageLimit {
+		if err := EditMessage(r.chatID, r.messageID, response, "HTML"); err != nil {
+			// متن پاسخ ممکن است HTML معتبر نباشد
+			_ = EditMessage(r.chatID, r.messageID, response, "")
+		}
+		return
+	}
+
+	_ = DeleteMessage(r.chatID, r.messageID)
+
+	runes := []rune(response)
+	for i := 0; i < len(runes); i += telegramMessageLimit {
+		end := i + telegramMessageLimit
+		if end > len(runes) {
+			end = len(runes)
+		}
+		_ = SendMessage(r.chatID, string(runes[i:end]))
+	}
+}
+
+// Fail حذف پیام موقت هنگام خطا
+func (r *streamingReply) Fail() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_ = DeleteMessage(r.chatID, r.messageID)
+}
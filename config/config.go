@@ -2,30 +2,126 @@ package config
 
 import (
 	"fmt"
+	"net"
 	"os"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // منطقه زمانی حتی روی سیستم بدون tzdata بارگذاری شود
 
 	"github.com/joho/godotenv"
 )
 
+// AIBackend یک سرویس‌دهنده AI در زنجیره failover
+type AIBackend struct {
+	Name     string
+	Provider string // "openai", "anthropic" یا "ollama"
+	Endpoint string
+	APIKey   string
+	Model    string
+}
+
+// TokenPack بسته توکن قابل خرید
+type TokenPack struct {
+	ID     string
+	Tokens int
+	Price  int64 // ریال
+}
+
+// FeatureCost هزینه و سقف روزانه یک نوع درخواست
+type FeatureCost struct {
+	Feature     string
+	BaseCredits int     // اعتبار ثابت هر درخواست
+	Multiplier  float64 // ضریب اعتبار محاسبه‌شده از توکن‌ها
+	DailyCap    int     // سقف اعتبار روزانه این قابلیت؛ صفر یعنی بدون سقف
+}
+
+// RateLimit محدودیت نرخ یک نقش با الگوریتم سطل توکن
+type RateLimit struct {
+	PerMinute float64 // نرخ پر شدن سطل؛ صفر یعنی بدون محدودیت
+	Burst     int     // ظرفیت سطل برای درخواست‌های پشت‌سرهم
+}
+
 type Config struct {
 	// Bot Configuration
 	BotToken string
 
 	// AI Configuration
-	AIAPIEndpoint string
-	AIAPIKey      string
+	AIProvider           string // "openai", "anthropic" یا "ollama"
+	AIAPIEndpoint        string
+	AIAPIKey             string
+	OpenAIModel          string
+	AnthropicAPIEndpoint string
+	AnthropicAPIKey      string
+	AnthropicModel       string
+	OllamaEndpoint       string
+	OllamaModel          string
+
+	// AIBackends زنجیره مرتب backendها؛ اولی اصلی است و بقیه در صورت خرابی استفاده می‌شوند
+	AIBackends []AIBackend
+
+	// AI Resilience Configuration
+	AIRequestTimeoutSeconds   int // مهلت هر تلاش برای پاسخ کامل
+	AIStreamTimeoutSeconds    int // مهلت هر تلاش برای پاسخ استریم
+	AIMaxRetries              int // تعداد تلاش مجدد روی 429 و 5xx
+	AIFailoverRetries         int // تعداد تلاش مجدد روی backendهایی که جایگزین بعدی دارند
+	AIRetryBaseDelayMS        int
+	AIRetryMaxDelayMS         int // Retry-After بیشتر از این مقدار یعنی عدم تلاش مجدد
+	AICircuitFailureThreshold int // تعداد خطای پیاپی برای باز شدن مدار
+	AICircuitCooldownSeconds  int
+
+	// Conversation Context Configuration
+	ContextMaxTurns      int // حداکثر تعداد پرسش/پاسخ قبلی که دوباره ارسال می‌شوند
+	ContextTokenBudget   int // سقف تخمینی توکن کل درخواست
+	ContextMaxAgeMinutes int // پس از این مدت بی‌فعالیتی، رشته گفتگوی جدید شروع می‌شود
+
+	// Streaming Configuration
+	StreamEditIntervalMS int // حداقل فاصله ویرایش پیام تلگرام هنگام استریم پاسخ
 
 	// Admin Configuration
 	AdminUsername string
 	AdminPassword string
 	JWTSecret     string
 
+	// Admin Panel Login Configuration (ADMIN_PASSWORD هش bcrypt است)
+	AdminTokenTTLMinutes int    // عمر token پنل مدیریت
+	AdminTOTPIssuer      string // نام نمایشی در برنامه احراز هویت
+
+	// Auth Session Configuration
+	AccessTokenTTLMinutes int // عمر access token
+	RefreshTokenTTLDays   int // عمر refresh token و نشست ورود
+
+	// OTP Configuration
+	OTPTTLSeconds    int
+	OTPMaxAttempts   int    // تعداد تلاش مجاز برای هر کد
+	OTPResendSeconds int    // حداقل فاصله ارسال دوباره کد
+	SMSSender        string // "kavenegar"؛ "console" و "fake" فقط با GIN_MODE=debug یا test
+	KavenegarAPIKey  string
+	KavenegarSender  string // خط ارسال؛ خالی یعنی خط پیش‌فرض حساب
+
+	// Bot Session Configuration
+	BotSessionStore    string // "sqlite" یا "memory"
+	BotSessionTTLHours int    // سشن بدون فعالیت پس از این مدت منقضی می‌شود
+
+	// Login Protection Configuration
+	LoginMaxFailures          int // خطای پشت‌سرهم تا قفل شماره، چت یا نام کاربری
+	LoginIPMaxFailures        int // برای IP بیشتر است چون ممکن است چند کاربر یک IP داشته باشند
+	LoginDelayAfter           int // پس از این تعداد خطا، فاصله مجاز بین تلاش‌ها دو برابر می‌شود
+	LoginLockoutMinutes       int // مدت قفل اول؛ هر قفل بعدی دو برابر می‌شود
+	LoginFailureWindowMinutes int // خطاهای قدیمی‌تر از این بازه شمرده نمی‌شوند
+
+	// API Key Configuration
+	APIKeyMaxPerUser int // حداکثر کلید فعال هر کاربر
+
 	// Server Configuration
 	APIPort     int
 	AdminPort   int
 	SupportPort int
 
+	// TrustedProxies پراکسی‌هایی که X-Forwarded-For آن‌ها پذیرفته می‌شود؛ خالی یعنی فقط آدرس اتصال
+	TrustedProxies []string
+
 	// Database Configuration
 	DatabasePath string
 
@@ -39,8 +135,42 @@ type Config struct {
 	// Token Configuration
 	DailyTokenLimit int
 
+	// Credit Pricing Configuration (برای مدل‌هایی که در جدول نرخ نیستند)
+	PromptCreditsPer1K     float64 // اعتبار به ازای هر ۱۰۰۰ توکن ورودی
+	CompletionCreditsPer1K float64 // اعتبار به ازای هر ۱۰۰۰ توکن خروجی
+	MinCreditsPerRequest   int
+
+	// Token Transfer Configuration (سقف روزانه هدیه توکن؛ صفر یعنی غیرفعال و منفی یعنی بدون سقف)
+	GiftDailySendLimit    int
+	GiftDailyReceiveLimit int
+
+	// Notification Configuration
+	LowBalanceThresholds   []int   // با عبور موجودی به زیر هر مقدار به کاربر اطلاع داده می‌شود
+	ResetDigestEnabled     bool    // ارسال خلاصه مصرف پس از ریست روزانه
+	UsageSpikeMultiplier   float64 // مصرف امروز بیش از این ضریب میانگین روزهای قبل یعنی جهش
+	UsageSpikeMinTokens    int     // کمتر از این مقدار هشدار جهش ارسال نمی‌شود
+	UsageSpikeBaselineDays int
+
+	// Feature Pricing Configuration
+	FeatureCosts      []FeatureCost
+	LongContextTokens int // درخواست چت با ورودی بیشتر از این مقدار "متن طولانی" حساب می‌شود
+
+	// ReservationTTLSeconds پس از این مدت، اعتبار رزروشده تسویه‌نشده آزاد می‌شود
+	ReservationTTLSeconds int
+
+	// Rate Limit Configuration (بر اساس نقش: guest، user، support و admin)
+	RateLimits map[string]RateLimit
+
+	// Payment Configuration
+	TokenPacks         []TokenPack
+	PaymentGateway     string // "zarinpal" یا "fake" (فقط با GIN_MODE=debug یا test)
+	ZarinpalMerchantID string
+	ZarinpalSandbox    bool
+	PaymentCallbackURL string // آدرس عمومی بازگشت از درگاه
+
 	// System Configuration
 	Timezone string
+	Location *time.Location // منطقه زمانی Timezone برای مرز روزها
 }
 
 var AppConfig *Config
@@ -50,34 +180,347 @@ func LoadConfig() error {
 	_ = godotenv.Load()
 
 	AppConfig = &Config{
-		BotToken:        getEnv("BOT_TOKEN", ""),
-		AIAPIEndpoint:   getEnv("AI_API_ENDPOINT", "https://api.openai.com/v1/chat/completions"),
-		AIAPIKey:        getEnv("AI_API_KEY", ""),
-		AdminUsername:   getEnv("ADMIN_USERNAME", "admin"),
-		AdminPassword:   getEnv("ADMIN_PASSWORD", ""),
-		JWTSecret:       getEnv("JWT_SECRET", "your-secret-key-min-32-characters"),
-		APIPort:         getEnvInt("API_PORT", 8080),
-		AdminPort:       getEnvInt("ADMIN_PORT", 8081),
-		SupportPort:     getEnvInt("SUPPORT_PORT", 8082),
-		DatabasePath:    getEnv("DATABASE_PATH", "./data/bot.db"),
-		MaxFileSizeMB:   getEnvInt("MAX_FILE_SIZE_MB", 10),
-		UploadPath:      getEnv("UPLOAD_PATH", "./data/uploads"),
-		LogLevel:        getEnv("LOG_LEVEL", "info"),
-		DailyTokenLimit: getEnvInt("DAILY_TOKEN_LIMIT", 30),
-		Timezone:        getEnv("TIMEZONE", "Asia/Tehran"),
+		BotToken:                  getEnv("BOT_TOKEN", ""),
+		AIProvider:                getEnv("AI_PROVIDER", "openai"),
+		AIAPIEndpoint:             getEnv("AI_API_ENDPOINT", "https://api.openai.com/v1/chat/completions"),
+		AIAPIKey:                  getEnv("AI_API_KEY", ""),
+		OpenAIModel:               getEnv("OPENAI_MODEL", "gpt-3.5-turbo"),
+		AnthropicAPIEndpoint:      getEnv("ANTHROPIC_API_ENDPOINT", "https://api.anthropic.com/v1/messages"),
+		AnthropicAPIKey:           getEnv("ANTHROPIC_API_KEY", ""),
+		AnthropicModel:            getEnv("ANTHROPIC_MODEL", "claude-3-haiku-20240307"),
+		OllamaEndpoint:            getEnv("OLLAMA_ENDPOINT", "http://localhost:11434/api/chat"),
+		OllamaModel:               getEnv("OLLAMA_MODEL", "llama3"),
+		AIRequestTimeoutSeconds:   getEnvInt("AI_REQUEST_TIMEOUT_SECONDS", 30),
+		AIStreamTimeoutSeconds:    getEnvInt("AI_STREAM_TIMEOUT_SECONDS", 300),
+		AIMaxRetries:              getEnvInt("AI_MAX_RETRIES", 3),
+		AIFailoverRetries:         getEnvInt("AI_FAILOVER_RETRIES", 1),
+		AIRetryBaseDelayMS:        getEnvInt("AI_RETRY_BASE_DELAY_MS", 500),
+		AIRetryMaxDelayMS:         getEnvInt("AI_RETRY_MAX_DELAY_MS", 10000),
+		AICircuitFailureThreshold: getEnvInt("AI_CIRCUIT_FAILURE_THRESHOLD", 5),
+		AICircuitCooldownSeconds:  getEnvInt("AI_CIRCUIT_COOLDOWN_SECONDS", 30),
+		ContextMaxTurns:           getEnvInt("CONTEXT_MAX_TURNS", 10),
+		ContextTokenBudget:        getEnvInt("CONTEXT_TOKEN_BUDGET", 3000),
+		ContextMaxAgeMinutes:      getEnvInt("CONTEXT_MAX_AGE_MINUTES", 120),
+		StreamEditIntervalMS:      getEnvInt("STREAM_EDIT_INTERVAL_MS", 1500),
+		AdminUsername:             getEnv("ADMIN_USERNAME", "admin"),
+		AdminPassword:             getEnv("ADMIN_PASSWORD", ""),
+		JWTSecret:                 getEnv("JWT_SECRET", "your-secret-key-min-32-characters"),
+		AdminTokenTTLMinutes:      getEnvInt("ADMIN_TOKEN_TTL_MINUTES", 60),
+		AdminTOTPIssuer:           getEnv("ADMIN_TOTP_ISSUER", "TechnoSharif Bot"),
+		AccessTokenTTLMinutes:     getEnvInt("ACCESS_TOKEN_TTL_MINUTES", 15),
+		RefreshTokenTTLDays:       getEnvInt("REFRESH_TOKEN_TTL_DAYS", 30),
+		OTPTTLSeconds:             getEnvInt("OTP_TTL_SECONDS", 300),
+		OTPMaxAttempts:            getEnvInt("OTP_MAX_ATTEMPTS", 5),
+		OTPResendSeconds:          getEnvInt("OTP_RESEND_SECONDS", 60),
+		SMSSender:                 getEnv("SMS_SENDER", "kavenegar"),
+		KavenegarAPIKey:           getEnv("KAVENEGAR_API_KEY", ""),
+		KavenegarSender:           getEnv("KAVENEGAR_SENDER", ""),
+		BotSessionStore:           getEnv("BOT_SESSION_STORE", "sqlite"),
+		BotSessionTTLHours:        getEnvInt("BOT_SESSION_TTL_HOURS", 168),
+		LoginMaxFailures:          getEnvInt("LOGIN_MAX_FAILURES", 5),
+		LoginIPMaxFailures:        getEnvInt("LOGIN_IP_MAX_FAILURES", 20),
+		LoginDelayAfter:           getEnvInt("LOGIN_DELAY_AFTER", 3),
+		LoginLockoutMinutes:       getEnvInt("LOGIN_LOCKOUT_MINUTES", 15),
+		LoginFailureWindowMinutes: getEnvInt("LOGIN_FAILURE_WINDOW_MINUTES", 30),
+		APIKeyMaxPerUser:          getEnvInt("API_KEY_MAX_PER_USER", 5),
+		APIPort:                   getEnvInt("API_PORT", 8080),
+		AdminPort:                 getEnvInt("ADMIN_PORT", 8081),
+		SupportPort:               getEnvInt("SUPPORT_PORT", 8082),
+		DatabasePath:              getEnv("DATABASE_PATH", "./data/bot.db"),
+		MaxFileSizeMB:             getEnvInt("MAX_FILE_SIZE_MB", 10),
+		UploadPath:                getEnv("UPLOAD_PATH", "./data/uploads"),
+		LogLevel:                  getEnv("LOG_LEVEL", "info"),
+		DailyTokenLimit:           getEnvInt("DAILY_TOKEN_LIMIT", 30),
+		PromptCreditsPer1K:        getEnvFloat("PROMPT_CREDITS_PER_1K", 0.5),
+		CompletionCreditsPer1K:    getEnvFloat("COMPLETION_CREDITS_PER_1K", 1),
+		MinCreditsPerRequest:      getEnvInt("MIN_CREDITS_PER_REQUEST", 1),
+		ReservationTTLSeconds:     getEnvInt("TOKEN_RESERVATION_TTL_SECONDS", 900),
+		LongContextTokens:         getEnvInt("LONG_CONTEXT_TOKENS", 2000),
+		GiftDailySendLimit:        getEnvInt("TOKEN_GIFT_DAILY_SEND_LIMIT", 50),
+		GiftDailyReceiveLimit:     getEnvInt("TOKEN_GIFT_DAILY_RECEIVE_LIMIT", 100),
+		ResetDigestEnabled:        getEnvBool("NOTIFY_RESET_DIGEST", true),
+		UsageSpikeMultiplier:      getEnvFloat("USAGE_SPIKE_MULTIPLIER", 2),
+		UsageSpikeMinTokens:       getEnvInt("USAGE_SPIKE_MIN_TOKENS", 500),
+		UsageSpikeBaselineDays:    getEnvInt("USAGE_SPIKE_BASELINE_DAYS", 7),
+		PaymentGateway:            getEnv("PAYMENT_GATEWAY", "zarinpal"),
+		ZarinpalMerchantID:        getEnv("ZARINPAL_MERCHANT_ID", ""),
+		ZarinpalSandbox:           getEnvBool("ZARINPAL_SANDBOX", false),
+		PaymentCallbackURL:        getEnv("PAYMENT_CALLBACK_URL", ""),
+		Timezone:                  getEnv("TIMEZONE", "Asia/Tehran"),
 	}
 
 	if AppConfig.BotToken == "" {
 		return fmt.Errorf("BOT_TOKEN is required in .env file")
 	}
 
-	if AppConfig.AIAPIKey == "" {
-		return fmt.Errorf("AI_API_KEY is required in .env file")
+	location, err := time.LoadLocation(AppConfig.Timezone)
+	if err != nil {
+		return fmt.Errorf("invalid TIMEZONE %q: %w", AppConfig.Timezone, err)
+	}
+	AppConfig.Location = location
+
+	if AppConfig.PaymentCallbackURL == "" {
+		AppConfig.PaymentCallbackURL = fmt.Sprintf("http://localhost:%d/api/v1/payments/callback", AppConfig.APIPort)
+	}
+
+	AppConfig.LowBalanceThresholds, err = loadThresholds(getEnv("LOW_BALANCE_THRESHOLDS", "10,3"))
+	if err != nil {
+		return err
+	}
+
+	AppConfig.FeatureCosts, err = loadFeatureCosts(getEnv("FEATURE_COSTS", "chat:0:1:0,long_context:1:1.5:20,code_analysis:2:1.5:20,file_upload:3:1.5:15"))
+	if err != nil {
+		return err
+	}
+
+	AppConfig.RateLimits, err = loadRateLimits(getEnv("RATE_LIMITS", "guest:10:5,user:20:10,support:60:30,admin:120:60"))
+	if err != nil {
+		return err
+	}
+
+	AppConfig.TrustedProxies, err = loadTrustedProxies(getEnv("TRUSTED_PROXIES", ""))
+	if err != nil {
+		return err
+	}
+
+	AppConfig.TokenPacks, err = loadTokenPacks(getEnv("TOKEN_PACKS", "small:50:500000,medium:150:1200000,large:500:3500000"))
+	if err != nil {
+		return err
+	}
+
+	switch AppConfig.SMSSender {
+	case "kavenegar":
+		if AppConfig.KavenegarAPIKey == "" {
+			return fmt.Errorf("KAVENEGAR_API_KEY is required with SMS_SENDER=kavenegar")
+		}
+	case "console", "fake":
+		// کد ورود در لاگ یا حافظه می‌ماند و به کاربر نمی‌رسد؛ فقط در حالت توسعه یا تست مجاز است
+		if mode := getEnv("GIN_MODE", "release"); mode != "debug" && mode != "test" {
+			return fmt.Errorf("SMS_SENDER=%s is only allowed with GIN_MODE=debug or GIN_MODE=test", AppConfig.SMSSender)
+		}
+	default:
+		return fmt.Errorf("unknown SMS_SENDER %q", AppConfig.SMSSender)
+	}
+
+	switch AppConfig.BotSessionStore {
+	case "sqlite", "memory":
+	default:
+		return fmt.Errorf("unknown BOT_SESSION_STORE %q", AppConfig.BotSessionStore)
+	}
+
+	switch AppConfig.PaymentGateway {
+	case "zarinpal":
+	case "fake":
+		// درگاه آزمایشی بدون پرداخت تایید می‌کند؛ فقط در حالت توسعه یا تست مجاز است
+		if mode := getEnv("GIN_MODE", "release"); mode != "debug" && mode != "test" {
+			return fmt.Errorf("PAYMENT_GATEWAY=fake is only allowed with GIN_MODE=debug or GIN_MODE=test")
+		}
+	default:
+		return fmt.Errorf("unknown PAYMENT_GATEWAY %q", AppConfig.PaymentGateway)
+	}
+
+	AppConfig.AIBackends = loadAIBackends(AppConfig)
+	if len(AppConfig.AIBackends) == 0 {
+		return fmt.Errorf("at least one AI backend is required")
+	}
+
+	for _, backend := range AppConfig.AIBackends {
+		switch backend.Provider {
+		case "openai", "anthropic":
+			if backend.APIKey == "" {
+				return fmt.Errorf("API key is required for AI backend %q", backend.Name)
+			}
+		case "ollama":
+			// Ollama محلی است و کلید API لازم ندارد
+		default:
+			return fmt.Errorf("unknown provider %q for AI backend %q", backend.Provider, backend.Name)
+		}
 	}
 
 	return nil
 }
 
+// ProviderDefaults تنظیمات پیش‌فرض هر نوع ارائه‌دهنده
+func (c *Config) ProviderDefaults(provider string) (endpoint, apiKey, model string) {
+	switch provider {
+	case "openai":
+		return c.AIAPIEndpoint, c.AIAPIKey, c.OpenAIModel
+	case "anthropic":
+		return c.AnthropicAPIEndpoint, c.AnthropicAPIKey, c.AnthropicModel
+	case "ollama":
+		return c.OllamaEndpoint, "", c.OllamaModel
+	}
+	return "", "", ""
+}
+
+// loadAIBackends خواندن زنجیره AI_BACKENDS؛ بدون آن فقط AI_PROVIDER استفاده می‌شود
+//
+// مثال: AI_BACKENDS=gapgpt,claude,local
+// و برای هر نام: AI_BACKEND_GAPGPT_PROVIDER، _ENDPOINT، _API_KEY و _MODEL
+func loadAIBackends(cfg *Config) []AIBackend {
+	var backends []AIBackend
+	for _, name := range strings.Split(getEnv("AI_BACKENDS", cfg.AIProvider), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		prefix := "AI_BACKEND_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		provider := getEnv(prefix+"PROVIDER", name)
+		endpoint, apiKey, model := cfg.ProviderDefaults(provider)
+
+		backends = append(backends, AIBackend{
+			Name:     name,
+			Provider: provider,
+			Endpoint: getEnv(prefix+"ENDPOINT", endpoint),
+			APIKey:   getEnv(prefix+"API_KEY", apiKey),
+			Model:    getEnv(prefix+"MODEL", model),
+		})
+	}
+	return backends
+}
+
+// loadTokenPacks خواندن بسته‌های توکن
+//
+// مثال: TOKEN_PACKS=small:50:500000,large:500:3500000
+// یعنی شناسه، تعداد توکن و قیمت به ریال
+func loadTokenPacks(value string) ([]TokenPack, error) {
+	var packs []TokenPack
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		parts := strings.Split(item, ":")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("invalid TOKEN_PACKS entry %q", item)
+		}
+
+		tokens, err := strconv.Atoi(parts[1])
+		if err != nil || tokens <= 0 {
+			return nil, fmt.Errorf("invalid token count in TOKEN_PACKS entry %q", item)
+		}
+		price, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil || price <= 0 {
+			return nil, fmt.Errorf("invalid price in TOKEN_PACKS entry %q", item)
+		}
+
+		packs = append(packs, TokenPack{ID: strings.TrimSpace(parts[0]), Tokens: tokens, Price: price})
+	}
+	return packs, nil
+}
+
+// loadFeatureCosts خواندن هزینه قابلیت‌ها
+//
+// مثال: FEATURE_COSTS=chat:0:1:0,code_analysis:2:1.5:20
+// یعنی قابلیت، اعتبار ثابت، ضریب و سقف روزانه
+func loadFeatureCosts(value string) ([]FeatureCost, error) {
+	var costs []FeatureCost
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		parts := strings.Split(item, ":")
+		if len(parts) != 4 {
+			return nil, fmt.Errorf("invalid FEATURE_COSTS entry %q", item)
+		}
+
+		base, err := strconv.Atoi(parts[1])
+		if err != nil || base < 0 {
+			return nil, fmt.Errorf("invalid base credits in FEATURE_COSTS entry %q", item)
+		}
+		multiplier, err := strconv.ParseFloat(parts[2], 64)
+		if err != nil || multiplier < 0 {
+			return nil, fmt.Errorf("invalid multiplier in FEATURE_COSTS entry %q", item)
+		}
+		dailyCap, err := strconv.Atoi(parts[3])
+		if err != nil || dailyCap < 0 {
+			return nil, fmt.Errorf("invalid daily cap in FEATURE_COSTS entry %q", item)
+		}
+
+		costs = append(costs, FeatureCost{
+			Feature:     strings.TrimSpace(parts[0]),
+			BaseCredits: base,
+			Multiplier:  multiplier,
+			DailyCap:    dailyCap,
+		})
+	}
+	return costs, nil
+}
+
+// loadRateLimits خواندن محدودیت نرخ نقش‌ها
+//
+// مثال: RATE_LIMITS=guest:10:5,user:20:10
+// یعنی نقش، تعداد درخواست در دقیقه و ظرفیت burst
+func loadRateLimits(value string) (map[string]RateLimit, error) {
+	limits := make(map[string]RateLimit)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		parts := strings.Split(item, ":")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("invalid RATE_LIMITS entry %q", item)
+		}
+
+		perMinute, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil || perMinute < 0 {
+			return nil, fmt.Errorf("invalid rate in RATE_LIMITS entry %q", item)
+		}
+		burst, err := strconv.Atoi(parts[2])
+		if err != nil || burst < 0 {
+			return nil, fmt.Errorf("invalid burst in RATE_LIMITS entry %q", item)
+		}
+
+		limits[strings.TrimSpace(parts[0])] = RateLimit{PerMinute: perMinute, Burst: burst}
+	}
+	return limits, nil
+}
+
+// loadTrustedProxies خواندن IP یا CIDR پراکسی‌های مورد اعتماد؛ مثال: TRUSTED_PROXIES=127.0.0.1,10.0.0.0/8
+func loadTrustedProxies(value string) ([]string, error) {
+	var proxies []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		if net.ParseIP(item) == nil {
+			if _, _, err := net.ParseCIDR(item); err != nil {
+				return nil, fmt.Errorf("invalid TRUSTED_PROXIES entry %q", item)
+			}
+		}
+		proxies = append(proxies, item)
+	}
+	return proxies, nil
+}
+
+// loadThresholds خواندن فهرست آستانه‌ها به ترتیب نزولی؛ مثال: LOW_BALANCE_THRESHOLDS=10,3
+func loadThresholds(value string) ([]int, error) {
+	var thresholds []int
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		threshold, err := strconv.Atoi(item)
+		if err != nil || threshold <= 0 {
+			return nil, fmt.Errorf("invalid LOW_BALANCE_THRESHOLDS entry %q", item)
+		}
+		thresholds = append(thresholds, threshold)
+	}
+
+	sort.Sort(sort.Reverse(sort.IntSlice(thresholds)))
+	return thresholds, nil
+}
+
 func getEnv(key, defaultVal string) string {
 	if value, exists := os.LookupEnv(key); exists {
 		return value
@@ -92,3 +535,19 @@ func getEnvInt(key string, defaultVal int) int {
 	}
 	return defaultVal
 }
+
+func getEnvFloat(key string, defaultVal float64) float64 {
+	valStr := getEnv(key, "")
+	if val, err := strconv.ParseFloat(valStr, 64); err == nil {
+		return val
+	}
+	return defaultVal
+}
+
+func getEnvBool(key string, defaultVal bool) bool {
+	valStr := getEnv(key, "")
+	if val, err := strconv.ParseBool(valStr); err == nil {
+		return val
+	}
+	return defaultVal
+}
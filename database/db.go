@@ -32,19 +32,49 @@ func InitDatabase(dbPath string) error {
 		return fmt.Errorf("خطا در اتصال به دیتابیس: %w", err)
 	}
 
+	// ستون telegram_verified بعداً اضافه شده است؛ چت‌هایی که پیش از آن متصل بودند
+	// با ورود ربات متصل شده‌اند و تاییدشده حساب می‌شوند تا ورود خودکار و ارسال کد
+	// به تلگرامشان از کار نیفتد
+	backfillTelegramVerified := DB.Migrator().HasTable(&User{}) && !DB.Migrator().HasColumn(&User{}, "TelegramVerified")
+
 	// خودکارسازی جدول‌ها
 	err = DB.AutoMigrate(
 		&User{},
+		&Role{},
+		&RoleAssignment{},
+		&AdminAccount{},
+		&BotSession{},
+		&AuthSession{},
+		&RevokedToken{},
+		&OTPCode{},
+		&LoginAttempt{},
+		&LoginLockout{},
+		&APIKey{},
+		&Plan{},
+		&ChatThread{},
 		&Conversation{},
 		&CodeAnalysis{},
 		&DailyTokenUsage{},
+		&DailyFeatureUsage{},
+		&TokenTransaction{},
+		&TokenReservation{},
+		&Payment{},
+		&TokenTransfer{},
 		&Setting{},
 		&SupportMessage{},
+		&ModelPricing{},
+		&FeaturePricing{},
 	)
 	if err != nil {
 		return fmt.Errorf("خطا در خودکارسازی جدول‌ها: %w", err)
 	}
 
+	if backfillTelegramVerified {
+		if err := DB.Model(&User{}).Where("telegram_id <> 0").Update("telegram_verified", true).Error; err != nil {
+			return fmt.Errorf("خطا در تایید اتصال‌های تلگرام موجود: %w", err)
+		}
+	}
+
 	log.Println("✅ دیتابیس با موفقیت راه‌اندازی شد")
 	return nil
 }
@@ -15,6 +15,78 @@ func RunMigrations(db *gorm.DB) error {
 	}
 	log.Println("✅ جدول users ایجاد شد")
 
+	// جدول نقش‌های دسترسی
+	if err := db.AutoMigrate(&Role{}); err != nil {
+		return err
+	}
+	log.Println("✅ جدول roles ایجاد شد")
+
+	// جدول نقش‌های کاربران
+	if err := db.AutoMigrate(&RoleAssignment{}); err != nil {
+		return err
+	}
+	log.Println("✅ جدول role_assignments ایجاد شد")
+
+	// جدول حساب‌های پنل مدیریت
+	if err := db.AutoMigrate(&AdminAccount{}); err != nil {
+		return err
+	}
+	log.Println("✅ جدول admin_accounts ایجاد شد")
+
+	// جدول سشن‌های ربات
+	if err := db.AutoMigrate(&BotSession{}); err != nil {
+		return err
+	}
+	log.Println("✅ جدول bot_sessions ایجاد شد")
+
+	// جدول نشست‌های ورود
+	if err := db.AutoMigrate(&AuthSession{}); err != nil {
+		return err
+	}
+	log.Println("✅ جدول auth_sessions ایجاد شد")
+
+	// جدول توکن‌های باطل‌شده
+	if err := db.AutoMigrate(&RevokedToken{}); err != nil {
+		return err
+	}
+	log.Println("✅ جدول revoked_tokens ایجاد شد")
+
+	// جدول کدهای یک‌بارمصرف
+	if err := db.AutoMigrate(&OTPCode{}); err != nil {
+		return err
+	}
+	log.Println("✅ جدول otp_codes ایجاد شد")
+
+	// جدول سابقه تلاش‌های ورود
+	if err := db.AutoMigrate(&LoginAttempt{}); err != nil {
+		return err
+	}
+	log.Println("✅ جدول login_attempts ایجاد شد")
+
+	// جدول قفل‌های ورود
+	if err := db.AutoMigrate(&LoginLockout{}); err != nil {
+		return err
+	}
+	log.Println("✅ جدول login_lockouts ایجاد شد")
+
+	// جدول کلیدهای API
+	if err := db.AutoMigrate(&APIKey{}); err != nil {
+		return err
+	}
+	log.Println("✅ جدول api_keys ایجاد شد")
+
+	// جدول پلن‌های اشتراک
+	if err := db.AutoMigrate(&Plan{}); err != nil {
+		return err
+	}
+	log.Println("✅ جدول plans ایجاد شد")
+
+	// جدول رشته‌های گفتگو
+	if err := db.AutoMigrate(&ChatThread{}); err != nil {
+		return err
+	}
+	log.Println("✅ جدول chat_threads ایجاد شد")
+
 	// جدول گفتگوها
 	if err := db.AutoMigrate(&Conversation{}); err != nil {
 		return err
@@ -39,12 +111,54 @@ func RunMigrations(db *gorm.DB) error {
 	}
 	log.Println("✅ جدول daily_token_usage ایجاد شد")
 
+	// دفتر تراکنش‌های توکن
+	if err := db.AutoMigrate(&TokenTransaction{}); err != nil {
+		return err
+	}
+	log.Println("✅ جدول token_transactions ایجاد شد")
+
+	// جدول رزرو توکن
+	if err := db.AutoMigrate(&TokenReservation{}); err != nil {
+		return err
+	}
+	log.Println("✅ جدول token_reservations ایجاد شد")
+
+	// جدول پرداخت‌ها
+	if err := db.AutoMigrate(&Payment{}); err != nil {
+		return err
+	}
+	log.Println("✅ جدول payments ایجاد شد")
+
+	// جدول انتقال توکن
+	if err := db.AutoMigrate(&TokenTransfer{}); err != nil {
+		return err
+	}
+	log.Println("✅ جدول token_transfers ایجاد شد")
+
 	// جدول تحلیل کد
 	if err := db.AutoMigrate(&CodeAnalysis{}); err != nil {
 		return err
 	}
 	log.Println("✅ جدول code_analysis ایجاد شد")
 
+	// جدول نرخ اعتبار مدل‌ها
+	if err := db.AutoMigrate(&ModelPricing{}); err != nil {
+		return err
+	}
+	log.Println("✅ جدول model_pricings ایجاد شد")
+
+	// جدول هزینه قابلیت‌ها
+	if err := db.AutoMigrate(&FeaturePricing{}); err != nil {
+		return err
+	}
+	log.Println("✅ جدول feature_pricings ایجاد شد")
+
+	// جدول مصرف روزانه قابلیت‌ها
+	if err := db.AutoMigrate(&DailyFeatureUsage{}); err != nil {
+		return err
+	}
+	log.Println("✅ جدول daily_feature_usages ایجاد شد")
+
 	// تنظیمات پیش‌فرض
 	seedDefaultSettings(db)
 
@@ -67,8 +181,17 @@ func seedDefaultSettings(db *gorm.DB) {
 			Value: "30",
 		},
 		{
+			// خالی یعنی مدل تنظیم‌شده backend (مثلاً OPENAI_MODEL یا AI_BACKEND_<NAME>_MODEL)
 			Key:   "ai_model",
-			Value: "gpt-3.5-turbo",
+			Value: "",
+		},
+		{
+			Key:   "ai_model_unlimited",
+			Value: "",
+		},
+		{
+			Key:   "ai_model_staff",
+			Value: "",
 		},
 	}
 
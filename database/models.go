@@ -5,38 +5,287 @@ import (
 )
 
 type User struct {
-	ID              uint      `gorm:"primaryKey"`
-	TelegramID      int64     `gorm:"uniqueIndex"`
-	PhoneNumber     string    `gorm:"uniqueIndex;not null"`
-	NationalCode    string    `gorm:"uniqueIndex;not null"`
-	FullName        string    `gorm:"not null"`
-	DailyTokens     int       `gorm:"default:30"`
-	UnlimitedTokens bool      `gorm:"default:false"`
-	LastTokenReset  time.Time `gorm:"not null"`
-	IsAdmin         bool      `gorm:"default:false"`
-	IsSupport       bool      `gorm:"default:false"`
-	IsOnline        bool      `gorm:"default:false"`
-	CreatedAt       time.Time `gorm:"not null"`
-	UpdatedAt       time.Time `gorm:"not null"`
+	ID               uint      `gorm:"primaryKey"`
+	TelegramID       int64     `gorm:"uniqueIndex"`
+	TelegramVerified bool      `gorm:"default:false"` // اتصال تلگرام با کد یک‌بارمصرف تایید شده است
+	PhoneNumber      string    `gorm:"uniqueIndex;not null"`
+	NationalCode     string    `gorm:"uniqueIndex;not null"`
+	FullName         string    `gorm:"not null"`
+	DailyTokens      int       `gorm:"default:30"`
+	PurchasedTokens  int       `gorm:"default:0"` // توکن خریداری‌شده؛ با ریست روزانه پاک نمی‌شود
+	UnlimitedTokens  bool      `gorm:"default:false"`
+	AIModel          string    `gorm:"size:100"` // خالی یعنی بر اساس تنظیمات
+	PlanID           uint      `gorm:"index"`    // صفر یعنی پلن رایگان
+	PlanStartsAt     time.Time // صفر یعنی از زمان تخصیص
+	PlanExpiresAt    time.Time // صفر یعنی بدون انقضا
+	LastTokenReset   time.Time `gorm:"not null"`
+	IsAdmin          bool      `gorm:"default:false"`
+	IsSupport        bool      `gorm:"default:false"`
+	IsOnline         bool      `gorm:"default:false"`
+	CreatedAt        time.Time `gorm:"not null"`
+	UpdatedAt        time.Time `gorm:"not null"`
 }
 
-type Conversation struct {
+// Role نقش دسترسی و مجوزهای آن
+type Role struct {
+	ID          uint      `gorm:"primaryKey"`
+	Name        string    `gorm:"uniqueIndex;size:50;not null"` // admin, support, auditor, ...
+	Title       string    `gorm:"not null"`
+	Permissions string    `gorm:"type:text"` // جداشده با ویرگول؛ "*" یعنی همه مجوزها
+	CreatedAt   time.Time `gorm:"not null"`
+	UpdatedAt   time.Time `gorm:"not null"`
+}
+
+// RoleAssignment نقش‌های تخصیص‌یافته به کاربر
+type RoleAssignment struct {
+	ID        uint      `gorm:"primaryKey"`
+	UserID    uint      `gorm:"uniqueIndex:idx_user_role;not null"`
+	RoleID    uint      `gorm:"uniqueIndex:idx_user_role;not null"`
+	CreatedAt time.Time `gorm:"not null"`
+}
+
+// AdminAccount حساب ورود پنل مدیریت با نام کاربری و رمز عبور
+type AdminAccount struct {
+	ID           uint   `gorm:"primaryKey"`
+	Username     string `gorm:"uniqueIndex;size:100;not null"`
+	PasswordHash string `gorm:"not null"`         // bcrypt
+	Role         string `gorm:"size:50;not null"` // نام نقش دسترسی
+	UserID       uint   `gorm:"index"`            // کاربر تلگرام متناظر؛ صفر یعنی بدون کاربر
+	TOTPSecret   string `gorm:"size:64"`          // پیش از تایید ثبت‌نام هم ذخیره می‌شود
+	TOTPEnabled  bool   `gorm:"default:false"`
+	TOTPLastStep int64  // آخرین بازه کد پذیرفته‌شده؛ جلوگیری از استفاده دوباره
+	Disabled     bool   `gorm:"default:false"`
+	LastLoginAt  time.Time
+	CreatedAt    time.Time `gorm:"not null"`
+	UpdatedAt    time.Time `gorm:"not null"`
+}
+
+// BotSession وضعیت گفتگوی ربات هر چت تا پس از راه‌اندازی دوباره ادامه یابد
+type BotSession struct {
+	ID           uint  `gorm:"primaryKey"`
+	ChatID       int64 `gorm:"uniqueIndex;not null"`
+	UserID       uint  `gorm:"index"`
+	ThreadID     uint
+	State        string `gorm:"size:50;not null"`
+	ActiveAt     time.Time
+	Phone        string `gorm:"size:20"` // شماره واردشده در مرحله ورود؛ کد ملی ذخیره نمی‌شود
+	FullName     string
+	OTPChallenge string    `gorm:"size:64"` // چالش کد ورود در انتظار تایید
+	ExpiresAt    time.Time `gorm:"index;not null"`
+	UpdatedAt    time.Time `gorm:"not null"`
+}
+
+// AuthSession نشست ورود یک دستگاه با refresh token چرخشی
+type AuthSession struct {
+	ID                uint   `gorm:"primaryKey"`
+	UserID            uint   `gorm:"index;not null"`
+	RefreshTokenHash  string `gorm:"uniqueIndex;size:64;not null"` // SHA-256 توکن فعلی
+	PreviousTokenHash string `gorm:"index;size:64"`                // توکن قبلی؛ استفاده دوباره یعنی سرقت توکن
+	AccessJTI         string `gorm:"size:64"`                      // شناسه آخرین access token صادرشده
+	UserAgent         string `gorm:"size:255"`
+	IP                string `gorm:"size:64"`
+	Revoked           bool   `gorm:"default:false;index"`
+	RevokedAt         time.Time
+	ExpiresAt         time.Time `gorm:"index;not null"`
+	LastUsedAt        time.Time
+	CreatedAt         time.Time `gorm:"not null"`
+}
+
+// APIKey کلید دسترسی برنامه‌ها؛ خود کلید فقط هنگام ایجاد نمایش داده می‌شود
+type APIKey struct {
 	ID         uint      `gorm:"primaryKey"`
 	UserID     uint      `gorm:"index;not null"`
-	Question   string    `gorm:"type:text;not null"`
-	Answer     string    `gorm:"type:text;not null"`
-	TokensUsed int       `gorm:"default:1"`
+	Name       string    `gorm:"size:100;not null"`
+	Prefix     string    `gorm:"size:16;not null"`             // ابتدای کلید برای شناسایی در فهرست
+	KeyHash    string    `gorm:"uniqueIndex;size:64;not null"` // SHA-256 کلید
+	Scopes     string    `gorm:"type:text;not null"`           // جداشده با ویرگول
+	RatePerMin int       `gorm:"default:0"`                    // صفر یعنی محدودیت نقش کاربر
+	RateBurst  int       `gorm:"default:0"`
+	ExpiresAt  time.Time // صفر یعنی بدون انقضا
+	LastUsedAt time.Time
+	LastUsedIP string    `gorm:"size:64"`
+	Revoked    bool      `gorm:"default:false;index"`
+	CreatedBy  uint      // کاربری که کلید را ساخته (خود کاربر یا ادمین)
 	CreatedAt  time.Time `gorm:"not null"`
 }
 
+// OTPCode کد یک‌بارمصرف ورود
+type OTPCode struct {
+	ID          uint      `gorm:"primaryKey"`
+	ChallengeID string    `gorm:"uniqueIndex;size:64;not null"` // شناسه‌ای که به کلاینت داده می‌شود
+	UserID      uint      `gorm:"index;not null"`
+	CodeHash    string    `gorm:"size:64;not null"`
+	Channel     string    `gorm:"size:20;not null"` // telegram یا sms
+	Attempts    int       `gorm:"default:0"`
+	Used        bool      `gorm:"default:false"`
+	ExpiresAt   time.Time `gorm:"index;not null"`
+	CreatedAt   time.Time `gorm:"not null"`
+}
+
+// LoginAttempt سابقه هر تلاش ورود برای بررسی تلاش‌های تصاحب حساب
+type LoginAttempt struct {
+	ID        uint      `gorm:"primaryKey"`
+	Flow      string    `gorm:"index;size:20;not null"` // api، otp، bot یا admin
+	Phone     string    `gorm:"index;size:20"`
+	Username  string    `gorm:"index;size:100"` // نام کاربری پنل مدیریت
+	IP        string    `gorm:"index;size:64"`
+	ChatID    int64     `gorm:"index"`
+	UserID    uint      `gorm:"index"` // فقط در ورود موفق
+	Success   bool      `gorm:"index"`
+	Reason    string    `gorm:"size:100"`
+	CreatedAt time.Time `gorm:"index;not null"`
+}
+
+// LoginLockout شمارنده خطای ورود و قفل موقت یک شماره، IP، چت یا نام کاربری
+type LoginLockout struct {
+	ID            uint   `gorm:"primaryKey"`
+	LockKey       string `gorm:"uniqueIndex;size:120;not null"` // مثلاً phone:0912...، ip:1.2.3.4 یا chat:123
+	Failures      int    `gorm:"default:0"`                     // خطاهای پشت‌سرهم در بازه شمارش
+	LockCount     int    `gorm:"default:0"`                     // تعداد قفل‌ها برای افزایش تدریجی مدت قفل
+	LastFailureAt time.Time
+	LockedUntil   time.Time `gorm:"index"`
+	UnlockedBy    string    `gorm:"size:50"` // کاربر یا حساب مدیریتی که قفل را برداشت، مثلاً user:5 یا account:2
+	UpdatedAt     time.Time `gorm:"not null"`
+}
+
+// RevokedToken access tokenهای باطل‌شده تا زمان انقضا
+type RevokedToken struct {
+	ID        uint      `gorm:"primaryKey"`
+	JTI       string    `gorm:"uniqueIndex;size:64;not null"`
+	UserID    uint      `gorm:"index;not null"`
+	ExpiresAt time.Time `gorm:"index;not null"`
+	CreatedAt time.Time `gorm:"not null"`
+}
+
+// Plan سطح اشتراک کاربر و سهمیه‌های آن
+type Plan struct {
+	ID            uint      `gorm:"primaryKey"`
+	Name          string    `gorm:"uniqueIndex;size:50;not null"` // free, student, premium, staff
+	Title         string    `gorm:"not null"`
+	DailyTokens   int       `gorm:"not null"`
+	MonthlyTokens int       `gorm:"default:0"` // صفر یعنی بدون سقف ماهانه
+	Unlimited     bool      `gorm:"default:false"`
+	AllowedModels string    `gorm:"type:text"` // جداشده با ویرگول؛ خالی یعنی همه مدل‌ها
+	MaxFileSizeMB int       `gorm:"not null"`
+	Priority      int       `gorm:"default:0"` // عدد بیشتر یعنی اولویت بالاتر
+	CreatedAt     time.Time `gorm:"not null"`
+	UpdatedAt     time.Time `gorm:"not null"`
+}
+
+type ChatThread struct {
+	ID        uint      `gorm:"primaryKey"`
+	UserID    uint      `gorm:"index;not null"`
+	Title     string    `gorm:"not null"`
+	CreatedAt time.Time `gorm:"not null"`
+	UpdatedAt time.Time `gorm:"not null"`
+}
+
+type Conversation struct {
+	ID               uint      `gorm:"primaryKey"`
+	UserID           uint      `gorm:"index;not null"`
+	ThreadID         uint      `gorm:"index"`
+	Question         string    `gorm:"type:text;not null"`
+	Answer           string    `gorm:"type:text;not null"`
+	Model            string    `gorm:"size:100"`
+	PromptTokens     int       `gorm:"default:0"`
+	CompletionTokens int       `gorm:"default:0"`
+	UsageEstimated   bool      `gorm:"default:false"` // ارائه‌دهنده مصرف را گزارش نکرد
+	TokensUsed       int       `gorm:"default:1"`     // اعتبار کسرشده از کاربر
+	CreatedAt        time.Time `gorm:"not null"`
+}
+
 type CodeAnalysis struct {
+	ID               uint      `gorm:"primaryKey"`
+	UserID           uint      `gorm:"index;not null"`
+	OriginalCode     string    `gorm:"type:text;not null"`
+	FixedCode        string    `gorm:"type:text;not null"`
+	Language         string    `gorm:"not null"`
+	Filename         string    `gorm:"not null"`
+	Model            string    `gorm:"size:100"`
+	PromptTokens     int       `gorm:"default:0"`
+	CompletionTokens int       `gorm:"default:0"`
+	UsageEstimated   bool      `gorm:"default:false"`
+	TokensUsed       int       `gorm:"default:1"` // اعتبار کسرشده از کاربر
+	CreatedAt        time.Time `gorm:"not null"`
+}
+
+// ModelPricing نرخ تبدیل توکن‌های مدل به اعتبار ربات
+type ModelPricing struct {
+	ID                     uint      `gorm:"primaryKey"`
+	Model                  string    `gorm:"uniqueIndex;not null"` // نام کامل یا پیشوند مدل، مثلاً "gpt-4o"
+	PromptCreditsPer1K     float64   `gorm:"not null"`
+	CompletionCreditsPer1K float64   `gorm:"not null"`
+	UpdatedAt              time.Time `gorm:"not null"`
+}
+
+// FeaturePricing هزینه و سقف روزانه یک قابلیت؛ مقادیر تنظیمات را جایگزین می‌کند
+type FeaturePricing struct {
+	ID          uint      `gorm:"primaryKey"`
+	Feature     string    `gorm:"uniqueIndex;size:50;not null"` // chat, long_context, code_analysis, file_upload
+	BaseCredits int       `gorm:"not null"`
+	Multiplier  float64   `gorm:"not null"`
+	DailyCap    int       `gorm:"default:0"` // صفر یعنی بدون سقف
+	UpdatedAt   time.Time `gorm:"not null"`
+}
+
+// TokenTransaction یک ردیف از دفتر توکن؛ فقط اضافه می‌شود و هرگز ویرایش نمی‌شود
+type TokenTransaction struct {
 	ID           uint      `gorm:"primaryKey"`
 	UserID       uint      `gorm:"index;not null"`
-	OriginalCode string    `gorm:"type:text;not null"`
-	FixedCode    string    `gorm:"type:text;not null"`
-	Language     string    `gorm:"not null"`
-	Filename     string    `gorm:"not null"`
-	CreatedAt    time.Time `gorm:"not null"`
+	Type         string    `gorm:"size:20;index;not null"` // grant, spend, refund, reset, admin_adjust
+	Amount       int       `gorm:"not null"`               // تغییر کل موجودی؛ منفی برای کسر
+	BalanceAfter int       `gorm:"not null"`
+	Reference    string    `gorm:"size:100;index"` // مثلاً "conversation:42"
+	Note         string    `gorm:"type:text"`
+	ActorID      uint      `gorm:"index"` // کاربر ادمینی که تغییر را انجام داد؛ صفر یعنی سیستم
+	CreatedAt    time.Time `gorm:"index;not null"`
+
+	// حساب پنل مدیریتی که تغییر را انجام داد؛ صفر یعنی تغییر با نشست کاربری یا سیستم
+	ActorAccountID uint `gorm:"index"`
+
+	// سهم توکن خریداری‌شده از Amount و موجودی خریداری‌شده پس از تراکنش؛
+	// BalanceAfter فقط سهمیه روزانه است
+	PurchasedAmount       int `gorm:"default:0"`
+	PurchasedBalanceAfter int `gorm:"default:0"`
+}
+
+// TokenReservation اعتباری که پیش از فراخوانی AI نگه داشته می‌شود
+type TokenReservation struct {
+	ID        uint      `gorm:"primaryKey"`
+	UserID    uint      `gorm:"index;not null"`
+	Amount    int       `gorm:"not null"`               // نگه‌داشته‌شده از سهمیه روزانه
+	Purchased int       `gorm:"default:0"`              // نگه‌داشته‌شده از توکن خریداری‌شده
+	Status    string    `gorm:"size:20;index;not null"` // held, committed, released, expired
+	Reference string    `gorm:"size:100"`               // رکورد نهایی پس از تسویه
+	ExpiresAt time.Time `gorm:"index;not null"`
+	CreatedAt time.Time `gorm:"not null"`
+	UpdatedAt time.Time `gorm:"not null"`
+}
+
+// TokenTransfer انتقال توکن از یک کاربر به کاربر دیگر
+type TokenTransfer struct {
+	ID         uint      `gorm:"primaryKey"`
+	FromUserID uint      `gorm:"index;not null"`
+	ToUserID   uint      `gorm:"index;not null"`
+	Amount     int       `gorm:"not null"`
+	Note       string    `gorm:"type:text"`
+	CreatedAt  time.Time `gorm:"index;not null"`
+}
+
+// Payment خرید یک بسته توکن از درگاه پرداخت
+type Payment struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"index;not null"`
+	PackID    string `gorm:"size:50;not null"`
+	Tokens    int    `gorm:"not null"`
+	Amount    int64  `gorm:"not null"` // ریال
+	Gateway   string `gorm:"size:20;not null"`
+	Authority string `gorm:"uniqueIndex;size:100"`   // شناسه فاکتور در درگاه
+	RefID     string `gorm:"size:100"`               // شماره پیگیری پس از تایید
+	Status    string `gorm:"size:20;index;not null"` // pending, paid, failed
+	PaidAt    time.Time
+	CreatedAt time.Time `gorm:"not null"`
+	UpdatedAt time.Time `gorm:"not null"`
 }
 
 type DailyTokenUsage struct {
@@ -46,6 +295,16 @@ type DailyTokenUsage struct {
 	Date       time.Time `gorm:"not null"`
 }
 
+// DailyFeatureUsage مصرف روزانه هر کاربر به تفکیک قابلیت
+type DailyFeatureUsage struct {
+	ID          uint      `gorm:"primaryKey"`
+	UserID      uint      `gorm:"uniqueIndex:idx_feature_usage_day;not null"`
+	Feature     string    `gorm:"uniqueIndex:idx_feature_usage_day;size:50;not null"`
+	Date        time.Time `gorm:"uniqueIndex:idx_feature_usage_day;not null"`
+	Requests    int       `gorm:"not null"`
+	CreditsUsed int       `gorm:"not null"`
+}
+
 type Setting struct {
 	ID    uint   `gorm:"primaryKey"`
 	Key   string `gorm:"uniqueIndex;not null"`
@@ -41,12 +41,30 @@ func main() {
 	defer database.CloseDatabase()
 	log.Println("✅ دیتابیس شروع شد")
 
+	// ایجاد پلن‌های پیش‌فرض اشتراک
+	if err := (&services.PlanService{}).EnsureDefaultPlans(); err != nil {
+		log.Fatalf("❌ خطا در ایجاد پلن‌ها: %v", err)
+	}
+
+	// ایجاد نقش‌های پیش‌فرض دسترسی
+	if err := (&services.RBACService{}).EnsureDefaultRoles(); err != nil {
+		log.Fatalf("❌ خطا در ایجاد نقش‌ها: %v", err)
+	}
+
+	// ایجاد حساب پنل مدیریت از تنظیمات
+	if err := (&services.AdminAuthService{}).EnsureConfigAdmin(); err != nil {
+		log.Fatalf("❌ خطا در ایجاد حساب مدیریت: %v", err)
+	}
+
 	// شروع ربات تلگرام
 	if err := bot.InitBot(); err != nil {
 		log.Fatalf("❌ خطا در شروع ربات: %v", err)
 	}
 	log.Println("✅ ربات تلگرام شروع شد")
 
+	// اعلان‌های سرویس‌ها از طریق ربات ارسال می‌شوند
+	services.SetNotifier(bot.SendMessage)
+
 	// شروع API سرور
 	api.InitServer()
 	log.Printf("✅ API سرور تنظیم شد - پورت %d", config.AppConfig.APIPort)
@@ -57,12 +75,16 @@ func main() {
 
 	var wg sync.WaitGroup
 
+	// context ربات و زمان‌بندها؛ با سیگنال shutdown لغو می‌شود
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+
 	// شروع ربات
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 		log.Println("🤖 ربات تلگرام شروع شد...")
-		bot.StartBot()
+		bot.StartBot(jobsCtx)
 	}()
 
 	// شروع API سرور
@@ -74,11 +96,32 @@ func main() {
 		}
 	}()
 
-	// شروع کرون جاب ریست توکن
+	// زمان‌بند ریست روزانه توکن؛ با سیگنال shutdown متوقف می‌شود
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		services.NewDailyResetScheduler().Run(jobsCtx)
+	}()
+
+	// آزادسازی رزروهای توکن منقضی
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		startReservationExpiryCron(jobsCtx)
+	}()
+
+	// هشدار جهش مصرف به ادمین‌ها
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		startTokenResetCron()
+		startUsageSpikeCron(jobsCtx)
+	}()
+
+	// پاک‌سازی نشست‌ها و tokenهای باطل‌شده منقضی
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		startAuthCleanupCron(jobsCtx)
 	}()
 
 	log.Println("\n" +
@@ -94,6 +137,7 @@ func main() {
 	// منتظر بماند برای shutdown
 	<-sigChan
 	log.Println("\n🛑 سیگنال shutdown دریافت شد...")
+	stopJobs()
 
 	// متوقف کردن graceful
 	_, cancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -111,20 +155,92 @@ func main() {
 	wg.Wait()
 }
 
-// startTokenResetCron ریست توکن‌ها هر روز در نیمه‌شب
-func startTokenResetCron() {
+// startReservationExpiryCron آزاد کردن دوره‌ای رزروهای توکنی که تسویه نشده‌اند
+func startReservationExpiryCron(ctx context.Context) {
 	tokenService := &services.TokenService{}
 
 	ticker := time.NewTicker(1 * time.Minute)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		now := time.Now()
-		// بررسی اگر ساعت 00:00 است
-		if now.Hour() == 0 && now.Minute() == 0 {
-			log.Println("🔄 ریست کردن توکن‌های روزانه...")
-			if err := tokenService.ResetAllDailyTokens(); err != nil {
-				log.Printf("❌ خطا در ریست توکن‌ها: %v", err)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			expired, err := tokenService.ExpireReservations()
+			if err != nil {
+				log.Printf("❌ خطا در آزادسازی رزروها: %v", err)
+				continue
+			}
+			if expired > 0 {
+				log.Printf("🔄 %d رزرو توکن منقضی آزاد شد", expired)
+			}
+		}
+	}
+}
+
+// startUsageSpikeCron بررسی دوره‌ای جهش مصرف کل نسبت به روزهای قبل
+func startUsageSpikeCron(ctx context.Context) {
+	notificationService := &services.NotificationService{}
+
+	ticker := time.NewTicker(15 * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			alerted, err := notificationService.CheckUsageSpike()
+			if err != nil {
+				log.Printf("❌ خطا در بررسی جهش مصرف: %v", err)
+				continue
+			}
+			if alerted {
+				log.Println("📈 هشدار جهش مصرف برای ادمین‌ها ارسال شد")
+			}
+		}
+	}
+}
+
+// startAuthCleanupCron حذف دوره‌ای نشست‌های ورود، ردیف‌های ابطال، کدهای ورود و سشن‌های ربات منقضی
+func startAuthCleanupCron(ctx context.Context) {
+	authService := &services.AuthService{}
+	otpService := &services.OTPService{}
+
+	ticker := time.NewTicker(1 * time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purged, err := authService.PurgeExpiredAuth()
+			if err != nil {
+				log.Printf("❌ خطا در پاک‌سازی نشست‌ها: %v", err)
+				continue
+			}
+			if purged > 0 {
+				log.Printf("🔄 %d نشست و token منقضی حذف شد", purged)
+			}
+
+			codes, err := otpService.PurgeExpiredCodes()
+			if err != nil {
+				log.Printf("❌ خطا در پاک‌سازی کدهای ورود: %v", err)
+				continue
+			}
+			if codes > 0 {
+				log.Printf("🔄 %d کد ورود منقضی حذف شد", codes)
+			}
+
+			sessions, err := bot.Sessions.PurgeExpired()
+			if err != nil {
+				log.Printf("❌ خطا در پاک‌سازی سشن‌های ربات: %v", err)
+				continue
+			}
+			if sessions > 0 {
+				log.Printf("🔄 %d سشن منقضی ربات حذف شد", sessions)
 			}
 		}
 	}
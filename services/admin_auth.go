@@ -0,0 +1,319 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"telegram-bot/config"
+	"telegram-bot/database"
+	"telegram-bot/utils"
+)
+
+// adminTokenScope مقدار claim scope در token پنل مدیریت
+const adminTokenScope = "admin"
+
+var (
+	ErrAdminInvalidCredentials = errors.New("نام کاربری یا رمز عبور نادرست است")
+	ErrAdminTOTPRequired       = errors.New("کد تایید دومرحله‌ای لازم است")
+	ErrAdminTOTPInvalid        = errors.New("کد تایید دومرحله‌ای نادرست است")
+	ErrAdminAccountNotFound    = errors.New("حساب مدیریت یافت نشد")
+)
+
+// AdminAuthService ورود پنل مدیریت با نام کاربری، رمز عبور و TOTP
+type AdminAuthService struct{}
+
+// AdminClaims اطلاعات token تاییدشده پنل مدیریت
+type AdminClaims struct {
+	AccountID uint
+	JTI       string
+	ExpiresAt time.Time
+}
+
+// EnsureConfigAdmin ایجاد حساب مدیریت از ADMIN_USERNAME و ADMIN_PASSWORD در صورت نبود
+//
+// ADMIN_PASSWORD باید هش bcrypt باشد؛ رمز ساده برای سازگاری هش و ذخیره می‌شود.
+// پس از ایجاد، رمز و TOTP حساب از جدول خوانده می‌شوند.
+func (s *AdminAuthService) EnsureConfigAdmin() error {
+	username := strings.TrimSpace(config.AppConfig.AdminUsername)
+	password := config.AppConfig.AdminPassword
+	if username == "" || password == "" {
+		return nil
+	}
+
+	var existing database.AdminAccount
+	result := database.DB.Where("username = ?", username).Limit(1).Find(&existing)
+	if result.Error != nil {
+		return fmt.Errorf("خطا در بررسی حساب مدیریت: %w", result.Error)
+	}
+	if result.RowsAffected > 0 {
+		return nil
+	}
+
+	hash := password
+	if !strings.HasPrefix(password, "$2") {
+		utils.LogInfo("AdminAuthService", "ADMIN_PASSWORD هش bcrypt نیست؛ بهتر است هش آن در تنظیمات قرار گیرد")
+		var err error
+		if hash, err = utils.HashPassword(password); err != nil {
+			return fmt.Errorf("خطا در هش رمز مدیریت: %w", err)
+		}
+	}
+
+	account := &database.AdminAccount{
+		Username:     username,
+		PasswordHash: hash,
+		Role:         RoleAdmin,
+		CreatedAt:    time.Now(),
+		UpdatedAt:    time.Now(),
+	}
+	if err := database.DB.Create(account).Error; err != nil {
+		return fmt.Errorf("خطا در ایجاد حساب مدیریت: %w", err)
+	}
+	return nil
+}
+
+// CreateAccount ایجاد حساب مدیریت جدید
+func (s *AdminAuthService) CreateAccount(username, password, role string, userID uint) (*database.AdminAccount, error) {
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return nil, fmt.Errorf("نام کاربری الزامی است")
+	}
+	if len(password) < 8 {
+		return nil, fmt.Errorf("رمز عبور باید حداقل ۸ کاراکتر باشد")
+	}
+
+	var roleRow database.Role
+	if err := database.DB.Where("name = ?", role).First(&roleRow).Error; err != nil {
+		return nil, ErrRoleNotFound
+	}
+
+	hash, err := utils.HashPassword(password)
+	if err != nil {
+		return nil, fmt.Errorf("خطا در هش رمز عبور: %w", err)
+	}
+
+	account := &database.AdminAccount{
+		Username:     username,
+		PasswordHash: hash,
+		Role:         role,
+		UserID:       userID,
+		CreatedAt:    time.Now(),
+		UpdatedAt:    time.Now(),
+	}
+	if err := database.DB.Create(account).Error; err != nil {
+		return nil, fmt.Errorf("این نام کاربری قبلاً ثبت شده است")
+	}
+	return account, nil
+}
+
+// GetAccounts دریافت حساب‌های مدیریت
+func (s *AdminAuthService) GetAccounts() ([]database.AdminAccount, error) {
+	var accounts []database.AdminAccount
+	if err := database.DB.Order("id ASC").Find(&accounts).Error; err != nil {
+		return nil, fmt.Errorf("خطا در دریافت حساب‌ها: %w", err)
+	}
+	return accounts, nil
+}
+
+// GetAccount دریافت حساب مدیریت
+func (s *AdminAuthService) GetAccount(accountID uint) (*database.AdminAccount, error) {
+	var account database.AdminAccount
+	if err := database.DB.First(&account, accountID).Error; err != nil {
+		return nil, ErrAdminAccountNotFound
+	}
+	return &account, nil
+}
+
+// Login بررسی رمز و در صورت فعال بودن، کد TOTP و صدور token پنل مدیریت
+func (s *AdminAuthService) Login(username, password, totpCode string) (string, *database.AdminAccount, error) {
+	var account database.AdminAccount
+	result := database.DB.Where("username = ?", strings.TrimSpace(username)).Limit(1).Find(&account)
+	if result.Error != nil {
+		return "", nil, fmt.Errorf("خطا در بررسی حساب: %w", result.Error)
+	}
+	if result.RowsAffected == 0 || account.Disabled || !utils.VerifyPassword(account.PasswordHash, password) {
+		return "", nil, ErrAdminInvalidCredentials
+	}
+
+	if account.TOTPEnabled {
+		if totpCode == "" {
+			return "", nil, ErrAdminTOTPRequired
+		}
+		if err := s.consumeTOTP(&account, totpCode); err != nil {
+			return "", nil, err
+		}
+	}
+
+	token, err := s.generateToken(account.ID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	database.DB.Model(&account).Update("last_login_at", time.Now())
+	return token, &account, nil
+}
+
+// VerifyToken تایید token پنل مدیریت؛ token کاربران عادی پذیرفته نمی‌شود
+func (s *AdminAuthService) VerifyToken(tokenString string) (*AdminClaims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, jwt.MapClaims{}, func(token *jwt.Token) (interface{}, error) {
+		return []byte(config.AppConfig.JWTSecret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil || !token.Valid {
+		return nil, fmt.Errorf("token نامعتبر است")
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims["scope"] != adminTokenScope {
+		return nil, fmt.Errorf("token پنل مدیریت نیست")
+	}
+
+	accountID, _ := claims["admin_id"].(float64)
+	jti, _ := claims["jti"].(string)
+	expiresAt, err := claims.GetExpirationTime()
+	if accountID == 0 || jti == "" || err != nil || expiresAt == nil {
+		return nil, fmt.Errorf("token نامعتبر است")
+	}
+
+	return &AdminClaims{AccountID: uint(accountID), JTI: jti, ExpiresAt: expiresAt.Time}, nil
+}
+
+// GetAccountPermissions مجوزهای نقش حساب مدیریت
+func (s *AdminAuthService) GetAccountPermissions(accountID uint) (*database.AdminAccount, []string, error) {
+	account, err := s.GetAccount(accountID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if account.Disabled {
+		return nil, nil, ErrAdminAccountNotFound
+	}
+
+	var role database.Role
+	result := database.DB.Where("name = ?", account.Role).Limit(1).Find(&role)
+	if result.Error != nil {
+		return nil, nil, fmt.Errorf("خطا در دریافت نقش: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return account, nil, nil
+	}
+	return account, splitPermissions(role.Permissions), nil
+}
+
+// Logout ابطال token پنل مدیریت تا پایان عمر آن
+func (s *AdminAuthService) Logout(claims *AdminClaims) error {
+	return database.DB.Create(&database.RevokedToken{
+		JTI:       claims.JTI,
+		ExpiresAt: claims.ExpiresAt,
+		CreatedAt: time.Now(),
+	}).Error
+}
+
+// SetupTOTP ایجاد کلید TOTP جدید؛ تا تایید با ConfirmTOTP فعال نمی‌شود
+func (s *AdminAuthService) SetupTOTP(accountID uint) (secret, uri string, err error) {
+	account, err := s.GetAccount(accountID)
+	if err != nil {
+		return "", "", err
+	}
+	if account.TOTPEnabled {
+		return "", "", fmt.Errorf("تایید دومرحله‌ای قبلاً فعال شده است")
+	}
+
+	secret, err = utils.GenerateTOTPSecret()
+	if err != nil {
+		return "", "", fmt.Errorf("خطا در تولید کلید: %w", err)
+	}
+
+	if err := database.DB.Model(account).Updates(map[string]interface{}{
+		"totp_secret":    secret,
+		"totp_last_step": 0,
+	}).Error; err != nil {
+		return "", "", fmt.Errorf("خطا در ذخیره کلید: %w", err)
+	}
+
+	return secret, utils.TOTPURI(config.AppConfig.AdminTOTPIssuer, account.Username, secret), nil
+}
+
+// ConfirmTOTP فعال‌سازی TOTP پس از وارد کردن اولین کد صحیح
+func (s *AdminAuthService) ConfirmTOTP(accountID uint, code string) error {
+	account, err := s.GetAccount(accountID)
+	if err != nil {
+		return err
+	}
+	if account.TOTPEnabled {
+		return fmt.Errorf("تایید دومرحله‌ای قبلاً فعال شده است")
+	}
+	if account.TOTPSecret == "" {
+		return fmt.Errorf("ابتدا کلید تایید دومرحله‌ای را ایجاد کنید")
+	}
+
+	if err := s.consumeTOTP(account, code); err != nil {
+		return err
+	}
+	return database.DB.Model(account).Update("totp_enabled", true).Error
+}
+
+// DisableTOTP غیرفعال کردن TOTP با رمز عبور و کد فعلی
+func (s *AdminAuthService) DisableTOTP(accountID uint, password, code string) error {
+	account, err := s.GetAccount(accountID)
+	if err != nil {
+		return err
+	}
+	if !account.TOTPEnabled {
+		return fmt.Errorf("تایید دومرحله‌ای فعال نیست")
+	}
+	if !utils.VerifyPassword(account.PasswordHash, password) {
+		return ErrAdminInvalidCredentials
+	}
+	if err := s.consumeTOTP(account, code); err != nil {
+		return err
+	}
+
+	return database.DB.Model(account).Updates(map[string]interface{}{
+		"totp_enabled":   false,
+		"totp_secret":    "",
+		"totp_last_step": 0,
+	}).Error
+}
+
+// consumeTOTP بررسی کد و ثبت بازه آن؛ هر کد فقط یک بار پذیرفته می‌شود
+func (s *AdminAuthService) consumeTOTP(account *database.AdminAccount, code string) error {
+	step, ok := utils.VerifyTOTP(account.TOTPSecret, strings.TrimSpace(code), account.TOTPLastStep, time.Now())
+	if !ok {
+		return ErrAdminTOTPInvalid
+	}
+
+	// به‌روزرسانی شرطی تا ورود هم‌زمان با همان کد پذیرفته نشود
+	result := database.DB.Model(&database.AdminAccount{}).
+		Where("id = ? AND totp_last_step < ?", account.ID, step).
+		Update("totp_last_step", step)
+	if result.Error != nil {
+		return fmt.Errorf("خطا در ثبت کد: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrAdminTOTPInvalid
+	}
+
+	account.TOTPLastStep = step
+	return nil
+}
+
+// generateToken تولید token با claim scope=admin
+func (s *AdminAuthService) generateToken(accountID uint) (string, error) {
+	jti, err := utils.GenerateSecureToken(16)
+	if err != nil {
+		return "", fmt.Errorf("خطا در تولید شناسه token: %w", err)
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"admin_id": accountID,
+		"scope":    adminTokenScope,
+		"jti":      jti,
+		"exp":      now.Add(time.Duration(config.AppConfig.AdminTokenTTLMinutes) * time.Minute).Unix(),
+		"iat":      now.Unix(),
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(config.AppConfig.JWTSecret))
+}
@@ -1,24 +1,28 @@
 package services
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
+	"log"
 	"time"
 
 	"telegram-bot/config"
 	"telegram-bot/database"
 )
 
-type AIService struct{}
+var threadService = &ThreadService{}
 
-// AIRequestBody ساختار درخواست API
-type AIRequestBody struct {
-	Model     string      `json:"model"`
-	Messages  []AIMessage `json:"messages"`
-	MaxTokens int         `json:"max_tokens,omitempty"`
+// سقف توکن خروجی هر نوع درخواست
+const (
+	queryMaxTokens    = 2000
+	analysisMaxTokens = 3000
+)
+
+// AIService سرویس پرس‌وجو از AI
+type AIService struct {
+	// Provider ارائه‌دهنده ثابت؛ اگر nil باشد ارائه‌دهنده پیش‌فرض تنظیمات استفاده می‌شود
+	Provider AIProvider
 }
 
 // AIMessage پیام برای API
@@ -27,76 +31,122 @@ type AIMessage struct {
 	Content string `json:"content"`
 }
 
-// AIResponse پاسخ API
-type AIResponse struct {
-	Choices []struct {
-		Message struct {
-			Content string `json:"content"`
-		} `json:"message"`
-	} `json:"choices"`
-	Error struct {
-		Message string `json:"message"`
-	} `json:"error"`
+// QueryOptions تنظیمات اختیاری هر درخواست
+type QueryOptions struct {
+	Provider string // نام ارائه‌دهنده برای همین درخواست؛ خالی یعنی پیش‌فرض
+	Model    string // مدل برای همین درخواست؛ خالی یعنی بر اساس تنظیمات کاربر
+	ThreadID uint   // رشته گفتگو؛ صفر یعنی آخرین رشته فعال یا رشته جدید
+	Feature  string // نوع درخواست برای هزینه و سقف روزانه؛ خالی یعنی بر اساس نوع فراخوانی
+
+	// OnDelta در صورت تنظیم، پاسخ به‌صورت استریم دریافت و هر تکه به آن داده می‌شود
+	OnDelta func(chunk string)
+}
+
+// QueryResult نتیجه یک درخواست همراه با مصرف و اعتبار محاسبه‌شده
+type QueryResult struct {
+	Content          string
+	Model            string
+	Provider         string
+	ThreadID         uint
+	PromptTokens     int
+	CompletionTokens int
+	UsageEstimated   bool
+	Feature          string // نوع درخواست؛ هزینه بر اساس آن محاسبه شده است
+	Credits          int    // اعتباری که باید از کاربر کسر شود
+	Reference        string // رکورد ذخیره‌شده برای ثبت در دفتر توکن
 }
 
 // QueryAI ارسال سوال به AI
 func (s *AIService) QueryAI(userID uint, question string) (string, error) {
+	result, err := s.QueryAIWithOptions(context.Background(), userID, question, QueryOptions{})
+	if err != nil {
+		return "", err
+	}
+	return result.Content, nil
+}
+
+// QueryAIWithOptions ارسال سوال به AI با تنظیمات دلخواه
+func (s *AIService) QueryAIWithOptions(ctx context.Context, userID uint, question string, opts QueryOptions) (*QueryResult, error) {
 	// دریافت mega prompt
 	megaPrompt, err := s.getMegaPrompt()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	// آماده‌سازی درخواست
-	requestBody := AIRequestBody{
-		Model: "gpt-3.5-turbo",
-		Messages: []AIMessage{
-			{
-				Role:    "system",
-				Content: megaPrompt,
-			},
-			{
-				Role:    "user",
-				Content: question,
-			},
-		},
-		MaxTokens: 2000,
+	thread, err := threadService.ResolveThread(userID, opts.ThreadID)
+	if err != nil {
+		return nil, err
 	}
 
-	// تبدیل به JSON
-	jsonBody, err := json.Marshal(requestBody)
+	// آماده‌سازی درخواست همراه با نوبت‌های قبلی گفتگو
+	messages, err := s.buildContextMessages(thread.ID, megaPrompt, question)
 	if err != nil {
-		return "", fmt.Errorf("خطا در تبدیل JSON: %w", err)
+		return nil, err
+	}
+
+	request := &AIRequest{
+		Messages:  messages,
+		MaxTokens: queryMaxTokens,
+	}
+
+	// سوال با تاریخچه طولانی جداگانه قیمت‌گذاری و محدود می‌شود
+	feature := opts.Feature
+	if feature == "" {
+		feature = QueryFeature(estimateUsage(request, "").PromptTokens)
+	}
+	if err := featureService.CheckDailyCap(userID, feature); err != nil {
+		return nil, err
 	}
 
 	// ارسال درخواست
-	resp, err := s.sendAIRequest(jsonBody)
+	result, err := s.complete(ctx, userID, request, opts)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	queryResult := newQueryResult(result, feature)
+	queryResult.ThreadID = thread.ID
 
 	// ذخیره مکالمه
 	conversation := database.Conversation{
-		UserID:     userID,
-		Question:   question,
-		Answer:     resp,
-		TokensUsed: 1,
-		CreatedAt:  time.Now(),
+		UserID:           userID,
+		ThreadID:         thread.ID,
+		Question:         question,
+		Answer:           result.Content,
+		Model:            result.Model,
+		PromptTokens:     queryResult.PromptTokens,
+		CompletionTokens: queryResult.CompletionTokens,
+		UsageEstimated:   queryResult.UsageEstimated,
+		TokensUsed:       queryResult.Credits,
+		CreatedAt:        time.Now(),
 	}
 
 	if err := database.DB.Create(&conversation).Error; err != nil {
-		return resp, fmt.Errorf("خطا در ذخیره مکالمه: %w", err)
+		return queryResult, fmt.Errorf("خطا در ذخیره مکالمه: %w", err)
+	}
+	queryResult.Reference = fmt.Sprintf("conversation:%d", conversation.ID)
+
+	if err := threadService.TouchThread(thread, question); err != nil {
+		return queryResult, fmt.Errorf("خطا در به‌روزرسانی گفتگو: %w", err)
 	}
 
-	return resp, nil
+	return queryResult, nil
 }
 
 // AnalyzeCode تحلیل کد
 func (s *AIService) AnalyzeCode(userID uint, code string, language string, filename string) (string, string, error) {
-	megaPrompt, err := s.getMegaPrompt()
+	result, err := s.AnalyzeCodeWithOptions(context.Background(), userID, code, language, filename, QueryOptions{})
 	if err != nil {
 		return "", "", err
 	}
+	return code, result.Content, nil
+}
+
+// AnalyzeCodeWithOptions تحلیل کد با تنظیمات دلخواه
+func (s *AIService) AnalyzeCodeWithOptions(ctx context.Context, userID uint, code string, language string, filename string, opts QueryOptions) (*QueryResult, error) {
+	megaPrompt, err := s.getMegaPrompt()
+	if err != nil {
+		return nil, err
+	}
 
 	prompt := fmt.Sprintf(`
 	به این کد %s نگاه کنید و آن را اصلاح کنید:
@@ -111,8 +161,7 @@ func (s *AIService) AnalyzeCode(userID uint, code string, language string, filen
 	3. پیشنهادات بهبود دهید
 	`, language, language, code)
 
-	requestBody := AIRequestBody{
-		Model: "gpt-3.5-turbo",
+	request := &AIRequest{
 		Messages: []AIMessage{
 			{
 				Role:    "system",
@@ -123,84 +172,193 @@ func (s *AIService) AnalyzeCode(userID uint, code string, language string, filen
 				Content: prompt,
 			},
 		},
-		MaxTokens: 3000,
+		MaxTokens: analysisMaxTokens,
 	}
 
-	jsonBody, err := json.Marshal(requestBody)
-	if err != nil {
-		return "", "", fmt.Errorf("خطا در تبدیل JSON: %w", err)
+	feature := opts.Feature
+	if feature == "" {
+		feature = FeatureCodeAnalysis
+	}
+	if err := featureService.CheckDailyCap(userID, feature); err != nil {
+		return nil, err
 	}
 
-	analysis, err := s.sendAIRequest(jsonBody)
+	result, err := s.complete(ctx, userID, request, opts)
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
+	queryResult := newQueryResult(result, feature)
 
 	// ذخیره تحلیل
 	codeAnalysis := database.CodeAnalysis{
-		UserID:       userID,
-		OriginalCode: code,
-		FixedCode:    analysis,
-		Language:     language,
-		Filename:     filename,
-		CreatedAt:    time.Now(),
+		UserID:           userID,
+		OriginalCode:     code,
+		FixedCode:        result.Content,
+		Language:         language,
+		Filename:         filename,
+		Model:            result.Model,
+		PromptTokens:     queryResult.PromptTokens,
+		CompletionTokens: queryResult.CompletionTokens,
+		UsageEstimated:   queryResult.UsageEstimated,
+		TokensUsed:       queryResult.Credits,
+		CreatedAt:        time.Now(),
 	}
 
 	if err := database.DB.Create(&codeAnalysis).Error; err != nil {
-		return analysis, "", fmt.Errorf("خطا در ذخیره تحلیل: %w", err)
+		return queryResult, fmt.Errorf("خطا در ذخیره تحلیل: %w", err)
 	}
+	queryResult.Reference = fmt.Sprintf("code_analysis:%d", codeAnalysis.ID)
 
-	return code, analysis, nil
+	return queryResult, nil
 }
 
-// sendAIRequest ارسال درخواست به API
-func (s *AIService) sendAIRequest(jsonBody []byte) (string, error) {
-	client := &http.Client{
-		Timeout: 30 * time.Second,
+// EstimateQueryCredits حداکثر اعتبار احتمالی یک سوال؛ برای رزرو پیش از ارسال
+func (s *AIService) EstimateQueryCredits(userID uint, question string) int {
+	promptTokens := config.AppConfig.ContextTokenBudget
+	if estimated := EstimateTokens(question); estimated > promptTokens {
+		promptTokens = estimated
 	}
+	return featureService.CalculateCredits(QueryFeature(promptTokens), s.ResolveModel(userID), promptTokens, queryMaxTokens)
+}
 
-	req, err := http.NewRequest("POST", config.AppConfig.AIAPIEndpoint, bytes.NewBuffer(jsonBody))
-	if err != nil {
-		return "", fmt.Errorf("خطا در ایجاد درخواست: %w", err)
+// EstimateAnalysisCredits حداکثر اعتبار احتمالی تحلیل یک کد؛ feature خالی یعنی تحلیل کد
+func (s *AIService) EstimateAnalysisCredits(userID uint, code, feature string) int {
+	if feature == "" {
+		feature = FeatureCodeAnalysis
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", config.AppConfig.AIAPIKey))
+	megaPrompt, _ := s.getMegaPrompt()
+	promptTokens := EstimateTokens(megaPrompt) + EstimateTokens(code)
+	return featureService.CalculateCredits(feature, s.ResolveModel(userID), promptTokens, analysisMaxTokens)
+}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("خطا در ارسال درخواست: %w", err)
+// newQueryResult تبدیل پاسخ ارائه‌دهنده به نتیجه همراه با اعتبار مصرفی
+//
+// مصرف قابلیت هنگام تسویه رزرو با CommitReservation ثبت می‌شود.
+func newQueryResult(result *AIResult, feature string) *QueryResult {
+	credits := featureService.CalculateCredits(feature, result.Model, result.Usage.PromptTokens, result.Usage.CompletionTokens)
+
+	return &QueryResult{
+		Content:          result.Content,
+		Model:            result.Model,
+		Provider:         result.Provider,
+		PromptTokens:     result.Usage.PromptTokens,
+		CompletionTokens: result.Usage.CompletionTokens,
+		UsageEstimated:   result.Usage.Estimated,
+		Feature:          feature,
+		Credits:          credits,
 	}
-	defer resp.Body.Close()
+}
 
-	body, err := io.ReadAll(resp.Body)
+// complete ارسال درخواست به زنجیره ارائه‌دهنده‌ها؛ در صورت خرابی هر کدام، بعدی امتحان می‌شود
+func (s *AIService) complete(ctx context.Context, userID uint, request *AIRequest, opts QueryOptions) (*AIResult, error) {
+	chain, explicit, err := s.providerChain(opts)
 	if err != nil {
-		return "", fmt.Errorf("خطا در خواندن پاسخ: %w", err)
+		return nil, err
 	}
 
-	var aiResp AIResponse
-	if err := json.Unmarshal(body, &aiResp); err != nil {
-		return "", fmt.Errorf("خطا در تحلیل پاسخ: %w", err)
+	// پس از تحویل بخشی از پاسخ استریم، تعویض ارائه‌دهنده متن تکراری می‌سازد
+	emitted := false
+	if opts.OnDelta != nil {
+		onDelta := opts.OnDelta
+		opts.OnDelta = func(chunk string) {
+			emitted = true
+			onDelta(chunk)
+		}
 	}
 
-	if aiResp.Error.Message != "" {
-		return "", fmt.Errorf("خطای API: %s", aiResp.Error.Message)
+	var lastErr error
+	for i, provider := range chain {
+		attempt := *request
+		model, err := s.modelForRequest(userID, provider, opts, explicit)
+		if err != nil {
+			// backendی که مدلش در پلن مجاز نیست در failover کنار گذاشته می‌شود
+			lastErr = err
+			if i < len(chain)-1 {
+				log.Printf("⚠️  %v؛ backend بعدی امتحان می‌شود", err)
+			}
+			continue
+		}
+		attempt.Model = model
+
+		maxRetries := config.AppConfig.AIMaxRetries
+		if i < len(chain)-1 {
+			maxRetries = config.AppConfig.AIFailoverRetries
+		}
+
+		result, err := s.completeWith(ctx, provider, &attempt, opts, maxRetries, func() bool { return !emitted })
+		if err == nil {
+			result.Provider = provider.Name()
+			if result.Model == "" {
+				result.Model = attempt.Model
+			}
+			if result.Usage.PromptTokens == 0 && result.Usage.CompletionTokens == 0 {
+				result.Usage = estimateUsage(&attempt, result.Content)
+			}
+			return result, nil
+		}
+
+		lastErr = err
+		var aiErr *AIError
+		if emitted || (errors.As(err, &aiErr) && aiErr.Kind == AIErrorCanceled) {
+			break
+		}
+
+		if i < len(chain)-1 {
+			log.Printf("⚠️  failover از %s به %s: %v", provider.Name(), chain[i+1].Name(), err)
+		}
 	}
 
-	if len(aiResp.Choices) == 0 {
-		return "", fmt.Errorf("پاسخ خالی از API")
+	return nil, lastErr
+}
+
+// completeWith ارسال درخواست به یک ارائه‌دهنده با تلاش مجدد؛ به‌صورت استریم اگر خواسته شده و پشتیبانی شود
+func (s *AIService) completeWith(ctx context.Context, provider AIProvider, request *AIRequest, opts QueryOptions, maxRetries int, canRetry func() bool) (*AIResult, error) {
+	timeout := time.Duration(config.AppConfig.AIRequestTimeoutSeconds) * time.Second
+
+	streamer, canStream := provider.(StreamingAIProvider)
+	if opts.OnDelta == nil || !canStream {
+		result, err := callWithRetry(ctx, provider, timeout, maxRetries, func(ctx context.Context) (*AIResult, error) {
+			return provider.Complete(ctx, request)
+		}, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		// ارائه‌دهنده استریم ندارد؛ کل پاسخ یک‌جا تحویل داده می‌شود
+		if opts.OnDelta != nil {
+			opts.OnDelta(result.Content)
+		}
+		return result, nil
 	}
 
-	return aiResp.Choices[0].Message.Content, nil
+	streamTimeout := time.Duration(config.AppConfig.AIStreamTimeoutSeconds) * time.Second
+	return callWithRetry(ctx, provider, streamTimeout, maxRetries, func(ctx context.Context) (*AIResult, error) {
+		return streamer.Stream(ctx, request, opts.OnDelta)
+	}, canRetry)
+}
+
+// providerChain ارائه‌دهنده‌های یک درخواست؛ انتخاب صریح بدون failover است
+func (s *AIService) providerChain(opts QueryOptions) ([]AIProvider, bool, error) {
+	if opts.Provider != "" {
+		provider, err := GetAIProvider(opts.Provider)
+		if err != nil {
+			return nil, true, err
+		}
+		return []AIProvider{provider}, true, nil
+	}
+
+	if s.Provider != nil {
+		return []AIProvider{s.Provider}, true, nil
+	}
+
+	chain, err := GetAIProviderChain()
+	return chain, false, err
 }
 
 // getMegaPrompt دریافت mega prompt
 func (s *AIService) getMegaPrompt() (string, error) {
-	var setting database.Setting
-	if err := database.DB.Where("key = ?", "mega_prompt").First(&setting).Error; err != nil {
-		return "شما یک دستیار برنامه‌نویسی هستید.", nil
-	}
-	return setting.Value, nil
+	return settingService.GetSetting("mega_prompt", "شما یک دستیار برنامه‌نویسی هستید."), nil
 }
 
 // GetConversationHistory دریافت تاریخچه گفتگو
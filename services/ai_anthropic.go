@@ -0,0 +1,218 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+)
+
+// anthropicAPIVersion نسخه API پیام‌های Anthropic
+const anthropicAPIVersion = "2023-06-01"
+
+// AnthropicProvider ارائه‌دهنده سازگار با API پیام‌های Anthropic
+type AnthropicProvider struct {
+	Backend  string // نام backend در زنجیره failover
+	Endpoint string
+	APIKey   string
+	Model    string
+}
+
+// anthropicRequestBody ساختار درخواست Messages API
+type anthropicRequestBody struct {
+	Model     string      `json:"model"`
+	System    string      `json:"system,omitempty"`
+	Messages  []AIMessage `json:"messages"`
+	MaxTokens int         `json:"max_tokens"`
+	Stream    bool        `json:"stream,omitempty"`
+}
+
+// anthropicUsage مصرف توکن در پاسخ
+type anthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// anthropicResponse پاسخ Messages API
+type anthropicResponse struct {
+	Model   string `json:"model"`
+	Content []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content"`
+	Usage anthropicUsage `json:"usage"`
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// anthropicStreamEvent یک رویداد از پاسخ استریم
+type anthropicStreamEvent struct {
+	Type    string `json:"type"`
+	Message struct {
+		Model string         `json:"model"`
+		Usage anthropicUsage `json:"usage"`
+	} `json:"message"`
+	Delta struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"delta"`
+	Usage anthropicUsage `json:"usage"`
+	Error struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// NewAnthropicProvider ایجاد ارائه‌دهنده Anthropic
+func NewAnthropicProvider(endpoint, apiKey, model string) *AnthropicProvider {
+	return &AnthropicProvider{
+		Endpoint: endpoint,
+		APIKey:   apiKey,
+		Model:    model,
+	}
+}
+
+// Name نام backend؛ در صورت خالی بودن، نوع ارائه‌دهنده
+func (p *AnthropicProvider) Name() string {
+	if p.Backend != "" {
+		return p.Backend
+	}
+	return "anthropic"
+}
+
+// Complete ارسال درخواست به Messages API
+func (p *AnthropicProvider) Complete(ctx context.Context, req *AIRequest) (*AIResult, error) {
+	requestBody := p.buildRequestBody(req)
+	model := requestBody.Model
+
+	body, err := postJSON(ctx, p.Endpoint, p.headers(), requestBody)
+	if err != nil {
+		return nil, err
+	}
+
+	var aiResp anthropicResponse
+	if err := json.Unmarshal(body, &aiResp); err != nil {
+		return nil, newInvalidResponseError("خطا در تحلیل پاسخ: %v", err)
+	}
+
+	if aiResp.Error.Message != "" {
+		return nil, newInvalidResponseError("خطای API: %s", aiResp.Error.Message)
+	}
+
+	var content strings.Builder
+	for _, block := range aiResp.Content {
+		if block.Type == "text" {
+			content.WriteString(block.Text)
+		}
+	}
+
+	if content.Len() == 0 {
+		return nil, newInvalidResponseError("پاسخ خالی از API")
+	}
+
+	if aiResp.Model != "" {
+		model = aiResp.Model
+	}
+
+	return &AIResult{
+		Content: content.String(),
+		Model:   model,
+		Usage: AIUsage{
+			PromptTokens:     aiResp.Usage.InputTokens,
+			CompletionTokens: aiResp.Usage.OutputTokens,
+		},
+	}, nil
+}
+
+// Stream ارسال درخواست استریم به Messages API
+func (p *AnthropicProvider) Stream(ctx context.Context, req *AIRequest, onDelta func(chunk string)) (*AIResult, error) {
+	requestBody := p.buildRequestBody(req)
+	requestBody.Stream = true
+	model := requestBody.Model
+
+	body, err := postStream(ctx, p.Endpoint, p.headers(), requestBody)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	var content strings.Builder
+	var usage AIUsage
+	err = readSSE(body, func(event, data string) error {
+		var ev anthropicStreamEvent
+		if err := json.Unmarshal([]byte(data), &ev); err != nil {
+			return newInvalidResponseError("خطا در تحلیل پاسخ: %v", err)
+		}
+
+		switch ev.Type {
+		case "message_start":
+			if ev.Message.Model != "" {
+				model = ev.Message.Model
+			}
+			usage.PromptTokens = ev.Message.Usage.InputTokens
+		case "message_delta":
+			// مقدار output_tokens تجمعی است
+			usage.CompletionTokens = ev.Usage.OutputTokens
+		case "content_block_delta":
+			if ev.Delta.Type == "text_delta" && ev.Delta.Text != "" {
+				content.WriteString(ev.Delta.Text)
+				onDelta(ev.Delta.Text)
+			}
+		case "error":
+			return &AIError{Kind: AIErrorServer, Message: ev.Error.Message}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if content.Len() == 0 {
+		return nil, newInvalidResponseError("پاسخ خالی از API")
+	}
+
+	return &AIResult{
+		Content: content.String(),
+		Model:   model,
+		Usage:   usage,
+	}, nil
+}
+
+// headers هدرهای احراز هویت Messages API
+func (p *AnthropicProvider) headers() map[string]string {
+	return map[string]string{
+		"x-api-key":         p.APIKey,
+		"anthropic-version": anthropicAPIVersion,
+	}
+}
+
+// buildRequestBody تبدیل درخواست عمومی به قالب Messages API
+func (p *AnthropicProvider) buildRequestBody(req *AIRequest) anthropicRequestBody {
+	model := req.Model
+	if model == "" {
+		model = p.Model
+	}
+
+	// در این API پیام system جدا از بقیه پیام‌ها ارسال می‌شود
+	var system []string
+	var messages []AIMessage
+	for _, msg := range req.Messages {
+		if msg.Role == "system" {
+			system = append(system, msg.Content)
+			continue
+		}
+		messages = append(messages, msg)
+	}
+
+	maxTokens := req.MaxTokens
+	if maxTokens == 0 {
+		maxTokens = 1024 // در این API الزامی است
+	}
+
+	return anthropicRequestBody{
+		Model:     model,
+		System:    strings.Join(system, "\n\n"),
+		Messages:  messages,
+		MaxTokens: maxTokens,
+	}
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// AIErrorKind نوع خطای درخواست AI
+type AIErrorKind string
+
+const (
+	AIErrorRateLimited     AIErrorKind = "rate_limited"     // HTTP 429
+	AIErrorServer          AIErrorKind = "server_error"     // HTTP 5xx
+	AIErrorTimeout         AIErrorKind = "timeout"          // پایان مهلت درخواست
+	AIErrorNetwork         AIErrorKind = "network"          // خطای اتصال
+	AIErrorAuth            AIErrorKind = "auth"             // HTTP 401/403
+	AIErrorBadRequest      AIErrorKind = "bad_request"      // سایر خطاهای 4xx
+	AIErrorInvalidResponse AIErrorKind = "invalid_response" // پاسخ خالی یا غیرقابل تحلیل
+	AIErrorCircuitOpen     AIErrorKind = "circuit_open"     // ارائه‌دهنده موقتاً غیرفعال است
+	AIErrorCanceled        AIErrorKind = "canceled"         // درخواست توسط فراخوان لغو شد
+)
+
+// AIError خطای نوع‌دار درخواست AI
+type AIError struct {
+	Kind       AIErrorKind
+	Provider   string
+	StatusCode int
+	RetryAfter time.Duration // مقدار هدر Retry-After در صورت وجود
+	Message    string
+	Err        error
+}
+
+// Error متن خطا
+func (e *AIError) Error() string {
+	msg := e.Message
+	if msg == "" && e.Err != nil {
+		msg = e.Err.Error()
+	}
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("خطای AI [%s/%s] (HTTP %d): %s", e.Provider, e.Kind, e.StatusCode, msg)
+	}
+	return fmt.Sprintf("خطای AI [%s/%s]: %s", e.Provider, e.Kind, msg)
+}
+
+// Unwrap خطای اصلی
+func (e *AIError) Unwrap() error {
+	return e.Err
+}
+
+// Retryable آیا تلاش مجدد برای این خطا معنا دارد
+func (e *AIError) Retryable() bool {
+	switch e.Kind {
+	case AIErrorRateLimited, AIErrorServer, AIErrorTimeout, AIErrorNetwork:
+		return true
+	}
+	return false
+}
+
+// UserMessage پیام قابل نمایش به کاربر
+func (e *AIError) UserMessage() string {
+	switch e.Kind {
+	case AIErrorRateLimited:
+		return "سرویس هوش مصنوعی در حال حاضر شلوغ است. چند لحظه دیگر دوباره تلاش کنید."
+	case AIErrorCircuitOpen, AIErrorServer, AIErrorNetwork:
+		return "سرویس هوش مصنوعی موقتاً در دسترس نیست. لطفاً کمی بعد دوباره تلاش کنید."
+	case AIErrorTimeout:
+		return "پاسخ سرویس هوش مصنوعی بیش از حد طول کشید. لطفاً دوباره تلاش کنید."
+	case AIErrorCanceled:
+		return "درخواست لغو شد."
+	}
+	return "خطا در پردازش درخواست. لطفاً بعداً دوباره تلاش کنید."
+}
+
+// AIErrorMessage پیام قابل نمایش به کاربر برای هر خطای سرویس AI
+func AIErrorMessage(err error) string {
+	if errors.Is(err, ErrFeatureCapReached) {
+		return err.Error()
+	}
+
+	var aiErr *AIError
+	if errors.As(err, &aiErr) {
+		return aiErr.UserMessage()
+	}
+	return "خطا در پردازش درخواست. لطفاً بعداً دوباره تلاش کنید."
+}
+
+// newHTTPError ساخت خطا از پاسخ HTTP ناموفق
+func newHTTPError(resp *http.Response, body []byte) *AIError {
+	aiErr := &AIError{
+		StatusCode: resp.StatusCode,
+		Message:    extractErrorMessage(body),
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		aiErr.Kind = AIErrorRateLimited
+	case resp.StatusCode >= 500:
+		aiErr.Kind = AIErrorServer
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		aiErr.Kind = AIErrorAuth
+	default:
+		aiErr.Kind = AIErrorBadRequest
+	}
+
+	return aiErr
+}
+
+// newTransportError ساخت خطا از خطای ارسال درخواست
+func newTransportError(ctx context.Context, err error) *AIError {
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return &AIError{Kind: AIErrorCanceled, Err: err}
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return &AIError{Kind: AIErrorTimeout, Err: err}
+	}
+
+	return &AIError{Kind: AIErrorNetwork, Err: err}
+}
+
+// newInvalidResponseError ساخت خطا برای پاسخ نامعتبر
+func newInvalidResponseError(format string, args ...interface{}) *AIError {
+	return &AIError{Kind: AIErrorInvalidResponse, Message: fmt.Sprintf(format, args...)}
+}
+
+// parseRetryAfter تبدیل هدر Retry-After (ثانیه یا تاریخ HTTP) به مدت زمان
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,177 @@
+package services
+
+import (
+	"sync"
+	"time"
+
+	"telegram-bot/config"
+)
+
+const (
+	healthWindowSize   = 50               // تعداد آخرین درخواست‌هایی که در سلامت حساب می‌شوند
+	healthWindowAge    = 10 * time.Minute // درخواست‌های قدیمی‌تر نادیده گرفته می‌شوند
+	healthMinSamples   = 5                // کمتر از این تعداد، backend سالم فرض می‌شود
+	healthMaxErrorRate = 0.5
+	healthSlowLatency  = 20 * time.Second
+)
+
+// وضعیت‌های سلامت backend
+const (
+	BackendHealthy  = "healthy"
+	BackendDegraded = "degraded"
+	BackendDown     = "down"
+)
+
+// healthSample نتیجه یک تلاش
+type healthSample struct {
+	at      time.Time
+	latency time.Duration
+	ok      bool
+}
+
+// backendHealth آمار اخیر یک backend
+type backendHealth struct {
+	mu            sync.Mutex
+	samples       []healthSample
+	lastError     string
+	lastErrorAt   time.Time
+	lastSuccessAt time.Time
+}
+
+// BackendHealthStatus گزارش سلامت یک backend برای /health
+type BackendHealthStatus struct {
+	Name          string     `json:"name"`
+	Provider      string     `json:"provider"`
+	Status        string     `json:"status"`
+	Circuit       string     `json:"circuit"`
+	Requests      int        `json:"requests"`
+	ErrorRate     float64    `json:"error_rate"`
+	AvgLatencyMS  int64      `json:"avg_latency_ms"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastErrorAt   *time.Time `json:"last_error_at,omitempty"`
+	LastSuccessAt *time.Time `json:"last_success_at,omitempty"`
+}
+
+var (
+	backendHealthMu sync.Mutex
+	backendHealths  = make(map[string]*backendHealth)
+)
+
+// healthFor دریافت آمار سلامت هر backend
+func healthFor(name string) *backendHealth {
+	backendHealthMu.Lock()
+	defer backendHealthMu.Unlock()
+
+	health, exists := backendHealths[name]
+	if !exists {
+		health = &backendHealth{}
+		backendHealths[name] = health
+	}
+	return health
+}
+
+// recordBackendResult ثبت نتیجه یک تلاش
+func recordBackendResult(name string, latency time.Duration, err *AIError) {
+	health := healthFor(name)
+	health.mu.Lock()
+	defer health.mu.Unlock()
+
+	now := time.Now()
+	// خطای خود درخواست (مثلاً 400) نشانه خرابی backend نیست
+	ok := err == nil || err.Kind == AIErrorBadRequest
+
+	health.samples = append(health.samples, healthSample{at: now, latency: latency, ok: ok})
+	if len(health.samples) > healthWindowSize {
+		health.samples = health.samples[len(health.samples)-healthWindowSize:]
+	}
+
+	if ok {
+		health.lastSuccessAt = now
+	} else {
+		health.lastError = err.Error()
+		health.lastErrorAt = now
+	}
+}
+
+// stats محاسبه نرخ خطا و میانگین تأخیر در پنجره اخیر
+func (h *backendHealth) stats() (requests int, errorRate float64, avgLatency time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var failures int
+	var totalLatency time.Duration
+	cutoff := time.Now().Add(-healthWindowAge)
+	for _, sample := range h.samples {
+		if sample.at.Before(cutoff) {
+			continue
+		}
+		requests++
+		totalLatency += sample.latency
+		if !sample.ok {
+			failures++
+		}
+	}
+
+	if requests == 0 {
+		return 0, 0, 0
+	}
+	return requests, float64(failures) / float64(requests), totalLatency / time.Duration(requests)
+}
+
+// backendStatus تعیین وضعیت یک backend
+func backendStatus(name string) string {
+	if circuitBreakerFor(name).State() == CircuitOpen {
+		return BackendDown
+	}
+
+	requests, errorRate, avgLatency := healthFor(name).stats()
+	if requests >= healthMinSamples && (errorRate >= healthMaxErrorRate || avgLatency >= healthSlowLatency) {
+		return BackendDegraded
+	}
+	return BackendHealthy
+}
+
+// healthRank رتبه مرتب‌سازی زنجیره failover؛ عدد کمتر یعنی اولویت بیشتر
+func healthRank(name string) int {
+	switch backendStatus(name) {
+	case BackendHealthy:
+		return 0
+	case BackendDegraded:
+		return 1
+	}
+	return 2
+}
+
+// AIHealthReport گزارش سلامت همه backendهای تنظیم‌شده
+func AIHealthReport() []BackendHealthStatus {
+	var report []BackendHealthStatus
+	for _, backend := range config.AppConfig.AIBackends {
+		health := healthFor(backend.Name)
+		requests, errorRate, avgLatency := health.stats()
+
+		status := BackendHealthStatus{
+			Name:         backend.Name,
+			Provider:     backend.Provider,
+			Status:       backendStatus(backend.Name),
+			Circuit:      circuitBreakerFor(backend.Name).State(),
+			Requests:     requests,
+			ErrorRate:    errorRate,
+			AvgLatencyMS: avgLatency.Milliseconds(),
+		}
+
+		health.mu.Lock()
+		status.LastError = health.lastError
+		if !health.lastErrorAt.IsZero() {
+			lastErrorAt := health.lastErrorAt
+			status.LastErrorAt = &lastErrorAt
+		}
+		if !health.lastSuccessAt.IsZero() {
+			lastSuccessAt := health.lastSuccessAt
+			status.LastSuccessAt = &lastSuccessAt
+		}
+		health.mu.Unlock()
+
+		report = append(report, status)
+	}
+	return report
+}
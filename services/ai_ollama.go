@@ -0,0 +1,160 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// OllamaProvider ارائه‌دهنده محلی سازگار با API چت Ollama
+type OllamaProvider struct {
+	Backend  string // نام backend در زنجیره failover
+	Endpoint string
+	Model    string
+}
+
+// ollamaRequestBody ساختار درخواست /api/chat
+type ollamaRequestBody struct {
+	Model    string                 `json:"model"`
+	Messages []AIMessage            `json:"messages"`
+	Stream   bool                   `json:"stream"`
+	Options  map[string]interface{} `json:"options,omitempty"`
+}
+
+// ollamaResponse پاسخ /api/chat
+type ollamaResponse struct {
+	Model   string `json:"model"`
+	Message struct {
+		Role    string `json:"role"`
+		Content string `json:"content"`
+	} `json:"message"`
+	Done            bool   `json:"done"`
+	PromptEvalCount int    `json:"prompt_eval_count"`
+	EvalCount       int    `json:"eval_count"`
+	Error           string `json:"error"`
+}
+
+// NewOllamaProvider ایجاد ارائه‌دهنده Ollama
+func NewOllamaProvider(endpoint, model string) *OllamaProvider {
+	return &OllamaProvider{
+		Endpoint: endpoint,
+		Model:    model,
+	}
+}
+
+// Name نام backend؛ در صورت خالی بودن، نوع ارائه‌دهنده
+func (p *OllamaProvider) Name() string {
+	if p.Backend != "" {
+		return p.Backend
+	}
+	return "ollama"
+}
+
+// Complete ارسال درخواست به Ollama
+func (p *OllamaProvider) Complete(ctx context.Context, req *AIRequest) (*AIResult, error) {
+	requestBody := p.buildRequestBody(req)
+	model := requestBody.Model
+
+	body, err := postJSON(ctx, p.Endpoint, nil, requestBody)
+	if err != nil {
+		return nil, err
+	}
+
+	var aiResp ollamaResponse
+	if err := json.Unmarshal(body, &aiResp); err != nil {
+		return nil, newInvalidResponseError("خطا در تحلیل پاسخ: %v", err)
+	}
+
+	if aiResp.Error != "" {
+		return nil, newInvalidResponseError("خطای API: %s", aiResp.Error)
+	}
+
+	if aiResp.Message.Content == "" {
+		return nil, newInvalidResponseError("پاسخ خالی از API")
+	}
+
+	if aiResp.Model != "" {
+		model = aiResp.Model
+	}
+
+	return &AIResult{
+		Content: aiResp.Message.Content,
+		Model:   model,
+		Usage: AIUsage{
+			PromptTokens:     aiResp.PromptEvalCount,
+			CompletionTokens: aiResp.EvalCount,
+		},
+	}, nil
+}
+
+// Stream ارسال درخواست استریم به Ollama (هر خط یک شیء JSON است)
+func (p *OllamaProvider) Stream(ctx context.Context, req *AIRequest, onDelta func(chunk string)) (*AIResult, error) {
+	requestBody := p.buildRequestBody(req)
+	requestBody.Stream = true
+	model := requestBody.Model
+
+	body, err := postStream(ctx, p.Endpoint, nil, requestBody)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	var content strings.Builder
+	var usage AIUsage
+	decoder := json.NewDecoder(body)
+	for {
+		var chunk ollamaResponse
+		if err := decoder.Decode(&chunk); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, newInvalidResponseError("خطا در تحلیل پاسخ: %v", err)
+		}
+
+		if chunk.Error != "" {
+			return nil, newInvalidResponseError("خطای API: %s", chunk.Error)
+		}
+		if chunk.Model != "" {
+			model = chunk.Model
+		}
+		if chunk.Message.Content != "" {
+			content.WriteString(chunk.Message.Content)
+			onDelta(chunk.Message.Content)
+		}
+		if chunk.Done {
+			// شمارش توکن‌ها فقط در آخرین خط می‌آید
+			usage.PromptTokens = chunk.PromptEvalCount
+			usage.CompletionTokens = chunk.EvalCount
+			break
+		}
+	}
+
+	if content.Len() == 0 {
+		return nil, newInvalidResponseError("پاسخ خالی از API")
+	}
+
+	return &AIResult{
+		Content: content.String(),
+		Model:   model,
+		Usage:   usage,
+	}, nil
+}
+
+// buildRequestBody تبدیل درخواست عمومی به قالب /api/chat
+func (p *OllamaProvider) buildRequestBody(req *AIRequest) ollamaRequestBody {
+	model := req.Model
+	if model == "" {
+		model = p.Model
+	}
+
+	requestBody := ollamaRequestBody{
+		Model:    model,
+		Messages: req.Messages,
+		Stream:   false,
+	}
+	if req.MaxTokens > 0 {
+		requestBody.Options = map[string]interface{}{"num_predict": req.MaxTokens}
+	}
+
+	return requestBody
+}
@@ -0,0 +1,204 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// OpenAIProvider ارائه‌دهنده سازگار با API چت OpenAI
+type OpenAIProvider struct {
+	Backend  string // نام backend در زنجیره failover
+	Endpoint string
+	APIKey   string
+	Model    string
+}
+
+// AIRequestBody ساختار درخواست API
+type AIRequestBody struct {
+	Model         string               `json:"model"`
+	Messages      []AIMessage          `json:"messages"`
+	MaxTokens     int                  `json:"max_tokens,omitempty"`
+	Stream        bool                 `json:"stream,omitempty"`
+	StreamOptions *openAIStreamOptions `json:"stream_options,omitempty"`
+}
+
+// openAIStreamOptions درخواست گزارش مصرف در انتهای استریم
+type openAIStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// openAIUsage مصرف توکن در پاسخ
+type openAIUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+// AIResponse پاسخ API
+type AIResponse struct {
+	Model   string `json:"model"`
+	Choices []struct {
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+	} `json:"choices"`
+	Usage *openAIUsage `json:"usage"`
+	Error struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// openAIStreamChunk یک تکه از پاسخ استریم
+type openAIStreamChunk struct {
+	Model   string `json:"model"`
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+	} `json:"choices"`
+	Usage *openAIUsage `json:"usage"`
+	Error struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// NewOpenAIProvider ایجاد ارائه‌دهنده OpenAI
+func NewOpenAIProvider(endpoint, apiKey, model string) *OpenAIProvider {
+	return &OpenAIProvider{
+		Endpoint: endpoint,
+		APIKey:   apiKey,
+		Model:    model,
+	}
+}
+
+// Name نام backend؛ در صورت خالی بودن، نوع ارائه‌دهنده
+func (p *OpenAIProvider) Name() string {
+	if p.Backend != "" {
+		return p.Backend
+	}
+	return "openai"
+}
+
+// Complete ارسال درخواست به API
+func (p *OpenAIProvider) Complete(ctx context.Context, req *AIRequest) (*AIResult, error) {
+	model := req.Model
+	if model == "" {
+		model = p.Model
+	}
+
+	requestBody := AIRequestBody{
+		Model:     model,
+		Messages:  req.Messages,
+		MaxTokens: req.MaxTokens,
+	}
+
+	headers := map[string]string{
+		"Authorization": fmt.Sprintf("Bearer %s", p.APIKey),
+	}
+
+	body, err := postJSON(ctx, p.Endpoint, headers, requestBody)
+	if err != nil {
+		return nil, err
+	}
+
+	var aiResp AIResponse
+	if err := json.Unmarshal(body, &aiResp); err != nil {
+		return nil, newInvalidResponseError("خطا در تحلیل پاسخ: %v", err)
+	}
+
+	if aiResp.Error.Message != "" {
+		return nil, newInvalidResponseError("خطای API: %s", aiResp.Error.Message)
+	}
+
+	if len(aiResp.Choices) == 0 {
+		return nil, newInvalidResponseError("پاسخ خالی از API")
+	}
+
+	if aiResp.Model != "" {
+		model = aiResp.Model
+	}
+
+	result := &AIResult{
+		Content: aiResp.Choices[0].Message.Content,
+		Model:   model,
+	}
+	if aiResp.Usage != nil {
+		result.Usage = AIUsage{
+			PromptTokens:     aiResp.Usage.PromptTokens,
+			CompletionTokens: aiResp.Usage.CompletionTokens,
+		}
+	}
+
+	return result, nil
+}
+
+// Stream ارسال درخواست استریم به API
+func (p *OpenAIProvider) Stream(ctx context.Context, req *AIRequest, onDelta func(chunk string)) (*AIResult, error) {
+	model := req.Model
+	if model == "" {
+		model = p.Model
+	}
+
+	requestBody := AIRequestBody{
+		Model:         model,
+		Messages:      req.Messages,
+		MaxTokens:     req.MaxTokens,
+		Stream:        true,
+		StreamOptions: &openAIStreamOptions{IncludeUsage: true},
+	}
+
+	headers := map[string]string{
+		"Authorization": fmt.Sprintf("Bearer %s", p.APIKey),
+	}
+
+	body, err := postStream(ctx, p.Endpoint, headers, requestBody)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	var content strings.Builder
+	var usage AIUsage
+	err = readSSE(body, func(event, data string) error {
+		if data == "[DONE]" {
+			return nil
+		}
+
+		var chunk openAIStreamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return newInvalidResponseError("خطا در تحلیل پاسخ: %v", err)
+		}
+		if chunk.Error.Message != "" {
+			return newInvalidResponseError("خطای API: %s", chunk.Error.Message)
+		}
+		if chunk.Model != "" {
+			model = chunk.Model
+		}
+		if chunk.Usage != nil {
+			usage.PromptTokens = chunk.Usage.PromptTokens
+			usage.CompletionTokens = chunk.Usage.CompletionTokens
+		}
+
+		for _, choice := range chunk.Choices {
+			if choice.Delta.Content != "" {
+				content.WriteString(choice.Delta.Content)
+				onDelta(choice.Delta.Content)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if content.Len() == 0 {
+		return nil, newInvalidResponseError("پاسخ خالی از API")
+	}
+
+	return &AIResult{
+		Content: content.String(),
+		Model:   model,
+		Usage:   usage,
+	}, nil
+}
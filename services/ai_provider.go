@@ -0,0 +1,162 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+
+	"telegram-bot/config"
+)
+
+// AIProvider رابط مشترک ارائه‌دهنده‌های AI
+type AIProvider interface {
+	// Name نام ارائه‌دهنده (مثلاً "openai")
+	Name() string
+	// Complete ارسال پیام‌ها و دریافت پاسخ کامل
+	Complete(ctx context.Context, req *AIRequest) (*AIResult, error)
+}
+
+// AIRequest درخواست مستقل از ارائه‌دهنده
+type AIRequest struct {
+	Model     string // خالی یعنی مدل پیش‌فرض ارائه‌دهنده
+	Messages  []AIMessage
+	MaxTokens int
+}
+
+// AIResult نتیجه درخواست AI
+type AIResult struct {
+	Content  string
+	Model    string
+	Provider string // نام backend پاسخ‌دهنده
+	Usage    AIUsage
+}
+
+// AIUsage مصرف توکن گزارش‌شده توسط ارائه‌دهنده
+type AIUsage struct {
+	PromptTokens     int
+	CompletionTokens int
+	Estimated        bool // ارائه‌دهنده مصرف را گزارش نکرد و مقدار تخمینی است
+}
+
+// NewAIProviderFromBackend ساخت ارائه‌دهنده از تنظیمات یک backend
+func NewAIProviderFromBackend(backend config.AIBackend) (AIProvider, error) {
+	switch backend.Provider {
+	case "openai":
+		provider := NewOpenAIProvider(backend.Endpoint, backend.APIKey, backend.Model)
+		provider.Backend = backend.Name
+		return provider, nil
+	case "anthropic":
+		provider := NewAnthropicProvider(backend.Endpoint, backend.APIKey, backend.Model)
+		provider.Backend = backend.Name
+		return provider, nil
+	case "ollama":
+		provider := NewOllamaProvider(backend.Endpoint, backend.Model)
+		provider.Backend = backend.Name
+		return provider, nil
+	}
+
+	return nil, fmt.Errorf("ارائه‌دهنده AI نامعتبر است: %s", backend.Provider)
+}
+
+// GetAIProvider دریافت ارائه‌دهنده بر اساس نام backend یا نوع آن؛ نام خالی یعنی backend اصلی
+func GetAIProvider(name string) (AIProvider, error) {
+	backends := config.AppConfig.AIBackends
+	if name == "" && len(backends) > 0 {
+		return NewAIProviderFromBackend(backends[0])
+	}
+
+	for _, backend := range backends {
+		if backend.Name == name {
+			return NewAIProviderFromBackend(backend)
+		}
+	}
+
+	// نوع ارائه‌دهنده بدون backend تعریف‌شده، با تنظیمات پیش‌فرض همان نوع
+	endpoint, apiKey, model := config.AppConfig.ProviderDefaults(name)
+	return NewAIProviderFromBackend(config.AIBackend{
+		Name:     name,
+		Provider: name,
+		Endpoint: endpoint,
+		APIKey:   apiKey,
+		Model:    model,
+	})
+}
+
+// GetAIProviderChain زنجیره failover؛ backendهای سالم پیش از backendهای مشکل‌دار می‌آیند
+func GetAIProviderChain() ([]AIProvider, error) {
+	var chain []AIProvider
+	for _, backend := range config.AppConfig.AIBackends {
+		provider, err := NewAIProviderFromBackend(backend)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, provider)
+	}
+
+	// مرتب‌سازی پایدار تا ترتیب تنظیمات بین backendهای هم‌وضعیت حفظ شود
+	sort.SliceStable(chain, func(i, j int) bool {
+		return healthRank(chain[i].Name()) < healthRank(chain[j].Name())
+	})
+
+	return chain, nil
+}
+
+// isPrimaryBackend آیا این ارائه‌دهنده backend اصلی تنظیمات است
+func isPrimaryBackend(provider AIProvider) bool {
+	backends := config.AppConfig.AIBackends
+	return len(backends) > 0 && backends[0].Name == provider.Name()
+}
+
+// aiHTTPClient کلاینت مشترک درخواست‌های AI؛ مهلت هر درخواست از context می‌آید
+var aiHTTPClient = &http.Client{}
+
+// postJSON ارسال درخواست JSON و خواندن بدنه پاسخ موفق
+func postJSON(ctx context.Context, url string, headers map[string]string, payload interface{}) ([]byte, error) {
+	resp, err := doJSONRequest(ctx, url, headers, payload)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, newTransportError(ctx, fmt.Errorf("خطا در خواندن پاسخ: %w", err))
+	}
+
+	return body, nil
+}
+
+// doJSONRequest ارسال درخواست JSON؛ پاسخ‌های ناموفق به AIError تبدیل می‌شوند
+func doJSONRequest(ctx context.Context, url string, headers map[string]string, payload interface{}) (*http.Response, error) {
+	jsonBody, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("خطا در تبدیل JSON: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return nil, fmt.Errorf("خطا در ایجاد درخواست: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := aiHTTPClient.Do(req)
+	if err != nil {
+		return nil, newTransportError(ctx, err)
+	}
+
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return nil, newHTTPError(resp, body)
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,131 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"telegram-bot/config"
+)
+
+var (
+	circuitBreakersMu sync.Mutex
+	circuitBreakers   = make(map[string]*CircuitBreaker)
+)
+
+// circuitBreakerFor دریافت مدارشکن هر ارائه‌دهنده
+func circuitBreakerFor(provider string) *CircuitBreaker {
+	circuitBreakersMu.Lock()
+	defer circuitBreakersMu.Unlock()
+
+	breaker, exists := circuitBreakers[provider]
+	if !exists {
+		breaker = NewCircuitBreaker(
+			config.AppConfig.AICircuitFailureThreshold,
+			time.Duration(config.AppConfig.AICircuitCooldownSeconds)*time.Second,
+		)
+		circuitBreakers[provider] = breaker
+	}
+	return breaker
+}
+
+// callWithRetry اجرای درخواست با تلاش مجدد، backoff تصادفی و مدارشکن
+func callWithRetry(ctx context.Context, provider AIProvider, timeout time.Duration, maxRetries int, call func(ctx context.Context) (*AIResult, error), canRetry func() bool) (*AIResult, error) {
+	breaker := circuitBreakerFor(provider.Name())
+
+	var lastErr *AIError
+	for attempt := 0; attempt <= maxRetries; attempt++ {
+		if attempt > 0 {
+			wait, ok := retryDelay(attempt, lastErr)
+			if !ok {
+				return nil, lastErr
+			}
+
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, newTransportError(ctx, ctx.Err())
+			case <-timer.C:
+			}
+		}
+
+		if allowed, remaining := breaker.Allow(); !allowed {
+			return nil, &AIError{
+				Kind:       AIErrorCircuitOpen,
+				Provider:   provider.Name(),
+				RetryAfter: remaining,
+				Message:    "ارائه‌دهنده به دلیل خطاهای پیاپی موقتاً غیرفعال است",
+			}
+		}
+
+		attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+		started := time.Now()
+		result, err := call(attemptCtx)
+		latency := time.Since(started)
+		cancel()
+
+		if err == nil {
+			breaker.RecordSuccess()
+			recordBackendResult(provider.Name(), latency, nil)
+			return result, nil
+		}
+
+		lastErr = asAIError(err, provider.Name())
+		if lastErr.Kind != AIErrorCanceled {
+			recordBackendResult(provider.Name(), latency, lastErr)
+		}
+
+		switch {
+		case lastErr.Kind == AIErrorCanceled:
+			breaker.Release()
+		case lastErr.Retryable():
+			breaker.RecordFailure()
+		default:
+			// ارائه‌دهنده پاسخ داده است؛ خطا مربوط به خود درخواست است
+			breaker.RecordSuccess()
+		}
+
+		// استریمی که بخشی از پاسخ را تحویل داده قابل تکرار نیست
+		if !lastErr.Retryable() || (canRetry != nil && !canRetry()) {
+			return nil, lastErr
+		}
+	}
+
+	return nil, lastErr
+}
+
+// retryDelay محاسبه زمان انتظار پیش از تلاش بعدی؛ ok=false یعنی تلاش مجدد بی‌فایده است
+func retryDelay(attempt int, lastErr *AIError) (time.Duration, bool) {
+	maxDelay := time.Duration(config.AppConfig.AIRetryMaxDelayMS) * time.Millisecond
+
+	if lastErr != nil && lastErr.RetryAfter > 0 {
+		if lastErr.RetryAfter > maxDelay {
+			return 0, false
+		}
+		return lastErr.RetryAfter, true
+	}
+
+	delay := time.Duration(config.AppConfig.AIRetryBaseDelayMS) * time.Millisecond << (attempt - 1)
+	if delay > maxDelay || delay <= 0 {
+		delay = maxDelay
+	}
+
+	// نیمی ثابت و نیمی تصادفی تا درخواست‌های هم‌زمان پخش شوند
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1)), true
+}
+
+// asAIError تبدیل هر خطا به AIError
+func asAIError(err error, provider string) *AIError {
+	var aiErr *AIError
+	if !errors.As(err, &aiErr) {
+		aiErr = &AIError{Kind: AIErrorBadRequest, Err: err}
+	}
+	if aiErr.Provider == "" {
+		aiErr.Provider = provider
+	}
+	return aiErr
+}
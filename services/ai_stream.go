@@ -0,0 +1,90 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// StreamingAIProvider ارائه‌دهنده‌ای که پاسخ را تکه‌تکه (SSE) برمی‌گرداند
+type StreamingAIProvider interface {
+	AIProvider
+	// Stream ارسال درخواست و فراخوانی onDelta برای هر تکه از پاسخ
+	Stream(ctx context.Context, req *AIRequest, onDelta func(chunk string)) (*AIResult, error)
+}
+
+// postStream ارسال درخواست JSON و برگرداندن بدنه پاسخ برای خواندن تدریجی
+func postStream(ctx context.Context, url string, headers map[string]string, payload interface{}) (io.ReadCloser, error) {
+	streamHeaders := map[string]string{"Accept": "text/event-stream"}
+	for key, value := range headers {
+		streamHeaders[key] = value
+	}
+
+	resp, err := doJSONRequest(ctx, url, streamHeaders, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+// readSSE خواندن رویدادهای Server-Sent Events و فراخوانی handler برای هر رویداد
+func readSSE(body io.Reader, handler func(event, data string) error) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	var event string
+	var data []string
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		switch {
+		case line == "":
+			// پایان یک رویداد
+			if len(data) > 0 {
+				if err := handler(event, strings.Join(data, "\n")); err != nil {
+					return err
+				}
+			}
+			event, data = "", nil
+		case strings.HasPrefix(line, ":"):
+			// توضیح (keep-alive)
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return &AIError{Kind: AIErrorNetwork, Message: "خطا در خواندن استریم", Err: err}
+	}
+
+	if len(data) > 0 {
+		return handler(event, strings.Join(data, "\n"))
+	}
+	return nil
+}
+
+// extractErrorMessage استخراج پیام خطا از بدنه JSON ارائه‌دهنده‌ها
+func extractErrorMessage(body []byte) string {
+	var openAIStyle struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(body, &openAIStyle) == nil && openAIStyle.Error.Message != "" {
+		return openAIStyle.Error.Message
+	}
+
+	var ollamaStyle struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &ollamaStyle) == nil && ollamaStyle.Error != "" {
+		return ollamaStyle.Error
+	}
+
+	return strings.TrimSpace(string(body))
+}
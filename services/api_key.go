@@ -0,0 +1,222 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"telegram-bot/config"
+	"telegram-bot/database"
+	"telegram-bot/utils"
+)
+
+// APIKeyPrefix پیشوند کلیدهای API برای تشخیص از JWT
+const APIKeyPrefix = "tsk_"
+
+// مجوزهای کلید API برای routeهای کاربر؛ مجوزهای پنل ادمین هم به‌عنوان scope پذیرفته می‌شوند
+const (
+	ScopeAIQuery     = "ai:query"
+	ScopeAIAnalyze   = "ai:analyze"
+	ScopeProfileRead = "profile:read"
+)
+
+// apiKeyScopeTitles عنوان فارسی scopeهای کاربری
+var apiKeyScopeTitles = map[string]string{
+	ScopeAIQuery:     "پرسش از AI",
+	ScopeAIAnalyze:   "تحلیل کد",
+	ScopeProfileRead: "مشاهده پروفایل و موجودی",
+}
+
+// apiKeyForbiddenScopes مجوزهایی که کلید API نمی‌تواند داشته باشد تا کلید نتواند دسترسی خود را گسترش دهد
+var apiKeyForbiddenScopes = map[string]bool{
+	PermAll:          true,
+	PermRolesWrite:   true,
+	PermAPIKeysWrite: true,
+}
+
+var (
+	ErrInvalidAPIKey  = errors.New("کلید API نامعتبر یا منقضی است")
+	ErrAPIKeyNotFound = errors.New("کلید API یافت نشد")
+)
+
+// apiKeyTouchInterval حداقل فاصله ثبت زمان آخرین استفاده؛ از نوشتن در هر درخواست جلوگیری می‌کند
+const apiKeyTouchInterval = time.Minute
+
+// APIKeyService صدور، بررسی و ابطال کلیدهای API
+type APIKeyService struct{}
+
+// APIKeyRequest درخواست صدور کلید
+type APIKeyRequest struct {
+	Name       string
+	Scopes     []string
+	ExpiresAt  time.Time // صفر یعنی بدون انقضا
+	RatePerMin int       // فقط توسط ادمین؛ صفر یعنی محدودیت نقش کاربر
+	RateBurst  int
+
+	// IssuerPermissions مجوزهای صادرکننده وقتی کلید برای کاربر دیگری صادر می‌شود
+	IssuerPermissions []string
+}
+
+// GetScopeTitles scopeهای کاربری قابل انتخاب
+func (s *APIKeyService) GetScopeTitles() map[string]string {
+	return apiKeyScopeTitles
+}
+
+// CreateKey صدور کلید برای کاربر؛ کلید خام فقط همین‌جا برگردانده می‌شود
+//
+// scopeهای پنل ادمین فقط در صورتی پذیرفته می‌شوند که صاحب کلید و، اگر کلید برای
+// کاربر دیگری صادر شود، صادرکننده هم آن مجوز را داشته باشند؛ وگرنه صادرکننده با
+// کلید کاربر دیگر به مجوزهایی می‌رسد که خودش ندارد. هنگام استفاده هم مجوزهای
+// کلید با مجوزهای فعلی کاربر مقایسه می‌شوند.
+func (s *APIKeyService) CreateKey(userID, createdBy uint, req *APIKeyRequest) (*database.APIKey, string, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return nil, "", fmt.Errorf("نام کلید الزامی است")
+	}
+	if !req.ExpiresAt.IsZero() && !req.ExpiresAt.After(time.Now()) {
+		return nil, "", fmt.Errorf("تاریخ انقضا باید در آینده باشد")
+	}
+	if req.RatePerMin < 0 || req.RateBurst < 0 {
+		return nil, "", fmt.Errorf("محدودیت نرخ نمی‌تواند منفی باشد")
+	}
+
+	scopes := splitPermissions(strings.Join(req.Scopes, ","))
+	if len(scopes) == 0 {
+		return nil, "", fmt.Errorf("حداقل یک scope لازم است")
+	}
+
+	permissions, err := rbacService.GetUserPermissions(userID)
+	if err != nil {
+		return nil, "", err
+	}
+	issuerPermissions := permissions
+	if createdBy != userID {
+		issuerPermissions = req.IssuerPermissions
+	}
+	for _, scope := range scopes {
+		if _, ok := apiKeyScopeTitles[scope]; ok {
+			continue
+		}
+		if _, ok := permissionTitles[scope]; !ok || apiKeyForbiddenScopes[scope] {
+			return nil, "", fmt.Errorf("scope %q نامعتبر است", scope)
+		}
+		if !HasPermission(permissions, scope) {
+			return nil, "", fmt.Errorf("کاربر مجوز %q را ندارد", scope)
+		}
+		if !HasPermission(issuerPermissions, scope) {
+			return nil, "", fmt.Errorf("صادرکننده مجوز %q را ندارد", scope)
+		}
+	}
+
+	var active int64
+	if err := database.DB.Model(&database.APIKey{}).
+		Where("user_id = ? AND revoked = ?", userID, false).
+		Count(&active).Error; err != nil {
+		return nil, "", fmt.Errorf("خطا در بررسی کلیدها: %w", err)
+	}
+	if limit := config.AppConfig.APIKeyMaxPerUser; limit > 0 && int(active) >= limit {
+		return nil, "", fmt.Errorf("حداکثر %d کلید فعال مجاز است", limit)
+	}
+
+	secret, err := utils.GenerateSecureToken(32)
+	if err != nil {
+		return nil, "", fmt.Errorf("خطا در تولید کلید: %w", err)
+	}
+	rawKey := APIKeyPrefix + secret
+
+	key := &database.APIKey{
+		UserID:     userID,
+		Name:       name,
+		Prefix:     rawKey[:len(APIKeyPrefix)+8],
+		KeyHash:    utils.HashToken(rawKey),
+		Scopes:     strings.Join(scopes, ","),
+		RatePerMin: req.RatePerMin,
+		RateBurst:  req.RateBurst,
+		ExpiresAt:  req.ExpiresAt,
+		CreatedBy:  createdBy,
+		CreatedAt:  time.Now(),
+	}
+	if err := database.DB.Create(key).Error; err != nil {
+		return nil, "", fmt.Errorf("خطا در ثبت کلید: %w", err)
+	}
+
+	utils.LogInfo("APIKeyService", fmt.Sprintf("کلید %s برای کاربر %d توسط %d صادر شد", key.Prefix, userID, createdBy))
+	return key, rawKey, nil
+}
+
+// Authenticate بررسی کلید خام و ثبت زمان آخرین استفاده
+func (s *APIKeyService) Authenticate(rawKey, ip string) (*database.APIKey, error) {
+	if !strings.HasPrefix(rawKey, APIKeyPrefix) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	var key database.APIKey
+	result := database.DB.Where("key_hash = ?", utils.HashToken(rawKey)).Limit(1).Find(&key)
+	if result.Error != nil {
+		return nil, fmt.Errorf("خطا در بررسی کلید: %w", result.Error)
+	}
+	if result.RowsAffected == 0 || key.Revoked {
+		return nil, ErrInvalidAPIKey
+	}
+	if !key.ExpiresAt.IsZero() && time.Now().After(key.ExpiresAt) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	if time.Since(key.LastUsedAt) > apiKeyTouchInterval || key.LastUsedIP != ip {
+		key.LastUsedAt = time.Now()
+		key.LastUsedIP = ip
+		if err := database.DB.Model(&database.APIKey{}).Where("id = ?", key.ID).Updates(map[string]interface{}{
+			"last_used_at": key.LastUsedAt,
+			"last_used_ip": ip,
+		}).Error; err != nil {
+			utils.LogError("APIKeyService", fmt.Sprintf("خطا در ثبت استفاده از کلید %d", key.ID), err)
+		}
+	}
+
+	return &key, nil
+}
+
+// GetUserKeys کلیدهای کاربر؛ کلیدهای باطل‌شده هم برای سابقه برگردانده می‌شوند
+func (s *APIKeyService) GetUserKeys(userID uint) ([]database.APIKey, error) {
+	var keys []database.APIKey
+	if err := database.DB.Where("user_id = ?", userID).Order("id DESC").Find(&keys).Error; err != nil {
+		return nil, fmt.Errorf("خطا در دریافت کلیدها: %w", err)
+	}
+	return keys, nil
+}
+
+// RevokeKey ابطال کلید؛ userID صفر یعنی ابطال توسط ادمین بدون بررسی مالکیت
+func (s *APIKeyService) RevokeKey(keyID, userID uint) error {
+	query := database.DB.Model(&database.APIKey{}).Where("id = ? AND revoked = ?", keyID, false)
+	if userID != 0 {
+		query = query.Where("user_id = ?", userID)
+	}
+
+	result := query.Update("revoked", true)
+	if result.Error != nil {
+		return fmt.Errorf("خطا در ابطال کلید: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// KeyScopes فهرست scopeهای کلید
+func KeyScopes(key *database.APIKey) []string {
+	return splitPermissions(key.Scopes)
+}
+
+// KeyRateLimit محدودیت نرخ اختصاصی کلید؛ ok نادرست یعنی محدودیت نقش کاربر اعمال می‌شود
+func KeyRateLimit(key *database.APIKey) (config.RateLimit, bool) {
+	if key.RatePerMin <= 0 {
+		return config.RateLimit{}, false
+	}
+
+	burst := key.RateBurst
+	if burst <= 0 {
+		burst = key.RatePerMin
+	}
+	return config.RateLimit{PerMinute: float64(key.RatePerMin), Burst: burst}, true
+}
@@ -0,0 +1,52 @@
+package services
+
+import (
+	"testing"
+
+	"telegram-bot/database"
+)
+
+func TestCreateKeyChecksIssuerAdminScopes(t *testing.T) {
+	setupTestDB(t)
+	if err := rbacService.EnsureDefaultRoles(); err != nil {
+		t.Fatalf("EnsureDefaultRoles: %v", err)
+	}
+	admin := createTestUser(t, 30, 0)
+	if err := rbacService.AssignRoles(admin.ID, []string{RoleAdmin}); err != nil {
+		t.Fatalf("AssignRoles: %v", err)
+	}
+
+	keys := &APIKeyService{}
+	issuer := []string{PermAPIKeysWrite}
+
+	// صادرکننده‌ای که فقط apikeys:write دارد نمی‌تواند مجوز ادمین صاحب کلید را به کلید بدهد
+	if _, _, err := keys.CreateKey(admin.ID, admin.ID+1, &APIKeyRequest{
+		Name:              "escalate",
+		Scopes:            []string{PermUsersWrite},
+		IssuerPermissions: issuer,
+	}); err == nil {
+		t.Fatal("CreateKey accepted an admin scope the issuer does not hold")
+	}
+
+	if _, _, err := keys.CreateKey(admin.ID, admin.ID+1, &APIKeyRequest{
+		Name:              "user scopes",
+		Scopes:            []string{ScopeAIQuery, ScopeProfileRead},
+		IssuerPermissions: issuer,
+	}); err != nil {
+		t.Fatalf("CreateKey with user scopes: %v", err)
+	}
+
+	// صاحب کلید برای خودش به مجوزهای خودش محدود است
+	if _, _, err := keys.CreateKey(admin.ID, admin.ID, &APIKeyRequest{
+		Name:   "own",
+		Scopes: []string{PermUsersWrite},
+	}); err != nil {
+		t.Fatalf("CreateKey for own admin scope: %v", err)
+	}
+
+	var count int64
+	database.DB.Model(&database.APIKey{}).Where("user_id = ?", admin.ID).Count(&count)
+	if count != 2 {
+		t.Errorf("keys = %d, want 2", count)
+	}
+}
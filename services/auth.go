@@ -47,7 +47,7 @@ func (s *AuthService) RegisterUser(telegramID int64, phoneNumber, nationalCode,
 		PhoneNumber:    phoneNumber,
 		NationalCode:   nationalCode,
 		FullName:       fullName,
-		DailyTokens:    config.AppConfig.DailyTokenLimit,
+		DailyTokens:    planService.FreeDailyTokens(),
 		LastTokenReset: time.Now(),
 		CreatedAt:      time.Now(),
 		UpdatedAt:      time.Now(),
@@ -60,43 +60,80 @@ func (s *AuthService) RegisterUser(telegramID int64, phoneNumber, nationalCode,
 	return &user, nil
 }
 
-// GenerateJWT تولید JWT token
-func (s *AuthService) GenerateJWT(userID uint) (string, error) {
+// AccessClaims اطلاعات access token تاییدشده
+type AccessClaims struct {
+	UserID    uint
+	SessionID uint
+	JTI       string
+	ExpiresAt time.Time
+}
+
+// GenerateJWT تولید access token کوتاه‌مدت برای یک نشست ورود
+func (s *AuthService) GenerateJWT(userID, sessionID uint) (string, string, error) {
+	jti, err := utils.GenerateSecureToken(16)
+	if err != nil {
+		return "", "", fmt.Errorf("خطا در تولید شناسه token: %w", err)
+	}
+
+	now := time.Now()
 	claims := jwt.MapClaims{
 		"user_id": userID,
-		"exp":     time.Now().Add(time.Hour * 24).Unix(),
-		"iat":     time.Now().Unix(),
+		"sid":     sessionID,
+		"jti":     jti,
+		"exp":     now.Add(accessTokenTTL()).Unix(),
+		"iat":     now.Unix(),
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(config.AppConfig.JWTSecret))
+	signed, err := token.SignedString([]byte(config.AppConfig.JWTSecret))
+	if err != nil {
+		return "", "", err
+	}
+	return signed, jti, nil
 }
 
-// VerifyJWT تایید JWT token
-func (s *AuthService) VerifyJWT(tokenString string) (uint, error) {
+// VerifyJWT تایید امضا و انقضای access token؛ باطل بودن آن جداگانه با IsTokenRevoked بررسی می‌شود
+func (s *AuthService) VerifyJWT(tokenString string) (*AccessClaims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, jwt.MapClaims{}, func(token *jwt.Token) (interface{}, error) {
 		return []byte(config.AppConfig.JWTSecret), nil
-	})
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
 
 	if err != nil {
-		return 0, fmt.Errorf("خطا در تایید token: %w", err)
+		return nil, fmt.Errorf("خطا در تایید token: %w", err)
 	}
 
 	if !token.Valid {
-		return 0, fmt.Errorf("token نامعتبر است")
+		return nil, fmt.Errorf("token نامعتبر است")
 	}
 
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok {
-		return 0, fmt.Errorf("claims نامعتبر است")
+		return nil, fmt.Errorf("claims نامعتبر است")
 	}
 
 	userID, ok := claims["user_id"].(float64)
 	if !ok {
-		return 0, fmt.Errorf("user_id یافت نشد")
+		return nil, fmt.Errorf("user_id یافت نشد")
+	}
+
+	// tokenهای قدیمی بدون نشست پذیرفته نمی‌شوند تا قابل ابطال باشند
+	sessionID, _ := claims["sid"].(float64)
+	jti, _ := claims["jti"].(string)
+	if sessionID == 0 || jti == "" {
+		return nil, fmt.Errorf("token فاقد نشست است")
+	}
+
+	expiresAt, err := claims.GetExpirationTime()
+	if err != nil || expiresAt == nil {
+		return nil, fmt.Errorf("انقضای token نامعتبر است")
 	}
 
-	return uint(userID), nil
+	return &AccessClaims{
+		UserID:    uint(userID),
+		SessionID: uint(sessionID),
+		JTI:       jti,
+		ExpiresAt: expiresAt.Time,
+	}, nil
 }
 
 // GenerateAdminPassword تولید رمز ادمین
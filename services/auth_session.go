@@ -0,0 +1,253 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"telegram-bot/config"
+	"telegram-bot/database"
+	"telegram-bot/utils"
+)
+
+var ErrInvalidRefreshToken = errors.New("refresh token نامعتبر یا منقضی است")
+
+// TokenPair access token و refresh token یک نشست
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"` // عمر access token به ثانیه
+	SessionID    uint   `json:"session_id"`
+}
+
+// accessTokenTTL عمر access token
+func accessTokenTTL() time.Duration {
+	return time.Duration(config.AppConfig.AccessTokenTTLMinutes) * time.Minute
+}
+
+// CreateSession ایجاد نشست ورود جدید و صدور اولین جفت token
+func (s *AuthService) CreateSession(userID uint, userAgent, ip string) (*TokenPair, error) {
+	refreshToken, err := utils.GenerateSecureToken(32)
+	if err != nil {
+		return nil, fmt.Errorf("خطا در تولید refresh token: %w", err)
+	}
+
+	now := time.Now()
+	session := &database.AuthSession{
+		UserID:           userID,
+		RefreshTokenHash: utils.HashToken(refreshToken),
+		UserAgent:        truncateRunes(userAgent, 250),
+		IP:               ip,
+		ExpiresAt:        now.AddDate(0, 0, config.AppConfig.RefreshTokenTTLDays),
+		LastUsedAt:       now,
+		CreatedAt:        now,
+	}
+	if err := database.DB.Create(session).Error; err != nil {
+		return nil, fmt.Errorf("خطا در ایجاد نشست: %w", err)
+	}
+
+	accessToken, jti, err := s.GenerateJWT(userID, session.ID)
+	if err != nil {
+		return nil, fmt.Errorf("خطا در تولید token: %w", err)
+	}
+	if err := database.DB.Model(session).Update("access_jti", jti).Error; err != nil {
+		return nil, fmt.Errorf("خطا در ثبت نشست: %w", err)
+	}
+
+	return newTokenPair(accessToken, refreshToken, session.ID), nil
+}
+
+// RefreshSession چرخش refresh token و صدور access token جدید
+//
+// هر refresh token فقط یک بار قابل استفاده است و access token قبلی نشست باطل
+// می‌شود. ارائه دوباره توکنی که قبلاً چرخیده، نشانه سرقت است و کل نشست را باطل
+// می‌کند. عمر نشست با چرخش تمدید نمی‌شود.
+func (s *AuthService) RefreshSession(refreshToken, userAgent, ip string) (*TokenPair, error) {
+	hash := utils.HashToken(refreshToken)
+
+	var session database.AuthSession
+	result := database.DB.Where("refresh_token_hash = ?", hash).Limit(1).Find(&session)
+	if result.Error != nil {
+		return nil, fmt.Errorf("خطا در بررسی نشست: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		s.detectRefreshReuse(hash)
+		return nil, ErrInvalidRefreshToken
+	}
+	if session.Revoked || time.Now().After(session.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	newRefreshToken, err := utils.GenerateSecureToken(32)
+	if err != nil {
+		return nil, fmt.Errorf("خطا در تولید refresh token: %w", err)
+	}
+	accessToken, jti, err := s.GenerateJWT(session.UserID, session.ID)
+	if err != nil {
+		return nil, fmt.Errorf("خطا در تولید token: %w", err)
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		// به‌روزرسانی شرطی؛ اگر درخواست هم‌زمان دیگری توکن را چرخانده باشد رد می‌شود
+		result := tx.Model(&database.AuthSession{}).
+			Where("id = ? AND refresh_token_hash = ? AND revoked = ?", session.ID, hash, false).
+			Updates(map[string]interface{}{
+				"refresh_token_hash":  utils.HashToken(newRefreshToken),
+				"previous_token_hash": hash,
+				"access_jti":          jti,
+				"user_agent":          truncateRunes(userAgent, 250),
+				"ip":                  ip,
+				"last_used_at":        time.Now(),
+			})
+		if result.Error != nil {
+			return fmt.Errorf("خطا در چرخش refresh token: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidRefreshToken
+		}
+
+		return revokeAccessToken(tx, session.UserID, session.AccessJTI)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return newTokenPair(accessToken, newRefreshToken, session.ID), nil
+}
+
+// RevokeSession باطل کردن یک نشست کاربر (خروج از یک دستگاه)
+func (s *AuthService) RevokeSession(userID, sessionID uint) error {
+	revoked, err := revokeSessions(database.DB.Where("id = ? AND user_id = ?", sessionID, userID))
+	if err != nil {
+		return err
+	}
+	if revoked == 0 {
+		return fmt.Errorf("نشست فعالی یافت نشد")
+	}
+	return nil
+}
+
+// RevokeAllSessions باطل کردن همه نشست‌های کاربر (خروج از همه دستگاه‌ها)
+func (s *AuthService) RevokeAllSessions(userID uint) (int, error) {
+	return revokeSessions(database.DB.Where("user_id = ?", userID))
+}
+
+// GetActiveSessions نشست‌های فعال کاربر
+func (s *AuthService) GetActiveSessions(userID uint) ([]database.AuthSession, error) {
+	var sessions []database.AuthSession
+	if err := database.DB.
+		Where("user_id = ? AND revoked = ? AND expires_at > ?", userID, false, time.Now().Local()).
+		Order("last_used_at DESC").
+		Find(&sessions).Error; err != nil {
+		return nil, fmt.Errorf("خطا در دریافت نشست‌ها: %w", err)
+	}
+	return sessions, nil
+}
+
+// IsTokenRevoked بررسی وجود access token در فهرست tokenهای باطل‌شده
+func (s *AuthService) IsTokenRevoked(jti string) (bool, error) {
+	var count int64
+	if err := database.DB.Model(&database.RevokedToken{}).Where("jti = ?", jti).Count(&count).Error; err != nil {
+		return false, fmt.Errorf("خطا در بررسی ابطال token: %w", err)
+	}
+	return count > 0, nil
+}
+
+// PurgeExpiredAuth حذف نشست‌ها و ردیف‌های ابطالی که دیگر اعتبار ندارند
+func (s *AuthService) PurgeExpiredAuth() (int64, error) {
+	now := time.Now().Local()
+
+	tokens := database.DB.Where("expires_at < ?", now).Delete(&database.RevokedToken{})
+	if tokens.Error != nil {
+		return 0, fmt.Errorf("خطا در حذف tokenهای منقضی: %w", tokens.Error)
+	}
+
+	// نشست باطل‌شده تا انقضای آخرین access token نگه داشته می‌شود
+	sessions := database.DB.
+		Where("expires_at < ? OR (revoked = ? AND revoked_at < ?)", now, true, now.Add(-accessTokenTTL())).
+		Delete(&database.AuthSession{})
+	if sessions.Error != nil {
+		return tokens.RowsAffected, fmt.Errorf("خطا در حذف نشست‌های منقضی: %w", sessions.Error)
+	}
+
+	return tokens.RowsAffected + sessions.RowsAffected, nil
+}
+
+// detectRefreshReuse باطل کردن نشستی که refresh token چرخیده‌اش دوباره ارائه شده است
+func (s *AuthService) detectRefreshReuse(hash string) {
+	var session database.AuthSession
+	result := database.DB.Where("previous_token_hash = ? AND revoked = ?", hash, false).Limit(1).Find(&session)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return
+	}
+
+	if _, err := revokeSessions(database.DB.Where("id = ?", session.ID)); err != nil {
+		utils.LogError("AuthService", fmt.Sprintf("خطا در ابطال نشست %d", session.ID), err)
+		return
+	}
+	utils.LogInfo("AuthService", fmt.Sprintf("استفاده دوباره از refresh token؛ نشست %d کاربر %d باطل شد", session.ID, session.UserID))
+}
+
+// revokeSessions باطل کردن نشست‌های فعال منطبق با query و access token فعلی آن‌ها
+func revokeSessions(query *gorm.DB) (int, error) {
+	var sessions []database.AuthSession
+	if err := query.Where("revoked = ?", false).Find(&sessions).Error; err != nil {
+		return 0, fmt.Errorf("خطا در دریافت نشست‌ها: %w", err)
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		for _, session := range sessions {
+			if err := tx.Model(&database.AuthSession{}).
+				Where("id = ?", session.ID).
+				Updates(map[string]interface{}{"revoked": true, "revoked_at": time.Now()}).Error; err != nil {
+				return fmt.Errorf("خطا در ابطال نشست: %w", err)
+			}
+			if err := revokeAccessToken(tx, session.UserID, session.AccessJTI); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(sessions), nil
+}
+
+// revokeAccessToken افزودن access token به فهرست ابطال تا پایان عمر آن
+func revokeAccessToken(tx *gorm.DB, userID uint, jti string) error {
+	if jti == "" {
+		return nil
+	}
+
+	var count int64
+	if err := tx.Model(&database.RevokedToken{}).Where("jti = ?", jti).Count(&count).Error; err != nil {
+		return fmt.Errorf("خطا در ابطال token: %w", err)
+	}
+	if count > 0 {
+		return nil
+	}
+
+	if err := tx.Create(&database.RevokedToken{
+		JTI:       jti,
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(accessTokenTTL()),
+		CreatedAt: time.Now(),
+	}).Error; err != nil {
+		return fmt.Errorf("خطا در ابطال token: %w", err)
+	}
+	return nil
+}
+
+// newTokenPair ساخت پاسخ token
+func newTokenPair(accessToken, refreshToken string, sessionID uint) *TokenPair {
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(accessTokenTTL().Seconds()),
+		SessionID:    sessionID,
+	}
+}
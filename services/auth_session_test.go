@@ -0,0 +1,76 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"telegram-bot/database"
+)
+
+func TestRefreshSessionRotates(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, 30, 0)
+
+	auth := &AuthService{}
+	first, err := auth.CreateSession(user.ID, "test", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+
+	second, err := auth.RefreshSession(first.RefreshToken, "test", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("RefreshSession: %v", err)
+	}
+	if second.RefreshToken == first.RefreshToken || second.SessionID != first.SessionID {
+		t.Fatalf("refresh did not rotate the token of session %d", first.SessionID)
+	}
+
+	// access token قبلی نشست با چرخش باطل می‌شود
+	claims, err := auth.VerifyJWT(first.AccessToken)
+	if err != nil {
+		t.Fatalf("VerifyJWT: %v", err)
+	}
+	if revoked, err := auth.IsTokenRevoked(claims.JTI); err != nil || !revoked {
+		t.Fatalf("old access token revoked = %v, %v; want true", revoked, err)
+	}
+}
+
+func TestRefreshSessionReuseRevokesSession(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, 30, 0)
+
+	auth := &AuthService{}
+	first, err := auth.CreateSession(user.ID, "test", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	second, err := auth.RefreshSession(first.RefreshToken, "test", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("RefreshSession: %v", err)
+	}
+
+	// ارائه دوباره توکن چرخیده نشانه سرقت است
+	if _, err := auth.RefreshSession(first.RefreshToken, "attacker", "10.0.0.1"); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("reused token: err=%v, want ErrInvalidRefreshToken", err)
+	}
+
+	var session database.AuthSession
+	if err := database.DB.First(&session, first.SessionID).Error; err != nil {
+		t.Fatalf("load session: %v", err)
+	}
+	if !session.Revoked {
+		t.Fatal("session was not revoked after refresh token reuse")
+	}
+
+	// توکن معتبر فعلی نشست هم دیگر پذیرفته نمی‌شود
+	if _, err := auth.RefreshSession(second.RefreshToken, "test", "127.0.0.1"); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("current token after reuse: err=%v, want ErrInvalidRefreshToken", err)
+	}
+	claims, err := auth.VerifyJWT(second.AccessToken)
+	if err != nil {
+		t.Fatalf("VerifyJWT: %v", err)
+	}
+	if revoked, err := auth.IsTokenRevoked(claims.JTI); err != nil || !revoked {
+		t.Fatalf("current access token revoked = %v, %v; want true", revoked, err)
+	}
+}
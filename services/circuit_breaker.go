@@ -0,0 +1,101 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// وضعیت‌های مدارشکن
+const (
+	CircuitClosed   = "closed"    // درخواست‌ها عادی ارسال می‌شوند
+	CircuitOpen     = "open"      // درخواست‌ها بدون ارسال رد می‌شوند
+	CircuitHalfOpen = "half_open" // یک درخواست آزمایشی مجاز است
+)
+
+// CircuitBreaker جلوگیری از ارسال پیاپی درخواست به ارائه‌دهنده‌ای که از کار افتاده
+type CircuitBreaker struct {
+	mu        sync.Mutex
+	state     string
+	failures  int
+	openedAt  time.Time
+	probing   bool
+	threshold int
+	cooldown  time.Duration
+}
+
+// NewCircuitBreaker ایجاد مدارشکن؛ پس از threshold خطای پیاپی به مدت cooldown باز می‌ماند
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		state:     CircuitClosed,
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
+
+// Allow بررسی مجاز بودن ارسال درخواست؛ در حالت باز، زمان باقی‌مانده را برمی‌گرداند
+func (b *CircuitBreaker) Allow() (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case CircuitOpen:
+		remaining := b.cooldown - time.Since(b.openedAt)
+		if remaining > 0 {
+			return false, remaining
+		}
+		b.state = CircuitHalfOpen
+		b.probing = true
+		return true, 0
+	case CircuitHalfOpen:
+		// فقط یک درخواست آزمایشی در هر زمان
+		if b.probing {
+			return false, b.cooldown
+		}
+		b.probing = true
+		return true, 0
+	}
+
+	return true, 0
+}
+
+// RecordSuccess ثبت درخواست موفق و بستن مدار
+func (b *CircuitBreaker) RecordSuccess() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.state = CircuitClosed
+	b.failures = 0
+	b.probing = false
+}
+
+// RecordFailure ثبت خطا و باز کردن مدار در صورت رسیدن به آستانه
+func (b *CircuitBreaker) RecordFailure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	b.probing = false
+	if b.state == CircuitHalfOpen || (b.threshold > 0 && b.failures >= b.threshold) {
+		b.state = CircuitOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// Release آزاد کردن درخواست آزمایشی بدون تغییر وضعیت (مثلاً هنگام لغو درخواست)
+func (b *CircuitBreaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+}
+
+// State وضعیت فعلی مدار
+func (b *CircuitBreaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == CircuitOpen && time.Since(b.openedAt) >= b.cooldown {
+		return CircuitHalfOpen
+	}
+	return b.state
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"telegram-bot/config"
+	"telegram-bot/database"
+)
+
+// summaryQuestionRunes حداکثر طول هر سوال در خلاصه نوبت‌های حذف‌شده
+const summaryQuestionRunes = 80
+
+// EstimateTokens تخمین تقریبی تعداد توکن یک متن
+func EstimateTokens(text string) int {
+	// به‌طور میانگین هر توکن حدود چهار کاراکتر است
+	return (utf8.RuneCountInString(text) + 3) / 4
+}
+
+// estimateUsage تخمین مصرف وقتی ارائه‌دهنده بلوک usage برنمی‌گرداند
+func estimateUsage(request *AIRequest, content string) AIUsage {
+	usage := AIUsage{
+		CompletionTokens: EstimateTokens(content),
+		Estimated:        true,
+	}
+	for _, message := range request.Messages {
+		usage.PromptTokens += EstimateTokens(message.Content)
+	}
+	return usage
+}
+
+// buildContextMessages ساخت پیام‌های درخواست همراه با نوبت‌های قبلی گفتگو
+func (s *AIService) buildContextMessages(threadID uint, systemPrompt, question string) ([]AIMessage, error) {
+	history, err := s.loadContextHistory(threadID)
+	if err != nil {
+		return nil, err
+	}
+
+	budget := config.AppConfig.ContextTokenBudget - EstimateTokens(systemPrompt) - EstimateTokens(question)
+
+	// از جدیدترین نوبت به قدیمی‌ترین، تا جایی که بودجه اجازه دهد
+	var kept []database.Conversation
+	var dropped []database.Conversation
+	for i := len(history) - 1; i >= 0; i-- {
+		cost := EstimateTokens(history[i].Question) + EstimateTokens(history[i].Answer)
+		if len(dropped) == 0 && cost <= budget {
+			budget -= cost
+			kept = append([]database.Conversation{history[i]}, kept...)
+			continue
+		}
+		dropped = append([]database.Conversation{history[i]}, dropped...)
+	}
+
+	messages := []AIMessage{
+		{
+			Role:    "system",
+			Content: systemPrompt,
+		},
+	}
+
+	if summary := summarizeTurns(dropped, budget); summary != "" {
+		messages = append(messages, AIMessage{Role: "system", Content: summary})
+	}
+
+	for _, turn := range kept {
+		messages = append(messages,
+			AIMessage{Role: "user", Content: turn.Question},
+			AIMessage{Role: "assistant", Content: turn.Answer},
+		)
+	}
+
+	messages = append(messages, AIMessage{Role: "user", Content: question})
+	return messages, nil
+}
+
+// loadContextHistory دریافت آخرین نوبت‌های رشته گفتگو به ترتیب زمانی
+func (s *AIService) loadContextHistory(threadID uint) ([]database.Conversation, error) {
+	if config.AppConfig.ContextMaxTurns <= 0 {
+		return nil, nil
+	}
+
+	var conversations []database.Conversation
+	if err := database.DB.Where("thread_id = ?", threadID).
+		Order("created_at DESC").
+		Limit(config.AppConfig.ContextMaxTurns).
+		Find(&conversations).Error; err != nil {
+		return nil, fmt.Errorf("خطا در دریافت زمینه گفتگو: %w", err)
+	}
+
+	// برگرداندن به ترتیب قدیمی به جدید
+	for i, j := 0, len(conversations)-1; i < j; i, j = i+1, j-1 {
+		conversations[i], conversations[j] = conversations[j], conversations[i]
+	}
+
+	return conversations, nil
+}
+
+// summarizeTurns خلاصه کوتاه از سوال‌های نوبت‌هایی که در بودجه جا نشدند
+func summarizeTurns(turns []database.Conversation, budget int) string {
+	if len(turns) == 0 || budget <= 0 {
+		return ""
+	}
+
+	header := "خلاصه سوال‌های قبلی کاربر در این گفتگو:"
+	summary := header
+	for i := len(turns) - 1; i >= 0; i-- {
+		line := "\n- " + truncateRunes(turns[i].Question, summaryQuestionRunes)
+		if EstimateTokens(summary+line) > budget {
+			break
+		}
+		summary += line
+	}
+
+	if summary == header {
+		return ""
+	}
+	return summary
+}
+
+// truncateRunes کوتاه کردن متن بدون شکستن حروف چندبایتی
+func truncateRunes(text string, max int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if utf8.RuneCountInString(text) <= max {
+		return text
+	}
+	return string([]rune(text)[:max]) + "…"
+}
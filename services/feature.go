@@ -0,0 +1,215 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"gorm.io/gorm"
+	"telegram-bot/config"
+	"telegram-bot/database"
+)
+
+// انواع درخواست با هزینه و سقف جداگانه
+const (
+	FeatureChat         = "chat"
+	FeatureLongContext  = "long_context"
+	FeatureCodeAnalysis = "code_analysis"
+	FeatureFileUpload   = "file_upload"
+)
+
+// features ترتیب نمایش قابلیت‌ها
+var features = []string{FeatureChat, FeatureLongContext, FeatureCodeAnalysis, FeatureFileUpload}
+
+// featureTitles عنوان فارسی قابلیت‌ها
+var featureTitles = map[string]string{
+	FeatureChat:         "چت",
+	FeatureLongContext:  "متن طولانی",
+	FeatureCodeAnalysis: "تحلیل کد",
+	FeatureFileUpload:   "آپلود فایل",
+}
+
+var ErrFeatureCapReached = errors.New("سقف روزانه این قابلیت پر شده است")
+
+// FeatureService هزینه و سقف روزانه هر نوع درخواست
+type FeatureService struct{}
+
+var featureService = &FeatureService{}
+
+// FeatureUsage مصرف امروز یک قابلیت
+type FeatureUsage struct {
+	Feature  string `json:"feature"`
+	Title    string `json:"title"`
+	Requests int    `json:"requests"`
+	Credits  int    `json:"credits"`
+	DailyCap int    `json:"daily_cap"` // صفر یعنی بدون سقف
+}
+
+// FeatureTitle عنوان فارسی قابلیت
+func FeatureTitle(feature string) string {
+	if title, ok := featureTitles[feature]; ok {
+		return title
+	}
+	return feature
+}
+
+// QueryFeature نوع درخواست چت بر اساس تخمین توکن ورودی
+func QueryFeature(promptTokens int) string {
+	if limit := config.AppConfig.LongContextTokens; limit > 0 && promptTokens > limit {
+		return FeatureLongContext
+	}
+	return FeatureChat
+}
+
+// GetFeatureCost هزینه یک قابلیت؛ ردیف جدول بر مقدار تنظیمات اولویت دارد
+func (s *FeatureService) GetFeatureCost(feature string) database.FeaturePricing {
+	cost := database.FeaturePricing{Feature: feature, Multiplier: 1}
+	for _, item := range config.AppConfig.FeatureCosts {
+		if item.Feature == feature {
+			cost.BaseCredits = item.BaseCredits
+			cost.Multiplier = item.Multiplier
+			cost.DailyCap = item.DailyCap
+			break
+		}
+	}
+
+	var row database.FeaturePricing
+	if result := database.DB.Where("feature = ?", feature).Limit(1).Find(&row); result.Error == nil && result.RowsAffected > 0 {
+		return row
+	}
+	return cost
+}
+
+// GetAllFeatureCosts هزینه همه قابلیت‌ها
+func (s *FeatureService) GetAllFeatureCosts() []database.FeaturePricing {
+	costs := make([]database.FeaturePricing, 0, len(features))
+	for _, feature := range features {
+		costs = append(costs, s.GetFeatureCost(feature))
+	}
+	return costs
+}
+
+// SetFeatureCost ثبت یا به‌روزرسانی هزینه یک قابلیت
+func (s *FeatureService) SetFeatureCost(cost *database.FeaturePricing) error {
+	if _, ok := featureTitles[cost.Feature]; !ok {
+		return fmt.Errorf("قابلیت %q نامعتبر است", cost.Feature)
+	}
+	if cost.BaseCredits < 0 || cost.Multiplier < 0 || cost.DailyCap < 0 {
+		return fmt.Errorf("هزینه و سقف نمی‌توانند منفی باشند")
+	}
+
+	var existing database.FeaturePricing
+	result := database.DB.Where("feature = ?", cost.Feature).Limit(1).Find(&existing)
+	if result.Error != nil {
+		return fmt.Errorf("خطا در ذخیره هزینه قابلیت: %w", result.Error)
+	}
+
+	cost.ID = existing.ID
+	cost.UpdatedAt = time.Now()
+	return database.DB.Save(cost).Error
+}
+
+// CalculateCredits اعتبار یک درخواست: اعتبار توکن‌ها ضرب در ضریب قابلیت به‌علاوه اعتبار ثابت
+func (s *FeatureService) CalculateCredits(feature, model string, promptTokens, completionTokens int) int {
+	cost := s.GetFeatureCost(feature)
+
+	tokenCost := pricingService.CalculateCost(model, promptTokens, completionTokens)
+	credits := int(math.Ceil(tokenCost*cost.Multiplier)) + cost.BaseCredits
+
+	if credits < config.AppConfig.MinCreditsPerRequest {
+		credits = config.AppConfig.MinCreditsPerRequest
+	}
+	return credits
+}
+
+// CheckDailyCap بررسی سقف روزانه قابلیت پیش از ارسال درخواست؛ کاربر نامحدود سقفی ندارد
+func (s *FeatureService) CheckDailyCap(userID uint, feature string) error {
+	cost := s.GetFeatureCost(feature)
+	if cost.DailyCap <= 0 {
+		return nil
+	}
+
+	var user database.User
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		return ErrUserNotFound
+	}
+	if user.UnlimitedTokens {
+		return nil
+	}
+
+	var used int
+	if err := database.DB.Model(&database.DailyFeatureUsage{}).
+		Where("user_id = ? AND feature = ? AND date = ?", userID, feature, DayStart(time.Now())).
+		Select("COALESCE(SUM(credits_used), 0)").
+		Scan(&used).Error; err != nil {
+		return fmt.Errorf("خطا در بررسی سقف روزانه: %w", err)
+	}
+
+	if used >= cost.DailyCap {
+		return fmt.Errorf("%w: %s (%d اعتبار در روز)", ErrFeatureCapReached, FeatureTitle(feature), cost.DailyCap)
+	}
+	return nil
+}
+
+// GetDailyBreakdown مصرف امروز کاربر به تفکیک قابلیت
+func (s *FeatureService) GetDailyBreakdown(userID uint) ([]FeatureUsage, error) {
+	var rows []database.DailyFeatureUsage
+	if err := database.DB.Where("user_id = ? AND date = ?", userID, DayStart(time.Now())).Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("خطا در دریافت مصرف قابلیت‌ها: %w", err)
+	}
+
+	byFeature := make(map[string]database.DailyFeatureUsage, len(rows))
+	for _, row := range rows {
+		byFeature[row.Feature] = row
+	}
+
+	breakdown := make([]FeatureUsage, 0, len(features))
+	for _, feature := range features {
+		row := byFeature[feature]
+		breakdown = append(breakdown, FeatureUsage{
+			Feature:  feature,
+			Title:    FeatureTitle(feature),
+			Requests: row.Requests,
+			Credits:  row.CreditsUsed,
+			DailyCap: s.GetFeatureCost(feature).DailyCap,
+		})
+	}
+	return breakdown, nil
+}
+
+// recordFeatureUsage افزایش اتمیک مصرف روزانه قابلیت؛ رکورد روز در صورت نبود ایجاد می‌شود
+func recordFeatureUsage(tx *gorm.DB, userID uint, feature string, credits int) error {
+	dateOnly := DayStart(time.Now())
+	increment := func() (int64, error) {
+		result := tx.Model(&database.DailyFeatureUsage{}).
+			Where("user_id = ? AND feature = ? AND date = ?", userID, feature, dateOnly).
+			Updates(map[string]interface{}{
+				"requests":     gorm.Expr("requests + 1"),
+				"credits_used": gorm.Expr("credits_used + ?", credits),
+			})
+		return result.RowsAffected, result.Error
+	}
+
+	updated, err := increment()
+	if err != nil {
+		return fmt.Errorf("خطا در ثبت مصرف قابلیت: %w", err)
+	}
+	if updated > 0 {
+		return nil
+	}
+
+	if err := tx.Create(&database.DailyFeatureUsage{
+		UserID:      userID,
+		Feature:     feature,
+		Date:        dateOnly,
+		Requests:    1,
+		CreditsUsed: credits,
+	}).Error; err != nil {
+		// درخواست هم‌زمان دیگری رکورد امروز را ایجاد کرده است
+		if _, err := increment(); err != nil {
+			return fmt.Errorf("خطا در ثبت مصرف قابلیت: %w", err)
+		}
+	}
+	return nil
+}
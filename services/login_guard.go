@@ -0,0 +1,352 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"telegram-bot/config"
+	"telegram-bot/database"
+	"telegram-bot/utils"
+)
+
+// مسیرهای ورود برای ثبت در سابقه تلاش‌ها
+const (
+	LoginFlowAPI   = "api"
+	LoginFlowOTP   = "otp"
+	LoginFlowBot   = "bot"
+	LoginFlowAdmin = "admin"
+)
+
+// دلیل ثبت تلاش ناموفق
+const (
+	LoginReasonInvalidCredentials = "invalid_credentials"
+	LoginReasonInvalidOTP         = "invalid_otp"
+	LoginReasonInvalidTOTP        = "invalid_totp"
+	LoginReasonBlocked            = "blocked" // تلاش در زمان قفل یا تاخیر؛ شمرده نمی‌شود
+)
+
+const (
+	// loginMaxDelay سقف تاخیر تدریجی بین تلاش‌ها
+	loginMaxDelay = 5 * time.Minute
+	// loginMaxLockout سقف مدت قفل پس از قفل‌های پیاپی
+	loginMaxLockout = 24 * time.Hour
+)
+
+var (
+	ErrLoginLocked     = errors.New("به دلیل تلاش‌های ناموفق زیاد، ورود موقتاً قفل شده است")
+	ErrLoginThrottled  = errors.New("تلاش‌های ناموفق زیاد؛ لطفاً کمی صبر کنید و دوباره تلاش کنید")
+	ErrLockoutNotFound = errors.New("قفل فعالی با این شناسه یافت نشد")
+)
+
+// LoginSubject مشخصات یک تلاش ورود؛ فیلدهای خالی شمرده نمی‌شوند
+type LoginSubject struct {
+	Flow     string
+	Phone    string
+	Username string // نام کاربری پنل مدیریت
+	IP       string
+	ChatID   int64
+}
+
+// LoginAttemptFilter فیلتر سابقه تلاش‌های ورود
+type LoginAttemptFilter struct {
+	Phone    string
+	Username string
+	IP       string
+	ChatID   int64
+	Limit    int
+}
+
+// LoginGuardService محافظت از ورود در برابر حدس زدن اطلاعات حساب
+//
+// خطاها برای هر شماره، نام کاربری، IP و چت جداگانه شمرده می‌شوند. پس از
+// LoginDelayAfter خطا، فاصله مجاز بین تلاش‌ها دو برابر می‌شود و با رسیدن به سقف،
+// کلید برای مدتی قفل و به ادمین‌ها اطلاع داده می‌شود.
+type LoginGuardService struct{}
+
+// keys کلیدهای شمارش خطای یک تلاش
+func (s LoginSubject) keys() []string {
+	var keys []string
+	if s.Phone != "" {
+		keys = append(keys, "phone:"+s.Phone)
+	}
+	if s.Username != "" {
+		keys = append(keys, "admin:"+strings.ToLower(s.Username))
+	}
+	if s.IP != "" {
+		keys = append(keys, "ip:"+s.IP)
+	}
+	if s.ChatID != 0 {
+		keys = append(keys, fmt.Sprintf("chat:%d", s.ChatID))
+	}
+	return keys
+}
+
+// accountKey کلید حساب هدف؛ فقط همین کلید با ورود موفق صفر می‌شود
+func (s LoginSubject) accountKey() string {
+	switch {
+	case s.Phone != "":
+		return "phone:" + s.Phone
+	case s.Username != "":
+		return "admin:" + strings.ToLower(s.Username)
+	}
+	return ""
+}
+
+// Check بررسی قفل یا تاخیر پیش از تلاش ورود و مدت انتظار لازم
+func (s *LoginGuardService) Check(subject LoginSubject) (time.Duration, error) {
+	keys := subject.keys()
+	if len(keys) == 0 {
+		return 0, nil
+	}
+
+	var rows []database.LoginLockout
+	if err := database.DB.Where("lock_key IN ?", keys).Find(&rows).Error; err != nil {
+		return 0, fmt.Errorf("خطا در بررسی قفل ورود: %w", err)
+	}
+
+	now := time.Now()
+	var lockWait, delayWait time.Duration
+	for i := range rows {
+		if wait := rows[i].LockedUntil.Sub(now); wait > lockWait {
+			lockWait = wait
+		}
+		if wait := loginDelay(&rows[i], now); wait > delayWait {
+			delayWait = wait
+		}
+	}
+
+	switch {
+	case lockWait > 0:
+		s.record(subject, 0, false, LoginReasonBlocked)
+		return lockWait, ErrLoginLocked
+	case delayWait > 0:
+		s.record(subject, 0, false, LoginReasonBlocked)
+		return delayWait, ErrLoginThrottled
+	}
+	return 0, nil
+}
+
+// RecordFailure ثبت تلاش ناموفق، افزایش شمارنده‌ها و قفل کلیدهایی که به سقف رسیده‌اند
+func (s *LoginGuardService) RecordFailure(subject LoginSubject, reason string) {
+	s.record(subject, 0, false, reason)
+
+	now := time.Now()
+	window := time.Duration(config.AppConfig.LoginFailureWindowMinutes) * time.Minute
+	var locked []database.LoginLockout
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		for _, key := range subject.keys() {
+			row, err := countFailure(tx, key, now, window)
+			if err != nil {
+				return err
+			}
+
+			maxFailures := loginMaxFailures(key)
+			if row.Failures < maxFailures {
+				continue
+			}
+
+			// قفل شرطی تا از میان خطاهای هم‌زمان فقط یکی قفل کند و هشدار بفرستد
+			lockedUntil := now.Add(lockoutDuration(row.LockCount + 1))
+			result := tx.Model(&database.LoginLockout{}).
+				Where("id = ? AND failures >= ?", row.ID, maxFailures).
+				Updates(map[string]interface{}{
+					"lock_count":   gorm.Expr("lock_count + 1"),
+					"locked_until": lockedUntil,
+					"failures":     0,
+				})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected > 0 {
+				row.LockCount++
+				row.LockedUntil = lockedUntil
+				row.Failures = 0
+				locked = append(locked, *row)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		utils.LogError("LoginGuard", "خطا در ثبت خطای ورود", err)
+		return
+	}
+
+	for _, row := range locked {
+		s.alertLockout(subject, &row)
+	}
+}
+
+// countFailure افزایش اتمیک شمارنده خطای کلید؛ ردیف کلید در صورت نبود ایجاد می‌شود
+//
+// شمارنده‌ای که آخرین خطایش بیرون از بازه شمارش است از یک شروع می‌شود.
+func countFailure(tx *gorm.DB, key string, now time.Time, window time.Duration) (*database.LoginLockout, error) {
+	row := &database.LoginLockout{LockKey: key, Failures: 1, LastFailureAt: now, UpdatedAt: now}
+	if err := tx.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "lock_key"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"failures":        gorm.Expr("CASE WHEN last_failure_at < ? THEN 1 ELSE failures + 1 END", now.Add(-window)),
+			"last_failure_at": now,
+			"updated_at":      now,
+		}),
+	}).Create(row).Error; err != nil {
+		return nil, err
+	}
+
+	if err := tx.Where("lock_key = ?", key).First(row).Error; err != nil {
+		return nil, err
+	}
+	return row, nil
+}
+
+// RecordSuccess ثبت ورود موفق و صفر کردن شمارنده حساب
+//
+// شمارنده IP و چت صفر نمی‌شود تا مهاجم نتواند با ورود به حساب خودش محدودیت را دور بزند.
+func (s *LoginGuardService) RecordSuccess(subject LoginSubject, userID uint) {
+	s.record(subject, userID, true, "")
+
+	if key := subject.accountKey(); key != "" {
+		if err := database.DB.Model(&database.LoginLockout{}).
+			Where("lock_key = ? AND locked_until < ?", key, time.Now()).
+			Updates(map[string]interface{}{"failures": 0, "updated_at": time.Now()}).Error; err != nil {
+			utils.LogError("LoginGuard", "خطا در صفر کردن شمارنده ورود", err)
+		}
+	}
+}
+
+// GetLockouts کلیدهای قفل‌شده یا دارای خطای اخیر
+func (s *LoginGuardService) GetLockouts() ([]database.LoginLockout, error) {
+	now := time.Now()
+	since := now.Add(-time.Duration(config.AppConfig.LoginFailureWindowMinutes) * time.Minute)
+
+	var rows []database.LoginLockout
+	if err := database.DB.Where("locked_until > ? OR (failures > 0 AND last_failure_at > ?)", now, since).
+		Order("updated_at DESC").Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("خطا در دریافت قفل‌ها: %w", err)
+	}
+	return rows, nil
+}
+
+// Unlock برداشتن قفل توسط ادمین؛ سابقه قفل‌ها برای مدت قفل بعدی حفظ می‌شود
+func (s *LoginGuardService) Unlock(lockoutID uint, unlockedBy string) error {
+	result := database.DB.Model(&database.LoginLockout{}).
+		Where("id = ? AND (locked_until > ? OR failures > 0)", lockoutID, time.Now()).
+		Updates(map[string]interface{}{
+			"locked_until": time.Time{},
+			"failures":     0,
+			"unlocked_by":  unlockedBy,
+			"updated_at":   time.Now(),
+		})
+	if result.Error != nil {
+		return fmt.Errorf("خطا در برداشتن قفل: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrLockoutNotFound
+	}
+
+	utils.LogInfo("LoginGuard", fmt.Sprintf("قفل ورود %d توسط %s برداشته شد", lockoutID, unlockedBy))
+	return nil
+}
+
+// GetAttempts سابقه تلاش‌های ورود برای بررسی تلاش‌های تصاحب حساب
+func (s *LoginGuardService) GetAttempts(filter LoginAttemptFilter) ([]database.LoginAttempt, error) {
+	query := database.DB.Model(&database.LoginAttempt{})
+	if filter.Phone != "" {
+		query = query.Where("phone = ?", filter.Phone)
+	}
+	if filter.Username != "" {
+		query = query.Where("username = ?", filter.Username)
+	}
+	if filter.IP != "" {
+		query = query.Where("ip = ?", filter.IP)
+	}
+	if filter.ChatID != 0 {
+		query = query.Where("chat_id = ?", filter.ChatID)
+	}
+
+	limit := filter.Limit
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+
+	var attempts []database.LoginAttempt
+	if err := query.Order("id DESC").Limit(limit).Find(&attempts).Error; err != nil {
+		return nil, fmt.Errorf("خطا در دریافت سابقه ورود: %w", err)
+	}
+	return attempts, nil
+}
+
+// record ثبت یک تلاش در سابقه
+func (s *LoginGuardService) record(subject LoginSubject, userID uint, success bool, reason string) {
+	attempt := &database.LoginAttempt{
+		Flow:      subject.Flow,
+		Phone:     subject.Phone,
+		Username:  subject.Username,
+		IP:        subject.IP,
+		ChatID:    subject.ChatID,
+		UserID:    userID,
+		Success:   success,
+		Reason:    reason,
+		CreatedAt: time.Now(),
+	}
+	if err := database.DB.Create(attempt).Error; err != nil {
+		utils.LogError("LoginGuard", "خطا در ثبت تلاش ورود", err)
+	}
+}
+
+// alertLockout اطلاع به ادمین‌ها هنگام قفل شدن یک کلید
+func (s *LoginGuardService) alertLockout(subject LoginSubject, row *database.LoginLockout) {
+	utils.LogInfo("LoginGuard", fmt.Sprintf("%s تا %s قفل شد", row.LockKey, row.LockedUntil.Format("15:04:05")))
+
+	notifyAdmins(fmt.Sprintf(
+		"🚨 <b>قفل ورود به دلیل تلاش‌های ناموفق</b>\n\n"+
+			"کلید: <code>%s</code>\n"+
+			"مسیر: %s\n"+
+			"IP: %s\n"+
+			"چت: %d\n"+
+			"قفل تا: %s (بار %d)\n\n"+
+			"شناسه قفل برای برداشتن: %d",
+		html.EscapeString(row.LockKey), subject.Flow, html.EscapeString(subject.IP), subject.ChatID,
+		row.LockedUntil.In(config.AppConfig.Location).Format("2006-01-02 15:04"), row.LockCount, row.ID,
+	))
+}
+
+// loginMaxFailures سقف خطا برای نوع کلید
+func loginMaxFailures(key string) int {
+	if strings.HasPrefix(key, "ip:") {
+		return config.AppConfig.LoginIPMaxFailures
+	}
+	return config.AppConfig.LoginMaxFailures
+}
+
+// loginDelay مدت انتظار باقی‌مانده تا تلاش بعدی؛ با هر خطا پس از LoginDelayAfter دو برابر می‌شود
+func loginDelay(row *database.LoginLockout, now time.Time) time.Duration {
+	window := time.Duration(config.AppConfig.LoginFailureWindowMinutes) * time.Minute
+	extra := row.Failures - config.AppConfig.LoginDelayAfter
+	if extra < 0 || now.Sub(row.LastFailureAt) > window {
+		return 0
+	}
+
+	delay := loginMaxDelay
+	if extra < 8 && time.Second<<(extra+1) < loginMaxDelay {
+		delay = time.Second << (extra + 1)
+	}
+	return row.LastFailureAt.Add(delay).Sub(now)
+}
+
+// lockoutDuration مدت قفل؛ هر قفل پیاپی دو برابر قبلی تا سقف یک روز
+func lockoutDuration(lockCount int) time.Duration {
+	duration := time.Duration(config.AppConfig.LoginLockoutMinutes) * time.Minute
+	for i := 1; i < lockCount && duration < loginMaxLockout; i++ {
+		duration *= 2
+	}
+	if duration > loginMaxLockout {
+		duration = loginMaxLockout
+	}
+	return duration
+}
@@ -0,0 +1,66 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"telegram-bot/config"
+	"telegram-bot/database"
+)
+
+func TestLoginGuardLockoutAndUnlock(t *testing.T) {
+	setupTestDB(t)
+	config.AppConfig.LoginMaxFailures = 3
+	config.AppConfig.LoginIPMaxFailures = 20
+	config.AppConfig.LoginDelayAfter = 10
+	config.AppConfig.LoginLockoutMinutes = 15
+	config.AppConfig.LoginFailureWindowMinutes = 30
+
+	guard := &LoginGuardService{}
+	subject := LoginSubject{Flow: LoginFlowAPI, Phone: "09120000001", IP: "10.0.0.1"}
+
+	lockFor := func(want time.Duration) uint {
+		t.Helper()
+		for i := 0; i < config.AppConfig.LoginMaxFailures; i++ {
+			if _, err := guard.Check(subject); err != nil {
+				t.Fatalf("Check before failure %d: %v", i+1, err)
+			}
+			guard.RecordFailure(subject, LoginReasonInvalidCredentials)
+		}
+
+		wait, err := guard.Check(subject)
+		if !errors.Is(err, ErrLoginLocked) {
+			t.Fatalf("Check = %v, want ErrLoginLocked", err)
+		}
+		if wait <= want-time.Minute || wait > want {
+			t.Errorf("lock wait = %v, want about %v", wait, want)
+		}
+
+		var row database.LoginLockout
+		if err := database.DB.Where("lock_key = ?", "phone:"+subject.Phone).First(&row).Error; err != nil {
+			t.Fatalf("lockout row: %v", err)
+		}
+		return row.ID
+	}
+
+	id := lockFor(15 * time.Minute)
+
+	// قفل IP هنوز به سقف خودش نرسیده است
+	if _, err := guard.Check(LoginSubject{Flow: LoginFlowAPI, IP: subject.IP}); err != nil {
+		t.Errorf("IP check = %v, want nil", err)
+	}
+
+	if err := guard.Unlock(id, "admin"); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+	if _, err := guard.Check(subject); err != nil {
+		t.Fatalf("Check after unlock = %v, want nil", err)
+	}
+	if err := guard.Unlock(id, "admin"); !errors.Is(err, ErrLockoutNotFound) {
+		t.Errorf("second Unlock = %v, want ErrLockoutNotFound", err)
+	}
+
+	// قفل بعدی دو برابر طول می‌کشد
+	lockFor(30 * time.Minute)
+}
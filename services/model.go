@@ -0,0 +1,153 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"telegram-bot/config"
+	"telegram-bot/database"
+)
+
+// کلیدهای تنظیمات انتخاب مدل
+const (
+	SettingAIModel          = "ai_model"           // مدل پیش‌فرض همه کاربران
+	SettingAIModelUnlimited = "ai_model_unlimited" // مدل کاربران دارای توکن نامحدود
+	SettingAIModelStaff     = "ai_model_staff"     // مدل ادمین‌ها و پشتیبان‌ها
+)
+
+var settingService = &SettingService{}
+
+// ErrModelNotAllowed مدل backend انتخاب‌شده در پلن کاربر مجاز نیست
+var ErrModelNotAllowed = errors.New("مدل این ارائه‌دهنده در پلن شما مجاز نیست")
+
+// ResolveModel تعیین مدل کاربر؛ رشته خالی یعنی مدل پیش‌فرض ارائه‌دهنده
+func (s *AIService) ResolveModel(userID uint) string {
+	// تنظیمات هر بار از دیتابیس خوانده می‌شوند تا تغییرات ادمین بدون ری‌استارت اعمال شوند
+	var user database.User
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		return settingService.GetSetting(SettingAIModel, "")
+	}
+
+	model := s.configuredModel(&user)
+
+	// مدلی که در پلن کاربر مجاز نیست با اولین مدل مجاز پلن جایگزین می‌شود؛ مدل پیش‌فرض
+	// ارائه‌دهنده هم در پلن محدود مجاز نیست چون معلوم نیست کدام مدل است
+	plan, err := userPlan(database.DB, &user)
+	if err != nil {
+		return model
+	}
+	if allowed := planModels(plan); len(allowed) > 0 && (model == "" || !AllowsModel(plan, model)) {
+		return allowed[0]
+	}
+	return model
+}
+
+// configuredModel مدل کاربر بر اساس تنظیمات اختصاصی، نقش و تنظیمات عمومی
+func (s *AIService) configuredModel(user *database.User) string {
+	if user.AIModel != "" {
+		return user.AIModel
+	}
+
+	if user.IsAdmin || user.IsSupport {
+		if model := settingService.GetSetting(SettingAIModelStaff, ""); model != "" {
+			return model
+		}
+	}
+
+	if user.UnlimitedTokens {
+		if model := settingService.GetSetting(SettingAIModelUnlimited, ""); model != "" {
+			return model
+		}
+	}
+
+	return settingService.GetSetting(SettingAIModel, "")
+}
+
+// modelForRequest تعیین مدل نهایی یک درخواست؛ explicit یعنی ارائه‌دهنده صریحاً انتخاب شده
+//
+// مدل نهایی، حتی اگر مدل پیش‌فرض backend باشد، با پلن کاربر سنجیده می‌شود تا انتخاب
+// ارائه‌دهنده یا failover راهی برای رسیدن به مدلی خارج از پلن نباشد.
+func (s *AIService) modelForRequest(userID uint, provider AIProvider, opts QueryOptions, explicit bool) (string, error) {
+	// مدل‌های درخواست و تنظیمات برای backend اصلی تعریف شده‌اند، نه جایگزین‌های failover
+	primary := isPrimaryBackend(provider)
+
+	model := ""
+	switch {
+	case opts.Model != "" && (explicit || primary):
+		model = opts.Model
+	case primary:
+		model = s.ResolveModel(userID)
+	}
+
+	// مدل ارائه‌دهنده دیگر (مثلاً gpt-* برای backend از نوع anthropic) به مدل پیش‌فرض backend برمی‌گردد
+	kind := backendProvider(provider.Name())
+	if !modelFitsProvider(kind, model) {
+		log.Printf("⚠️  مدل %s با backend %s (%s) سازگار نیست؛ مدل پیش‌فرض backend استفاده می‌شود", model, provider.Name(), kind)
+		model = ""
+	}
+
+	var user database.User
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		return model, nil
+	}
+	plan, err := userPlan(database.DB, &user)
+	if err != nil {
+		return model, nil
+	}
+
+	effective := model
+	if effective == "" {
+		effective = backendModel(provider.Name())
+	}
+	if len(planModels(plan)) > 0 && (effective == "" || !AllowsModel(plan, effective)) {
+		return "", fmt.Errorf("%w: %s", ErrModelNotAllowed, provider.Name())
+	}
+	return model, nil
+}
+
+// providerModelPrefixes پیشوند نام مدل‌های هر نوع ارائه‌دهنده؛ مدل‌های Ollama نام ثابتی ندارند
+var providerModelPrefixes = map[string][]string{
+	"openai":    {"gpt-", "chatgpt-", "o1", "o3", "o4"},
+	"anthropic": {"claude-"},
+}
+
+// modelFitsProvider آیا مدل می‌تواند به ارائه‌دهنده‌ای از نوع kind فرستاده شود
+//
+// فقط مدلی رد می‌شود که نامش آشکارا به نوع دیگری تعلق دارد؛ نام‌های ناشناخته پذیرفته می‌شوند.
+func modelFitsProvider(kind, model string) bool {
+	if model == "" {
+		return true
+	}
+
+	for owner, prefixes := range providerModelPrefixes {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(model, prefix) {
+				return owner == kind
+			}
+		}
+	}
+	return true
+}
+
+// backendModel مدل پیش‌فرض یک backend؛ نام ناشناخته یعنی تنظیمات پیش‌فرض همان نوع
+func backendModel(name string) string {
+	for _, backend := range config.AppConfig.AIBackends {
+		if backend.Name == name {
+			return backend.Model
+		}
+	}
+	_, _, model := config.AppConfig.ProviderDefaults(name)
+	return model
+}
+
+// backendProvider نوع ارائه‌دهنده یک backend؛ نام ناشناخته خودش نوع ارائه‌دهنده است
+func backendProvider(name string) string {
+	for _, backend := range config.AppConfig.AIBackends {
+		if backend.Name == name {
+			return backend.Provider
+		}
+	}
+	return name
+}
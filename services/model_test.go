@@ -0,0 +1,62 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"telegram-bot/config"
+	"telegram-bot/database"
+)
+
+func TestModelForRequestChecksBackendDefault(t *testing.T) {
+	setupTestDB(t)
+	config.AppConfig.AIBackends = []config.AIBackend{
+		{Name: "main", Provider: "openai", Model: "gpt-4o-mini"},
+		{Name: "claude", Provider: "anthropic", Model: "claude-3-opus"},
+	}
+
+	plan := &database.Plan{Name: "limited", Title: "محدود", DailyTokens: 10, AllowedModels: "gpt-4o-mini"}
+	if err := database.DB.Create(plan).Error; err != nil {
+		t.Fatalf("create plan: %v", err)
+	}
+	user := createTestUser(t, 10, 0)
+	if err := database.DB.Model(user).Update("plan_id", plan.ID).Error; err != nil {
+		t.Fatalf("set plan: %v", err)
+	}
+
+	ai := &AIService{}
+	tests := []struct {
+		name     string
+		backend  config.AIBackend
+		explicit bool
+		want     string
+		wantErr  bool
+	}{
+		{name: "primary", backend: config.AppConfig.AIBackends[0], want: "gpt-4o-mini"},
+		{name: "failover default outside plan", backend: config.AppConfig.AIBackends[1], wantErr: true},
+		{name: "explicit default outside plan", backend: config.AppConfig.AIBackends[1], explicit: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider, err := NewAIProviderFromBackend(tt.backend)
+			if err != nil {
+				t.Fatalf("NewAIProviderFromBackend: %v", err)
+			}
+
+			model, err := ai.modelForRequest(user.ID, provider, QueryOptions{}, tt.explicit)
+			if tt.wantErr {
+				if !errors.Is(err, ErrModelNotAllowed) {
+					t.Fatalf("err = %v, want ErrModelNotAllowed", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("modelForRequest: %v", err)
+			}
+			if model != tt.want {
+				t.Errorf("model = %q, want %q", model, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"telegram-bot/config"
+	"telegram-bot/database"
+	"telegram-bot/utils"
+)
+
+// settingLastSpikeAlert روزی که آخرین هشدار جهش مصرف برای آن ارسال شد
+const settingLastSpikeAlert = "last_usage_spike_alert"
+
+// NotificationService اعلان موجودی کم، خلاصه ریست روزانه و هشدار جهش مصرف
+type NotificationService struct{}
+
+var notificationService = &NotificationService{}
+
+// NotifyBalanceDrop اطلاع به کاربر وقتی موجودی از یکی از آستانه‌ها پایین‌تر می‌رود
+//
+// فقط پایین‌ترین آستانه‌ای که در این تغییر رد شده اعلام می‌شود؛ چون مقایسه با موجودی
+// قبل و بعد انجام می‌شود، برای هر عبور فقط یک پیام ارسال می‌شود.
+func (s *NotificationService) NotifyBalanceDrop(userID uint, before, after int) {
+	if after >= before {
+		return
+	}
+
+	if after <= 0 {
+		notifyUser(userID,
+			"⛔️ <b>توکن‌های شما تمام شد.</b>\n\n"+
+				"سهمیه روزانه در نیمه‌شب شارژ می‌شود؛ برای ادامه همین حالا می‌توانید از منوی اصلی توکن بخرید.")
+		return
+	}
+
+	crossed := 0
+	for _, threshold := range config.AppConfig.LowBalanceThresholds {
+		if before >= threshold && after < threshold {
+			crossed = threshold
+		}
+	}
+	if crossed == 0 {
+		return
+	}
+
+	notifyUser(userID, fmt.Sprintf(
+		"⚠️ <b>موجودی توکن شما کم است</b>\n\n"+
+			"موجودی فعلی: <b>%d</b> توکن (کمتر از %d)\n"+
+			"برای جلوگیری از قطع شدن گفتگو، از منوی اصلی توکن بخرید.",
+		after, crossed,
+	))
+}
+
+// SendResetDigest ارسال خلاصه مصرف روز قبل و موجودی جدید پس از ریست روزانه
+func (s *NotificationService) SendResetDigest(userID uint, period time.Time) {
+	if !config.AppConfig.ResetDigestEnabled {
+		return
+	}
+
+	var user database.User
+	if err := database.DB.First(&user, userID).Error; err != nil || user.TelegramID == 0 {
+		return
+	}
+
+	var used int
+	database.DB.Model(&database.DailyTokenUsage{}).
+		Where("user_id = ? AND date = ?", userID, DayStart(period.AddDate(0, 0, -1))).
+		Select("COALESCE(SUM(tokens_used), 0)").
+		Scan(&used)
+
+	notifyUser(userID, fmt.Sprintf(
+		"🌅 <b>سهمیه امروز شما شارژ شد</b>\n\n"+
+			"سهمیه روزانه: <b>%d</b>\n"+
+			"توکن خریداری‌شده: <b>%d</b>\n"+
+			"مصرف دیروز: %d",
+		user.DailyTokens, user.PurchasedTokens, used,
+	))
+}
+
+// CheckUsageSpike مقایسه مصرف امروز با میانگین روزهای قبل و هشدار به ادمین‌ها
+//
+// برای هر روز حداکثر یک هشدار ارسال می‌شود. اگر هنوز داده‌ای از روزهای قبل وجود
+// نداشته باشد، مبنایی برای مقایسه نیست و هشداری ارسال نمی‌شود.
+func (s *NotificationService) CheckUsageSpike() (bool, error) {
+	cfg := config.AppConfig
+	if cfg.UsageSpikeMultiplier <= 0 || cfg.UsageSpikeBaselineDays <= 0 {
+		return false, nil
+	}
+
+	today := DayStart(time.Now())
+	if settingService.GetSetting(settingLastSpikeAlert, "") == today.Format("2006-01-02") {
+		return false, nil
+	}
+
+	var todayUsage int
+	if err := database.DB.Model(&database.DailyTokenUsage{}).
+		Where("date = ?", today).
+		Select("COALESCE(SUM(tokens_used), 0)").
+		Scan(&todayUsage).Error; err != nil {
+		return false, fmt.Errorf("خطا در محاسبه مصرف امروز: %w", err)
+	}
+	if todayUsage < cfg.UsageSpikeMinTokens {
+		return false, nil
+	}
+
+	var baseline struct {
+		Total int
+		Days  int
+	}
+	if err := database.DB.Model(&database.DailyTokenUsage{}).
+		Where("date >= ? AND date < ?", DayStart(today.AddDate(0, 0, -cfg.UsageSpikeBaselineDays)), today).
+		Select("COALESCE(SUM(tokens_used), 0) AS total, COUNT(DISTINCT date) AS days").
+		Scan(&baseline).Error; err != nil {
+		return false, fmt.Errorf("خطا در محاسبه میانگین مصرف: %w", err)
+	}
+	if baseline.Days == 0 || baseline.Total == 0 {
+		return false, nil
+	}
+
+	average := float64(baseline.Total) / float64(baseline.Days)
+	if float64(todayUsage) <= average*cfg.UsageSpikeMultiplier {
+		return false, nil
+	}
+
+	notifyAdmins(fmt.Sprintf(
+		"📈 <b>جهش مصرف توکن</b>\n\n"+
+			"مصرف امروز تاکنون: <b>%d</b>\n"+
+			"میانگین %d روز گذشته: %.0f\n"+
+			"ضریب: %.1f برابر",
+		todayUsage, baseline.Days, average, float64(todayUsage)/average,
+	))
+
+	if err := settingService.SetSetting(settingLastSpikeAlert, today.Format("2006-01-02")); err != nil {
+		return true, err
+	}
+	return true, nil
+}
+
+// notifyAdmins ارسال اعلان به همه ادمین‌هایی که تلگرام متصل دارند
+func notifyAdmins(text string) {
+	var admins []database.User
+	if err := database.DB.Where("is_admin = ? AND telegram_id <> 0", true).Find(&admins).Error; err != nil {
+		utils.LogError("Notifier", "خطا در دریافت ادمین‌ها", err)
+		return
+	}
+
+	for _, admin := range admins {
+		notifyUser(admin.ID, text)
+	}
+}
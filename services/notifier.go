@@ -0,0 +1,34 @@
+package services
+
+import (
+	"fmt"
+
+	"telegram-bot/database"
+	"telegram-bot/utils"
+)
+
+// Notifier ارسال پیام به یک چت تلگرام؛ در main به ربات متصل می‌شود
+type Notifier func(chatID int64, text string) error
+
+var notifier Notifier
+
+// SetNotifier تنظیم مسیر ارسال اعلان‌ها
+func SetNotifier(n Notifier) {
+	notifier = n
+}
+
+// notifyUser ارسال اعلان به کاربر؛ کاربری که تلگرام متصل ندارد نادیده گرفته می‌شود
+func notifyUser(userID uint, text string) {
+	if notifier == nil {
+		return
+	}
+
+	var user database.User
+	if err := database.DB.Select("id", "telegram_id").First(&user, userID).Error; err != nil || user.TelegramID == 0 {
+		return
+	}
+
+	if err := notifier(user.TelegramID, text); err != nil {
+		utils.LogError("Notifier", fmt.Sprintf("خطا در ارسال اعلان به کاربر %d", userID), err)
+	}
+}
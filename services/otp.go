@@ -0,0 +1,217 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"gorm.io/gorm"
+	"telegram-bot/config"
+	"telegram-bot/database"
+	"telegram-bot/utils"
+)
+
+// کانال‌های ارسال کد یک‌بارمصرف
+const (
+	OTPChannelTelegram = "telegram"
+	OTPChannelSMS      = "sms"
+)
+
+// otpDigits تعداد ارقام کد
+const otpDigits = 6
+
+var (
+	ErrOTPInvalid         = errors.New("کد تایید نادرست است")
+	ErrOTPExpired         = errors.New("کد تایید منقضی شده است؛ دوباره وارد شوید")
+	ErrOTPTooManyAttempts = errors.New("تعداد تلاش‌ها بیش از حد مجاز است؛ دوباره وارد شوید")
+	ErrOTPResendTooSoon   = errors.New("کد قبلی به‌تازگی ارسال شده است")
+)
+
+// OTPService ارسال و بررسی کد یک‌بارمصرف ورود
+type OTPService struct{}
+
+// OTPChallenge کد ارسال‌شده‌ای که منتظر تایید است
+type OTPChallenge struct {
+	ChallengeID string `json:"challenge_id"`
+	Channel     string `json:"channel"`
+	Destination string `json:"destination"` // مقصد ماسک‌شده برای نمایش
+	ExpiresIn   int    `json:"expires_in"`  // ثانیه
+}
+
+// ResendWait مدت انتظار تا امکان ارسال دوباره کد برای کاربر
+func (s *OTPService) ResendWait(userID uint) time.Duration {
+	var last database.OTPCode
+	result := database.DB.Where("user_id = ?", userID).Order("id DESC").Limit(1).Find(&last)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return 0
+	}
+
+	wait := time.Until(last.CreatedAt.Add(time.Duration(config.AppConfig.OTPResendSeconds) * time.Second))
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// SendLoginCode ایجاد کد ورود و ارسال آن به تلگرام تاییدشده کاربر یا با پیامک
+//
+// کدهای قبلی کاربر با ارسال کد جدید باطل می‌شوند. اگر ارسال تلگرام شکست بخورد،
+// کد با پیامک فرستاده می‌شود.
+func (s *OTPService) SendLoginCode(user *database.User) (*OTPChallenge, error) {
+	if s.ResendWait(user.ID) > 0 {
+		return nil, ErrOTPResendTooSoon
+	}
+
+	code, err := generateOTP()
+	if err != nil {
+		return nil, fmt.Errorf("خطا در تولید کد: %w", err)
+	}
+	challengeID, err := utils.GenerateSecureToken(16)
+	if err != nil {
+		return nil, fmt.Errorf("خطا در تولید کد: %w", err)
+	}
+
+	if err := database.DB.Model(&database.OTPCode{}).
+		Where("user_id = ? AND used = ?", user.ID, false).
+		Update("used", true).Error; err != nil {
+		return nil, fmt.Errorf("خطا در ابطال کدهای قبلی: %w", err)
+	}
+
+	ttl := time.Duration(config.AppConfig.OTPTTLSeconds) * time.Second
+	text := fmt.Sprintf("کد ورود شما: %s\nاین کد تا %d دقیقه معتبر است. آن را در اختیار کسی قرار ندهید.", code, int(ttl.Minutes()))
+
+	channel, destination, err := deliverOTP(user, text)
+	if err != nil {
+		return nil, err
+	}
+
+	otp := &database.OTPCode{
+		ChallengeID: challengeID,
+		UserID:      user.ID,
+		CodeHash:    hashOTP(challengeID, code),
+		Channel:     channel,
+		ExpiresAt:   time.Now().Add(ttl),
+		CreatedAt:   time.Now(),
+	}
+	if err := database.DB.Create(otp).Error; err != nil {
+		return nil, fmt.Errorf("خطا در ثبت کد: %w", err)
+	}
+
+	return &OTPChallenge{
+		ChallengeID: challengeID,
+		Channel:     channel,
+		Destination: destination,
+		ExpiresIn:   int(ttl.Seconds()),
+	}, nil
+}
+
+// VerifyLoginCode بررسی کد و برگرداندن شناسه کاربر؛ هر کد فقط یک بار پذیرفته می‌شود
+func (s *OTPService) VerifyLoginCode(challengeID, code string) (uint, error) {
+	var otp database.OTPCode
+	result := database.DB.Where("challenge_id = ?", challengeID).Limit(1).Find(&otp)
+	if result.Error != nil {
+		return 0, fmt.Errorf("خطا در بررسی کد: %w", result.Error)
+	}
+	if result.RowsAffected == 0 || otp.Used {
+		return 0, ErrOTPInvalid
+	}
+	if time.Now().After(otp.ExpiresAt) {
+		return 0, ErrOTPExpired
+	}
+
+	// شمارش تلاش به‌صورت شرطی تا درخواست‌های هم‌زمان از سقف عبور نکنند
+	counted := database.DB.Model(&database.OTPCode{}).
+		Where("id = ? AND used = ? AND attempts < ?", otp.ID, false, config.AppConfig.OTPMaxAttempts).
+		Update("attempts", gorm.Expr("attempts + 1"))
+	if counted.Error != nil {
+		return 0, fmt.Errorf("خطا در بررسی کد: %w", counted.Error)
+	}
+	if counted.RowsAffected == 0 {
+		return 0, ErrOTPTooManyAttempts
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashOTP(challengeID, code)), []byte(otp.CodeHash)) != 1 {
+		if otp.Attempts+1 >= config.AppConfig.OTPMaxAttempts {
+			return 0, ErrOTPTooManyAttempts
+		}
+		return 0, ErrOTPInvalid
+	}
+
+	used := database.DB.Model(&database.OTPCode{}).
+		Where("id = ? AND used = ?", otp.ID, false).
+		Update("used", true)
+	if used.Error != nil {
+		return 0, fmt.Errorf("خطا در ثبت کد: %w", used.Error)
+	}
+	if used.RowsAffected == 0 {
+		return 0, ErrOTPInvalid
+	}
+
+	return otp.UserID, nil
+}
+
+// PurgeExpiredCodes حذف کدهای منقضی
+func (s *OTPService) PurgeExpiredCodes() (int64, error) {
+	result := database.DB.Where("expires_at < ?", time.Now().Local()).Delete(&database.OTPCode{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("خطا در حذف کدهای منقضی: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+// deliverOTP ارسال کد؛ اولویت با تلگرام متصل کاربر است
+//
+// چتی که اتصالش با کد تایید نشده مقصد قابل اعتماد نیست و کد با پیامک فرستاده می‌شود.
+func deliverOTP(user *database.User, text string) (string, string, error) {
+	if user.TelegramID != 0 && user.TelegramVerified && notifier != nil {
+		err := notifier(user.TelegramID, "🔐 "+text)
+		if err == nil {
+			return OTPChannelTelegram, "تلگرام", nil
+		}
+		utils.LogError("OTPService", fmt.Sprintf("ارسال کد به تلگرام کاربر %d ناموفق بود", user.ID), err)
+	}
+
+	sender, err := GetSMSSender()
+	if err != nil {
+		return "", "", err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := sender.Send(ctx, user.PhoneNumber, text); err != nil {
+		return "", "", fmt.Errorf("خطا در ارسال پیامک: %w", err)
+	}
+	return OTPChannelSMS, maskPhone(user.PhoneNumber), nil
+}
+
+// generateOTP تولید کد عددی تصادفی
+func generateOTP() (string, error) {
+	max := big.NewInt(1)
+	for i := 0; i < otpDigits; i++ {
+		max.Mul(max, big.NewInt(10))
+	}
+
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", otpDigits, n.Int64()), nil
+}
+
+// hashOTP هش کد همراه با شناسه چالش
+func hashOTP(challengeID, code string) string {
+	return utils.HashToken(challengeID + ":" + code)
+}
+
+// maskPhone پنهان کردن ارقام میانی شماره؛ مثال: 0912***6789
+func maskPhone(phone string) string {
+	if len(phone) < 8 {
+		return phone
+	}
+	return phone[:4] + "***" + phone[len(phone)-4:]
+}
@@ -0,0 +1,61 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"telegram-bot/database"
+)
+
+// createTestOTP ثبت کد ورود مشخص برای کاربر بدون ارسال آن
+func createTestOTP(t *testing.T, userID uint, challengeID, code string) {
+	t.Helper()
+
+	if err := database.DB.Create(&database.OTPCode{
+		ChallengeID: challengeID,
+		UserID:      userID,
+		CodeHash:    hashOTP(challengeID, code),
+		Channel:     OTPChannelSMS,
+		ExpiresAt:   time.Now().Add(5 * time.Minute),
+		CreatedAt:   time.Now(),
+	}).Error; err != nil {
+		t.Fatalf("create otp: %v", err)
+	}
+}
+
+func TestVerifyLoginCodeAcceptsOnce(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, 30, 0)
+	createTestOTP(t, user.ID, "challenge", "123456")
+
+	otp := &OTPService{}
+	userID, err := otp.VerifyLoginCode("challenge", "123456")
+	if err != nil || userID != user.ID {
+		t.Fatalf("VerifyLoginCode = %d, %v; want %d, nil", userID, err, user.ID)
+	}
+	if _, err := otp.VerifyLoginCode("challenge", "123456"); !errors.Is(err, ErrOTPInvalid) {
+		t.Fatalf("reused code: err=%v, want ErrOTPInvalid", err)
+	}
+}
+
+func TestVerifyLoginCodeMaxAttempts(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, 30, 0)
+	createTestOTP(t, user.ID, "challenge", "123456")
+
+	otp := &OTPService{}
+	for i := 1; i < 3; i++ {
+		if _, err := otp.VerifyLoginCode("challenge", "000000"); !errors.Is(err, ErrOTPInvalid) {
+			t.Fatalf("attempt %d: err=%v, want ErrOTPInvalid", i, err)
+		}
+	}
+	if _, err := otp.VerifyLoginCode("challenge", "000000"); !errors.Is(err, ErrOTPTooManyAttempts) {
+		t.Fatalf("last attempt: err=%v, want ErrOTPTooManyAttempts", err)
+	}
+
+	// پس از پر شدن سقف، کد درست هم پذیرفته نمی‌شود
+	if _, err := otp.VerifyLoginCode("challenge", "123456"); !errors.Is(err, ErrOTPTooManyAttempts) {
+		t.Fatalf("correct code after limit: err=%v, want ErrOTPTooManyAttempts", err)
+	}
+}
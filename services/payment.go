@@ -0,0 +1,167 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"telegram-bot/config"
+	"telegram-bot/database"
+	"telegram-bot/utils"
+)
+
+// وضعیت‌های پرداخت
+const (
+	PaymentPending = "pending"
+	PaymentPaid    = "paid"
+	PaymentFailed  = "failed"
+)
+
+// paymentTimeout مهلت هر درخواست به درگاه پرداخت
+const paymentTimeout = 20 * time.Second
+
+var (
+	ErrPackNotFound     = errors.New("بسته توکن یافت نشد")
+	ErrPaymentNotFound  = errors.New("پرداخت یافت نشد")
+	ErrPaymentCancelled = errors.New("پرداخت توسط کاربر لغو شد")
+)
+
+// PaymentService خرید بسته‌های توکن از درگاه پرداخت
+type PaymentService struct{}
+
+// GetTokenPacks بسته‌های توکن قابل خرید
+func (s *PaymentService) GetTokenPacks() []config.TokenPack {
+	return config.AppConfig.TokenPacks
+}
+
+// GetTokenPack دریافت بسته با شناسه
+func (s *PaymentService) GetTokenPack(packID string) (*config.TokenPack, error) {
+	for _, pack := range config.AppConfig.TokenPacks {
+		if pack.ID == packID {
+			return &pack, nil
+		}
+	}
+	return nil, ErrPackNotFound
+}
+
+// CreatePurchase ایجاد فاکتور خرید بسته و برگرداندن آدرس صفحه پرداخت
+func (s *PaymentService) CreatePurchase(userID uint, packID string) (*database.Payment, string, error) {
+	pack, err := s.GetTokenPack(packID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var user database.User
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		return nil, "", ErrUserNotFound
+	}
+
+	gateway, err := GetPaymentGateway()
+	if err != nil {
+		return nil, "", err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), paymentTimeout)
+	defer cancel()
+
+	invoice, err := gateway.CreateInvoice(ctx, &Invoice{
+		Amount:      pack.Price,
+		Description: fmt.Sprintf("خرید %d توکن", pack.Tokens),
+		CallbackURL: config.AppConfig.PaymentCallbackURL,
+		Mobile:      user.PhoneNumber,
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("خطا در ایجاد فاکتور: %w", err)
+	}
+
+	payment := &database.Payment{
+		UserID:    userID,
+		PackID:    pack.ID,
+		Tokens:    pack.Tokens,
+		Amount:    pack.Price,
+		Gateway:   gateway.Name(),
+		Authority: invoice.Authority,
+		Status:    PaymentPending,
+	}
+	if err := database.DB.Create(payment).Error; err != nil {
+		return nil, "", fmt.Errorf("خطا در ثبت پرداخت: %w", err)
+	}
+
+	return payment, invoice.PaymentURL, nil
+}
+
+// VerifyPayment تایید پرداخت پس از بازگشت از درگاه و افزودن توکن
+//
+// فراخوانی دوباره برای پرداختی که قبلاً تایید شده، همان پرداخت را بدون افزودن
+// دوباره توکن برمی‌گرداند. status فقط گزارش مرورگر است و قابل جعل است؛ بنابراین
+// وضعیت پرداخت همیشه از درگاه پرسیده می‌شود و پرداخت ناموفق هم دوباره بررسی می‌شود.
+func (s *PaymentService) VerifyPayment(authority, status string) (*database.Payment, error) {
+	var payment database.Payment
+	if err := database.DB.Where("authority = ?", authority).First(&payment).Error; err != nil {
+		return nil, ErrPaymentNotFound
+	}
+
+	if payment.Status == PaymentPaid {
+		return &payment, nil
+	}
+
+	gateway, err := GetPaymentGateway()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), paymentTimeout)
+	defer cancel()
+
+	refID, err := gateway.Verify(ctx, authority, payment.Amount)
+	if errors.Is(err, ErrPaymentNotVerified) {
+		s.markFailed(payment.ID)
+		payment.Status = PaymentFailed
+		if status != "OK" {
+			return &payment, ErrPaymentCancelled
+		}
+		return &payment, err
+	}
+	if err != nil {
+		// خطای ارتباطی؛ پرداخت در وضعیت فعلی می‌ماند تا دوباره بررسی شود
+		return &payment, fmt.Errorf("خطا در تایید پرداخت: %w", err)
+	}
+
+	credited, err := tokenService.CreditPurchase(payment.ID, refID)
+	if err != nil {
+		return &payment, err
+	}
+
+	if err := database.DB.First(&payment, payment.ID).Error; err != nil {
+		return nil, ErrPaymentNotFound
+	}
+
+	if credited {
+		utils.LogSuccess("PaymentService", fmt.Sprintf("پرداخت %d تایید شد (کد پیگیری %s)", payment.ID, refID))
+		notifyUser(payment.UserID, fmt.Sprintf(
+			"✅ پرداخت شما تایید شد و <b>%d</b> توکن به حسابتان اضافه شد.\nکد پیگیری: <code>%s</code>",
+			payment.Tokens, refID,
+		))
+	}
+
+	return &payment, nil
+}
+
+// GetUserPayments دریافت آخرین پرداخت‌های کاربر
+func (s *PaymentService) GetUserPayments(userID uint, limit int) ([]database.Payment, error) {
+	var payments []database.Payment
+	if err := database.DB.Where("user_id = ?", userID).Order("id DESC").Limit(limit).Find(&payments).Error; err != nil {
+		return nil, fmt.Errorf("خطا در دریافت پرداخت‌ها: %w", err)
+	}
+	return payments, nil
+}
+
+// markFailed علامت‌گذاری پرداخت در انتظار به‌عنوان ناموفق
+func (s *PaymentService) markFailed(paymentID uint) {
+	if err := database.DB.Model(&database.Payment{}).
+		Where("id = ? AND status = ?", paymentID, PaymentPending).
+		Update("status", PaymentFailed).Error; err != nil {
+		utils.LogError("PaymentService", fmt.Sprintf("خطا در ثبت شکست پرداخت %d", paymentID), err)
+	}
+}
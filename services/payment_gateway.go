@@ -0,0 +1,238 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"telegram-bot/config"
+)
+
+// PaymentGateway رابط مشترک درگاه‌های پرداخت
+type PaymentGateway interface {
+	// Name نام درگاه (مثلاً "zarinpal")
+	Name() string
+	// CreateInvoice ایجاد فاکتور و دریافت آدرس صفحه پرداخت
+	CreateInvoice(ctx context.Context, invoice *Invoice) (*InvoiceResult, error)
+	// Verify تایید پرداخت پس از بازگشت کاربر و دریافت شماره پیگیری
+	Verify(ctx context.Context, authority string, amount int64) (string, error)
+}
+
+// Invoice درخواست ایجاد فاکتور
+type Invoice struct {
+	Amount      int64 // ریال
+	Description string
+	CallbackURL string
+	Mobile      string
+}
+
+// InvoiceResult فاکتور ایجادشده در درگاه
+type InvoiceResult struct {
+	Authority  string
+	PaymentURL string
+}
+
+// ErrPaymentNotVerified درگاه پرداخت را تایید نکرد
+var ErrPaymentNotVerified = errors.New("پرداخت تایید نشد")
+
+// fakeGateway درگاه آزمایشی مشترک؛ وضعیت آن در حافظه است و باید بین درخواست‌ها حفظ شود
+var fakeGateway = NewFakeGateway()
+
+// GetPaymentGateway درگاه پرداخت بر اساس تنظیمات
+func GetPaymentGateway() (PaymentGateway, error) {
+	switch config.AppConfig.PaymentGateway {
+	case "zarinpal":
+		return NewZarinpalGateway(config.AppConfig.ZarinpalMerchantID, config.AppConfig.ZarinpalSandbox), nil
+	case "fake":
+		return fakeGateway, nil
+	}
+	return nil, fmt.Errorf("درگاه پرداخت نامعتبر است: %s", config.AppConfig.PaymentGateway)
+}
+
+// ZarinpalGateway درگاه زرین‌پال (API نسخه ۴)
+type ZarinpalGateway struct {
+	MerchantID string
+	Sandbox    bool
+	client     *http.Client
+}
+
+// zarinpalResponse پاسخ مشترک API زرین‌پال؛ در خطا data آرایه خالی و errors شیء است
+type zarinpalResponse struct {
+	Data   json.RawMessage `json:"data"`
+	Errors json.RawMessage `json:"errors"`
+}
+
+// zarinpalData بخش data پاسخ موفق
+type zarinpalData struct {
+	Code      int    `json:"code"`
+	Message   string `json:"message"`
+	Authority string `json:"authority"`
+	RefID     int64  `json:"ref_id"`
+}
+
+// zarinpalError بخش errors پاسخ ناموفق
+type zarinpalError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// NewZarinpalGateway ایجاد درگاه زرین‌پال
+func NewZarinpalGateway(merchantID string, sandbox bool) *ZarinpalGateway {
+	return &ZarinpalGateway{
+		MerchantID: merchantID,
+		Sandbox:    sandbox,
+		client:     &http.Client{Timeout: 20 * time.Second},
+	}
+}
+
+// Name نام درگاه
+func (g *ZarinpalGateway) Name() string {
+	return "zarinpal"
+}
+
+// baseURL آدرس پایه API و صفحه پرداخت
+func (g *ZarinpalGateway) baseURL() string {
+	if g.Sandbox {
+		return "https://sandbox.zarinpal.com/pg"
+	}
+	return "https://payment.zarinpal.com/pg"
+}
+
+// CreateInvoice ایجاد فاکتور در زرین‌پال
+func (g *ZarinpalGateway) CreateInvoice(ctx context.Context, invoice *Invoice) (*InvoiceResult, error) {
+	payload := map[string]interface{}{
+		"merchant_id":  g.MerchantID,
+		"amount":       invoice.Amount,
+		"currency":     "IRR",
+		"description":  invoice.Description,
+		"callback_url": invoice.CallbackURL,
+	}
+	if invoice.Mobile != "" {
+		payload["metadata"] = map[string]string{"mobile": invoice.Mobile}
+	}
+
+	data, err := g.post(ctx, "/v4/payment/request.json", payload)
+	if err != nil {
+		return nil, err
+	}
+	if data.Code != 100 || data.Authority == "" {
+		return nil, fmt.Errorf("خطا در ایجاد فاکتور زرین‌پال: کد %d", data.Code)
+	}
+
+	return &InvoiceResult{
+		Authority:  data.Authority,
+		PaymentURL: g.baseURL() + "/StartPay/" + url.PathEscape(data.Authority),
+	}, nil
+}
+
+// Verify تایید پرداخت؛ کد ۱۰۱ یعنی پرداخت قبلاً تایید شده است
+func (g *ZarinpalGateway) Verify(ctx context.Context, authority string, amount int64) (string, error) {
+	data, err := g.post(ctx, "/v4/payment/verify.json", map[string]interface{}{
+		"merchant_id": g.MerchantID,
+		"amount":      amount,
+		"authority":   authority,
+	})
+	if err != nil {
+		return "", err
+	}
+	if data.Code != 100 && data.Code != 101 {
+		return "", fmt.Errorf("%w: کد %d", ErrPaymentNotVerified, data.Code)
+	}
+
+	return strconv.FormatInt(data.RefID, 10), nil
+}
+
+// post ارسال درخواست به API زرین‌پال و خواندن بخش data
+func (g *ZarinpalGateway) post(ctx context.Context, path string, payload interface{}) (*zarinpalData, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("خطا در تبدیل JSON: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", g.baseURL()+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("خطا در ایجاد درخواست: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("خطا در ارتباط با زرین‌پال: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("خطا در خواندن پاسخ زرین‌پال: %w", err)
+	}
+
+	var parsed zarinpalResponse
+	if err := json.Unmarshal(respBody, &parsed); err != nil {
+		return nil, fmt.Errorf("پاسخ نامعتبر از زرین‌پال (HTTP %d)", resp.StatusCode)
+	}
+
+	var apiErr zarinpalError
+	if json.Unmarshal(parsed.Errors, &apiErr) == nil && apiErr.Code != 0 {
+		return nil, fmt.Errorf("%w: %s (کد %d)", ErrPaymentNotVerified, apiErr.Message, apiErr.Code)
+	}
+
+	var data zarinpalData
+	if err := json.Unmarshal(parsed.Data, &data); err != nil {
+		return nil, fmt.Errorf("پاسخ نامعتبر از زرین‌پال (HTTP %d)", resp.StatusCode)
+	}
+	return &data, nil
+}
+
+// FakeGateway درگاه آزمایشی درون‌برنامه‌ای؛ هر فاکتور بلافاصله قابل تایید است
+type FakeGateway struct {
+	mu       sync.Mutex
+	invoices map[string]int64 // authority -> مبلغ
+	sequence int
+}
+
+// NewFakeGateway ایجاد درگاه آزمایشی
+func NewFakeGateway() *FakeGateway {
+	return &FakeGateway{invoices: make(map[string]int64)}
+}
+
+// Name نام درگاه
+func (g *FakeGateway) Name() string {
+	return "fake"
+}
+
+// CreateInvoice ایجاد فاکتور؛ آدرس پرداخت مستقیماً به callback با وضعیت OK اشاره می‌کند
+func (g *FakeGateway) CreateInvoice(ctx context.Context, invoice *Invoice) (*InvoiceResult, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.sequence++
+	authority := fmt.Sprintf("FAKE%016d", g.sequence)
+	g.invoices[authority] = invoice.Amount
+
+	query := url.Values{"Authority": {authority}, "Status": {"OK"}}
+	return &InvoiceResult{
+		Authority:  authority,
+		PaymentURL: invoice.CallbackURL + "?" + query.Encode(),
+	}, nil
+}
+
+// Verify تایید فاکتوری که با همین مبلغ ایجاد شده باشد
+func (g *FakeGateway) Verify(ctx context.Context, authority string, amount int64) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	expected, ok := g.invoices[authority]
+	if !ok || expected != amount {
+		return "", ErrPaymentNotVerified
+	}
+	return "REF" + authority, nil
+}
@@ -0,0 +1,153 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"telegram-bot/config"
+	"telegram-bot/database"
+)
+
+// setupTestPayments درگاه آزمایشی تازه و یک بسته توکن
+func setupTestPayments(t *testing.T) *PaymentService {
+	t.Helper()
+
+	config.AppConfig.PaymentGateway = "fake"
+	config.AppConfig.TokenPacks = []config.TokenPack{{ID: "small", Tokens: 50, Price: 500000}}
+	fakeGateway = NewFakeGateway()
+	return &PaymentService{}
+}
+
+// createTestPayment ایجاد فاکتور خرید بسته small برای کاربر
+func createTestPayment(t *testing.T, payments *PaymentService, userID uint) *database.Payment {
+	t.Helper()
+
+	payment, _, err := payments.CreatePurchase(userID, "small")
+	if err != nil {
+		t.Fatalf("CreatePurchase: %v", err)
+	}
+	return payment
+}
+
+// paymentStatus وضعیت فعلی پرداخت در دیتابیس
+func paymentStatus(t *testing.T, paymentID uint) string {
+	t.Helper()
+
+	var payment database.Payment
+	if err := database.DB.First(&payment, paymentID).Error; err != nil {
+		t.Fatalf("reload payment: %v", err)
+	}
+	return payment.Status
+}
+
+func TestVerifyPaymentCreditsOnce(t *testing.T) {
+	setupTestDB(t)
+	payments := setupTestPayments(t)
+	user := createTestUser(t, 30, 0)
+	payment := createTestPayment(t, payments, user.ID)
+
+	// callback تکراری توکن را دوباره اضافه نمی‌کند
+	for i := 0; i < 2; i++ {
+		if _, err := payments.VerifyPayment(payment.Authority, "OK"); err != nil {
+			t.Fatalf("VerifyPayment %d: %v", i+1, err)
+		}
+	}
+
+	if got := reloadUser(t, user.ID).PurchasedTokens; got != 50 {
+		t.Errorf("purchased = %d, want 50", got)
+	}
+	if got := paymentStatus(t, payment.ID); got != PaymentPaid {
+		t.Errorf("status = %q, want %q", got, PaymentPaid)
+	}
+
+	var grants int64
+	database.DB.Model(&database.TokenTransaction{}).Where("user_id = ? AND type = ?", user.ID, TransactionGrant).Count(&grants)
+	if grants != 1 {
+		t.Errorf("grant entries = %d, want 1", grants)
+	}
+}
+
+func TestVerifyPaymentRejected(t *testing.T) {
+	tests := []struct {
+		name    string
+		tamper  func(t *testing.T, payment *database.Payment)
+		status  string
+		wantErr error
+	}{
+		{
+			name: "amount mismatch",
+			tamper: func(t *testing.T, payment *database.Payment) {
+				if err := database.DB.Model(payment).Update("amount", payment.Amount-1).Error; err != nil {
+					t.Fatalf("tamper amount: %v", err)
+				}
+			},
+			status:  "OK",
+			wantErr: ErrPaymentNotVerified,
+		},
+		{
+			name: "cancelled and not paid",
+			tamper: func(t *testing.T, payment *database.Payment) {
+				// فاکتوری که درگاه آن را پرداخت‌شده نمی‌شناسد
+				fakeGateway = NewFakeGateway()
+			},
+			status:  "NOK",
+			wantErr: ErrPaymentCancelled,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			payments := setupTestPayments(t)
+			user := createTestUser(t, 30, 0)
+			payment := createTestPayment(t, payments, user.ID)
+			tt.tamper(t, payment)
+
+			if _, err := payments.VerifyPayment(payment.Authority, tt.status); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("VerifyPayment = %v, want %v", err, tt.wantErr)
+			}
+			if got := paymentStatus(t, payment.ID); got != PaymentFailed {
+				t.Errorf("status = %q, want %q", got, PaymentFailed)
+			}
+			if got := reloadUser(t, user.ID).PurchasedTokens; got != 0 {
+				t.Errorf("purchased = %d, want 0", got)
+			}
+		})
+	}
+}
+
+func TestVerifyPaymentTrustsGatewayOverStatus(t *testing.T) {
+	setupTestDB(t)
+	payments := setupTestPayments(t)
+	user := createTestUser(t, 30, 0)
+	payment := createTestPayment(t, payments, user.ID)
+
+	// Status جعلی یا بازگشت مرورگر با NOK پرداخت انجام‌شده را رد نمی‌کند
+	if _, err := payments.VerifyPayment(payment.Authority, "NOK"); err != nil {
+		t.Fatalf("VerifyPayment: %v", err)
+	}
+	if got := reloadUser(t, user.ID).PurchasedTokens; got != 50 {
+		t.Errorf("purchased = %d, want 50", got)
+	}
+}
+
+func TestVerifyPaymentRetriesFailedPayment(t *testing.T) {
+	setupTestDB(t)
+	payments := setupTestPayments(t)
+	user := createTestUser(t, 30, 0)
+	payment := createTestPayment(t, payments, user.ID)
+
+	if err := database.DB.Model(payment).Update("status", PaymentFailed).Error; err != nil {
+		t.Fatalf("mark failed: %v", err)
+	}
+
+	if _, err := payments.VerifyPayment(payment.Authority, "OK"); err != nil {
+		t.Fatalf("VerifyPayment: %v", err)
+	}
+	if got := paymentStatus(t, payment.ID); got != PaymentPaid {
+		t.Errorf("status = %q, want %q", got, PaymentPaid)
+	}
+	if got := reloadUser(t, user.ID).PurchasedTokens; got != 50 {
+		t.Errorf("purchased = %d, want 50", got)
+	}
+}
@@ -0,0 +1,273 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"telegram-bot/config"
+	"telegram-bot/database"
+	"telegram-bot/utils"
+)
+
+// نام پلن‌های پیش‌فرض
+const (
+	PlanFree    = "free"
+	PlanStudent = "student"
+	PlanPremium = "premium"
+	PlanStaff   = "staff"
+)
+
+// PlanService مدیریت پلن‌های اشتراک و سهمیه کاربران
+type PlanService struct{}
+
+var planService = &PlanService{}
+
+// defaultPlans پلن‌هایی که در صورت نبود ایجاد می‌شوند
+func defaultPlans() []database.Plan {
+	return []database.Plan{
+		{Name: PlanFree, Title: "رایگان", DailyTokens: config.AppConfig.DailyTokenLimit, MonthlyTokens: 600, MaxFileSizeMB: config.AppConfig.MaxFileSizeMB, Priority: 0},
+		{Name: PlanStudent, Title: "دانشجویی", DailyTokens: 60, MonthlyTokens: 1200, MaxFileSizeMB: 20, Priority: 1},
+		{Name: PlanPremium, Title: "ویژه", DailyTokens: 200, MonthlyTokens: 5000, MaxFileSizeMB: 50, Priority: 2},
+		{Name: PlanStaff, Title: "کادر آموزشی", Unlimited: true, MaxFileSizeMB: 100, Priority: 3},
+	}
+}
+
+// EnsureDefaultPlans ایجاد پلن‌های پیش‌فرض؛ پلن‌های موجود تغییر نمی‌کنند
+func (s *PlanService) EnsureDefaultPlans() error {
+	for _, plan := range defaultPlans() {
+		var existing database.Plan
+		result := database.DB.Where("name = ?", plan.Name).Limit(1).Find(&existing)
+		if result.Error != nil {
+			return fmt.Errorf("خطا در بررسی پلن‌ها: %w", result.Error)
+		}
+		if result.RowsAffected > 0 {
+			continue
+		}
+
+		if err := database.DB.Create(&plan).Error; err != nil {
+			return fmt.Errorf("خطا در ایجاد پلن %s: %w", plan.Name, err)
+		}
+	}
+	return nil
+}
+
+// GetPlans دریافت همه پلن‌ها
+func (s *PlanService) GetPlans() ([]database.Plan, error) {
+	var plans []database.Plan
+	if err := database.DB.Order("priority ASC").Find(&plans).Error; err != nil {
+		return nil, fmt.Errorf("خطا در دریافت پلن‌ها: %w", err)
+	}
+	return plans, nil
+}
+
+// GetPlanByName دریافت پلن با نام
+func (s *PlanService) GetPlanByName(name string) (*database.Plan, error) {
+	return planByName(database.DB, name)
+}
+
+// SavePlan ایجاد یا به‌روزرسانی پلن بر اساس نام
+func (s *PlanService) SavePlan(plan *database.Plan) error {
+	if plan.Name == "" {
+		return fmt.Errorf("نام پلن الزامی است")
+	}
+	if plan.DailyTokens < 0 || plan.MonthlyTokens < 0 || plan.MaxFileSizeMB < 0 {
+		return fmt.Errorf("سهمیه‌های پلن نمی‌توانند منفی باشند")
+	}
+
+	var existing database.Plan
+	result := database.DB.Where("name = ?", plan.Name).Limit(1).Find(&existing)
+	if result.Error != nil {
+		return fmt.Errorf("خطا در ذخیره پلن: %w", result.Error)
+	}
+
+	if result.RowsAffected > 0 {
+		plan.ID = existing.ID
+		plan.CreatedAt = existing.CreatedAt
+	}
+	return database.DB.Save(plan).Error
+}
+
+// GetUserPlan پلن فعال کاربر
+func (s *PlanService) GetUserPlan(userID uint) (*database.Plan, error) {
+	var user database.User
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		return nil, ErrUserNotFound
+	}
+	return userPlan(database.DB, &user)
+}
+
+// AssignPlan تخصیص پلن به کاربر؛ startsAt و expiresAt صفر یعنی از همین حالا و بدون انقضا
+func (s *PlanService) AssignPlan(userID uint, name string, startsAt, expiresAt time.Time, actor Actor) error {
+	plan, err := planByName(database.DB, name)
+	if err != nil {
+		return err
+	}
+	if !expiresAt.IsZero() && !startsAt.IsZero() && !expiresAt.After(startsAt) {
+		return fmt.Errorf("تاریخ انقضا باید بعد از تاریخ شروع باشد")
+	}
+
+	planID := plan.ID
+	if name == PlanFree {
+		planID = 0
+	}
+
+	if err := database.DB.Model(&database.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"plan_id":         planID,
+		"plan_starts_at":  startsAt,
+		"plan_expires_at": expiresAt,
+	}).Error; err != nil {
+		return fmt.Errorf("خطا در تخصیص پلن: %w", err)
+	}
+
+	// پلنی که هنوز شروع نشده تا زمان شروع روی موجودی اثری ندارد
+	active, err := s.GetUserPlan(userID)
+	if err != nil {
+		return err
+	}
+	return s.applyPlan(userID, active, actor)
+}
+
+// applyPlan هماهنگ کردن توکن نامحدود و موجودی امروز کاربر با پلن
+func (s *PlanService) applyPlan(userID uint, plan *database.Plan, actor Actor) error {
+	if err := database.DB.Model(&database.User{}).
+		Where("id = ?", userID).
+		Update("unlimited_tokens", plan.Unlimited).Error; err != nil {
+		return fmt.Errorf("خطا در تخصیص پلن: %w", err)
+	}
+
+	note := fmt.Sprintf("تغییر پلن به %s", plan.Title)
+	entry := &TokenEntry{Type: TransactionAdminAdjust, Note: note, Actor: actor}
+	_, err := tokenService.adjustBalance(userID, entry, 0, func(tx *gorm.DB, user *database.User) (tokenDelta, error) {
+		if plan.Unlimited {
+			return tokenDelta{Daily: -user.DailyTokens}, nil
+		}
+
+		allowance, err := dailyAllowance(tx, user, plan, DayStart(time.Now()))
+		if err != nil {
+			return tokenDelta{}, err
+		}
+		return tokenDelta{Daily: allowance - user.DailyTokens}, nil
+	})
+	return err
+}
+
+// ExpirePlans بازگرداندن کاربرانی که پلنشان منقضی شده به پلن رایگان
+func (s *PlanService) ExpirePlans() error {
+	var users []database.User
+	if err := database.DB.Where("plan_id <> 0").Find(&users).Error; err != nil {
+		return fmt.Errorf("خطا در بررسی انقضای پلن‌ها: %w", err)
+	}
+
+	now := time.Now()
+	for _, user := range users {
+		if user.PlanExpiresAt.IsZero() || now.Before(user.PlanExpiresAt) {
+			continue
+		}
+
+		if err := s.AssignPlan(user.ID, PlanFree, time.Time{}, time.Time{}, SystemActor); err != nil {
+			utils.LogError("PlanService", fmt.Sprintf("خطا در پایان پلن کاربر %d", user.ID), err)
+			continue
+		}
+		utils.LogInfo("PlanService", fmt.Sprintf("پلن کاربر %d منقضی شد", user.ID))
+	}
+	return nil
+}
+
+// FreeDailyTokens سهمیه روزانه پلن رایگان برای کاربران جدید
+func (s *PlanService) FreeDailyTokens() int {
+	plan, err := planByName(database.DB, PlanFree)
+	if err != nil {
+		return config.AppConfig.DailyTokenLimit
+	}
+	return plan.DailyTokens
+}
+
+// AllowsModel بررسی مجاز بودن یک مدل در پلن
+func AllowsModel(plan *database.Plan, model string) bool {
+	allowed := planModels(plan)
+	if len(allowed) == 0 || model == "" {
+		return true
+	}
+
+	for _, name := range allowed {
+		if name == model {
+			return true
+		}
+	}
+	return false
+}
+
+// planModels فهرست مدل‌های مجاز پلن
+func planModels(plan *database.Plan) []string {
+	var models []string
+	for _, name := range strings.Split(plan.AllowedModels, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			models = append(models, name)
+		}
+	}
+	return models
+}
+
+// planByName دریافت پلن با نام
+func planByName(db *gorm.DB, name string) (*database.Plan, error) {
+	var plan database.Plan
+	if err := db.Where("name = ?", name).First(&plan).Error; err != nil {
+		return nil, fmt.Errorf("پلن %q یافت نشد", name)
+	}
+	return &plan, nil
+}
+
+// userPlan پلن فعال کاربر؛ پلن منقضی یا شروع‌نشده یعنی پلن رایگان
+func userPlan(db *gorm.DB, user *database.User) (*database.Plan, error) {
+	now := time.Now()
+	active := user.PlanID != 0 &&
+		(user.PlanStartsAt.IsZero() || !now.Before(user.PlanStartsAt)) &&
+		(user.PlanExpiresAt.IsZero() || now.Before(user.PlanExpiresAt))
+
+	if active {
+		var plan database.Plan
+		if err := db.First(&plan, user.PlanID).Error; err == nil {
+			return &plan, nil
+		}
+	}
+
+	plan, err := planByName(db, PlanFree)
+	if err != nil {
+		// پلن‌ها هنوز ایجاد نشده‌اند
+		return &database.Plan{
+			Name:          PlanFree,
+			Title:         "رایگان",
+			DailyTokens:   config.AppConfig.DailyTokenLimit,
+			MaxFileSizeMB: config.AppConfig.MaxFileSizeMB,
+		}, nil
+	}
+	return plan, nil
+}
+
+// dailyAllowance سهمیه روزانه کاربر با در نظر گرفتن باقی‌مانده سهمیه ماهانه
+func dailyAllowance(db *gorm.DB, user *database.User, plan *database.Plan, day time.Time) (int, error) {
+	if plan.MonthlyTokens <= 0 {
+		return plan.DailyTokens, nil
+	}
+
+	monthStart := time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, day.Location())
+
+	var used int
+	if err := db.Model(&database.DailyTokenUsage{}).
+		Where("user_id = ? AND date >= ?", user.ID, monthStart).
+		Select("COALESCE(SUM(tokens_used), 0)").
+		Scan(&used).Error; err != nil {
+		return 0, fmt.Errorf("خطا در محاسبه مصرف ماهانه: %w", err)
+	}
+
+	remaining := plan.MonthlyTokens - used
+	if remaining < 0 {
+		remaining = 0
+	}
+	if remaining < plan.DailyTokens {
+		return remaining, nil
+	}
+	return plan.DailyTokens, nil
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"telegram-bot/config"
+	"telegram-bot/database"
+)
+
+// PricingService تبدیل مصرف توکن ارائه‌دهنده به اعتبار ربات
+type PricingService struct{}
+
+var pricingService = &PricingService{}
+
+// GetPricing دریافت نرخ یک مدل؛ طولانی‌ترین پیشوند منطبق انتخاب می‌شود
+func (s *PricingService) GetPricing(model string) database.ModelPricing {
+	pricing := database.ModelPricing{
+		Model:                  model,
+		PromptCreditsPer1K:     config.AppConfig.PromptCreditsPer1K,
+		CompletionCreditsPer1K: config.AppConfig.CompletionCreditsPer1K,
+	}
+
+	var table []database.ModelPricing
+	if err := database.DB.Find(&table).Error; err != nil {
+		return pricing
+	}
+
+	matched := -1
+	for _, row := range table {
+		if strings.HasPrefix(model, row.Model) && len(row.Model) > matched {
+			pricing = row
+			matched = len(row.Model)
+		}
+	}
+	return pricing
+}
+
+// CalculateCost اعتبار دقیق یک درخواست بر اساس نرخ مدل، پیش از گرد کردن
+func (s *PricingService) CalculateCost(model string, promptTokens, completionTokens int) float64 {
+	pricing := s.GetPricing(model)
+	return float64(promptTokens)/1000*pricing.PromptCreditsPer1K +
+		float64(completionTokens)/1000*pricing.CompletionCreditsPer1K
+}
+
+// GetAllPricing دریافت جدول نرخ‌ها
+func (s *PricingService) GetAllPricing() ([]database.ModelPricing, error) {
+	var table []database.ModelPricing
+	if err := database.DB.Order("model").Find(&table).Error; err != nil {
+		return nil, fmt.Errorf("خطا در دریافت نرخ‌ها: %w", err)
+	}
+	return table, nil
+}
+
+// SetPricing ثبت یا به‌روزرسانی نرخ یک مدل
+func (s *PricingService) SetPricing(model string, promptPer1K, completionPer1K float64) error {
+	if model == "" {
+		return fmt.Errorf("نام مدل الزامی است")
+	}
+	if promptPer1K < 0 || completionPer1K < 0 {
+		return fmt.Errorf("نرخ نمی‌تواند منفی باشد")
+	}
+
+	var pricing database.ModelPricing
+	result := database.DB.Where("model = ?", model).First(&pricing)
+
+	pricing.Model = model
+	pricing.PromptCreditsPer1K = promptPer1K
+	pricing.CompletionCreditsPer1K = completionPer1K
+	pricing.UpdatedAt = time.Now()
+
+	if result.RowsAffected == 0 {
+		return database.DB.Create(&pricing).Error
+	}
+	return database.DB.Save(&pricing).Error
+}
+
+// DeletePricing حذف نرخ یک مدل؛ پس از آن نرخ پیش‌فرض اعمال می‌شود
+func (s *PricingService) DeletePricing(model string) error {
+	return database.DB.Where("model = ?", model).Delete(&database.ModelPricing{}).Error
+}
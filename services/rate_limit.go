@@ -0,0 +1,148 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"telegram-bot/config"
+	"telegram-bot/database"
+)
+
+// نقش‌ها برای انتخاب محدودیت نرخ
+const (
+	RoleGuest   = "guest"
+	RoleUser    = "user"
+	RoleSupport = "support"
+	RoleAdmin   = "admin"
+)
+
+// rateLimitSweepInterval فاصله پاک‌سازی سطل‌های پرشده از حافظه
+const rateLimitSweepInterval = 10 * time.Minute
+
+// RateLimiter محدودکننده نرخ با الگوریتم سطل توکن
+//
+// هر کلید (مثلاً chat:123، user:7 یا ip:1.2.3.4) سطل جداگانه دارد. ظرفیت سطل
+// همان burst نقش است و با نرخ ثابت در دقیقه دوباره پر می‌شود.
+type RateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*rateBucket
+	lastSweep time.Time
+}
+
+// rateBucket وضعیت سطل یک کلید
+type rateBucket struct {
+	tokens   float64
+	updated  time.Time
+	capacity float64
+	rate     float64 // توکن در ثانیه
+}
+
+var rateLimiter = NewRateLimiter()
+
+// NewRateLimiter ایجاد محدودکننده نرخ
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{buckets: make(map[string]*rateBucket), lastSweep: time.Now()}
+}
+
+// GetRateLimiter محدودکننده نرخ مشترک ربات و API
+func GetRateLimiter() *RateLimiter {
+	return rateLimiter
+}
+
+// Allow مصرف یک توکن از سطل کلید بر اساس محدودیت نقش
+//
+// در صورت رد شدن، مدت انتظار تا آزاد شدن توکن بعدی برگردانده می‌شود. نقشی که
+// محدودیتی برای آن تنظیم نشده باشد محدود نمی‌شود.
+func (l *RateLimiter) Allow(key, role string) (bool, time.Duration) {
+	limit, ok := config.AppConfig.RateLimits[role]
+	if !ok {
+		return true, 0
+	}
+	return l.AllowLimit(key, limit)
+}
+
+// AllowLimit مصرف یک توکن از سطل کلید با محدودیت مشخص؛ نرخ صفر یعنی بدون محدودیت
+func (l *RateLimiter) AllowLimit(key string, limit config.RateLimit) (bool, time.Duration) {
+	if limit.PerMinute <= 0 {
+		return true, 0
+	}
+
+	now := time.Now()
+	capacity := float64(limit.Burst)
+	if capacity < 1 {
+		capacity = 1
+	}
+	rate := limit.PerMinute / 60
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	bucket, exists := l.buckets[key]
+	if !exists || bucket.capacity != capacity || bucket.rate != rate {
+		// سطل جدید یا تغییر نقش/تنظیمات؛ با ظرفیت کامل شروع می‌شود
+		bucket = &rateBucket{tokens: capacity, updated: now, capacity: capacity, rate: rate}
+		l.buckets[key] = bucket
+	}
+
+	bucket.refill(now)
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+
+	wait := time.Duration(math.Ceil((1 - bucket.tokens) / bucket.rate * float64(time.Second)))
+	return false, wait
+}
+
+// refill پر کردن سطل به نسبت زمان گذشته
+func (b *rateBucket) refill(now time.Time) {
+	elapsed := now.Sub(b.updated).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(b.capacity, b.tokens+elapsed*b.rate)
+		b.updated = now
+	}
+}
+
+// sweep حذف سطل‌هایی که کاملاً پر شده‌اند؛ حذف آن‌ها رفتاری را تغییر نمی‌دهد
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+	l.lastSweep = now
+
+	for key, bucket := range l.buckets {
+		bucket.refill(now)
+		if bucket.tokens >= bucket.capacity {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// UserRole نقش کاربر برای محدودیت نرخ
+func UserRole(userID uint) string {
+	if userID == 0 {
+		return RoleGuest
+	}
+
+	var user database.User
+	if err := database.DB.Select("id", "is_admin", "is_support").First(&user, userID).Error; err != nil {
+		return RoleGuest
+	}
+
+	switch {
+	case user.IsAdmin:
+		return RoleAdmin
+	case user.IsSupport:
+		return RoleSupport
+	}
+	return RoleUser
+}
+
+// RateLimitKey کلید سطل؛ kind یکی از chat، user یا ip است
+func RateLimitKey(kind string, id interface{}) string {
+	return fmt.Sprintf("%s:%v", kind, id)
+}
@@ -0,0 +1,58 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"telegram-bot/config"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	config.AppConfig = &config.Config{
+		RateLimits: map[string]config.RateLimit{
+			RoleUser:  {PerMinute: 6, Burst: 2},
+			RoleAdmin: {PerMinute: 0, Burst: 1},
+		},
+	}
+
+	limiter := NewRateLimiter()
+	key := RateLimitKey("user", 1)
+
+	// ظرفیت سطل برای درخواست‌های پشت‌سرهم
+	for i := 0; i < 2; i++ {
+		if ok, _ := limiter.Allow(key, RoleUser); !ok {
+			t.Fatalf("request %d rejected within burst", i+1)
+		}
+	}
+
+	ok, wait := limiter.Allow(key, RoleUser)
+	if ok {
+		t.Fatal("request allowed after burst")
+	}
+	// شش توکن در دقیقه یعنی یک توکن هر ده ثانیه
+	if wait <= 9*time.Second || wait > 10*time.Second {
+		t.Errorf("wait = %v, want about 10s", wait)
+	}
+
+	// گذشت زمان سطل را به نسبت نرخ پر می‌کند
+	limiter.buckets[key].updated = time.Now().Add(-10 * time.Second)
+	if ok, _ := limiter.Allow(key, RoleUser); !ok {
+		t.Error("request rejected after refill")
+	}
+	if ok, _ := limiter.Allow(key, RoleUser); ok {
+		t.Error("refill added more than one token")
+	}
+
+	// کلیدها سطل جداگانه دارند و نقش بدون محدودیت رد نمی‌شود
+	if ok, _ := limiter.Allow(RateLimitKey("user", 2), RoleUser); !ok {
+		t.Error("other key rejected")
+	}
+	for i := 0; i < 5; i++ {
+		if ok, _ := limiter.Allow(key, RoleAdmin); !ok {
+			t.Fatal("unlimited role rejected")
+		}
+		if ok, _ := limiter.Allow(key, RoleGuest); !ok {
+			t.Fatal("role without limit rejected")
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"telegram-bot/database"
+)
+
+// مجوزهای دسترسی پنل ادمین و پشتیبانی
+const (
+	PermAll           = "*"
+	PermUsersRead     = "users:read"
+	PermUsersWrite    = "users:write"
+	PermTokensWrite   = "tokens:write"
+	PermAnalyticsRead = "analytics:read"
+	PermBillingRead   = "billing:read" // نرخ مدل‌ها، هزینه قابلیت‌ها و پلن‌ها
+	PermBillingWrite  = "billing:write"
+	PermSettingsRead  = "settings:read"
+	PermSettingsWrite = "settings:write"
+	PermStaffWrite    = "staff:write" // افزودن و حذف پشتیبان
+	PermRolesRead     = "roles:read"
+	PermRolesWrite    = "roles:write"
+	PermAPIKeysWrite  = "apikeys:write" // صدور و ابطال کلید API برای کاربران
+	PermTicketsRead   = "tickets:read"
+	PermTicketsWrite  = "tickets:write" // تغییر وضعیت تیکت
+	PermTicketsReply  = "tickets:reply"
+)
+
+// RoleAuditor نقش فقط‌خواندنی برای گزارش‌گیری
+const RoleAuditor = "auditor"
+
+// permissionTitles عنوان فارسی مجوزهای معتبر
+var permissionTitles = map[string]string{
+	PermAll:           "همه مجوزها",
+	PermUsersRead:     "مشاهده کاربران",
+	PermUsersWrite:    "مدیریت کاربران",
+	PermTokensWrite:   "تغییر موجودی توکن",
+	PermAnalyticsRead: "مشاهده آمار و مصرف",
+	PermBillingRead:   "مشاهده نرخ‌ها و پلن‌ها",
+	PermBillingWrite:  "مدیریت نرخ‌ها و پلن‌ها",
+	PermSettingsRead:  "مشاهده تنظیمات",
+	PermSettingsWrite: "تغییر تنظیمات",
+	PermStaffWrite:    "مدیریت پشتیبان‌ها",
+	PermRolesRead:     "مشاهده نقش‌ها",
+	PermRolesWrite:    "مدیریت نقش‌ها",
+	PermAPIKeysWrite:  "مدیریت کلیدهای API",
+	PermTicketsRead:   "مشاهده تیکت‌ها",
+	PermTicketsWrite:  "تغییر وضعیت تیکت",
+	PermTicketsReply:  "پاسخ به تیکت",
+}
+
+var ErrRoleNotFound = errors.New("نقش یافت نشد")
+
+// RBACService نقش‌ها و مجوزهای دسترسی کاربران
+//
+// فیلدهای IsAdmin و IsSupport کاربر معادل داشتن نقش admin و support هستند؛
+// نقش‌های دیگر از جدول role_assignments خوانده می‌شوند.
+type RBACService struct{}
+
+var rbacService = &RBACService{}
+
+// defaultRoles نقش‌هایی که در صورت نبود ایجاد می‌شوند
+func defaultRoles() []database.Role {
+	return []database.Role{
+		{Name: RoleAdmin, Title: "مدیر", Permissions: PermAll},
+		{Name: RoleSupport, Title: "پشتیبان", Permissions: strings.Join([]string{PermTicketsRead, PermTicketsWrite, PermTicketsReply}, ",")},
+		{Name: RoleAuditor, Title: "ناظر", Permissions: strings.Join([]string{PermUsersRead, PermAnalyticsRead, PermBillingRead, PermSettingsRead, PermRolesRead}, ",")},
+	}
+}
+
+// EnsureDefaultRoles ایجاد نقش‌های پیش‌فرض؛ نقش‌های موجود تغییر نمی‌کنند
+func (s *RBACService) EnsureDefaultRoles() error {
+	for _, role := range defaultRoles() {
+		var existing database.Role
+		result := database.DB.Where("name = ?", role.Name).Limit(1).Find(&existing)
+		if result.Error != nil {
+			return fmt.Errorf("خطا در بررسی نقش‌ها: %w", result.Error)
+		}
+		if result.RowsAffected > 0 {
+			continue
+		}
+
+		if err := database.DB.Create(&role).Error; err != nil {
+			return fmt.Errorf("خطا در ایجاد نقش %s: %w", role.Name, err)
+		}
+	}
+	return nil
+}
+
+// GetPermissionTitles فهرست مجوزهای معتبر با عنوان
+func (s *RBACService) GetPermissionTitles() map[string]string {
+	return permissionTitles
+}
+
+// GetRoles دریافت همه نقش‌ها
+func (s *RBACService) GetRoles() ([]database.Role, error) {
+	var roles []database.Role
+	if err := database.DB.Order("id ASC").Find(&roles).Error; err != nil {
+		return nil, fmt.Errorf("خطا در دریافت نقش‌ها: %w", err)
+	}
+	return roles, nil
+}
+
+// SaveRole ایجاد یا به‌روزرسانی نقش بر اساس نام
+//
+// نقش admin قابل تغییر نیست تا دسترسی مدیران هیچ‌وقت از بین نرود.
+func (s *RBACService) SaveRole(role *database.Role) error {
+	if role.Name == "" {
+		return fmt.Errorf("نام نقش الزامی است")
+	}
+	if role.Name == RoleAdmin {
+		return fmt.Errorf("نقش %s قابل تغییر نیست", RoleAdmin)
+	}
+
+	permissions := splitPermissions(role.Permissions)
+	for _, permission := range permissions {
+		if _, ok := permissionTitles[permission]; !ok {
+			return fmt.Errorf("مجوز %q نامعتبر است", permission)
+		}
+	}
+	role.Permissions = strings.Join(permissions, ",")
+
+	var existing database.Role
+	result := database.DB.Where("name = ?", role.Name).Limit(1).Find(&existing)
+	if result.Error != nil {
+		return fmt.Errorf("خطا در ذخیره نقش: %w", result.Error)
+	}
+
+	if result.RowsAffected > 0 {
+		role.ID = existing.ID
+		role.CreatedAt = existing.CreatedAt
+	}
+	return database.DB.Save(role).Error
+}
+
+// AssignRoles جایگزینی نقش‌های کاربر
+//
+// نقش‌های admin و support با فیلدهای IsAdmin و IsSupport هماهنگ نگه داشته می‌شوند.
+func (s *RBACService) AssignRoles(userID uint, roleNames []string) error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		var user database.User
+		if err := tx.First(&user, userID).Error; err != nil {
+			return ErrUserNotFound
+		}
+
+		var roles []database.Role
+		if len(roleNames) > 0 {
+			if err := tx.Where("name IN ?", roleNames).Find(&roles).Error; err != nil {
+				return fmt.Errorf("خطا در دریافت نقش‌ها: %w", err)
+			}
+		}
+		if len(roles) != len(uniqueStrings(roleNames)) {
+			return ErrRoleNotFound
+		}
+
+		if err := tx.Where("user_id = ?", userID).Delete(&database.RoleAssignment{}).Error; err != nil {
+			return fmt.Errorf("خطا در حذف نقش‌های قبلی: %w", err)
+		}
+
+		isAdmin, isSupport := false, false
+		for _, role := range roles {
+			switch role.Name {
+			case RoleAdmin:
+				isAdmin = true
+			case RoleSupport:
+				isSupport = true
+			}
+
+			if err := tx.Create(&database.RoleAssignment{UserID: userID, RoleID: role.ID, CreatedAt: time.Now()}).Error; err != nil {
+				return fmt.Errorf("خطا در تخصیص نقش %s: %w", role.Name, err)
+			}
+		}
+
+		return tx.Model(&user).Updates(map[string]interface{}{
+			"is_admin":   isAdmin,
+			"is_support": isSupport,
+		}).Error
+	})
+}
+
+// GetUserRoles نام نقش‌های کاربر
+func (s *RBACService) GetUserRoles(userID uint) ([]string, error) {
+	var user database.User
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		return nil, ErrUserNotFound
+	}
+
+	var names []string
+	if err := database.DB.Model(&database.Role{}).
+		Joins("JOIN role_assignments ON role_assignments.role_id = roles.id").
+		Where("role_assignments.user_id = ?", userID).
+		Pluck("roles.name", &names).Error; err != nil {
+		return nil, fmt.Errorf("خطا در دریافت نقش‌های کاربر: %w", err)
+	}
+
+	if user.IsAdmin {
+		names = append(names, RoleAdmin)
+	}
+	if user.IsSupport {
+		names = append(names, RoleSupport)
+	}
+	return uniqueStrings(names), nil
+}
+
+// GetUserPermissions مجوزهای کاربر از مجموع همه نقش‌هایش
+func (s *RBACService) GetUserPermissions(userID uint) ([]string, error) {
+	roleNames, err := s.GetUserRoles(userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(roleNames) == 0 {
+		return nil, nil
+	}
+
+	var roles []database.Role
+	if err := database.DB.Where("name IN ?", roleNames).Find(&roles).Error; err != nil {
+		return nil, fmt.Errorf("خطا در دریافت مجوزها: %w", err)
+	}
+
+	var permissions []string
+	for _, role := range roles {
+		permissions = append(permissions, splitPermissions(role.Permissions)...)
+	}
+	return uniqueStrings(permissions), nil
+}
+
+// HasPermission بررسی وجود مجوز در فهرست مجوزهای کاربر
+func HasPermission(permissions []string, permission string) bool {
+	for _, p := range permissions {
+		if p == PermAll || p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// splitPermissions جدا کردن مجوزهای ذخیره‌شده با ویرگول
+func splitPermissions(value string) []string {
+	var permissions []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			permissions = append(permissions, item)
+		}
+	}
+	return uniqueStrings(permissions)
+}
+
+// uniqueStrings حذف مقادیر تکراری و مرتب‌سازی
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			result = append(result, value)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,78 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"telegram-bot/config"
+	"telegram-bot/database"
+)
+
+// setupTestDB دیتابیس موقت و تنظیمات حداقلی برای تست سرویس‌ها
+func setupTestDB(t *testing.T) {
+	t.Helper()
+
+	config.AppConfig = &config.Config{
+		JWTSecret:             "test-secret-key-min-32-characters!",
+		AccessTokenTTLMinutes: 15,
+		RefreshTokenTTLDays:   30,
+		OTPTTLSeconds:         300,
+		OTPMaxAttempts:        3,
+		ReservationTTLSeconds: 300,
+		Location:              time.UTC,
+		DailyTokenLimit:       30,
+	}
+
+	if err := database.InitDatabase(t.TempDir() + "/test.db?_busy_timeout=5000"); err != nil {
+		t.Fatalf("InitDatabase: %v", err)
+	}
+	t.Cleanup(func() {
+		if db, err := database.DB.DB(); err == nil {
+			db.Close()
+		}
+	})
+}
+
+// createTestUser ایجاد کاربر با موجودی مشخص
+func createTestUser(t *testing.T, daily, purchased int) *database.User {
+	t.Helper()
+
+	var count int64
+	database.DB.Model(&database.User{}).Count(&count)
+
+	now := time.Now()
+	user := &database.User{
+		TelegramID:     int64(count + 1),
+		PhoneNumber:    fmt.Sprintf("0912000%04d", count+1),
+		NationalCode:   fmt.Sprintf("000000%04d", count+1),
+		FullName:       "کاربر تست",
+		LastTokenReset: now,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	if err := database.DB.Create(user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	// مقدار صفر با Create نادیده گرفته می‌شود و پیش‌فرض ستون جای آن می‌نشیند
+	if err := database.DB.Model(user).Updates(map[string]interface{}{
+		"daily_tokens":     daily,
+		"purchased_tokens": purchased,
+	}).Error; err != nil {
+		t.Fatalf("set balance: %v", err)
+	}
+	user.DailyTokens, user.PurchasedTokens = daily, purchased
+	return user
+}
+
+// reloadUser خواندن دوباره کاربر از دیتابیس
+func reloadUser(t *testing.T, userID uint) *database.User {
+	t.Helper()
+
+	var user database.User
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		t.Fatalf("reload user: %v", err)
+	}
+	return &user
+}
@@ -0,0 +1,49 @@
+package services
+
+import (
+	"fmt"
+
+	"telegram-bot/database"
+)
+
+type SettingService struct{}
+
+// GetSetting دریافت مقدار یک تنظیم؛ در صورت نبود، مقدار پیش‌فرض برگردانده می‌شود
+func (s *SettingService) GetSetting(key, defaultVal string) string {
+	var setting database.Setting
+	if err := database.DB.Where("key = ?", key).First(&setting).Error; err != nil {
+		return defaultVal
+	}
+	return setting.Value
+}
+
+// SetSetting ایجاد یا به‌روزرسانی یک تنظیم
+func (s *SettingService) SetSetting(key, value string) error {
+	var setting database.Setting
+	result := database.DB.Where("key = ?", key).Limit(1).Find(&setting)
+	if result.Error != nil {
+		return fmt.Errorf("خطا در دریافت تنظیمات: %w", result.Error)
+	}
+
+	if result.RowsAffected == 0 {
+		setting = database.Setting{Key: key, Value: value}
+		if err := database.DB.Create(&setting).Error; err != nil {
+			return fmt.Errorf("خطا در ذخیره تنظیمات: %w", err)
+		}
+		return nil
+	}
+
+	if err := database.DB.Model(&setting).Update("value", value).Error; err != nil {
+		return fmt.Errorf("خطا در ذخیره تنظیمات: %w", err)
+	}
+	return nil
+}
+
+// GetAllSettings دریافت همه تنظیمات
+func (s *SettingService) GetAllSettings() ([]database.Setting, error) {
+	var settings []database.Setting
+	if err := database.DB.Order("key ASC").Find(&settings).Error; err != nil {
+		return nil, fmt.Errorf("خطا در دریافت تنظیمات: %w", err)
+	}
+	return settings, nil
+}
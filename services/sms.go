@@ -0,0 +1,164 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"telegram-bot/config"
+)
+
+// SMSSender رابط مشترک سرویس‌های ارسال پیامک
+type SMSSender interface {
+	// Name نام سرویس (مثلاً "console")
+	Name() string
+	// Send ارسال متن به شماره موبایل
+	Send(ctx context.Context, phone, text string) error
+}
+
+// SMSMessage پیامک ارسال‌شده توسط FakeSMSSender
+type SMSMessage struct {
+	Phone  string
+	Text   string
+	SentAt time.Time
+}
+
+// fakeSMSSender سرویس آزمایشی مشترک؛ پیامک‌ها در حافظه نگه داشته می‌شوند
+var fakeSMSSender = NewFakeSMSSender()
+
+// GetSMSSender سرویس پیامک بر اساس تنظیمات
+func GetSMSSender() (SMSSender, error) {
+	switch config.AppConfig.SMSSender {
+	case "kavenegar":
+		return NewKavenegarSMSSender(config.AppConfig.KavenegarAPIKey, config.AppConfig.KavenegarSender), nil
+	case "console":
+		return ConsoleSMSSender{}, nil
+	case "fake":
+		return fakeSMSSender, nil
+	}
+	return nil, fmt.Errorf("سرویس پیامک نامعتبر است: %s", config.AppConfig.SMSSender)
+}
+
+// KavenegarSMSSender ارسال پیامک با سرویس کاوه‌نگار
+type KavenegarSMSSender struct {
+	APIKey string
+	Sender string
+	client *http.Client
+}
+
+// kavenegarResponse پاسخ API کاوه‌نگار؛ وضعیت ۲۰۰ یعنی پیامک پذیرفته شد
+type kavenegarResponse struct {
+	Return struct {
+		Status  int    `json:"status"`
+		Message string `json:"message"`
+	} `json:"return"`
+}
+
+// NewKavenegarSMSSender ایجاد سرویس پیامک کاوه‌نگار
+func NewKavenegarSMSSender(apiKey, sender string) *KavenegarSMSSender {
+	return &KavenegarSMSSender{
+		APIKey: apiKey,
+		Sender: sender,
+		client: &http.Client{Timeout: 20 * time.Second},
+	}
+}
+
+// Name نام سرویس
+func (k *KavenegarSMSSender) Name() string {
+	return "kavenegar"
+}
+
+// Send ارسال پیامک با API کاوه‌نگار
+func (k *KavenegarSMSSender) Send(ctx context.Context, phone, text string) error {
+	form := url.Values{"receptor": {phone}, "message": {text}}
+	if k.Sender != "" {
+		form.Set("sender", k.Sender)
+	}
+
+	endpoint := "https://api.kavenegar.com/v1/" + url.PathEscape(k.APIKey) + "/sms/send.json"
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("خطا در ایجاد درخواست: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("خطا در ارتباط با کاوه‌نگار: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("خطا در خواندن پاسخ کاوه‌نگار: %w", err)
+	}
+
+	var parsed kavenegarResponse
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return fmt.Errorf("پاسخ نامعتبر از کاوه‌نگار (HTTP %d)", resp.StatusCode)
+	}
+	if parsed.Return.Status != http.StatusOK {
+		return fmt.Errorf("کاوه‌نگار پیامک را نپذیرفت: %s (کد %d)", parsed.Return.Message, parsed.Return.Status)
+	}
+	return nil
+}
+
+// ConsoleSMSSender چاپ پیامک در لاگ؛ برای توسعه بدون سرویس پیامک
+type ConsoleSMSSender struct{}
+
+// Name نام سرویس
+func (ConsoleSMSSender) Name() string {
+	return "console"
+}
+
+// Send چاپ پیامک در لاگ
+func (ConsoleSMSSender) Send(ctx context.Context, phone, text string) error {
+	log.Printf("📱 پیامک به %s: %s", phone, text)
+	return nil
+}
+
+// FakeSMSSender سرویس پیامک درون‌برنامه‌ای برای آزمایش
+type FakeSMSSender struct {
+	mu       sync.Mutex
+	messages []SMSMessage
+}
+
+// NewFakeSMSSender ایجاد سرویس پیامک آزمایشی
+func NewFakeSMSSender() *FakeSMSSender {
+	return &FakeSMSSender{}
+}
+
+// Name نام سرویس
+func (f *FakeSMSSender) Name() string {
+	return "fake"
+}
+
+// Send ذخیره پیامک در حافظه
+func (f *FakeSMSSender) Send(ctx context.Context, phone, text string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.messages = append(f.messages, SMSMessage{Phone: phone, Text: text, SentAt: time.Now()})
+	return nil
+}
+
+// LastMessage آخرین پیامک ارسال‌شده به شماره
+func (f *FakeSMSSender) LastMessage(phone string) (SMSMessage, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i := len(f.messages) - 1; i >= 0; i-- {
+		if f.messages[i].Phone == phone {
+			return f.messages[i], true
+		}
+	}
+	return SMSMessage{}, false
+}
@@ -0,0 +1,94 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"telegram-bot/config"
+	"telegram-bot/database"
+)
+
+// threadTitleRunes حداکثر طول عنوان خودکار رشته گفتگو
+const threadTitleRunes = 50
+
+type ThreadService struct{}
+
+// CreateThread ایجاد رشته گفتگوی جدید
+func (s *ThreadService) CreateThread(userID uint, title string) (*database.ChatThread, error) {
+	thread := database.ChatThread{
+		UserID:    userID,
+		Title:     truncateRunes(title, threadTitleRunes),
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	if err := database.DB.Create(&thread).Error; err != nil {
+		return nil, fmt.Errorf("خطا در ایجاد گفتگو: %w", err)
+	}
+
+	return &thread, nil
+}
+
+// GetThread دریافت رشته گفتگو متعلق به کاربر
+func (s *ThreadService) GetThread(userID, threadID uint) (*database.ChatThread, error) {
+	var thread database.ChatThread
+	if err := database.DB.Where("id = ? AND user_id = ?", threadID, userID).First(&thread).Error; err != nil {
+		return nil, fmt.Errorf("گفتگو یافت نشد")
+	}
+	return &thread, nil
+}
+
+// GetUserThreads دریافت آخرین رشته‌های گفتگوی کاربر
+func (s *ThreadService) GetUserThreads(userID uint, limit int) ([]database.ChatThread, error) {
+	var threads []database.ChatThread
+	if err := database.DB.Where("user_id = ?", userID).
+		Order("updated_at DESC").
+		Limit(limit).
+		Find(&threads).Error; err != nil {
+		return nil, fmt.Errorf("خطا در دریافت گفتگوها: %w", err)
+	}
+	return threads, nil
+}
+
+// GetThreadConversations دریافت پرسش و پاسخ‌های یک رشته به ترتیب زمانی
+func (s *ThreadService) GetThreadConversations(threadID uint) ([]database.Conversation, error) {
+	var conversations []database.Conversation
+	if err := database.DB.Where("thread_id = ?", threadID).
+		Order("created_at ASC").
+		Find(&conversations).Error; err != nil {
+		return nil, fmt.Errorf("خطا در دریافت پیام‌های گفتگو: %w", err)
+	}
+	return conversations, nil
+}
+
+// ResolveThread دریافت رشته مشخص‌شده، یا آخرین رشته فعال، یا ایجاد رشته جدید
+func (s *ThreadService) ResolveThread(userID, threadID uint) (*database.ChatThread, error) {
+	if threadID != 0 {
+		return s.GetThread(userID, threadID)
+	}
+
+	var thread database.ChatThread
+	query := database.DB.Where("user_id = ?", userID)
+	if config.AppConfig.ContextMaxAgeMinutes > 0 {
+		since := time.Now().Add(-time.Duration(config.AppConfig.ContextMaxAgeMinutes) * time.Minute)
+		query = query.Where("updated_at >= ?", since)
+	}
+
+	if err := query.Order("updated_at DESC").First(&thread).Error; err == nil {
+		return &thread, nil
+	}
+
+	return s.CreateThread(userID, "")
+}
+
+// TouchThread به‌روزرسانی زمان آخرین فعالیت و تنظیم عنوان خودکار
+func (s *ThreadService) TouchThread(thread *database.ChatThread, question string) error {
+	updates := map[string]interface{}{
+		"updated_at": time.Now(),
+	}
+	if thread.Title == "" {
+		updates["title"] = truncateRunes(question, threadTitleRunes)
+	}
+
+	return database.DB.Model(thread).Updates(updates).Error
+}
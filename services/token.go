@@ -1,145 +1,457 @@
 package services
 
 import (
+	"errors"
 	"fmt"
 	"time"
 
-	"telegram-bot/config"
+	"gorm.io/gorm"
 	"telegram-bot/database"
 	"telegram-bot/utils"
 )
 
 type TokenService struct{}
 
-// GetUserTokens دریافت توکن‌های کاربر
+var tokenService = &TokenService{}
+
+// انواع تراکنش‌های دفتر توکن
+const (
+	TransactionGrant       = "grant"
+	TransactionSpend       = "spend"
+	TransactionRefund      = "refund"
+	TransactionReset       = "reset"
+	TransactionAdminAdjust = "admin_adjust"
+	TransactionTransferOut = "transfer_out"
+	TransactionTransferIn  = "transfer_in"
+)
+
+// ledgerMaxAttempts تعداد تلاش مجدد وقتی موجودی هم‌زمان توسط درخواست دیگری تغییر کرده
+const ledgerMaxAttempts = 5
+
+var (
+	ErrInsufficientTokens = errors.New("توکن کافی ندارید")
+	ErrUserNotFound       = errors.New("کاربر یافت نشد")
+
+	// errBalanceChanged موجودی بین خواندن و نوشتن تغییر کرده؛ تراکنش دوباره اجرا می‌شود
+	errBalanceChanged = errors.New("balance changed concurrently")
+
+	// errAlreadyReset کاربر در دوره جاری قبلاً ریست شده است
+	errAlreadyReset = errors.New("already reset in this period")
+
+	// errAlreadyCredited توکن این پرداخت قبلاً به حساب کاربر اضافه شده است
+	errAlreadyCredited = errors.New("payment already credited")
+)
+
+// TokenEntry توضیحات یک تراکنش در دفتر توکن
+type TokenEntry struct {
+	Type      string
+	Reference string // شناسه موجودیت مرتبط، مثلاً "conversation:42"
+	Note      string
+	Actor     Actor // ادمین انجام‌دهنده؛ مقدار صفر یعنی سیستم
+}
+
+// Actor انجام‌دهنده یک تغییر مدیریتی
+//
+// ورود پنل مدیریت حساب جداگانه دارد که ممکن است به کاربری متصل نباشد؛ بنابراین هر
+// دو شناسه ثبت می‌شود.
+type Actor struct {
+	UserID    uint
+	AccountID uint // حساب پنل مدیریت؛ صفر برای نشست کاربری
+}
+
+// SystemActor تغییرات خودکار سیستم
+var SystemActor = Actor{}
+
+// tokenDelta تغییر سهمیه روزانه و توکن خریداری‌شده در یک تراکنش
+type tokenDelta struct {
+	Daily     int
+	Purchased int
+}
+
+// total مجموع تغییر موجودی
+func (d tokenDelta) total() int {
+	return d.Daily + d.Purchased
+}
+
+// spendDelta کسر amount ابتدا از سهمیه روزانه و سپس از توکن خریداری‌شده، حداکثر تا کل موجودی
+func spendDelta(user *database.User, amount int) tokenDelta {
+	fromDaily := amount
+	if fromDaily > user.DailyTokens {
+		fromDaily = user.DailyTokens
+	}
+	if fromDaily < 0 {
+		fromDaily = 0 // سهمیه منفی بدهی رزروهاست و با ریست روزانه تسویه می‌شود
+	}
+
+	fromPurchased := amount - fromDaily
+	if fromPurchased > user.PurchasedTokens {
+		fromPurchased = user.PurchasedTokens
+	}
+
+	return tokenDelta{Daily: -fromDaily, Purchased: -fromPurchased}
+}
+
+// availableTokens کل موجودی قابل مصرف کاربر
+func availableTokens(user *database.User) int {
+	return user.DailyTokens + user.PurchasedTokens
+}
+
+// GetUserTokens دریافت توکن‌های کاربر؛ سهمیه روزانه به‌علاوه توکن خریداری‌شده
 func (s *TokenService) GetUserTokens(userID uint) (int, error) {
 	var user database.User
 	if err := database.DB.First(&user, userID).Error; err != nil {
-		return 0, fmt.Errorf("کاربر یافت نشد")
+		return 0, ErrUserNotFound
 	}
 
 	if user.UnlimitedTokens {
 		return 999999, nil // توکن نامحدود
 	}
 
-	return user.DailyTokens, nil
+	return availableTokens(&user), nil
 }
 
-// DeductTokens کسر توکن
+// DeductTokens کسر توکن؛ اگر موجودی کافی نباشد چیزی کسر نمی‌شود
 func (s *TokenService) DeductTokens(userID uint, amount int) error {
-	var user database.User
-	if err := database.DB.First(&user, userID).Error; err != nil {
-		return fmt.Errorf("کاربر یافت نشد")
+	_, err := s.adjustBalance(userID, &TokenEntry{Type: TransactionSpend}, amount, func(tx *gorm.DB, user *database.User) (tokenDelta, error) {
+		if !user.UnlimitedTokens && availableTokens(user) < amount {
+			return tokenDelta{}, ErrInsufficientTokens
+		}
+		return spendDelta(user, amount), nil
+	})
+	return err
+}
+
+// adjustBalance تغییر اتمیک موجودی و ثبت تراکنش در دفتر، داخل یک تراکنش دیتابیس
+//
+// compute با موجودی فعلی و همان تراکنش دیتابیس فراخوانی می‌شود و تغییر موجودی را
+// برمی‌گرداند؛ می‌تواند entry را هم تکمیل کند. به‌روزرسانی فقط اگر موجودی از زمان
+// خواندن تغییر نکرده باشد انجام می‌شود؛ در غیر این صورت کل تراکنش با موجودی جدید
+// تکرار می‌شود. usage مصرفی است که در آمار روزانه ثبت می‌شود و اگر موجودی را به
+// زیر آستانه‌های هشدار برساند به کاربر اطلاع داده می‌شود.
+func (s *TokenService) adjustBalance(userID uint, entry *TokenEntry, usage int, compute func(tx *gorm.DB, user *database.User) (tokenDelta, error)) (*database.TokenTransaction, error) {
+	for attempt := 0; attempt < ledgerMaxAttempts; attempt++ {
+		var record *database.TokenTransaction
+		var user database.User
+		err := database.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.First(&user, userID).Error; err != nil {
+				return ErrUserNotFound
+			}
+
+			if usage > 0 {
+				if err := recordDailyUsage(tx, userID, usage); err != nil {
+					return err
+				}
+			}
+
+			delta, err := compute(tx, &user)
+			if err != nil {
+				return err
+			}
+
+			record, err = applyDelta(tx, &user, entry, delta)
+			return err
+		})
+
+		if errors.Is(err, errBalanceChanged) {
+			continue
+		}
+
+		// مصرف واقعی با موجودی پیش از درخواست مقایسه می‌شود، نه با رزرو موقت
+		if err == nil && usage > 0 && !user.UnlimitedTokens {
+			after := availableTokens(&user)
+			notificationService.NotifyBalanceDrop(userID, after+usage, after)
+		}
+		return record, err
 	}
 
-	if user.UnlimitedTokens {
-		return nil // توکن نامحدود را کسر نمی‌کنیم
+	return nil, fmt.Errorf("موجودی هم‌زمان در حال تغییر است؛ دوباره تلاش کنید")
+}
+
+// applyDelta اعمال تغییر موجودی با بررسی هم‌زمانی و ثبت ردیف دفتر، داخل تراکنش tx
+//
+// اگر موجودی کاربر از زمان خواندن user تغییر کرده باشد errBalanceChanged برمی‌گردد.
+// تغییر صفر ثبت نمی‌شود، جز تنظیم ادمین و انتقال که همیشه در تاریخچه می‌آیند.
+func applyDelta(tx *gorm.DB, user *database.User, entry *TokenEntry, delta tokenDelta) (*database.TokenTransaction, error) {
+	// موجودی کاربر نامحدود تغییر نمی‌کند، جز توکنی که خریده یا دریافت کرده
+	// و پس از پایان پلن قابل استفاده است
+	if user.UnlimitedTokens && entry.Type != TransactionAdminAdjust {
+		purchased := delta.Purchased
+		if purchased < 0 {
+			purchased = 0
+		}
+		delta = tokenDelta{Purchased: purchased}
+	}
+	if delta == (tokenDelta{}) && !alwaysRecorded(entry.Type) {
+		return nil, nil
 	}
 
-	if user.DailyTokens < amount {
-		return fmt.Errorf("توکن کافی ندارید")
+	balance := user.DailyTokens + delta.Daily
+	purchased := user.PurchasedTokens + delta.Purchased
+	result := tx.Model(&database.User{}).
+		Where("id = ? AND daily_tokens = ? AND purchased_tokens = ?", user.ID, user.DailyTokens, user.PurchasedTokens).
+		Updates(map[string]interface{}{
+			"daily_tokens":     balance,
+			"purchased_tokens": purchased,
+		})
+	if result.Error != nil {
+		return nil, fmt.Errorf("خطا در به‌روزرسانی موجودی: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, errBalanceChanged
 	}
 
-	user.DailyTokens -= amount
-	if err := database.DB.Save(&user).Error; err != nil {
-		return fmt.Errorf("خطا در کسر توکن: %w", err)
+	record := &database.TokenTransaction{
+		UserID:                user.ID,
+		Type:                  entry.Type,
+		Amount:                delta.total(),
+		PurchasedAmount:       delta.Purchased,
+		BalanceAfter:          balance,
+		PurchasedBalanceAfter: purchased,
+		Reference:             entry.Reference,
+		Note:                  entry.Note,
+		ActorID:               entry.Actor.UserID,
+		ActorAccountID:        entry.Actor.AccountID,
+		CreatedAt:             time.Now(),
+	}
+	if err := tx.Create(record).Error; err != nil {
+		return nil, fmt.Errorf("خطا در ثبت تراکنش: %w", err)
 	}
 
-	// ثبت در دیتابیس مصرف روزانه
-	return s.RecordDailyUsage(userID, amount)
+	user.DailyTokens = balance
+	user.PurchasedTokens = purchased
+	return record, nil
+}
+
+// alwaysRecorded تراکنش‌هایی که حتی بدون تغییر موجودی در دفتر ثبت می‌شوند
+func alwaysRecorded(entryType string) bool {
+	return entryType == TransactionAdminAdjust ||
+		entryType == TransactionTransferOut ||
+		entryType == TransactionTransferIn
 }
 
 // RecordDailyUsage ثبت مصرف روزانه
 func (s *TokenService) RecordDailyUsage(userID uint, tokens int) error {
-	today := time.Now()
-	dateOnly := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, today.Location())
+	return recordDailyUsage(database.DB, userID, tokens)
+}
 
-	var dailyUsage database.DailyTokenUsage
-	result := database.DB.Where("user_id = ? AND date = ?", userID, dateOnly).First(&dailyUsage)
+// recordDailyUsage افزایش اتمیک مصرف روزانه؛ رکورد روز در صورت نبود ایجاد می‌شود
+func recordDailyUsage(tx *gorm.DB, userID uint, tokens int) error {
+	dateOnly := DayStart(time.Now())
 
-	if result.RowsAffected == 0 {
-		// ایجاد رکورد جدید
-		dailyUsage = database.DailyTokenUsage{
-			UserID:     userID,
-			TokensUsed: tokens,
-			Date:       dateOnly,
-		}
-		return database.DB.Create(&dailyUsage).Error
+	result := tx.Model(&database.DailyTokenUsage{}).
+		Where("user_id = ? AND date = ?", userID, dateOnly).
+		Update("tokens_used", gorm.Expr("tokens_used + ?", tokens))
+	if result.Error != nil {
+		return fmt.Errorf("خطا در ثبت مصرف روزانه: %w", result.Error)
+	}
+	if result.RowsAffected > 0 {
+		return nil
 	}
 
-	// به‌روزرسانی رکورد موجود
-	dailyUsage.TokensUsed += tokens
-	return database.DB.Save(&dailyUsage).Error
+	// ایجاد رکورد جدید
+	return tx.Create(&database.DailyTokenUsage{
+		UserID:     userID,
+		TokensUsed: tokens,
+		Date:       dateOnly,
+	}).Error
 }
 
 // ResetDailyTokens ریست توکن روزانه
 func (s *TokenService) ResetDailyTokens(userID uint) error {
-	var user database.User
-	if err := database.DB.First(&user, userID).Error; err != nil {
-		return fmt.Errorf("کاربر یافت نشد")
+	return s.resetUser(userID, time.Time{})
+}
+
+// resetUser بازگرداندن موجودی کاربر به سهمیه روزانه پلنش
+//
+// اگر periodStart صفر نباشد، کاربری که پس از آن ریست شده نادیده گرفته می‌شود تا
+// اجرای دوباره یا هم‌زمان ریست یک روز، موجودی را دو بار شارژ نکند
+func (s *TokenService) resetUser(userID uint, periodStart time.Time) error {
+	day := periodStart
+	if day.IsZero() {
+		day = DayStart(time.Now())
 	}
 
-	if !user.UnlimitedTokens {
-		user.DailyTokens = config.AppConfig.DailyTokenLimit
+	_, err := s.adjustBalance(userID, &TokenEntry{Type: TransactionReset}, 0, func(tx *gorm.DB, user *database.User) (tokenDelta, error) {
+		if !periodStart.IsZero() && !user.LastTokenReset.Before(periodStart) {
+			return tokenDelta{}, errAlreadyReset
+		}
+
+		// سهمیه بر اساس پلن کاربر و باقی‌مانده سهمیه ماهانه
+		plan, err := userPlan(tx, user)
+		if err != nil {
+			return tokenDelta{}, err
+		}
+		limit, err := dailyAllowance(tx, user, plan, day)
+		if err != nil {
+			return tokenDelta{}, err
+		}
+
+		if err := tx.Model(&database.User{}).Where("id = ?", userID).Update("last_token_reset", time.Now()).Error; err != nil {
+			return tokenDelta{}, fmt.Errorf("خطا در ریست توکن: %w", err)
+		}
+		// بدهی کسری رزروها از سهمیه روز جدید کم می‌شود
+		if user.DailyTokens < 0 {
+			limit += user.DailyTokens
+		}
+		return tokenDelta{Daily: limit - user.DailyTokens}, nil
+	})
+	if err != nil {
+		return err
 	}
 
-	user.LastTokenReset = time.Now()
-	return database.DB.Save(&user).Error
+	if !periodStart.IsZero() {
+		notificationService.SendResetDigest(userID, periodStart)
+	}
+	return nil
 }
 
-// ResetAllDailyTokens ریست توکن همه کاربران
+// ResetAllDailyTokens ریست توکن همه کاربران برای روز جاری
 func (s *TokenService) ResetAllDailyTokens() error {
-	result := database.DB.Model(&database.User{}).
-		Where("unlimited_tokens = ?", false).
-		Updates(map[string]interface{}{
-			"daily_tokens":     config.AppConfig.DailyTokenLimit,
-			"last_token_reset": time.Now(),
-		})
+	_, err := s.ResetAllDailyTokensFor(DayStart(time.Now()))
+	return err
+}
 
-	if result.Error != nil {
-		return fmt.Errorf("خطا در ریست توکن‌ها: %w", result.Error)
+// ResetAllDailyTokensFor ریست توکن کاربرانی که از periodStart به بعد ریست نشده‌اند
+func (s *TokenService) ResetAllDailyTokensFor(periodStart time.Time) (int, error) {
+	// زمان‌ها با منطقه زمانی سرور ذخیره شده‌اند و SQLite آن‌ها را به‌صورت متن مقایسه می‌کند
+	var userIDs []uint
+	if err := database.DB.Model(&database.User{}).
+		Where("unlimited_tokens = ? AND last_token_reset < ?", false, periodStart.Local()).
+		Pluck("id", &userIDs).Error; err != nil {
+		return 0, fmt.Errorf("خطا در ریست توکن‌ها: %w", err)
 	}
 
-	utils.LogSuccess("TokenService", fmt.Sprintf("توکن %d کاربر با موفقیت ریست شد", result.RowsAffected))
-	return nil
+	var reset, failed int
+	for _, userID := range userIDs {
+		err := s.resetUser(userID, periodStart)
+		switch {
+		case err == nil:
+			reset++
+		case errors.Is(err, errAlreadyReset):
+			// نمونه دیگری از برنامه زودتر ریست کرده است
+		default:
+			utils.LogError("TokenService", fmt.Sprintf("خطا در ریست توکن کاربر %d", userID), err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return reset, fmt.Errorf("ریست توکن %d کاربر ناموفق بود", failed)
+	}
+
+	utils.LogSuccess("TokenService", fmt.Sprintf("توکن %d کاربر با موفقیت ریست شد", reset))
+	return reset, nil
 }
 
 // AddTokens اضافه کردن توکن‌ها
 func (s *TokenService) AddTokens(userID uint, amount int) error {
-	var user database.User
-	if err := database.DB.First(&user, userID).Error; err != nil {
-		return fmt.Errorf("کاربر یافت نشد")
+	_, err := s.adjustBalance(userID, &TokenEntry{Type: TransactionGrant}, 0, func(tx *gorm.DB, user *database.User) (tokenDelta, error) {
+		return tokenDelta{Daily: amount}, nil
+	})
+	return err
+}
+
+// CreditPurchase افزودن توکن بسته خریداری‌شده پس از تایید پرداخت
+//
+// وضعیت پرداخت در انتظار یا ناموفق در همان تراکنش دیتابیس به paid تغییر می‌کند؛ اگر
+// پرداخت قبلاً اعمال شده باشد چیزی اضافه نمی‌شود و credited برابر false است.
+func (s *TokenService) CreditPurchase(paymentID uint, refID string) (bool, error) {
+	var payment database.Payment
+	if err := database.DB.First(&payment, paymentID).Error; err != nil {
+		return false, fmt.Errorf("پرداخت یافت نشد")
 	}
 
-	if user.UnlimitedTokens {
-		return nil // توکن نامحدود
+	entry := &TokenEntry{
+		Type:      TransactionGrant,
+		Reference: fmt.Sprintf("payment:%d", paymentID),
+		Note:      fmt.Sprintf("خرید بسته %s", payment.PackID),
+	}
+	_, err := s.adjustBalance(payment.UserID, entry, 0, func(tx *gorm.DB, user *database.User) (tokenDelta, error) {
+		result := tx.Model(&database.Payment{}).
+			Where("id = ? AND status IN ?", paymentID, []string{PaymentPending, PaymentFailed}).
+			Updates(map[string]interface{}{
+				"status":  PaymentPaid,
+				"ref_id":  refID,
+				"paid_at": time.Now(),
+			})
+		if result.Error != nil {
+			return tokenDelta{}, fmt.Errorf("خطا در ثبت پرداخت: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return tokenDelta{}, errAlreadyCredited
+		}
+		return tokenDelta{Purchased: payment.Tokens}, nil
+	})
+	if errors.Is(err, errAlreadyCredited) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SetTokenBalance تنظیم مستقیم موجودی توسط ادمین
+func (s *TokenService) SetTokenBalance(userID uint, amount int, actor Actor, note string) error {
+	if amount < 0 {
+		return fmt.Errorf("موجودی نمی‌تواند منفی باشد")
 	}
 
-	user.DailyTokens += amount
-	return database.DB.Save(&user).Error
+	entry := &TokenEntry{Type: TransactionAdminAdjust, Note: note, Actor: actor}
+	_, err := s.adjustBalance(userID, entry, 0, func(tx *gorm.DB, user *database.User) (tokenDelta, error) {
+		return tokenDelta{Daily: amount - user.DailyTokens}, nil
+	})
+	return err
 }
 
 // SetUnlimitedTokens تنظیم توکن نامحدود
 func (s *TokenService) SetUnlimitedTokens(userID uint, unlimited bool) error {
-	var user database.User
-	if err := database.DB.First(&user, userID).Error; err != nil {
-		return fmt.Errorf("کاربر یافت نشد")
+	if err := database.DB.Model(&database.User{}).
+		Where("id = ?", userID).
+		Update("unlimited_tokens", unlimited).Error; err != nil {
+		return fmt.Errorf("خطا در تنظیم توکن نامحدود: %w", err)
 	}
 
-	user.UnlimitedTokens = unlimited
 	if unlimited {
-		user.DailyTokens = 0
-	} else {
-		user.DailyTokens = config.AppConfig.DailyTokenLimit
+		return s.SetTokenBalance(userID, 0, SystemActor, "فعال‌سازی توکن نامحدود")
+	}
+
+	plan, err := planService.GetUserPlan(userID)
+	if err != nil {
+		return err
+	}
+
+	entry := &TokenEntry{Type: TransactionAdminAdjust, Note: "لغو توکن نامحدود"}
+	_, err = s.adjustBalance(userID, entry, 0, func(tx *gorm.DB, user *database.User) (tokenDelta, error) {
+		allowance, err := dailyAllowance(tx, user, plan, DayStart(time.Now()))
+		if err != nil {
+			return tokenDelta{}, err
+		}
+		return tokenDelta{Daily: allowance - user.DailyTokens}, nil
+	})
+	return err
+}
+
+// GetTransactions دریافت آخرین تراکنش‌های دفتر توکن کاربر
+func (s *TokenService) GetTransactions(userID uint, limit, offset int) ([]database.TokenTransaction, int64, error) {
+	var transactions []database.TokenTransaction
+	var total int64
+
+	query := database.DB.Model(&database.TokenTransaction{}).Where("user_id = ?", userID)
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("خطا در دریافت تراکنش‌ها: %w", err)
+	}
+
+	if err := query.Order("id DESC").Limit(limit).Offset(offset).Find(&transactions).Error; err != nil {
+		return nil, 0, fmt.Errorf("خطا در دریافت تراکنش‌ها: %w", err)
 	}
 
-	return database.DB.Save(&user).Error
+	return transactions, total, nil
 }
 
 // GetDailyUsageStats دریافت آمار مصرف روزانه
 func (s *TokenService) GetDailyUsageStats(userID uint) (*database.DailyTokenUsage, error) {
-	today := time.Now()
-	dateOnly := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, today.Location())
+	dateOnly := DayStart(time.Now())
 
 	var usage database.DailyTokenUsage
 	result := database.DB.Where("user_id = ? AND date = ?", userID, dateOnly).First(&usage)
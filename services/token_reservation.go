@@ -0,0 +1,197 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"telegram-bot/config"
+	"telegram-bot/database"
+	"telegram-bot/utils"
+)
+
+// وضعیت‌های رزرو توکن
+const (
+	ReservationHeld      = "held"
+	ReservationCommitted = "committed"
+	ReservationReleased  = "released"
+	ReservationExpired   = "expired"
+)
+
+var ErrReservationSettled = errors.New("رزرو توکن قبلاً تسویه شده است")
+
+// ReserveTokens نگه‌داشتن اعتبار پیش از فراخوانی AI
+//
+// اعتبار بلافاصله از موجودی کسر می‌شود تا درخواست‌های هم‌زمان نتوانند بیش از موجودی
+// مصرف کنند؛ ابتدا از سهمیه روزانه و سپس از توکن خریداری‌شده. amount سقف تخمینی
+// هزینه است؛ اگر موجودی کمتر از آن باشد همان موجودی رزرو می‌شود و کسری احتمالی هنگام
+// تسویه به‌صورت بدهی ثبت می‌شود. فقط وقتی موجودی تمام شده باشد درخواست رد می‌شود.
+func (s *TokenService) ReserveTokens(userID uint, amount int) (*database.TokenReservation, error) {
+	var reservation *database.TokenReservation
+	entry := &TokenEntry{Type: TransactionSpend, Note: "رزرو پیش از درخواست AI"}
+
+	_, err := s.adjustBalance(userID, entry, 0, func(tx *gorm.DB, user *database.User) (tokenDelta, error) {
+		var held tokenDelta
+		if !user.UnlimitedTokens {
+			hold := amount
+			if available := availableTokens(user); available <= 0 {
+				return tokenDelta{}, ErrInsufficientTokens
+			} else if hold > available {
+				hold = available
+			}
+			held = spendDelta(user, hold)
+		}
+
+		reservation = &database.TokenReservation{
+			UserID:    userID,
+			Amount:    -held.Daily,
+			Purchased: -held.Purchased,
+			Status:    ReservationHeld,
+			ExpiresAt: time.Now().Add(time.Duration(config.AppConfig.ReservationTTLSeconds) * time.Second),
+		}
+		if err := tx.Create(reservation).Error; err != nil {
+			return tokenDelta{}, fmt.Errorf("خطا در رزرو توکن: %w", err)
+		}
+
+		entry.Reference = reservationReference(reservation.ID)
+		return held, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return reservation, nil
+}
+
+// CommitReservation تسویه رزرو با هزینه واقعی
+//
+// مازاد رزرو بازگردانده می‌شود و کسری آن از موجودی کسر می‌شود؛ آنچه موجودی کفاف
+// نمی‌دهد به‌صورت بدهی از سهمیه روزانه کم می‌شود تا درخواست‌های بعدی تا تسویه آن
+// رد شوند. اگر رزرو پیش‌تر منقضی و آزاد شده باشد، کل هزینه دوباره کسر می‌شود.
+// مصرف روزانه feature در همان تراکنش ثبت می‌شود تا فقط درخواست تسویه‌شده شمرده شود.
+func (s *TokenService) CommitReservation(reservationID uint, feature string, credits int, reference string) error {
+	var reservation database.TokenReservation
+	if err := database.DB.First(&reservation, reservationID).Error; err != nil {
+		return fmt.Errorf("رزرو توکن یافت نشد")
+	}
+
+	entry := &TokenEntry{Type: TransactionSpend, Reference: reference}
+	_, err := s.adjustBalance(reservation.UserID, entry, credits, func(tx *gorm.DB, user *database.User) (tokenDelta, error) {
+		held, err := settleReservation(tx, reservationID, ReservationCommitted, reference)
+		if err != nil {
+			return tokenDelta{}, err
+		}
+		if feature != "" {
+			if err := recordFeatureUsage(tx, user.ID, feature, credits); err != nil {
+				return tokenDelta{}, err
+			}
+		}
+
+		if surplus := held.total() - credits; surplus > 0 {
+			// مازاد ابتدا به توکن خریداری‌شده بازمی‌گردد، چون آخر از همه از آن کسر شده بود
+			entry.Type = TransactionRefund
+			entry.Reference = reservationReference(reservationID)
+			entry.Note = "بازگشت مازاد رزرو"
+
+			refund := tokenDelta{Purchased: surplus}
+			if refund.Purchased > held.Purchased {
+				refund.Purchased = held.Purchased
+			}
+			refund.Daily = surplus - refund.Purchased
+			return refund, nil
+		}
+
+		shortfall := credits - held.total()
+		charge := spendDelta(user, shortfall)
+		charge.Daily -= shortfall + charge.total()
+		return charge, nil
+	})
+	return err
+}
+
+// ReleaseReservation آزاد کردن کامل رزرو، مثلاً وقتی فراخوانی AI ناموفق بود
+func (s *TokenService) ReleaseReservation(reservationID uint, reason string) error {
+	return s.releaseReservation(reservationID, ReservationReleased, reason)
+}
+
+// releaseReservation بازگرداندن اعتبار رزرو و تغییر وضعیت آن؛ رزرو تسویه‌شده نادیده گرفته می‌شود
+func (s *TokenService) releaseReservation(reservationID uint, status, reason string) error {
+	var reservation database.TokenReservation
+	if err := database.DB.First(&reservation, reservationID).Error; err != nil {
+		return fmt.Errorf("رزرو توکن یافت نشد")
+	}
+
+	entry := &TokenEntry{Type: TransactionRefund, Reference: reservationReference(reservationID), Note: reason}
+	_, err := s.adjustBalance(reservation.UserID, entry, 0, func(tx *gorm.DB, user *database.User) (tokenDelta, error) {
+		return settleReservation(tx, reservationID, status, "")
+	})
+	if errors.Is(err, ErrReservationSettled) {
+		return nil
+	}
+	return err
+}
+
+// settleReservation تغییر وضعیت رزرو و برگرداندن اعتباری که هنوز در اختیار رزرو است
+func settleReservation(tx *gorm.DB, reservationID uint, status, reference string) (tokenDelta, error) {
+	var reservation database.TokenReservation
+	if err := tx.First(&reservation, reservationID).Error; err != nil {
+		return tokenDelta{}, fmt.Errorf("رزرو توکن یافت نشد")
+	}
+
+	held := tokenDelta{Daily: reservation.Amount, Purchased: reservation.Purchased}
+	switch reservation.Status {
+	case ReservationHeld:
+	case ReservationExpired:
+		if status != ReservationCommitted {
+			return tokenDelta{}, ErrReservationSettled
+		}
+		// اعتبار رزرو منقضی‌شده قبلاً بازگردانده شده است
+		held = tokenDelta{}
+	default:
+		return tokenDelta{}, ErrReservationSettled
+	}
+
+	updates := map[string]interface{}{"status": status}
+	if reference != "" {
+		updates["reference"] = reference
+	}
+
+	result := tx.Model(&database.TokenReservation{}).
+		Where("id = ? AND status = ?", reservationID, reservation.Status).
+		Updates(updates)
+	if result.Error != nil {
+		return tokenDelta{}, fmt.Errorf("خطا در تسویه رزرو: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return tokenDelta{}, errBalanceChanged
+	}
+
+	return held, nil
+}
+
+// ExpireReservations آزاد کردن رزروهایی که در مهلت مقرر تسویه نشده‌اند
+func (s *TokenService) ExpireReservations() (int, error) {
+	var ids []uint
+	if err := database.DB.Model(&database.TokenReservation{}).
+		Where("status = ? AND expires_at < ?", ReservationHeld, time.Now()).
+		Pluck("id", &ids).Error; err != nil {
+		return 0, fmt.Errorf("خطا در دریافت رزروهای منقضی: %w", err)
+	}
+
+	expired := 0
+	for _, id := range ids {
+		if err := s.releaseReservation(id, ReservationExpired, "انقضای رزرو"); err != nil {
+			utils.LogError("TokenService", fmt.Sprintf("خطا در آزادسازی رزرو %d", id), err)
+			continue
+		}
+		expired++
+	}
+
+	return expired, nil
+}
+
+// reservationReference شناسه رزرو در دفتر توکن
+func reservationReference(reservationID uint) string {
+	return fmt.Sprintf("reservation:%d", reservationID)
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"telegram-bot/database"
+)
+
+func TestCommitReservationRefundsSurplus(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, 30, 20)
+
+	// ۳۰ از سهمیه روزانه و ۱۰ از توکن خریداری‌شده نگه داشته می‌شود
+	reservation, err := tokenService.ReserveTokens(user.ID, 40)
+	if err != nil {
+		t.Fatalf("ReserveTokens: %v", err)
+	}
+	if got := reloadUser(t, user.ID); got.DailyTokens != 0 || got.PurchasedTokens != 10 {
+		t.Fatalf("after reserve: daily=%d purchased=%d, want 0/10", got.DailyTokens, got.PurchasedTokens)
+	}
+
+	if err := tokenService.CommitReservation(reservation.ID, FeatureChat, 25, "conversation:1"); err != nil {
+		t.Fatalf("CommitReservation: %v", err)
+	}
+
+	// مازاد ۱۵ ابتدا به توکن خریداری‌شده و بقیه به سهمیه روزانه بازمی‌گردد
+	got := reloadUser(t, user.ID)
+	if got.DailyTokens != 5 || got.PurchasedTokens != 20 {
+		t.Fatalf("after commit: daily=%d purchased=%d, want 5/20", got.DailyTokens, got.PurchasedTokens)
+	}
+
+	var usage database.DailyFeatureUsage
+	if err := database.DB.Where("user_id = ? AND feature = ?", user.ID, FeatureChat).First(&usage).Error; err != nil {
+		t.Fatalf("feature usage: %v", err)
+	}
+	if usage.Requests != 1 || usage.CreditsUsed != 25 {
+		t.Fatalf("feature usage = %d requests / %d credits, want 1/25", usage.Requests, usage.CreditsUsed)
+	}
+}
+
+func TestCommitReservationShortfallBecomesDebt(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, 10, 0)
+
+	reservation, err := tokenService.ReserveTokens(user.ID, 10)
+	if err != nil {
+		t.Fatalf("ReserveTokens: %v", err)
+	}
+
+	// هزینه واقعی ۱۵ واحد بیشتر از رزرو است و موجودی دیگری نمانده
+	if err := tokenService.CommitReservation(reservation.ID, FeatureChat, 25, "conversation:1"); err != nil {
+		t.Fatalf("CommitReservation: %v", err)
+	}
+	if got := reloadUser(t, user.ID); got.DailyTokens != -15 || got.PurchasedTokens != 0 {
+		t.Fatalf("after commit: daily=%d purchased=%d, want -15/0", got.DailyTokens, got.PurchasedTokens)
+	}
+
+	// تا تسویه بدهی، رزرو جدید پذیرفته نمی‌شود
+	if _, err := tokenService.ReserveTokens(user.ID, 1); !errors.Is(err, ErrInsufficientTokens) {
+		t.Fatalf("ReserveTokens with debt: err=%v, want ErrInsufficientTokens", err)
+	}
+}
+
+func TestCommitReservationShortfallUsesPurchased(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, 10, 8)
+
+	reservation, err := tokenService.ReserveTokens(user.ID, 10)
+	if err != nil {
+		t.Fatalf("ReserveTokens: %v", err)
+	}
+
+	// از کسری ۱۵ واحد، ۸ واحد از توکن خریداری‌شده و ۷ واحد به‌صورت بدهی کسر می‌شود
+	if err := tokenService.CommitReservation(reservation.ID, FeatureChat, 25, "conversation:1"); err != nil {
+		t.Fatalf("CommitReservation: %v", err)
+	}
+	if got := reloadUser(t, user.ID); got.DailyTokens != -7 || got.PurchasedTokens != 0 {
+		t.Fatalf("after commit: daily=%d purchased=%d, want -7/0", got.DailyTokens, got.PurchasedTokens)
+	}
+}
+
+func TestCommitReservationOnlyOnce(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, 30, 0)
+
+	reservation, err := tokenService.ReserveTokens(user.ID, 20)
+	if err != nil {
+		t.Fatalf("ReserveTokens: %v", err)
+	}
+	if err := tokenService.CommitReservation(reservation.ID, FeatureChat, 20, "conversation:1"); err != nil {
+		t.Fatalf("CommitReservation: %v", err)
+	}
+	if err := tokenService.CommitReservation(reservation.ID, FeatureChat, 20, "conversation:1"); !errors.Is(err, ErrReservationSettled) {
+		t.Fatalf("second commit: err=%v, want ErrReservationSettled", err)
+	}
+	if err := tokenService.ReleaseReservation(reservation.ID, "تست"); err != nil {
+		t.Fatalf("release after commit: %v", err)
+	}
+
+	if got := reloadUser(t, user.ID); got.DailyTokens != 10 {
+		t.Fatalf("daily=%d, want 10", got.DailyTokens)
+	}
+
+	var usage database.DailyFeatureUsage
+	if err := database.DB.Where("user_id = ? AND feature = ?", user.ID, FeatureChat).First(&usage).Error; err != nil {
+		t.Fatalf("feature usage: %v", err)
+	}
+	if usage.Requests != 1 {
+		t.Fatalf("feature usage requests = %d, want 1", usage.Requests)
+	}
+}
+
+func TestReserveTokensCapsAtBalance(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, 5, 3)
+
+	// تخمین ۱۰۰ واحد است ولی فقط ۸ واحد موجودی هست
+	reservation, err := tokenService.ReserveTokens(user.ID, 100)
+	if err != nil {
+		t.Fatalf("ReserveTokens: %v", err)
+	}
+	if reservation.Amount != 5 || reservation.Purchased != 3 {
+		t.Fatalf("reservation = %d/%d, want 5/3", reservation.Amount, reservation.Purchased)
+	}
+
+	// درخواست هم‌زمان دیگر تا آزاد شدن رزرو موجودی ندارد
+	if _, err := tokenService.ReserveTokens(user.ID, 1); !errors.Is(err, ErrInsufficientTokens) {
+		t.Fatalf("second reserve: err=%v, want ErrInsufficientTokens", err)
+	}
+
+	// هزینه واقعی کمتر از موجودی بود و بدهی‌ای نمی‌ماند
+	if err := tokenService.CommitReservation(reservation.ID, FeatureChat, 6, "conversation:1"); err != nil {
+		t.Fatalf("CommitReservation: %v", err)
+	}
+	if got := reloadUser(t, user.ID); got.DailyTokens != 0 || got.PurchasedTokens != 2 {
+		t.Fatalf("after commit: daily=%d purchased=%d, want 0/2", got.DailyTokens, got.PurchasedTokens)
+	}
+}
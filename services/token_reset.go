@@ -0,0 +1,98 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"telegram-bot/config"
+)
+
+// settingLastTokenReset شروع آخرین روزی که ریست توکن آن با موفقیت انجام شد
+const settingLastTokenReset = "last_daily_token_reset"
+
+// DailyResetScheduler ریست روزانه توکن‌ها در نیمه‌شب منطقه زمانی تنظیمات
+//
+// به‌جای اجرا در دقیقه دقیق نیمه‌شب، در هر بررسی شروع روز جاری با آخرین اجرای
+// موفق ذخیره‌شده مقایسه می‌شود؛ بنابراین راه‌اندازی مجدد یا تأخیر در نیمه‌شب باعث از
+// دست رفتن ریست نمی‌شود و در شروع برنامه ریست‌های جامانده جبران می‌شوند.
+type DailyResetScheduler struct {
+	tokens   *TokenService
+	interval time.Duration
+}
+
+// NewDailyResetScheduler ایجاد زمان‌بند ریست روزانه
+func NewDailyResetScheduler() *DailyResetScheduler {
+	return &DailyResetScheduler{
+		tokens:   &TokenService{},
+		interval: time.Minute,
+	}
+}
+
+// DayStart شروع روزی که t در آن است، در منطقه زمانی تنظیمات
+func DayStart(t time.Time) time.Time {
+	local := t.In(config.AppConfig.Location)
+	return time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, config.AppConfig.Location)
+}
+
+// Run اجرای زمان‌بند تا لغو ctx
+func (s *DailyResetScheduler) Run(ctx context.Context) {
+	s.RunPending()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.RunPending()
+		}
+	}
+}
+
+// RunPending پایان پلن‌های منقضی و اجرای ریست روز جاری اگر هنوز انجام نشده باشد
+func (s *DailyResetScheduler) RunPending() {
+	if err := planService.ExpirePlans(); err != nil {
+		log.Printf("❌ %v", err)
+	}
+
+	period := DayStart(time.Now())
+
+	lastRun, ok := s.lastRun()
+	if ok && !lastRun.Before(period) {
+		return
+	}
+
+	if ok {
+		if missed := int(period.Sub(lastRun).Round(time.Hour).Hours()/24) - 1; missed > 0 {
+			log.Printf("⚠️  %d ریست روزانه از دست رفته بود؛ جبران با ریست امروز", missed)
+		}
+	}
+
+	log.Printf("🔄 ریست توکن‌های روزانه برای %s...", period.Format("2006-01-02"))
+	if _, err := s.tokens.ResetAllDailyTokensFor(period); err != nil {
+		// آخرین اجرا ثبت نمی‌شود تا در بررسی بعدی دوباره تلاش شود
+		log.Printf("❌ خطا در ریست توکن‌ها: %v", err)
+		return
+	}
+
+	if err := settingService.SetSetting(settingLastTokenReset, period.Format(time.RFC3339)); err != nil {
+		log.Printf("❌ خطا در ثبت زمان آخرین ریست: %v", err)
+	}
+}
+
+// lastRun شروع روز آخرین ریست موفق
+func (s *DailyResetScheduler) lastRun() (time.Time, bool) {
+	value := settingService.GetSetting(settingLastTokenReset, "")
+	if value == "" {
+		return time.Time{}, false
+	}
+
+	lastRun, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return lastRun, true
+}
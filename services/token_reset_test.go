@@ -0,0 +1,94 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"telegram-bot/config"
+	"telegram-bot/database"
+)
+
+// setLastReset تنظیم زمان آخرین ریست کاربر
+func setLastReset(t *testing.T, userID uint, at time.Time) {
+	t.Helper()
+
+	if err := database.DB.Model(&database.User{}).Where("id = ?", userID).Update("last_token_reset", at).Error; err != nil {
+		t.Fatalf("set last reset: %v", err)
+	}
+}
+
+// countResets تعداد ردیف‌های ریست در دفتر توکن کاربر
+func countResets(t *testing.T, userID uint) int64 {
+	t.Helper()
+
+	var count int64
+	if err := database.DB.Model(&database.TokenTransaction{}).
+		Where("user_id = ? AND type = ?", userID, TransactionReset).
+		Count(&count).Error; err != nil {
+		t.Fatalf("count resets: %v", err)
+	}
+	return count
+}
+
+func TestResetAllDailyTokensForIsIdempotent(t *testing.T) {
+	setupTestDB(t)
+	period := DayStart(time.Now())
+
+	stale := createTestUser(t, 4, 0)
+	setLastReset(t, stale.ID, period.Add(-time.Hour))
+	fresh := createTestUser(t, 7, 0)
+
+	tokens := &TokenService{}
+	for run, want := range []int{1, 0} {
+		reset, err := tokens.ResetAllDailyTokensFor(period)
+		if err != nil {
+			t.Fatalf("run %d: ResetAllDailyTokensFor: %v", run, err)
+		}
+		if reset != want {
+			t.Errorf("run %d: reset = %d, want %d", run, reset, want)
+		}
+	}
+
+	if got := reloadUser(t, stale.ID).DailyTokens; got != config.AppConfig.DailyTokenLimit {
+		t.Errorf("stale daily = %d, want %d", got, config.AppConfig.DailyTokenLimit)
+	}
+	if got := countResets(t, stale.ID); got != 1 {
+		t.Errorf("stale resets = %d, want 1", got)
+	}
+
+	// کاربری که پس از شروع دوره ریست شده دست نمی‌خورد
+	if got := reloadUser(t, fresh.ID).DailyTokens; got != 7 {
+		t.Errorf("fresh daily = %d, want 7", got)
+	}
+	if got := countResets(t, fresh.ID); got != 0 {
+		t.Errorf("fresh resets = %d, want 0", got)
+	}
+}
+
+func TestDailyResetSchedulerCatchesUp(t *testing.T) {
+	setupTestDB(t)
+	period := DayStart(time.Now())
+
+	// آخرین اجرای موفق سه روز پیش بوده است
+	if err := settingService.SetSetting(settingLastTokenReset, period.AddDate(0, 0, -3).Format(time.RFC3339)); err != nil {
+		t.Fatalf("SetSetting: %v", err)
+	}
+	user := createTestUser(t, 2, 0)
+	setLastReset(t, user.ID, period.AddDate(0, 0, -3))
+
+	scheduler := NewDailyResetScheduler()
+	scheduler.RunPending()
+	scheduler.RunPending()
+
+	if got := reloadUser(t, user.ID).DailyTokens; got != config.AppConfig.DailyTokenLimit {
+		t.Errorf("daily = %d, want %d", got, config.AppConfig.DailyTokenLimit)
+	}
+	if got := countResets(t, user.ID); got != 1 {
+		t.Errorf("resets = %d, want 1", got)
+	}
+
+	lastRun, ok := scheduler.lastRun()
+	if !ok || !lastRun.Equal(period) {
+		t.Errorf("lastRun = %v, %v; want %v", lastRun, ok, period)
+	}
+}
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"gorm.io/gorm"
+	"telegram-bot/database"
+)
+
+// countTransactions تعداد ردیف‌های دفتر توکن کاربر
+func countTransactions(t *testing.T, userID uint) int64 {
+	t.Helper()
+
+	var count int64
+	if err := database.DB.Model(&database.TokenTransaction{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+		t.Fatalf("count transactions: %v", err)
+	}
+	return count
+}
+
+func TestAdjustBalanceRetriesOnConcurrentChange(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, 20, 0)
+
+	tokens := &TokenService{}
+	calls := 0
+	record, err := tokens.adjustBalance(user.ID, &TokenEntry{Type: TransactionGrant}, 0, func(tx *gorm.DB, u *database.User) (tokenDelta, error) {
+		calls++
+		if calls == 1 {
+			// موجودی خوانده‌شده کهنه است، مثل وقتی درخواست دیگری هم‌زمان آن را تغییر داده
+			u.DailyTokens++
+		}
+		return tokenDelta{Daily: 5}, nil
+	})
+	if err != nil {
+		t.Fatalf("adjustBalance: %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("compute calls = %d, want 2", calls)
+	}
+	if record == nil || record.BalanceAfter != 25 {
+		t.Errorf("record = %+v, want BalanceAfter 25", record)
+	}
+	if got := reloadUser(t, user.ID).DailyTokens; got != 25 {
+		t.Errorf("daily = %d, want 25", got)
+	}
+	if got := countTransactions(t, user.ID); got != 1 {
+		t.Errorf("transactions = %d, want 1", got)
+	}
+}
+
+func TestAdjustBalanceGivesUpAfterMaxAttempts(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, 20, 0)
+
+	tokens := &TokenService{}
+	calls := 0
+	_, err := tokens.adjustBalance(user.ID, &TokenEntry{Type: TransactionGrant}, 0, func(tx *gorm.DB, u *database.User) (tokenDelta, error) {
+		calls++
+		u.DailyTokens++
+		return tokenDelta{Daily: 5}, nil
+	})
+	if err == nil {
+		t.Fatal("adjustBalance succeeded with a balance that always changes")
+	}
+	if calls != ledgerMaxAttempts {
+		t.Errorf("compute calls = %d, want %d", calls, ledgerMaxAttempts)
+	}
+	if got := reloadUser(t, user.ID).DailyTokens; got != 20 {
+		t.Errorf("daily = %d, want 20", got)
+	}
+	if got := countTransactions(t, user.ID); got != 0 {
+		t.Errorf("transactions = %d, want 0", got)
+	}
+}
+
+func TestDeductTokensInsufficientChangesNothing(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, 20, 0)
+
+	tokens := &TokenService{}
+	if err := tokens.DeductTokens(user.ID, 30); !errors.Is(err, ErrInsufficientTokens) {
+		t.Fatalf("DeductTokens = %v, want ErrInsufficientTokens", err)
+	}
+	if got := reloadUser(t, user.ID).DailyTokens; got != 20 {
+		t.Errorf("daily = %d, want 20", got)
+	}
+	if got := countTransactions(t, user.ID); got != 0 {
+		t.Errorf("transactions = %d, want 0", got)
+	}
+}
+
+func TestSetTokenBalanceRecordsActor(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, 20, 0)
+
+	// ورود پنل مدیریت بدون کاربر متصل فقط شناسه حساب را دارد
+	actor := Actor{AccountID: 7}
+	if err := tokenService.SetTokenBalance(user.ID, 50, actor, "تست"); err != nil {
+		t.Fatalf("SetTokenBalance: %v", err)
+	}
+
+	var entry database.TokenTransaction
+	if err := database.DB.Where("user_id = ?", user.ID).Last(&entry).Error; err != nil {
+		t.Fatalf("ledger entry: %v", err)
+	}
+	if entry.ActorID != 0 || entry.ActorAccountID != 7 {
+		t.Errorf("actor = user %d / account %d, want 0/7", entry.ActorID, entry.ActorAccountID)
+	}
+	if entry.Amount != 30 {
+		t.Errorf("amount = %d, want 30", entry.Amount)
+	}
+}
@@ -0,0 +1,157 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"time"
+
+	"gorm.io/gorm"
+	"telegram-bot/config"
+	"telegram-bot/database"
+)
+
+var ErrTransferLimitExceeded = errors.New("سقف روزانه انتقال توکن پر شده است")
+
+// TransferTokens انتقال توکن از یک کاربر به کاربر دیگر
+//
+// کسر از فرستنده، افزودن به گیرنده و ثبت هر دو ردیف دفتر در یک تراکنش دیتابیس
+// انجام می‌شود. توکن دریافتی به موجودی خریداری‌شده گیرنده اضافه می‌شود تا با ریست
+// روزانه از بین نرود. فرستنده با توکن نامحدود فقط به سقف روزانه محدود است.
+func (s *TokenService) TransferTokens(fromUserID, toUserID uint, amount int, note string) (*database.TokenTransfer, error) {
+	if amount <= 0 {
+		return nil, fmt.Errorf("مقدار انتقال باید بیشتر از صفر باشد")
+	}
+	if fromUserID == toUserID {
+		return nil, fmt.Errorf("انتقال توکن به خودتان ممکن نیست")
+	}
+
+	for attempt := 0; attempt < ledgerMaxAttempts; attempt++ {
+		var transfer *database.TokenTransfer
+		var sender database.User
+		err := database.DB.Transaction(func(tx *gorm.DB) error {
+			var recipient database.User
+			if err := tx.First(&sender, fromUserID).Error; err != nil {
+				return ErrUserNotFound
+			}
+			if err := tx.First(&recipient, toUserID).Error; err != nil {
+				return ErrUserNotFound
+			}
+
+			if err := checkTransferLimits(tx, fromUserID, toUserID, amount); err != nil {
+				return err
+			}
+
+			var debit tokenDelta
+			if !sender.UnlimitedTokens {
+				if availableTokens(&sender) < amount {
+					return ErrInsufficientTokens
+				}
+				debit = spendDelta(&sender, amount)
+			}
+
+			transfer = &database.TokenTransfer{
+				FromUserID: fromUserID,
+				ToUserID:   toUserID,
+				Amount:     amount,
+				Note:       note,
+				CreatedAt:  time.Now(),
+			}
+			if err := tx.Create(transfer).Error; err != nil {
+				return fmt.Errorf("خطا در ثبت انتقال: %w", err)
+			}
+
+			reference := fmt.Sprintf("transfer:%d", transfer.ID)
+			if _, err := applyDelta(tx, &sender, &TokenEntry{
+				Type:      TransactionTransferOut,
+				Reference: reference,
+				Note:      fmt.Sprintf("انتقال %d توکن به %s", amount, recipient.FullName),
+			}, debit); err != nil {
+				return err
+			}
+
+			_, err := applyDelta(tx, &recipient, &TokenEntry{
+				Type:      TransactionTransferIn,
+				Reference: reference,
+				Note:      fmt.Sprintf("دریافت %d توکن از %s", amount, sender.FullName),
+			}, tokenDelta{Purchased: amount})
+			return err
+		})
+
+		if errors.Is(err, errBalanceChanged) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if !sender.UnlimitedTokens {
+			after := availableTokens(&sender)
+			notificationService.NotifyBalanceDrop(fromUserID, after+amount, after)
+		}
+		notifyUser(toUserID, fmt.Sprintf("🎁 %s به شما <b>%d</b> توکن هدیه داد.", html.EscapeString(sender.FullName), amount))
+		return transfer, nil
+	}
+
+	return nil, fmt.Errorf("موجودی هم‌زمان در حال تغییر است؛ دوباره تلاش کنید")
+}
+
+// GetTransferredToday مجموع توکن ارسالی و دریافتی کاربر در روز جاری
+func (s *TokenService) GetTransferredToday(userID uint) (sent, received int, err error) {
+	since := DayStart(time.Now())
+	if sent, err = transferredSince(database.DB, "from_user_id", userID, since); err != nil {
+		return 0, 0, err
+	}
+	if received, err = transferredSince(database.DB, "to_user_id", userID, since); err != nil {
+		return 0, 0, err
+	}
+	return sent, received, nil
+}
+
+// checkTransferLimits بررسی سقف روزانه ارسال فرستنده و دریافت گیرنده
+func checkTransferLimits(tx *gorm.DB, fromUserID, toUserID uint, amount int) error {
+	since := DayStart(time.Now())
+
+	if limit := config.AppConfig.GiftDailySendLimit; limit >= 0 {
+		sent, err := transferredSince(tx, "from_user_id", fromUserID, since)
+		if err != nil {
+			return err
+		}
+		if sent+amount > limit {
+			return fmt.Errorf("%w: امروز حداکثر %d توکن دیگر می‌توانید منتقل کنید", ErrTransferLimitExceeded, remainingLimit(limit, sent))
+		}
+	}
+
+	if limit := config.AppConfig.GiftDailyReceiveLimit; limit >= 0 {
+		received, err := transferredSince(tx, "to_user_id", toUserID, since)
+		if err != nil {
+			return err
+		}
+		if received+amount > limit {
+			return fmt.Errorf("%w: گیرنده امروز حداکثر %d توکن دیگر می‌تواند دریافت کند", ErrTransferLimitExceeded, remainingLimit(limit, received))
+		}
+	}
+
+	return nil
+}
+
+// transferredSince مجموع انتقال‌های کاربر از زمان since؛ column مشخص می‌کند ارسال یا دریافت
+func transferredSince(tx *gorm.DB, column string, userID uint, since time.Time) (int, error) {
+	// زمان‌ها با منطقه زمانی سرور ذخیره شده‌اند و SQLite آن‌ها را به‌صورت متن مقایسه می‌کند
+	var total int
+	if err := tx.Model(&database.TokenTransfer{}).
+		Where(column+" = ? AND created_at >= ?", userID, since.Local()).
+		Select("COALESCE(SUM(amount), 0)").
+		Scan(&total).Error; err != nil {
+		return 0, fmt.Errorf("خطا در محاسبه انتقال‌های امروز: %w", err)
+	}
+	return total, nil
+}
+
+// remainingLimit باقی‌مانده سقف روزانه
+func remainingLimit(limit, used int) int {
+	if used >= limit {
+		return 0
+	}
+	return limit - used
+}
@@ -0,0 +1,78 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"telegram-bot/config"
+	"telegram-bot/database"
+)
+
+func TestTransferTokensMovesBalanceAtomically(t *testing.T) {
+	setupTestDB(t)
+	config.AppConfig.GiftDailySendLimit = 15
+	config.AppConfig.GiftDailyReceiveLimit = -1
+
+	sender := createTestUser(t, 10, 0)
+	recipient := createTestUser(t, 0, 0)
+	tokens := &TokenService{}
+
+	transfer, err := tokens.TransferTokens(sender.ID, recipient.ID, 8, "")
+	if err != nil {
+		t.Fatalf("TransferTokens: %v", err)
+	}
+	if got := reloadUser(t, sender.ID); got.DailyTokens+got.PurchasedTokens != 2 {
+		t.Errorf("sender balance = %d, want 2", got.DailyTokens+got.PurchasedTokens)
+	}
+	// توکن دریافتی با ریست روزانه از بین نمی‌رود
+	if got := reloadUser(t, recipient.ID).PurchasedTokens; got != 8 {
+		t.Errorf("recipient purchased = %d, want 8", got)
+	}
+
+	var entries []database.TokenTransaction
+	database.DB.Where("reference = ?", fmt.Sprintf("transfer:%d", transfer.ID)).Order("id").Find(&entries)
+	if len(entries) != 2 || entries[0].Type != TransactionTransferOut || entries[1].Type != TransactionTransferIn {
+		t.Fatalf("ledger entries = %+v, want transfer_out and transfer_in", entries)
+	}
+
+	tests := []struct {
+		name    string
+		grant   int
+		amount  int
+		wantErr error
+	}{
+		{name: "insufficient balance", amount: 5, wantErr: ErrInsufficientTokens},
+		{name: "daily send limit", grant: 20, amount: 10, wantErr: ErrTransferLimitExceeded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.grant > 0 {
+				if err := tokens.AddTokens(sender.ID, tt.grant); err != nil {
+					t.Fatalf("AddTokens: %v", err)
+				}
+			}
+			before := reloadUser(t, sender.ID)
+
+			if _, err := tokens.TransferTokens(sender.ID, recipient.ID, tt.amount, ""); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("TransferTokens = %v, want %v", err, tt.wantErr)
+			}
+
+			// انتقال ناموفق هیچ بخشی را اعمال نمی‌کند
+			after := reloadUser(t, sender.ID)
+			if after.DailyTokens != before.DailyTokens || after.PurchasedTokens != before.PurchasedTokens {
+				t.Errorf("sender changed from %d/%d to %d/%d", before.DailyTokens, before.PurchasedTokens, after.DailyTokens, after.PurchasedTokens)
+			}
+			if got := reloadUser(t, recipient.ID).PurchasedTokens; got != 8 {
+				t.Errorf("recipient purchased = %d, want 8", got)
+			}
+
+			var transfers int64
+			database.DB.Model(&database.TokenTransfer{}).Count(&transfers)
+			if transfers != 1 {
+				t.Errorf("transfers = %d, want 1", transfers)
+			}
+		})
+	}
+}
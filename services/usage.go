@@ -0,0 +1,120 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"telegram-bot/database"
+)
+
+// UsageService گزارش مصرف واقعی توکن و اعتبار
+type UsageService struct{}
+
+var usageService = &UsageService{}
+
+// UsageSummary مجموع مصرف یک کاربر یا کل سیستم
+type UsageSummary struct {
+	UserID           uint   `json:"user_id,omitempty"`
+	FullName         string `json:"full_name,omitempty"`
+	PhoneNumber      string `json:"phone_number,omitempty"`
+	Requests         int64  `json:"requests"`
+	PromptTokens     int64  `json:"prompt_tokens"`
+	CompletionTokens int64  `json:"completion_tokens"`
+	Credits          int64  `json:"credits"`
+}
+
+// usageTables جدول‌هایی که مصرف AI در آن‌ها ثبت می‌شود
+var usageTables = []interface{}{&database.Conversation{}, &database.CodeAnalysis{}}
+
+const usageColumns = "user_id, COUNT(*) AS requests, COALESCE(SUM(prompt_tokens), 0) AS prompt_tokens, " +
+	"COALESCE(SUM(completion_tokens), 0) AS completion_tokens, COALESCE(SUM(tokens_used), 0) AS credits"
+
+// GetUserUsage مجموع مصرف یک کاربر
+func (s *UsageService) GetUserUsage(userID uint) (*UsageSummary, error) {
+	report, err := s.collect(time.Time{}, "user_id = ?", userID)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &UsageSummary{UserID: userID}
+	if row, exists := report[userID]; exists {
+		summary = row
+	}
+	return summary, nil
+}
+
+// GetUsageReport مصرف همه کاربران از زمان since به بعد، به ترتیب بیشترین اعتبار
+func (s *UsageService) GetUsageReport(since time.Time) ([]UsageSummary, *UsageSummary, error) {
+	report, err := s.collect(since, "")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var users []database.User
+	if len(report) > 0 {
+		ids := make([]uint, 0, len(report))
+		for id := range report {
+			ids = append(ids, id)
+		}
+		if err := database.DB.Where("id IN ?", ids).Find(&users).Error; err != nil {
+			return nil, nil, fmt.Errorf("خطا در دریافت کاربران: %w", err)
+		}
+	}
+
+	names := make(map[uint]database.User, len(users))
+	for _, user := range users {
+		names[user.ID] = user
+	}
+
+	total := &UsageSummary{}
+	rows := make([]UsageSummary, 0, len(report))
+	for id, row := range report {
+		row.FullName = names[id].FullName
+		row.PhoneNumber = names[id].PhoneNumber
+		rows = append(rows, *row)
+
+		total.Requests += row.Requests
+		total.PromptTokens += row.PromptTokens
+		total.CompletionTokens += row.CompletionTokens
+		total.Credits += row.Credits
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i].Credits > rows[j].Credits
+	})
+
+	return rows, total, nil
+}
+
+// collect جمع مصرف گفتگوها و تحلیل‌های کد به تفکیک کاربر
+func (s *UsageService) collect(since time.Time, where string, args ...interface{}) (map[uint]*UsageSummary, error) {
+	report := make(map[uint]*UsageSummary)
+	for _, table := range usageTables {
+		query := database.DB.Model(table).Select(usageColumns).Group("user_id")
+		if where != "" {
+			query = query.Where(where, args...)
+		}
+		if !since.IsZero() {
+			query = query.Where("created_at >= ?", since)
+		}
+
+		var rows []UsageSummary
+		if err := query.Scan(&rows).Error; err != nil {
+			return nil, fmt.Errorf("خطا در محاسبه مصرف: %w", err)
+		}
+
+		for _, row := range rows {
+			summary, exists := report[row.UserID]
+			if !exists {
+				summary = &UsageSummary{UserID: row.UserID}
+				report[row.UserID] = summary
+			}
+			summary.Requests += row.Requests
+			summary.PromptTokens += row.PromptTokens
+			summary.CompletionTokens += row.CompletionTokens
+			summary.Credits += row.Credits
+		}
+	}
+	return report, nil
+}
@@ -7,6 +7,7 @@ import (
 	"strings"
 	"time"
 
+	"gorm.io/gorm"
 	"telegram-bot/database"
 	"telegram-bot/utils"
 )
@@ -45,6 +46,24 @@ func (s *UserService) UpdateUser(user *database.User) error {
 	return database.DB.Save(user).Error
 }
 
+// LinkTelegram اتصال چت تلگرام به کاربر پس از تایید کد یک‌بارمصرف
+//
+// اگر این چت پیش‌تر به کاربر دیگری متصل بوده، اتصال قبلی برداشته می‌شود.
+func (s *UserService) LinkTelegram(userID uint, chatID int64) error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&database.User{}).
+			Where("telegram_id = ? AND id <> ?", chatID, userID).
+			Updates(map[string]interface{}{"telegram_id": 0, "telegram_verified": false}).Error; err != nil {
+			return fmt.Errorf("خطا در برداشتن اتصال قبلی: %w", err)
+		}
+		if err := tx.Model(&database.User{}).Where("id = ?", userID).
+			Updates(map[string]interface{}{"telegram_id": chatID, "telegram_verified": true, "updated_at": time.Now()}).Error; err != nil {
+			return fmt.Errorf("خطا در اتصال تلگرام: %w", err)
+		}
+		return nil
+	})
+}
+
 // DeleteUser حذف کاربر
 func (s *UserService) DeleteUser(userID uint) error {
 	return database.DB.Delete(&database.User{}, userID).Error
@@ -128,7 +147,7 @@ func (s *UserService) ImportUsers(filePath string) (int, []string, error) {
 			PhoneNumber:    phone,
 			NationalCode:   national,
 			FullName:       name,
-			DailyTokens:    30,
+			DailyTokens:    planService.FreeDailyTokens(),
 			CreatedAt:      time.Now(),
 			UpdatedAt:      time.Now(),
 			LastTokenReset: time.Now(),
@@ -187,15 +206,29 @@ func (s *UserService) GetUserStats(userID uint) (map[string]interface{}, error)
 	var totalTokensUsed int
 	database.DB.Model(&database.DailyTokenUsage{}).Where("user_id = ?", userID).Select("COALESCE(SUM(tokens_used), 0)").Scan(&totalTokensUsed)
 
+	usage, err := usageService.GetUserUsage(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	plan, err := userPlan(database.DB, &user)
+	if err != nil {
+		return nil, err
+	}
+
 	stats := map[string]interface{}{
 		"user_id":           user.ID,
 		"full_name":         user.FullName,
 		"phone_number":      user.PhoneNumber,
 		"current_tokens":    user.DailyTokens,
+		"purchased_tokens":  user.PurchasedTokens,
 		"unlimited_tokens":  user.UnlimitedTokens,
 		"conversations":     conversationCount,
 		"code_analysis":     codeAnalysisCount,
 		"total_tokens_used": totalTokensUsed,
+		"usage":             usage,
+		"plan":              plan.Name,
+		"plan_expires_at":   user.PlanExpiresAt,
 		"created_at":        user.CreatedAt,
 		"last_token_reset":  user.LastTokenReset,
 	}
@@ -2,6 +2,8 @@ package utils
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"log"
 	"os"
@@ -28,6 +30,21 @@ func VerifyPassword(hashedPassword, password string) bool {
 	return err == nil
 }
 
+// GenerateSecureToken تولید رشته تصادفی امن با n بایت (خروجی hex)
+func GenerateSecureToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// HashToken هش SHA-256 توکن برای ذخیره در دیتابیس
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
 // IsValidCodeFile بررسی پسوند فایل کد
 
 // DetectLanguage تشخیص زبان برنامه‌نویسی